@@ -0,0 +1,50 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+)
+
+// ErrAgentEndpointNotFound is returned (wrapped) by submissions in agent mode
+// when the circonus-agent responds 404, the check is not refreshed.
+var ErrAgentEndpointNotFound = errors.New("circonus-agent endpoint not found")
+
+// ErrNoCheckBundle is returned by GetCheckBundle and RefreshCheckBundle in
+// agent mode, submissions go to a circonus-agent and there is no check bundle.
+var ErrNoCheckBundle = errors.New("no check bundle, submitting to a circonus-agent (agent mode)")
+
+// agentModeConfig returns true if the configuration submits to a circonus-agent,
+// Config.AgentMode or an http SubmissionURL without a CheckConfig.
+func agentModeConfig(cfg *Config) bool {
+	if cfg.AgentMode {
+		return true
+	}
+	if cfg.SubmissionURL == "" || cfg.CheckConfig != nil {
+		return false
+	}
+	su, err := parseSubmissionURL(cfg.SubmissionURL)
+	if err != nil {
+		return false
+	}
+	return su.isHTTP()
+}
+
+// agentCheckID returns the check id from a circonus-agent submission url
+// (e.g. http://127.0.0.1:2609/write/<id>), or an empty string.
+func agentCheckID(surl string) string {
+	u, err := url.Parse(surl)
+	if err != nil {
+		return ""
+	}
+	segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(segments) == 2 && segments[0] == "write" {
+		return segments[1]
+	}
+	return ""
+}
@@ -0,0 +1,147 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/circonus-labs/go-apiclient"
+)
+
+// newFakeAgent returns an agent style endpoint, PUT /write/<id> for the known
+// ids (204 for "nocontent", otherwise 200 with stats), 404 for anything else.
+func newFakeAgent(t *testing.T, requests *int32) *httptest.Server {
+	t.Helper()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(requests, 1)
+		_, _ = io.Copy(io.Discard, r.Body)
+		switch r.URL.Path {
+		case "/write/myapp":
+			fmt.Fprintln(w, `{"stats":1}`)
+		case "/write/nocontent":
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestTrapCheck_agentMode(t *testing.T) {
+	var requests int32
+	agent := newFakeAgent(t, &requests)
+	logger := &LogWrapper{Log: log.New(io.Discard, "", log.LstdFlags)}
+
+	tests := []struct {
+		newTC     func(surl string) (*TrapCheck, error)
+		wantErrIs error
+		name      string
+		id        string
+		wantStats uint64
+	}{
+		{
+			name: "submission url, detected", id: "myapp", wantStats: 1,
+			newTC: func(surl string) (*TrapCheck, error) {
+				return NewFromSubmissionURL(&Config{SubmissionURL: surl, Logger: logger})
+			},
+		},
+		{
+			name: "no content", id: "nocontent",
+			newTC: func(surl string) (*TrapCheck, error) {
+				return NewFromSubmissionURL(&Config{SubmissionURL: surl, Logger: logger})
+			},
+		},
+		{
+			// no api calls, the mock panics if called
+			name: "New, api client", id: "myapp", wantStats: 1,
+			newTC: func(surl string) (*TrapCheck, error) {
+				return New(&Config{Client: &APIMock{}, SubmissionURL: surl, Logger: logger})
+			},
+		},
+		{
+			name: "endpoint not found", id: "unknown", wantErrIs: ErrAgentEndpointNotFound,
+			newTC: func(surl string) (*TrapCheck, error) {
+				return NewFromSubmissionURL(&Config{SubmissionURL: surl, AgentMode: true, Logger: logger})
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			tc, err := tt.newTC(agent.URL + "/write/" + tt.id)
+			if err != nil {
+				t.Fatalf("creating trap check: %v", err)
+			}
+			if !tc.agentMode {
+				t.Fatal("agent mode not enabled")
+			}
+			if cfg, err := tc.GetBrokerTLSConfig(); cfg != nil || err != nil {
+				t.Errorf("GetBrokerTLSConfig() = %v, %v, want nil, nil", cfg, err)
+			}
+			if _, err := tc.GetCheckBundle(); !errors.Is(err, ErrNoCheckBundle) {
+				t.Errorf("GetCheckBundle() error = %v, want %v", err, ErrNoCheckBundle)
+			}
+
+			atomic.StoreInt32(&requests, 0)
+			var metrics bytes.Buffer
+			metrics.WriteString(`{"foo":{"_type":"n","_value":1}}`)
+			result, err := tc.SendMetrics(context.Background(), metrics)
+			if tt.wantErrIs != nil {
+				if !errors.Is(err, tt.wantErrIs) {
+					t.Fatalf("SendMetrics() error = %v, want %v", err, tt.wantErrIs)
+				}
+				if n := atomic.LoadInt32(&requests); n != 1 {
+					t.Errorf("agent requests = %d, want 1", n)
+				}
+				if n := len(tc.RefreshStats()); n != 0 {
+					t.Errorf("refreshes = %v, want none", tc.RefreshStats())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("SendMetrics() error = %v", err)
+			}
+			if result.CheckUUID != tt.id {
+				t.Errorf("CheckUUID = %q, want %q", result.CheckUUID, tt.id)
+			}
+			if result.Stats != tt.wantStats {
+				t.Errorf("Stats = %d, want %d", result.Stats, tt.wantStats)
+			}
+		})
+	}
+}
+
+func Test_agentModeConfig(t *testing.T) {
+	tests := []struct {
+		cfg  *Config
+		name string
+		want bool
+	}{
+		{name: "no submission url", cfg: &Config{}},
+		{name: "explicit", cfg: &Config{AgentMode: true}, want: true},
+		{name: "http submission url", cfg: &Config{SubmissionURL: "http://127.0.0.1:2609/write/myapp"}, want: true},
+		{name: "https submission url", cfg: &Config{SubmissionURL: "https://127.0.0.1:2609/write/myapp"}},
+		{name: "http with check config", cfg: &Config{SubmissionURL: "http://127.0.0.1:2609/write/myapp", CheckConfig: &apiclient.CheckBundle{}}},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			if got := agentModeConfig(tt.cfg); got != tt.want {
+				t.Errorf("agentModeConfig() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
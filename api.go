@@ -9,6 +9,15 @@ package trapcheck
 
 import "github.com/circonus-labs/go-apiclient"
 
+// API is the subset of the Circonus API client (github.com/circonus-labs/go-apiclient)
+// used by the package. It is the full contract an adapter must implement:
+//
+//   - Get is used to fetch the broker CA certificate (/pki/ca.crt)
+//   - FetchBroker, FetchBrokers and SearchBrokers are used to select the broker
+//   - FetchCheckBundle, CreateCheckBundle and SearchCheckBundles are used to find or create the check
+//   - UpdateCheckBundle is used to update check tags, rotate the secret and (de)activate checks
+//
+// Methods are only called when the related feature is used, but all must be implemented.
 type API interface {
 	// generic methods
 	Get(requrl string) ([]byte, error)
@@ -22,3 +31,6 @@ type API interface {
 	SearchCheckBundles(searchCriteria *apiclient.SearchQueryType, filterCriteria *apiclient.SearchFilterType) (*[]apiclient.CheckBundle, error)
 	UpdateCheckBundle(cfg *apiclient.CheckBundle) (*apiclient.CheckBundle, error)
 }
+
+// ensure the real API client satisfies API, so the interfaces cannot drift
+var _ API = (*apiclient.API)(nil)
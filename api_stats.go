@@ -0,0 +1,135 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/circonus-labs/go-apiclient"
+)
+
+// API method names used in APICallStats.
+const (
+	APIMethodGet                = "Get"
+	APIMethodFetchBroker        = "FetchBroker"
+	APIMethodFetchBrokers       = "FetchBrokers"
+	APIMethodSearchBrokers      = "SearchBrokers"
+	APIMethodFetchCheckBundle   = "FetchCheckBundle"
+	APIMethodCreateCheckBundle  = "CreateCheckBundle"
+	APIMethodSearchCheckBundles = "SearchCheckBundles"
+	APIMethodUpdateCheckBundle  = "UpdateCheckBundle"
+)
+
+// APICallStats number of Circonus API requests made, by API method.
+type APICallStats struct {
+	Calls map[string]uint64
+	Total uint64
+}
+
+// APICallStats returns a copy of the number of API requests made by the trap
+// check. Requests made by the broker list (shared by all trap checks in the
+// process) are not counted.
+func (tc *TrapCheck) APICallStats() APICallStats {
+	tc.apiCallsMu.Lock()
+	defer tc.apiCallsMu.Unlock()
+
+	stats := APICallStats{Calls: make(map[string]uint64, len(tc.apiCalls))}
+	for method, count := range tc.apiCalls {
+		stats.Calls[method] = count
+		stats.Total += count
+	}
+	return stats
+}
+
+// ResetAPICallStats clears the API call counters.
+func (tc *TrapCheck) ResetAPICallStats() {
+	tc.apiCallsMu.Lock()
+	defer tc.apiCallsMu.Unlock()
+
+	tc.apiCalls = nil
+}
+
+// recordAPICall increments the counter for the API method.
+func (tc *TrapCheck) recordAPICall(method string) {
+	tc.apiCallsMu.Lock()
+	defer tc.apiCallsMu.Unlock()
+
+	if tc.apiCalls == nil {
+		tc.apiCalls = make(map[string]uint64)
+	}
+	tc.apiCalls[method]++
+}
+
+// logAPICallStats logs a one line summary of the API calls made during what.
+func (tc *TrapCheck) logAPICallStats(what string) {
+	stats := tc.APICallStats()
+	methods := make([]string, 0, len(stats.Calls))
+	for method, count := range stats.Calls {
+		methods = append(methods, method+"="+strconv.FormatUint(count, 10))
+	}
+	sort.Strings(methods)
+	tc.logger().Infof("%s made %d API call(s) [%s]", what, stats.Total, strings.Join(methods, " "))
+}
+
+// uncountedClient returns the caller supplied API client, without the counting
+// wrapper - for the process wide broker list, which must not hold on to (or
+// count requests against) the trap check which initialized it.
+func (tc *TrapCheck) uncountedClient() API {
+	if ca, ok := tc.client.(*countingAPI); ok {
+		return ca.next
+	}
+	return tc.client
+}
+
+// countingAPI counts the requests made with the wrapped API client.
+type countingAPI struct {
+	next API
+	tc   *TrapCheck
+}
+
+var _ API = (*countingAPI)(nil)
+
+func (ca *countingAPI) Get(requrl string) ([]byte, error) {
+	ca.tc.recordAPICall(APIMethodGet)
+	return ca.next.Get(requrl)
+}
+
+func (ca *countingAPI) FetchBroker(cid apiclient.CIDType) (*apiclient.Broker, error) {
+	ca.tc.recordAPICall(APIMethodFetchBroker)
+	return ca.next.FetchBroker(cid)
+}
+
+func (ca *countingAPI) FetchBrokers() (*[]apiclient.Broker, error) {
+	ca.tc.recordAPICall(APIMethodFetchBrokers)
+	return ca.next.FetchBrokers()
+}
+
+func (ca *countingAPI) SearchBrokers(searchCriteria *apiclient.SearchQueryType, filterCriteria *apiclient.SearchFilterType) (*[]apiclient.Broker, error) {
+	ca.tc.recordAPICall(APIMethodSearchBrokers)
+	return ca.next.SearchBrokers(searchCriteria, filterCriteria)
+}
+
+func (ca *countingAPI) FetchCheckBundle(cid apiclient.CIDType) (*apiclient.CheckBundle, error) {
+	ca.tc.recordAPICall(APIMethodFetchCheckBundle)
+	return ca.next.FetchCheckBundle(cid)
+}
+
+func (ca *countingAPI) CreateCheckBundle(cfg *apiclient.CheckBundle) (*apiclient.CheckBundle, error) {
+	ca.tc.recordAPICall(APIMethodCreateCheckBundle)
+	return ca.next.CreateCheckBundle(cfg)
+}
+
+func (ca *countingAPI) SearchCheckBundles(searchCriteria *apiclient.SearchQueryType, filterCriteria *apiclient.SearchFilterType) (*[]apiclient.CheckBundle, error) {
+	ca.tc.recordAPICall(APIMethodSearchCheckBundles)
+	return ca.next.SearchCheckBundles(searchCriteria, filterCriteria)
+}
+
+func (ca *countingAPI) UpdateCheckBundle(cfg *apiclient.CheckBundle) (*apiclient.CheckBundle, error) {
+	ca.tc.recordAPICall(APIMethodUpdateCheckBundle)
+	return ca.next.UpdateCheckBundle(cfg)
+}
@@ -0,0 +1,121 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/circonus-labs/go-apiclient"
+)
+
+func TestNew_apiCallBudget(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, `{"stats":1}`)
+	}))
+	defer ts.Close()
+
+	tsURL, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatalf("creating test broker: %s", err)
+	}
+	brokerIP := tsURL.Hostname()
+	bp, err := strconv.Atoi(tsURL.Port())
+	if err != nil {
+		t.Fatalf("parsing test broker port: %s", err)
+	}
+	brokerPort := uint16(bp)
+
+	broker := apiclient.Broker{
+		CID:  "/broker/123",
+		Name: "foo",
+		Type: circonusType,
+		Details: []apiclient.BrokerDetail{
+			{
+				Status:  statusActive,
+				Modules: []string{"httptrap"},
+				IP:      &brokerIP,
+				Port:    &brokerPort,
+			},
+		},
+	}
+	client := &APIMock{
+		FetchCheckBundleFunc: func(cid apiclient.CIDType) (*apiclient.CheckBundle, error) {
+			return &apiclient.CheckBundle{
+				CID:     "/check_bundle/123",
+				Brokers: []string{"/broker/123"},
+				Type:    "httptrap",
+				Config:  apiclient.CheckBundleConfig{"submission_url": ts.URL + "/module/httptrap/0c5b9a36-2a2e-4e3c-9c8f-0d1f2a3b4c5d/secret"},
+				Status:  "active",
+			}, nil
+		},
+		FetchBrokerFunc: func(cid apiclient.CIDType) (*apiclient.Broker, error) {
+			b := broker
+			return &b, nil
+		},
+		FetchBrokersFunc: func() (*[]apiclient.Broker, error) {
+			return &[]apiclient.Broker{broker}, nil
+		},
+	}
+
+	var logs bytes.Buffer
+	tc, err := New(&Config{
+		Client:      client,
+		CheckConfig: &apiclient.CheckBundle{CID: "/check_bundle/123"},
+		Logger:      &LogWrapper{Log: log.New(&logs, "", 0)},
+	})
+	if err != nil {
+		t.Fatalf("New() unexpected error: %s", err)
+	}
+
+	// a cached check bundle cid should only need to fetch the bundle and its broker
+	const budget = 2
+	stats := tc.APICallStats()
+	if stats.Total > budget {
+		t.Errorf("New() made %d API calls %v, want <= %d", stats.Total, stats.Calls, budget)
+	}
+	if stats.Calls[APIMethodFetchCheckBundle] != 1 {
+		t.Errorf("FetchCheckBundle calls = %d, want 1", stats.Calls[APIMethodFetchCheckBundle])
+	}
+	var total uint64
+	for _, n := range stats.Calls {
+		total += n
+	}
+	if total != stats.Total {
+		t.Errorf("Total = %d, want sum of calls %d", stats.Total, total)
+	}
+	if calls := uint64(len(client.FetchCheckBundleCalls()) + len(client.FetchBrokerCalls())); calls != stats.Total {
+		t.Errorf("Total = %d, want %d (client calls, excluding the broker list)", stats.Total, calls)
+	}
+	if n := stats.Calls[APIMethodFetchBrokers]; n != 0 {
+		t.Errorf("FetchBrokers calls = %d, want 0 (broker list not counted)", n)
+	}
+	if got := tc.uncountedClient(); got != API(client) {
+		t.Errorf("uncountedClient() = %T, want the configured client", got)
+	}
+	if want := fmt.Sprintf("initialization made %d API call(s)", stats.Total); !strings.Contains(logs.String(), want) {
+		t.Errorf("log output %q missing %q", logs.String(), want)
+	}
+
+	tc.ResetAPICallStats()
+	if stats := tc.APICallStats(); stats.Total != 0 || len(stats.Calls) != 0 {
+		t.Errorf("APICallStats() after reset = %+v, want empty", stats)
+	}
+
+	if _, err := tc.RefreshCheckBundle(); err != nil {
+		t.Fatalf("RefreshCheckBundle() unexpected error: %s", err)
+	}
+	if stats := tc.APICallStats(); stats.Calls[APIMethodFetchCheckBundle] != 1 {
+		t.Errorf("APICallStats() after refresh = %+v, want 1 FetchCheckBundle", stats)
+	}
+}
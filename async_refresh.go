@@ -0,0 +1,155 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// ErrCheckRefreshing is returned (wrapped) by SendMetrics, with Config.AsyncRefresh,
+// when the check is being refreshed in the background - the metrics were not
+// submitted, callers may retry on the next flush.
+var ErrCheckRefreshing = errors.New("check refresh in progress")
+
+// ErrClosed is returned (wrapped) when an operation requires a background
+// worker and the trap check has been closed.
+var ErrClosed = errors.New("trap check closed")
+
+const maxAsyncRefreshBackoff = time.Minute
+
+// Close stops the background check refresh worker (Config.AsyncRefresh), if
+// running, and waits for it to exit. An API call already in progress is
+// allowed to complete. SubmitAsync submissions in flight are canceled, Close
+// waits for them (not for their callbacks, which may call Close). With
+// Config.SpoolFlushOnClose spooled submissions are resubmitted first. Close
+// is safe to call more than once.
+func (tc *TrapCheck) Close() error {
+	tc.asyncRefreshMu.Lock()
+	closed := tc.closed
+	tc.asyncRefreshMu.Unlock()
+
+	if !closed && tc.spoolFlushOnClose {
+		tc.flushSpool()
+	}
+
+	tc.asyncRefreshMu.Lock()
+	tc.closed = true
+	cancel := tc.asyncRefreshCancel
+	tc.asyncRefreshMu.Unlock()
+
+	if cancel != nil {
+		cancel()
+	}
+	tc.stopAsyncSubmissions()
+	tc.asyncRefreshWG.Wait()
+	tc.resetCircuit()
+	tc.closeEvents()
+	return nil
+}
+
+// asyncRefreshInProgress returns true if the background refresh worker is running.
+func (tc *TrapCheck) asyncRefreshInProgress() bool {
+	tc.asyncRefreshMu.Lock()
+	defer tc.asyncRefreshMu.Unlock()
+	return tc.asyncRefreshing
+}
+
+// startAsyncRefresh starts the background refresh worker, unless one is
+// already running. Returns ErrClosed if the trap check has been closed.
+func (tc *TrapCheck) startAsyncRefresh(reason RefreshReason) error {
+	tc.asyncRefreshMu.Lock()
+	defer tc.asyncRefreshMu.Unlock()
+
+	if tc.closed {
+		return ErrClosed
+	}
+	if tc.asyncRefreshing {
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	tc.asyncRefreshing = true
+	tc.asyncRefreshCancel = cancel
+	tc.asyncRefreshWG.Add(1)
+	go tc.asyncRefreshWorker(ctx, reason)
+	return nil
+}
+
+// asyncRefreshWorker refreshes the check, backing off between failed
+// attempts, until it succeeds or the trap check is closed.
+func (tc *TrapCheck) asyncRefreshWorker(ctx context.Context, reason RefreshReason) {
+	defer tc.asyncRefreshWG.Done()
+	defer func() {
+		tc.asyncRefreshMu.Lock()
+		tc.asyncRefreshing = false
+		tc.asyncRefreshCancel = nil
+		tc.asyncRefreshMu.Unlock()
+	}()
+
+	backoff := tc.refreshRetryDelay
+	for {
+		refreshed, err := tc.refreshCheck(reason)
+		if err == nil {
+			if refreshed {
+				tc.logger().Infof("check refreshed in background")
+			}
+			return
+		}
+
+		delay := backoff
+		if tc.refreshRetryJitter > 0 {
+			delay += time.Duration(rand.Int63n(int64(tc.refreshRetryJitter)))
+		}
+		tc.logger().Warnf("background check refresh: %s -- retrying in %s", err, delay.String())
+		select {
+		case <-ctx.Done():
+			tc.logger().Debugf("background check refresh stopped: %s", ctx.Err())
+			return
+		case <-tc.clock().After(delay):
+		}
+
+		backoff *= 2
+		if backoff <= 0 {
+			backoff = time.Second
+		}
+		if backoff > maxAsyncRefreshBackoff {
+			backoff = maxAsyncRefreshBackoff
+		}
+	}
+}
+
+// sendMetricsAsyncRefresh submits the metrics, a submission requiring a
+// check refresh starts the background worker and returns ErrCheckRefreshing.
+func (tc *TrapCheck) sendMetricsAsyncRefresh(ctx context.Context, metrics bytes.Buffer, encoding string) (*TrapResult, error) {
+	if tc.asyncRefreshInProgress() {
+		return nil, fmt.Errorf("submission skipped: %w", ErrCheckRefreshing)
+	}
+
+	result, refresh, submitErr := tc.submitEncoded(ctx, metrics, encoding)
+	if !refresh {
+		if submitErr == nil {
+			tc.recordRefreshOutcome(true)
+		}
+		return result, submitErr
+	}
+
+	if wait := tc.refreshCooldownRemaining(); wait > 0 {
+		tc.logger().Warnf("check refresh suppressed, next refresh allowed in %s: %s", wait.String(), submitErr)
+		return nil, &refreshSuppressedError{err: submitErr, wait: wait}
+	}
+	if err := tc.startAsyncRefresh(submitRefreshReason(submitErr)); err != nil {
+		return nil, fmt.Errorf("unable to refresh (%s): %w", submitErr, err)
+	}
+	// reset by the next successful submission
+	tc.recordRefreshOutcome(false)
+
+	return nil, fmt.Errorf("%s: %w", submitErr, ErrCheckRefreshing)
+}
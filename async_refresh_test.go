@@ -0,0 +1,149 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/circonus-labs/go-apiclient"
+	"github.com/circonus-labs/go-apiclient/config"
+)
+
+func newAsyncRefreshTestTrapCheck(client API, submissionURL string) *TrapCheck {
+	tc := newTestTrapCheck(submissionURL)
+	tc.client = client
+	tc.checkBundle = &apiclient.CheckBundle{
+		CID:    "/check_bundle/123",
+		Config: apiclient.CheckBundleConfig{config.SubmissionURL: submissionURL},
+	}
+	tc.refreshCooldown = time.Minute
+	tc.refreshRetryDelay = 10 * time.Millisecond
+	tc.asyncRefresh = true
+	return tc
+}
+
+func TestTrapCheck_SendMetrics_asyncRefresh(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/new" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprintln(w, `{"stats":1}`)
+	}))
+	defer ts.Close()
+
+	var fetches int32
+	release := make(chan struct{})
+	client := &APIMock{
+		FetchCheckBundleFunc: func(cid apiclient.CIDType) (*apiclient.CheckBundle, error) {
+			if atomic.AddInt32(&fetches, 1) == 1 {
+				return nil, fmt.Errorf("api unavailable") // first attempt fails, worker backs off
+			}
+			<-release
+			return &apiclient.CheckBundle{
+				CID:    "/check_bundle/123",
+				Config: apiclient.CheckBundleConfig{config.SubmissionURL: ts.URL + "/new"},
+			}, nil
+		},
+	}
+
+	tc := newAsyncRefreshTestTrapCheck(client, ts.URL+"/old")
+	defer tc.Close()
+
+	var metrics bytes.Buffer
+	metrics.WriteString(`{"foo":{"_type":"n","_value":1}}`)
+
+	// 404 returns promptly and starts the worker
+	start := time.Now()
+	if _, err := tc.SendMetrics(context.Background(), metrics); !errors.Is(err, ErrCheckRefreshing) {
+		t.Fatalf("SendMetrics() error = %v, want %v", err, ErrCheckRefreshing)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("SendMetrics() took %s, expected to return promptly", elapsed)
+	}
+
+	// fail fast while the refresh is in flight
+	if _, err := tc.SendMetrics(context.Background(), metrics); !errors.Is(err, ErrCheckRefreshing) {
+		t.Fatalf("SendMetrics() error = %v, want %v", err, ErrCheckRefreshing)
+	}
+
+	close(release)
+	deadline := time.Now().Add(5 * time.Second)
+	for tc.asyncRefreshInProgress() {
+		if time.Now().After(deadline) {
+			t.Fatal("background refresh did not complete")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// repaired state used
+	result, err := tc.SendMetrics(context.Background(), metrics)
+	if err != nil {
+		t.Fatalf("SendMetrics() error = %v", err)
+	}
+	if result.Stats != 1 {
+		t.Errorf("SendMetrics() stats = %d, want 1", result.Stats)
+	}
+	if n := atomic.LoadInt32(&fetches); n != 2 {
+		t.Errorf("FetchCheckBundle calls = %d, want 2 (one failed, one retried)", n)
+	}
+	if tc.refreshFailures != 0 {
+		t.Errorf("refresh failures = %d, want 0", tc.refreshFailures)
+	}
+}
+
+func TestTrapCheck_Close_asyncRefresh(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer ts.Close()
+
+	client := &APIMock{
+		FetchCheckBundleFunc: func(cid apiclient.CIDType) (*apiclient.CheckBundle, error) {
+			return nil, fmt.Errorf("api unavailable")
+		},
+	}
+
+	tc := newAsyncRefreshTestTrapCheck(client, ts.URL)
+
+	var metrics bytes.Buffer
+	metrics.WriteString(`{"foo":{"_type":"n","_value":1}}`)
+	if _, err := tc.SendMetrics(context.Background(), metrics); !errors.Is(err, ErrCheckRefreshing) {
+		t.Fatalf("SendMetrics() error = %v, want %v", err, ErrCheckRefreshing)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		_ = tc.Close()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close() did not stop the background refresh")
+	}
+	if tc.asyncRefreshInProgress() {
+		t.Error("background refresh still in progress after Close()")
+	}
+
+	// closed, no new worker (past the cooldown)
+	tc.refreshFailures = 0
+	tc.lastRefresh = time.Time{}
+	if _, err := tc.SendMetrics(context.Background(), metrics); !errors.Is(err, ErrClosed) {
+		t.Errorf("SendMetrics() error = %v, want %v", err, ErrClosed)
+	}
+	if err := tc.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+}
@@ -0,0 +1,170 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/circonus-labs/go-apiclient"
+)
+
+const defaultBatchConcurrency = 4
+
+// BatchTarget is the per check configuration used by NewBatch.
+type BatchTarget struct {
+	// DisplayName check display name (default Target)
+	DisplayName string
+	// Target check target (required), used with the check type and search tags to find an existing check
+	Target string
+	// Tags additional check tags
+	Tags apiclient.TagType
+}
+
+// batch holds the work shared by the checks created by NewBatch.
+type batch struct {
+	cfg    Config
+	broker *apiclient.Broker
+	caCert []byte
+}
+
+// NewBatch creates a TrapCheck for each target, e.g. one check per monitored
+// database. The broker is selected (and verified) and the broker CA cert is
+// fetched once, then each check is searched for or created using cfg with the
+// target's DisplayName, Target and Tags applied to cfg.CheckConfig, at most
+// Config.BatchConcurrency at a time. The returned checks and errors correspond
+// to targets by index, a failed target does not abort the batch. If the shared
+// work fails, every target has the same error.
+func NewBatch(cfg *Config, targets []BatchTarget) ([]*TrapCheck, []error) {
+	checks := make([]*TrapCheck, len(targets))
+	errs := make([]error, len(targets))
+	if len(targets) == 0 {
+		return checks, errs
+	}
+
+	b, err := newBatch(cfg)
+	if err != nil {
+		for i := range errs {
+			errs[i] = err
+		}
+		return checks, errs
+	}
+
+	concurrency := cfg.BatchConcurrency
+	if concurrency == 0 {
+		concurrency = defaultBatchConcurrency
+	}
+
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i := range targets {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			checks[i], errs[i] = b.newCheck(targets[i])
+		}(i)
+	}
+	wg.Wait()
+
+	return checks, errs
+}
+
+// newBatch validates the configuration, selects the broker and fetches the
+// broker CA cert (if it will be needed) for the checks in the batch.
+func newBatch(cfg *Config) (*batch, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("invalid configuration  (nil)")
+	}
+	if cfg.Client == nil {
+		return nil, fmt.Errorf("invalid configuration (nil api client)")
+	}
+	if cfg.SubmissionURL != "" {
+		return nil, fmt.Errorf("invalid configuration (SubmissionURL not supported with NewBatch)")
+	}
+	if cfg.CheckConfig != nil && cfg.CheckConfig.CID != "" {
+		return nil, fmt.Errorf("invalid configuration (CheckConfig.CID not supported with NewBatch)")
+	}
+
+	tc, err := newTrapCheck(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	tc.waitInitJitter()
+
+	checkType := "httptrap"
+	if cfg.CheckConfig != nil && cfg.CheckConfig.Type != "" {
+		checkType = cfg.CheckConfig.Type
+	}
+	if err := tc.getBroker(checkType); err != nil {
+		return nil, fmt.Errorf("selecting batch broker: %w", err)
+	}
+
+	b := &batch{cfg: *cfg, broker: tc.broker}
+
+	if tc.transport == nil && tc.custTLSConfig == nil && !tc.usingPublicCA && len(tc.caCertPEM) == 0 && tc.caCertFile == "" {
+		cert, err := tc.fetchCert()
+		if err != nil {
+			return nil, fmt.Errorf("batch broker ca cert: %w", err)
+		}
+		b.caCert = cert
+	}
+
+	tc.logAPICallStats("batch initialization")
+
+	return b, nil
+}
+
+// newCheck creates the TrapCheck for target using the shared broker and CA cert.
+func (b *batch) newCheck(target BatchTarget) (*TrapCheck, error) {
+	if target.Target == "" {
+		return nil, fmt.Errorf("invalid batch target (empty Target)")
+	}
+
+	var checkConfig apiclient.CheckBundle
+	if b.cfg.CheckConfig != nil {
+		checkConfig = *b.cfg.CheckConfig
+	}
+	// the config map is updated when defaults are applied
+	if len(checkConfig.Config) > 0 {
+		checkConfig.Config = make(apiclient.CheckBundleConfig, len(b.cfg.CheckConfig.Config))
+		for k, v := range b.cfg.CheckConfig.Config {
+			checkConfig.Config[k] = v
+		}
+	}
+	checkConfig.Target = target.Target
+	checkConfig.DisplayName = target.DisplayName
+	if checkConfig.DisplayName == "" {
+		checkConfig.DisplayName = target.Target
+	}
+	checkConfig.Tags = append(append(apiclient.TagType{}, checkConfig.Tags...), target.Tags...)
+
+	cfg := b.cfg
+	cfg.CheckConfig = &checkConfig
+	cfg.Broker = b.broker
+
+	tc, err := newTrapCheck(&cfg)
+	if err != nil {
+		return nil, fmt.Errorf("target %s: %w", target.Target, err)
+	}
+	tc.newCheckBundle = true
+	tc.preselectedVerified = true
+	if len(b.caCert) > 0 {
+		// treated like cached state, fetched from the api again if it proves invalid
+		tc.caCertPEM = b.caCert
+		tc.caCertFromState = true
+	}
+
+	if err := tc.setupCheck(); err != nil {
+		return nil, fmt.Errorf("target %s: %w", target.Target, err)
+	}
+
+	return tc, nil
+}
@@ -0,0 +1,172 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/circonus-labs/go-apiclient"
+)
+
+func TestNewBatch(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, `{"stats":1}`)
+	}))
+	defer ts.Close()
+
+	tsURL, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatalf("creating test broker: %s", err)
+	}
+	brokerIP := tsURL.Hostname()
+	bp, err := strconv.Atoi(tsURL.Port())
+	if err != nil {
+		t.Fatalf("parsing test broker port: %s", err)
+	}
+	brokerPort := uint16(bp)
+
+	caPEM, _, _ := generateTestCA(t, time.Now().Add(24*time.Hour))
+	caJSON, err := json.Marshal(caCert{Contents: string(caPEM)})
+	if err != nil {
+		t.Fatalf("marshal ca cert: %s", err)
+	}
+
+	broker := apiclient.Broker{
+		CID:  "/broker/123",
+		Name: "foo",
+		Type: circonusType,
+		Details: []apiclient.BrokerDetail{
+			{
+				CN:      "foo.example.com",
+				Status:  statusActive,
+				Modules: []string{"httptrap"},
+				IP:      &brokerIP,
+				Port:    &brokerPort,
+			},
+		},
+	}
+
+	var mu sync.Mutex
+	created := 0
+	client := &APIMock{
+		GetFunc: func(requrl string) ([]byte, error) {
+			return caJSON, nil
+		},
+		FetchBrokersFunc: func() (*[]apiclient.Broker, error) {
+			return &[]apiclient.Broker{broker}, nil
+		},
+		SearchCheckBundlesFunc: func(searchCriteria *apiclient.SearchQueryType, filterCriteria *apiclient.SearchFilterType) (*[]apiclient.CheckBundle, error) {
+			return &[]apiclient.CheckBundle{}, nil
+		},
+		CreateCheckBundleFunc: func(cfg *apiclient.CheckBundle) (*apiclient.CheckBundle, error) {
+			mu.Lock()
+			created++
+			id := created
+			mu.Unlock()
+			bundle := *cfg
+			bundle.CID = fmt.Sprintf("/check_bundle/%d", id)
+			bundle.Status = statusActive
+			bundle.Config = apiclient.CheckBundleConfig{"submission_url": fmt.Sprintf("https://%s:%d/module/httptrap/%d/secret", brokerIP, brokerPort, id)}
+			return &bundle, nil
+		},
+	}
+
+	logger := &LogWrapper{Log: log.New(io.Discard, "", 0)}
+	initTestBrokerList(t, client, logger)
+	fetchBrokers := len(client.FetchBrokersCalls())
+
+	targets := []BatchTarget{
+		{Target: "db1", Tags: apiclient.TagType{"db:db1"}},
+		{Target: "db2", DisplayName: "database two"},
+		{DisplayName: "no target"},
+		{Target: "db3"},
+	}
+	checks, errs := NewBatch(&Config{
+		Client:           client,
+		Logger:           logger,
+		CheckSearchTags:  apiclient.TagType{"service:batch"},
+		BatchConcurrency: 2,
+	}, targets)
+
+	if len(checks) != len(targets) || len(errs) != len(targets) {
+		t.Fatalf("NewBatch() returned %d checks, %d errors, want %d", len(checks), len(errs), len(targets))
+	}
+	for i, target := range targets {
+		if target.Target == "" {
+			if errs[i] == nil || checks[i] != nil {
+				t.Errorf("NewBatch() target %d = %v, %v, want error", i, checks[i], errs[i])
+			}
+			continue
+		}
+		if errs[i] != nil {
+			t.Fatalf("NewBatch() target %s unexpected error: %s", target.Target, errs[i])
+		}
+		bundle, err := checks[i].GetCheckBundle()
+		if err != nil {
+			t.Fatalf("GetCheckBundle() unexpected error: %s", err)
+		}
+		if bundle.Target != target.Target {
+			t.Errorf("target %d bundle target = %s, want %s", i, bundle.Target, target.Target)
+		}
+		if len(bundle.Brokers) != 1 || bundle.Brokers[0] != broker.CID {
+			t.Errorf("target %d bundle brokers = %v, want [%s]", i, bundle.Brokers, broker.CID)
+		}
+		if _, err := checks[i].GetBrokerTLSConfig(); err != nil {
+			t.Errorf("target %d GetBrokerTLSConfig() unexpected error: %s", i, err)
+		}
+	}
+	if checks[1] != nil && checks[1].checkBundle.DisplayName != "database two" {
+		t.Errorf("display name = %s, want database two", checks[1].checkBundle.DisplayName)
+	}
+
+	// shared work is done once, per check work once per valid target
+	if n := len(client.GetCalls()); n != 1 {
+		t.Errorf("Get (ca cert) calls = %d, want 1", n)
+	}
+	if n := len(client.FetchBrokersCalls()) - fetchBrokers; n != 0 {
+		t.Errorf("FetchBrokers calls = %d, want 0", n)
+	}
+	if n := len(client.SearchCheckBundlesCalls()); n != 3 {
+		t.Errorf("SearchCheckBundles calls = %d, want 3", n)
+	}
+	if n := len(client.CreateCheckBundleCalls()); n != 3 {
+		t.Errorf("CreateCheckBundle calls = %d, want 3", n)
+	}
+}
+
+func TestNewBatch_invalid(t *testing.T) {
+	tests := []struct {
+		cfg  *Config
+		name string
+	}{
+		{name: "nil config"},
+		{name: "no api client", cfg: &Config{}},
+		{name: "submission url", cfg: &Config{Client: &APIMock{}, SubmissionURL: "http://127.0.0.1/"}},
+		{name: "check cid", cfg: &Config{Client: &APIMock{}, CheckConfig: &apiclient.CheckBundle{CID: "/check_bundle/123"}}},
+		{name: "batch concurrency", cfg: &Config{Client: &APIMock{}, BatchConcurrency: -1}},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			checks, errs := NewBatch(tt.cfg, []BatchTarget{{Target: "a"}, {Target: "b"}})
+			for i := range errs {
+				if errs[i] == nil || checks[i] != nil {
+					t.Errorf("NewBatch() target %d = %v, %v, want error", i, checks[i], errs[i])
+				}
+			}
+		})
+	}
+}
@@ -6,13 +6,9 @@
 package trapcheck
 
 import (
-	"crypto/rand"
 	"fmt"
-	"math/big"
 	"net"
-	"net/url"
 	"os"
-	"reflect"
 	"strconv"
 	"strings"
 	"time"
@@ -28,6 +24,18 @@ const (
 	defaultBrokerMaxResponseTime = "500ms" // 500 milliseconds
 )
 
+var (
+	// brokerConnectRetryDelay delay between broker connectivity check attempts.
+	brokerConnectRetryDelay = 2 * time.Second
+	// defaultPublicCAHosts submission url hosts using a public CA cert.
+	defaultPublicCAHosts = []string{"api.circonus.com"}
+	// defaultBrokerPortOverrides broker hosts which are always reached on a specific port.
+	defaultBrokerPortOverrides = map[string]string{
+		"trap.noit.circonus.net": "443",
+		"api.circonus.net":       "443",
+	}
+)
+
 func (tc *TrapCheck) fetchBroker(cid, checkType string) error {
 	if cid == "" {
 		return fmt.Errorf("invalid broker cid (empty)")
@@ -37,6 +45,10 @@ func (tc *TrapCheck) fetchBroker(cid, checkType string) error {
 		return fmt.Errorf("invalid check type (empty)")
 	}
 
+	if tc.preselectedBroker != nil && tc.preselectedBroker.CID == cid {
+		return tc.usePreselectedBroker(checkType)
+	}
+
 	if tc.brokerList == nil {
 		if err := tc.initBrokerList(); err != nil {
 			return err
@@ -48,13 +60,50 @@ func (tc *TrapCheck) fetchBroker(cid, checkType string) error {
 		return fmt.Errorf("retrieving broker (%s): %w", cid, err)
 	}
 	if valid, err := tc.isValidBroker(&broker, checkType); !valid {
-		return fmt.Errorf("%s (%s) is an invalid broker for check type %s: %w", broker.Name, tc.checkConfig.Brokers[0], checkType, err)
+		return fmt.Errorf("%s (%s) is an invalid broker for check type %s: %w", broker.Name, cid, checkType, err)
+	}
+	tc.broker = &broker
+	return nil
+}
+
+// usePreselectedBroker verifies (unless already verified, see NewBatch) and uses
+// the caller supplied broker.
+func (tc *TrapCheck) usePreselectedBroker(checkType string) error {
+	broker := *tc.preselectedBroker
+	if !tc.preselectedVerified {
+		if valid, err := tc.isValidBroker(&broker, checkType); !valid {
+			return fmt.Errorf("%s (%s) is an invalid broker for check type %s: %w", broker.Name, broker.CID, checkType, err)
+		}
 	}
 	tc.broker = &broker
+	tc.logWith(nil).Infof("using pre-selected broker '%s'", broker.Name)
 	return nil
 }
 
+// getBroker selects the broker for a new check.
 func (tc *TrapCheck) getBroker(checkType string) error {
+	_, span := tc.startSpan(tc.spanParent(), SpanGetBroker)
+	defer span.end()
+
+	if err := tc.chooseBroker(checkType); err != nil {
+		span.setError(err)
+		return err
+	}
+	span.setString(SpanAttrBrokerCID, tc.brokerCID())
+	if tc.broker != nil {
+		tc.emitEvent(EventBrokerSelected, tc.broker.Name, "", tc.broker.CID)
+	}
+	return nil
+}
+
+func (tc *TrapCheck) chooseBroker(checkType string) error {
+	//
+	// caller supplied broker
+	//
+	if tc.preselectedBroker != nil {
+		return tc.usePreselectedBroker(checkType)
+	}
+
 	//
 	// caller defined specific broker, try to use it
 	//
@@ -71,41 +120,34 @@ func (tc *TrapCheck) getBroker(checkType string) error {
 	//
 	// otherwise, select an applicable broker
 	//
-	var list *[]apiclient.Broker
-
 	if len(tc.brokerSelectTags) > 0 {
-		// filter := apiclient.SearchFilterType{
-		// 	"f__tags_has": tc.brokerSelectTags,
-		// }
-		bl, err := tc.brokerList.SearchBrokerList(tc.brokerSelectTags) // tc.client.SearchBrokers(nil, &filter)
-		if err != nil {
-			return fmt.Errorf("search brokers: %w", err)
+		if err := validateSearchTags(tc.brokerSelectTags); err != nil {
+			return fmt.Errorf("broker select tags: %w", err)
 		}
-		list = bl
-	} else {
-		bl, err := tc.brokerList.GetBrokerList() // tc.client.FetchBrokers()
-		if err != nil {
-			return fmt.Errorf("fetch brokers: %w", err)
-		}
-		list = bl
 	}
 
-	if len(*list) == 0 {
-		return fmt.Errorf("zero brokers found")
+	list, err := tc.selectableBrokers()
+	if err != nil {
+		return err
 	}
 
 	validBrokers := make(map[string]apiclient.Broker)
 	haveEnterprise := false
+	var rejected map[string]string
 
 	for _, broker := range *list {
 		broker := broker
 		valid, err := tc.isValidBroker(&broker, checkType)
-		if err != nil {
-			tc.Log.Debugf("skipping, broker '%s' -- invalid: %s", broker.Name, err)
-			continue
-		}
-		if !valid {
-			tc.Log.Debugf("skipping, broker '%s' -- invalid", broker.Name)
+		if err != nil || !valid {
+			reason := "invalid"
+			if err != nil {
+				reason = err.Error()
+			}
+			tc.logger().Debugf("skipping, broker '%s' (%s) -- %s", broker.Name, broker.CID, reason)
+			if rejected == nil {
+				rejected = make(map[string]string)
+			}
+			rejected[broker.CID] = reason
 			continue
 		}
 		validBrokers[broker.CID] = broker
@@ -114,28 +156,30 @@ func (tc *TrapCheck) getBroker(checkType string) error {
 		}
 	}
 
-	if haveEnterprise { // eliminate non-enterprise brokers from valid brokers
+	switch {
+	case haveEnterprise && !tc.noEnterprisePref: // eliminate non-enterprise brokers from valid brokers
 		for k, v := range validBrokers {
 			if v.Type != enterpriseType {
 				delete(validBrokers, k)
 			}
 		}
+		tc.logger().Infof("broker selection: enterprise brokers preferred, non-enterprise brokers eliminated")
+	case haveEnterprise:
+		tc.logger().Infof("broker selection: enterprise preference disabled, selecting from all %d valid brokers", len(validBrokers))
 	}
 
 	if len(validBrokers) == 0 {
-		return fmt.Errorf("found %d broker(s), zero are valid", len(*list))
+		return &BrokerSelectionError{NumBrokers: len(*list), Reasons: rejected}
 	}
 
-	validBrokerKeys := reflect.ValueOf(validBrokers).MapKeys()
-	maxBrokers := big.NewInt(int64(len(validBrokerKeys)))
-	bidx, err := rand.Int(rand.Reader, maxBrokers)
+	selectedBroker, err := tc.selectBroker(validBrokers, checkType)
 	if err != nil {
-		return fmt.Errorf("rand: %w", err)
+		return err
 	}
-	selectedBroker := validBrokers[validBrokerKeys[bidx.Uint64()].String()]
 
-	tc.Log.Infof("selected broker '%s'", selectedBroker.Name)
 	tc.broker = &selectedBroker
+	tc.logWith(nil).Infof("selected broker '%s'", selectedBroker.Name)
+	tc.logBrokerProbe()
 
 	return nil
 }
@@ -145,9 +189,6 @@ func (tc *TrapCheck) isValidBroker(broker *apiclient.Broker, checkType string) (
 		return false, fmt.Errorf("invalid state, broker (nil)")
 	}
 
-	var brokerHost string
-	var brokerPort string
-
 	if broker.Type != circonusType && broker.Type != enterpriseType {
 		return false, fmt.Errorf("broker '%s' has unknown type (%s)", broker.Name, broker.Type)
 	}
@@ -159,21 +200,30 @@ func (tc *TrapCheck) isValidBroker(broker *apiclient.Broker, checkType string) (
 	httpProxy := os.Getenv("HTTP_PROXY")
 	httpsProxy := os.Getenv("HTTPS_PROXY")
 
+	var ipErr error
+	var reasons []string // why each instance was skipped
+
 	for _, detail := range broker.Details {
 		detail := detail
 
 		// broker must be active
 		if detail.Status != statusActive {
-			tc.Log.Debugf("skipping -- broker '%s' instance '%s' -- not active (%s)", broker.Name, detail.CN, detail.Status)
+			tc.logger().Debugf("skipping -- broker '%s' instance '%s' -- not active (%s)", broker.Name, detail.CN, detail.Status)
+			reasons = append(reasons, fmt.Sprintf("%s: not active (%s)", detail.CN, detail.Status))
 			continue
 		}
 
 		// broker must have module loaded for the check type to be used
 		if ok, err := tc.brokerSupportsCheckType(checkType, &detail); !ok {
-			tc.Log.Debugf("skipping -- broker '%s' instance '%s' -- does not support check type (%s): %s", broker.Name, detail.CN, checkType, err)
+			tc.logger().Debugf("skipping -- broker '%s' instance '%s' -- does not support check type (%s): %s", broker.Name, detail.CN, checkType, err)
+			reasons = append(reasons, fmt.Sprintf("%s: missing module (%s)", detail.CN, checkType))
 			continue
 		}
 
+		// instance details may be partially populated, host and port are per instance
+		var brokerHost string
+		var brokerPort string
+
 		if detail.ExternalPort != 0 {
 			brokerPort = strconv.Itoa(int(detail.ExternalPort))
 		} else {
@@ -185,51 +235,94 @@ func (tc *TrapCheck) isValidBroker(broker *apiclient.Broker, checkType string) (
 		}
 
 		if detail.ExternalHost != nil && *detail.ExternalHost != "" {
-			brokerHost = *detail.ExternalHost
+			brokerHost = unbracketHost(*detail.ExternalHost)
 		} else if detail.IP != nil && *detail.IP != "" {
-			brokerHost = *detail.IP
+			brokerHost = unbracketHost(*detail.IP)
 		}
 
 		if brokerHost == "" {
-			tc.Log.Debugf("skipping -- broker '%s' instance '%s' -- no IP or external host set", broker.Name, detail.CN)
+			tc.logger().Debugf("skipping -- broker '%s' instance '%s' -- no IP or external host set", broker.Name, detail.CN)
+			reasons = append(reasons, fmt.Sprintf("%s: no ip or external host", detail.CN))
 			continue
 		}
 
-		if brokerHost == "trap.noit.circonus.net" && brokerPort != "443" {
-			brokerPort = "443"
-		}
-		if brokerHost == "api.circonus.net" && brokerPort != "443" {
-			brokerPort = "443"
+		if port, ok := tc.brokerPortOverride(brokerHost); ok {
+			brokerPort = port
 		}
 
-		// do not direct connect to test broker, if a proxy env var is set and check is httptrap
+		// do not direct connect to test broker, if a proxy is configured and check is httptrap
 		if strings.Contains(strings.ToLower(checkType), "httptrap") {
-			if httpProxy != "" || httpsProxy != "" {
-				tc.Log.Debugf("skipping connection test, proxy environment var(s) set -- HTTP:'%s' HTTPS:'%s'", httpProxy, httpsProxy)
+			if tc.useProxy(brokerHost) {
+				tc.logger().Debugf("skipping connection test, proxy configured -- %s", tc.proxyURL.Redacted())
+				return true, nil
+			}
+			if tc.proxyURL == nil && (httpProxy != "" || httpsProxy != "") {
+				tc.logger().Debugf("skipping connection test, proxy environment var(s) set -- HTTP:'%s' HTTPS:'%s'", httpProxy, httpsProxy)
 				return true, nil
 			}
 		}
 
+		if err := tc.verifyIPProtocol(brokerHost); err != nil {
+			tc.logger().Warnf("skipping -- broker '%s' instance '%s' -- %s", broker.Name, detail.CN, err)
+			ipErr = err
+			reasons = append(reasons, fmt.Sprintf("%s: %s", detail.CN, err))
+			continue
+		}
+
+		if tc.skipBrokerConnCheck {
+			tc.logger().Infof("broker '%s' instance '%s' -- connectivity check skipped (%s)", broker.Name, detail.CN, net.JoinHostPort(brokerHost, brokerPort))
+			return true, nil
+		}
+
 		retries := 5
-		target := fmt.Sprintf("%s:%s", brokerHost, brokerPort)
+		target := net.JoinHostPort(brokerHost, brokerPort)
+		var dialErr error
 		for attempt := 1; attempt <= retries; attempt++ {
-			// broker must be reachable and respond within designated time
-			conn, err := net.DialTimeout("tcp", target, tc.brokerMaxResponseTime)
+			// broker must be reachable and respond within designated time (0, not enforced)
+			start := tc.clock().Now()
+			conn, err := tc.dialTimeout(brokerHost, brokerPort, tc.brokerMaxResponseTime)
+			dialErr = err
 			if err == nil {
+				connectTime := tc.clock().Since(start)
 				conn.Close()
-				tc.Log.Debugf("broker '%s' instance '%s' -- is valid", broker.Name, detail.CN)
+				tc.recordBrokerProbe(BrokerProbeResult{
+					BrokerCID:       broker.CID,
+					Instance:        detail.CN,
+					Address:         target,
+					ConnectTime:     connectTime,
+					MaxResponseTime: tc.brokerMaxResponseTime,
+				})
+				tc.logger().Debugf("broker '%s' instance '%s' -- is valid, connect time %s", broker.Name, detail.CN, connectTime)
 				return true, nil
 			}
 
-			tc.Log.Debugf("broker '%s' instance '%s' -- unable to connect (%s): %v -- retry in 2s, attempt %d of %d", broker.Name, detail.CN, target, err, attempt, retries)
-			time.Sleep(2 * time.Second)
+			tc.logger().Debugf("broker '%s' instance '%s' -- unable to connect (%s): %v -- retry in %s, attempt %d of %d", broker.Name, detail.CN, target, err, brokerConnectRetryDelay, attempt, retries)
+			tc.clock().Sleep(brokerConnectRetryDelay)
 		}
+		reasons = append(reasons, fmt.Sprintf("%s: unable to connect (%s): %s", detail.CN, target, dialErr))
 	}
 
-	return false, fmt.Errorf("no valid broker instances found")
+	if ipErr != nil {
+		return false, fmt.Errorf("no valid broker instances found (%s): %w", strings.Join(reasons, "; "), ipErr)
+	}
+	return false, fmt.Errorf("no valid broker instances found (%s)", strings.Join(reasons, "; "))
+}
+
+// brokerPortOverride returns the port to use for a broker host, if overridden.
+func (tc *TrapCheck) brokerPortOverride(host string) (string, bool) {
+	overrides := tc.brokerPortOverrides
+	if overrides == nil {
+		overrides = defaultBrokerPortOverrides
+	}
+	port, ok := overrides[host]
+	return port, ok
 }
 
-// Verify broker supports the check type to be used.
+// Verify broker supports the check type to be used. The base module (before
+// the first colon) must be loaded. For extended types (e.g. httptrap:cua:host:linux)
+// the modules are also searched for the extended type, or a prefix of it
+// (e.g. httptrap:cua). If only the base module matched a warning is logged,
+// or with Config.StrictBrokerTypeMatch, the broker is not supported.
 func (tc *TrapCheck) brokerSupportsCheckType(checkType string, details *apiclient.BrokerDetail) (bool, error) {
 	if details == nil {
 		return false, fmt.Errorf("invalid broker details (nil)")
@@ -245,14 +338,30 @@ func (tc *TrapCheck) brokerSupportsCheckType(checkType string, details *apiclien
 		baseType = baseType[0:idx]
 	}
 
+	modules := make(map[string]bool, len(details.Modules))
 	for _, module := range details.Modules {
-		if module == baseType {
+		modules[module] = true
+	}
+
+	if !modules[baseType] {
+		return false, fmt.Errorf("check type '%s' not found in broker modules (%s)", baseType, strings.Join(details.Modules, ","))
+	}
+
+	if baseType == checkType {
+		return true, nil
+	}
+
+	for subType := checkType; subType != baseType; subType = subType[:strings.LastIndex(subType, ":")] {
+		if modules[subType] {
 			return true, nil
 		}
 	}
 
-	return false, fmt.Errorf("check type '%s' not found in broker modules (%s)", baseType, strings.Join(details.Modules, ","))
-
+	if tc.strictBrokerTypeMatch {
+		return false, fmt.Errorf("extended check type '%s' not found in broker modules (%s), only base module '%s'", checkType, strings.Join(details.Modules, ","), baseType)
+	}
+	tc.logger().Warnf("broker instance '%s' -- extended check type '%s' not advertised, only base module '%s' matched", details.CN, checkType, baseType)
+	return true, nil
 }
 
 func (tc *TrapCheck) getBrokerCNList() (string, string, error) {
@@ -262,41 +371,166 @@ func (tc *TrapCheck) getBrokerCNList() (string, string, error) {
 	if tc.checkBundle == nil {
 		return "", "", fmt.Errorf("invalid state, check bundle is nil")
 	}
-	submissionURL := tc.checkBundle.Config[config.SubmissionURL]
-	u, err := url.Parse(submissionURL)
+	su, err := parseSubmissionURL(tc.checkBundle.Config[config.SubmissionURL])
 	if err != nil {
-		return "", "", fmt.Errorf("parse submission URL: %w", err)
+		return "", "", err
 	}
 
-	hostParts := strings.Split(u.Host, ":")
-	host := hostParts[0]
+	if !su.hostIsIP { // it's an FQDN
+		return su.host, "", nil
+	}
 
-	if net.ParseIP(host) == nil { // it's an FQDN (or at the very least, not an ip)
-		return u.Hostname(), "", nil
+	cnList := brokerInstanceCNs(tc.broker, su.host)
+	if len(cnList) == 0 {
+		return "", "", fmt.Errorf("unable to match URL host (%s) to broker instance", su.hostPort())
 	}
 
-	cn := ""
-	cnList := make([]string, 0, len(tc.broker.Details))
-	for _, detail := range tc.broker.Details {
-		if detail.Status != statusActive {
+	return cnList[0], strings.Join(cnList, ","), nil
+}
+
+// selectableBrokers returns the brokers matching the broker select tags, or
+// all brokers if there are no tags. If there are none the broker list is
+// refreshed (at most every 5 minutes) and searched again, the broker may have
+// been added since the list was fetched.
+func (tc *TrapCheck) selectableBrokers() (*[]apiclient.Broker, error) {
+	list, err := tc.listBrokers()
+	if err == nil && len(*list) > 0 {
+		return list, nil
+	}
+	if err != nil {
+		tc.logger().Warnf("%s, refreshing broker list", err)
+	} else {
+		tc.logger().Warnf("no brokers matching tags %v, refreshing broker list", tc.brokerSelectTags)
+	}
+
+	if err := tc.brokerList.RefreshBrokers(); err != nil {
+		return nil, fmt.Errorf("refresh brokers: %w", err)
+	}
+
+	list, err = tc.listBrokers()
+	if err != nil {
+		return nil, err
+	}
+	if len(*list) == 0 {
+		if len(tc.brokerSelectTags) > 0 {
+			return nil, fmt.Errorf("no brokers matching tags %v after refresh", tc.brokerSelectTags)
+		}
+		return nil, fmt.Errorf("zero brokers found after refresh")
+	}
+	return list, nil
+}
+
+// listBrokers returns the cached brokers matching the broker select tags, or
+// all cached brokers if there are no tags.
+func (tc *TrapCheck) listBrokers() (*[]apiclient.Broker, error) {
+	if len(tc.brokerSelectTags) > 0 {
+		list, err := tc.brokerList.SearchBrokerList(tc.brokerSelectTags)
+		if err != nil {
+			return nil, fmt.Errorf("search brokers: %w", err)
+		}
+		return list, nil
+	}
+	list, err := tc.brokerList.GetBrokerList()
+	if err != nil {
+		return nil, fmt.Errorf("fetch brokers: %w", err)
+	}
+	return list, nil
+}
+
+// brokerInstanceCNs returns the CNs of the active broker instances with an
+// ip or external host matching host (instances without a CN are skipped).
+func brokerInstanceCNs(broker *apiclient.Broker, host string) []string {
+	cnList := make([]string, 0, len(broker.Details))
+	for _, detail := range broker.Details {
+		if detail.Status != statusActive || detail.CN == "" {
 			continue
 		}
-		if detail.IP != nil && *detail.IP == host {
-			if cn == "" {
-				cn = detail.CN
-			}
+		if detail.IP != nil && sameHost(*detail.IP, host) {
 			cnList = append(cnList, detail.CN)
-		} else if detail.ExternalHost != nil && *detail.ExternalHost == host {
-			if cn == "" {
-				cn = detail.CN
-			}
+		} else if detail.ExternalHost != nil && sameHost(*detail.ExternalHost, host) {
 			cnList = append(cnList, detail.CN)
 		}
 	}
+	return cnList
+}
 
-	if len(cnList) == 0 {
-		return "", "", fmt.Errorf("unable to match URL host (%s) to broker instance", u.Host)
+// sameHost reports whether two hosts are the same, ip addresses are compared
+// in normalized form (e.g. 2001:db8::1 and [2001:DB8:0:0:0:0:0:1]) and names
+// case insensitively.
+func sameHost(a, b string) bool {
+	a, b = unbracketHost(a), unbracketHost(b)
+	if a == "" || b == "" {
+		return false
+	}
+	if ipA, ipB := net.ParseIP(a), net.ParseIP(b); ipA != nil && ipB != nil {
+		return ipA.Equal(ipB)
+	}
+	return strings.EqualFold(a, b)
+}
+
+// unbracketHost removes the brackets from an ipv6 literal host ([2001:db8::1]).
+func unbracketHost(host string) string {
+	host = strings.TrimSpace(host)
+	if len(host) > 1 && host[0] == '[' && host[len(host)-1] == ']' {
+		return host[1 : len(host)-1]
+	}
+	return host
+}
+
+// fetchCheckBundleBroker fetches the check bundle broker serving the submission
+// url. For bundles with multiple brokers, the broker with an instance matching
+// the submission url host is used, falling back to the first broker.
+func (tc *TrapCheck) fetchCheckBundleBroker() error {
+	if tc.checkBundle == nil {
+		return fmt.Errorf("invalid state, check bundle not initialized")
+	}
+	if len(tc.checkBundle.Brokers) == 0 {
+		return fmt.Errorf("invalid check bundle, 0 brokers")
 	}
 
-	return cn, strings.Join(cnList, ","), nil
+	cid := tc.checkBundle.Brokers[0]
+	if len(tc.checkBundle.Brokers) > 1 {
+		if matched, ok := tc.matchSubmissionURLBroker(); ok {
+			tc.logger().Infof("using check bundle broker %s, an instance matches the submission url host", matched)
+			cid = matched
+		} else {
+			tc.logger().Warnf("no check bundle broker matches submission url host, using first broker %s", cid)
+		}
+	}
+
+	return tc.fetchBroker(cid, tc.checkBundle.Type)
+}
+
+// matchSubmissionURLBroker returns the cid of the first check bundle broker
+// with an instance matching the submission url host.
+func (tc *TrapCheck) matchSubmissionURLBroker() (string, bool) {
+	su, err := parseSubmissionURL(tc.checkBundle.Config[config.SubmissionURL])
+	if err != nil {
+		return "", false
+	}
+	host := su.host
+
+	for _, cid := range tc.checkBundle.Brokers {
+		var broker apiclient.Broker
+		switch {
+		case tc.preselectedBroker != nil && tc.preselectedBroker.CID == cid:
+			broker = *tc.preselectedBroker
+		default:
+			if tc.brokerList == nil {
+				if err := tc.initBrokerList(); err != nil {
+					return "", false
+				}
+			}
+			b, err := tc.brokerList.GetBroker(cid)
+			if err != nil {
+				tc.logger().Debugf("matching submission url broker (%s): %s", cid, err)
+				continue
+			}
+			broker = b
+		}
+		if len(brokerInstanceCNs(&broker, host)) > 0 {
+			return cid, true
+		}
+	}
+	return "", false
 }
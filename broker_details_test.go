@@ -0,0 +1,124 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/circonus-labs/go-apiclient"
+)
+
+// brokerDetailPermutations returns broker instance details with each
+// pointer nil/set, ports zero/set, status active/inactive and cn empty/set.
+func brokerDetailPermutations() []apiclient.BrokerDetail {
+	ip := "127.0.0.1"
+	host := "broker.example.com"
+	zero := uint16(0)
+	port := uint16(43191)
+
+	var details []apiclient.BrokerDetail
+	for _, ipp := range []*string{nil, &ip} {
+		for _, hostp := range []*string{nil, &host} {
+			for _, portp := range []*uint16{nil, &zero, &port} {
+				for _, extPort := range []uint16{0, 443} {
+					for _, status := range []string{statusActive, "provisioned"} {
+						for _, cn := range []string{"", "broker.example.com"} {
+							details = append(details, apiclient.BrokerDetail{
+								CN:           cn,
+								IP:           ipp,
+								ExternalHost: hostp,
+								Port:         portp,
+								ExternalPort: extPort,
+								Status:       status,
+								Modules:      []string{"httptrap"},
+							})
+						}
+					}
+				}
+			}
+		}
+	}
+	return details
+}
+
+func TestBrokerDetails_noPanic(t *testing.T) {
+	caPEM, _, _ := generateTestCA(t, time.Now().Add(24*time.Hour))
+	details := brokerDetailPermutations()
+
+	for i, detail := range details {
+		detail := detail
+		name := fmt.Sprintf("%d_ip=%t_host=%t_port=%v_ext=%d_%s_cn=%t", i, detail.IP != nil, detail.ExternalHost != nil,
+			detail.Port != nil && *detail.Port != 0, detail.ExternalPort, detail.Status, detail.CN != "")
+		t.Run(name, func(t *testing.T) {
+			defer func() {
+				if r := recover(); r != nil {
+					t.Fatalf("panic: %v", r)
+				}
+			}()
+
+			broker := &apiclient.Broker{
+				CID:     "/broker/1",
+				Name:    "test",
+				Type:    circonusType,
+				Details: []apiclient.BrokerDetail{detail},
+			}
+			for _, surl := range []string{"https://127.0.0.1:43191/module/httptrap/abc/secret", "https://broker.example.com/module/httptrap/abc/secret"} {
+				tc := &TrapCheck{
+					broker:              broker,
+					checkBundle:         &apiclient.CheckBundle{Brokers: []string{broker.CID}, Config: apiclient.CheckBundleConfig{"submission_url": surl}},
+					submissionURL:       surl,
+					caCertPEM:           caPEM,
+					skipBrokerConnCheck: true,
+				}
+				tc.Log = &LogWrapper{Log: log.New(io.Discard, "", 0)}
+
+				_, _ = tc.isValidBroker(broker, "httptrap")
+				_, _, _ = tc.getBrokerCNList()
+				if err := tc.setBrokerTLSConfig(); err == nil && tc.tlsConfig != nil {
+					// no peer certificates must not panic either
+					_ = tc.tlsConfig.VerifyConnection(tls.ConnectionState{})
+				}
+				_, _ = tc.matchSubmissionURLBroker()
+			}
+		})
+	}
+
+	// a broker with a mix of partial instances, a host from one instance must not leak into the next
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %s", err)
+	}
+	closedPort := uint16(l.Addr().(*net.TCPAddr).Port)
+	l.Close()
+
+	origDelay := brokerConnectRetryDelay
+	brokerConnectRetryDelay = 0
+	defer func() { brokerConnectRetryDelay = origDelay }()
+
+	ip := "127.0.0.1"
+	tc := &TrapCheck{brokerMaxResponseTime: 100 * time.Millisecond}
+	tc.Log = &LogWrapper{Log: log.New(io.Discard, "", 0)}
+	broker := &apiclient.Broker{
+		CID:  "/broker/1",
+		Name: "mixed",
+		Type: circonusType,
+		Details: []apiclient.BrokerDetail{
+			{CN: "a", IP: &ip, Port: &closedPort, Status: statusActive, Modules: []string{"httptrap"}},
+			{CN: "b", Status: statusActive, Modules: []string{"httptrap"}},
+		},
+	}
+	valid, err := tc.isValidBroker(broker, "httptrap")
+	if valid || err == nil || !strings.Contains(err.Error(), "b: no ip or external host") {
+		t.Errorf("isValidBroker() = %t, %v, want instance b without ip or external host", valid, err)
+	}
+}
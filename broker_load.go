@@ -0,0 +1,195 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/circonus-labs/go-apiclient"
+	"github.com/circonus-labs/go-trapcheck/internal/clock"
+)
+
+// BrokerSelectionStrategy defines how a broker is chosen from the valid
+// brokers when creating a check.
+type BrokerSelectionStrategy string
+
+const (
+	// BrokerSelectionRandom select a valid broker at random (default).
+	BrokerSelectionRandom BrokerSelectionStrategy = "random"
+	// BrokerSelectionSpread select the valid broker hosting the fewest checks
+	// matching the check search tags, ties are broken randomly.
+	BrokerSelectionSpread BrokerSelectionStrategy = "spread"
+)
+
+const defaultBrokerLoadCacheTTL = "5m"
+
+// brokerLoadCache caches the number of checks per broker (by search query)
+// for the process, counting a check search costs an API call.
+type brokerLoadCache struct {
+	entries map[string]brokerLoadEntry
+	sync.Mutex
+}
+
+type brokerLoadEntry struct {
+	fetched time.Time
+	counts  map[string]int
+}
+
+var brokerLoad = &brokerLoadCache{entries: make(map[string]brokerLoadEntry)}
+
+// brokerSelectIndex returns a cryptographically random index in [0, n) used
+// to pick one of the (sorted) valid brokers, replaced in tests.
+var brokerSelectIndex = func(n int) (int, error) {
+	idx, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return 0, fmt.Errorf("rand: %w", err)
+	}
+	return int(idx.Int64()), nil
+}
+
+// get returns a copy of the cached counts for query, if fetched within ttl.
+func (c *brokerLoadCache) get(query string, ttl time.Duration) (map[string]int, bool) {
+	c.Lock()
+	defer c.Unlock()
+	e, ok := c.entries[query]
+	if !ok || clock.Default().Since(e.fetched) >= ttl {
+		return nil, false
+	}
+	counts := make(map[string]int, len(e.counts))
+	for cid, n := range e.counts {
+		counts[cid] = n
+	}
+	return counts, true
+}
+
+func (c *brokerLoadCache) set(query string, counts map[string]int) {
+	c.Lock()
+	defer c.Unlock()
+	c.entries[query] = brokerLoadEntry{fetched: clock.Default().Now(), counts: counts}
+}
+
+// add counts a check created on broker cid, so checks created within the ttl keep spreading.
+func (c *brokerLoadCache) add(query, cid string) {
+	c.Lock()
+	defer c.Unlock()
+	if e, ok := c.entries[query]; ok {
+		e.counts[cid]++
+	}
+}
+
+// brokerLoadQuery returns the search used to count checks per broker.
+func (tc *TrapCheck) brokerLoadQuery(checkType string) (apiclient.SearchQueryType, error) {
+	if err := validateQuotedSearchValue("type", checkType); err != nil {
+		return "", err
+	}
+	if err := validateSearchTags(tc.checkSearchTags); err != nil {
+		return "", err
+	}
+	q := fmt.Sprintf(`(active:1)(type:"%s")`, checkType)
+	if len(tc.checkSearchTags) > 0 {
+		q += fmt.Sprintf("(tags:%s)", strings.Join(tc.checkSearchTags, ","))
+	}
+	return apiclient.SearchQueryType(q), nil
+}
+
+// brokerCheckCounts returns the number of checks matching query per broker cid.
+func (tc *TrapCheck) brokerCheckCounts(query apiclient.SearchQueryType) (map[string]int, error) {
+	if counts, ok := brokerLoad.get(string(query), tc.brokerLoadCacheTTL); ok {
+		return counts, nil
+	}
+
+	bundles, err := tc.client.SearchCheckBundles(&query, nil)
+	if err != nil {
+		return nil, fmt.Errorf("searching check bundles: %w", err)
+	}
+	counts := make(map[string]int)
+	if bundles != nil {
+		for _, b := range *bundles {
+			for _, cid := range b.Brokers {
+				counts[cid]++
+			}
+		}
+	}
+	brokerLoad.set(string(query), counts)
+
+	result := make(map[string]int, len(counts))
+	for cid, n := range counts {
+		result[cid] = n
+	}
+	return result, nil
+}
+
+// selectBroker picks one of the valid brokers using the broker selection strategy.
+func (tc *TrapCheck) selectBroker(valid map[string]apiclient.Broker, checkType string) (apiclient.Broker, error) {
+	cids := make([]string, 0, len(valid))
+	for cid := range valid {
+		cids = append(cids, cid)
+	}
+	sort.Strings(cids)
+
+	var query apiclient.SearchQueryType
+	if tc.brokerSelectStrategy == BrokerSelectionSpread {
+		var err error
+		if query, err = tc.brokerLoadQuery(checkType); err == nil {
+			var counts map[string]int
+			if counts, err = tc.brokerCheckCounts(query); err == nil {
+				cids = preferEnterprise(leastLoaded(cids, counts), valid)
+			}
+		}
+		if err != nil {
+			tc.logger().Warnf("broker load spreading: %s -- selecting randomly", err)
+			query = ""
+		}
+	}
+
+	idx, err := brokerSelectIndex(len(cids))
+	if err != nil {
+		return apiclient.Broker{}, err
+	}
+	broker := valid[cids[idx]]
+	if query != "" {
+		brokerLoad.add(string(query), broker.CID)
+	}
+	return broker, nil
+}
+
+// leastLoaded returns the cids with the fewest checks.
+func leastLoaded(cids []string, counts map[string]int) []string {
+	var least []string
+	min := -1
+	for _, cid := range cids {
+		n := counts[cid]
+		switch {
+		case min == -1 || n < min:
+			min = n
+			least = []string{cid}
+		case n == min:
+			least = append(least, cid)
+		}
+	}
+	return least
+}
+
+// preferEnterprise returns the enterprise broker cids, or all cids if none
+// are enterprise brokers - the enterprise preference as a tiebreaker.
+func preferEnterprise(cids []string, valid map[string]apiclient.Broker) []string {
+	var enterprise []string
+	for _, cid := range cids {
+		if valid[cid].Type == enterpriseType {
+			enterprise = append(enterprise, cid)
+		}
+	}
+	if len(enterprise) == 0 {
+		return cids
+	}
+	return enterprise
+}
@@ -0,0 +1,197 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/circonus-labs/go-apiclient"
+)
+
+func resetBrokerLoadCache() {
+	brokerLoad.Lock()
+	brokerLoad.entries = make(map[string]brokerLoadEntry)
+	brokerLoad.Unlock()
+}
+
+func TestTrapCheck_getBroker_spread(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	tsURL, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatalf("creating test broker: %s", err)
+	}
+	ip := tsURL.Hostname()
+	p, err := strconv.Atoi(tsURL.Port())
+	if err != nil {
+		t.Fatalf("parsing test broker port: %s", err)
+	}
+	port := uint16(p)
+
+	newBroker := func(cid string) apiclient.Broker {
+		return apiclient.Broker{CID: cid, Name: cid, Type: circonusType, Details: []apiclient.BrokerDetail{
+			{CN: cid, Status: statusActive, Modules: []string{"httptrap"}, IP: &ip, Port: &port},
+		}}
+	}
+	brokers := []apiclient.Broker{newBroker("/broker/1"), newBroker("/broker/2"), newBroker("/broker/3")}
+
+	// existing checks: broker 1 has 3, broker 2 has 1, broker 3 has 2
+	existing := []apiclient.CheckBundle{
+		{Brokers: []string{"/broker/1"}},
+		{Brokers: []string{"/broker/1"}},
+		{Brokers: []string{"/broker/1"}},
+		{Brokers: []string{"/broker/2"}},
+		{Brokers: []string{"/broker/3"}},
+		{Brokers: []string{"/broker/3"}},
+	}
+
+	newTC := func(client *APIMock, ttl time.Duration) *TrapCheck {
+		bl := &refreshTestBrokerList{client: client}
+		if err := bl.FetchBrokers(); err != nil {
+			t.Fatalf("FetchBrokers() unexpected error: %s", err)
+		}
+		tc := &TrapCheck{
+			client:                client,
+			brokerMaxResponseTime: 500 * time.Millisecond,
+			brokerList:            bl,
+			brokerSelectStrategy:  BrokerSelectionSpread,
+			brokerLoadCacheTTL:    ttl,
+			checkSearchTags:       apiclient.TagType{"service:spread"},
+		}
+		tc.Log = &LogWrapper{Log: log.New(io.Discard, "", 0)}
+		return tc
+	}
+	newClient := func() *APIMock {
+		return &APIMock{
+			FetchBrokersFunc: func() (*[]apiclient.Broker, error) {
+				list := brokers
+				return &list, nil
+			},
+			SearchCheckBundlesFunc: func(searchCriteria *apiclient.SearchQueryType, filterCriteria *apiclient.SearchFilterType) (*[]apiclient.CheckBundle, error) {
+				list := existing
+				return &list, nil
+			},
+		}
+	}
+
+	t.Run("least loaded, cached", func(t *testing.T) {
+		resetBrokerLoadCache()
+		client := newClient()
+		tc := newTC(client, time.Minute)
+
+		// broker 2 (1 check), then broker 2 or 3 (2 checks each, cached count updated), ...
+		var got []string
+		for i := 0; i < 3; i++ {
+			if err := tc.getBroker("httptrap"); err != nil {
+				t.Fatalf("getBroker() unexpected error: %s", err)
+			}
+			got = append(got, tc.broker.CID)
+		}
+		if got[0] != "/broker/2" {
+			t.Errorf("first selected broker = %s, want /broker/2", got[0])
+		}
+		counts, ok := brokerLoad.get(`(active:1)(type:"httptrap")(tags:service:spread)`, time.Minute)
+		if !ok {
+			t.Fatal("broker load not cached")
+		}
+		if want := map[string]int{"/broker/1": 3, "/broker/2": 3, "/broker/3": 3}; !reflect.DeepEqual(counts, want) {
+			t.Errorf("cached counts = %v, want %v (selections %v)", counts, want, got)
+		}
+		if n := len(client.SearchCheckBundlesCalls()); n != 1 {
+			t.Errorf("SearchCheckBundles calls = %d, want 1", n)
+		}
+		if q := client.SearchCheckBundlesCalls()[0].SearchCriteria; q == nil || *q != `(active:1)(type:"httptrap")(tags:service:spread)` {
+			t.Errorf("search criteria = %v", q)
+		}
+	})
+
+	t.Run("cache expired", func(t *testing.T) {
+		resetBrokerLoadCache()
+		client := newClient()
+		tc := newTC(client, time.Nanosecond)
+		for i := 0; i < 2; i++ {
+			if err := tc.getBroker("httptrap"); err != nil {
+				t.Fatalf("getBroker() unexpected error: %s", err)
+			}
+			if tc.broker.CID != "/broker/2" {
+				t.Errorf("selected broker = %s, want /broker/2", tc.broker.CID)
+			}
+		}
+		if n := len(client.SearchCheckBundlesCalls()); n != 2 {
+			t.Errorf("SearchCheckBundles calls = %d, want 2", n)
+		}
+	})
+
+	t.Run("enterprise preference disabled, tiebreaker", func(t *testing.T) {
+		resetBrokerLoadCache()
+		enterprise := newBroker("/broker/3")
+		enterprise.Type = enterpriseType
+		client := newClient()
+		client.FetchBrokersFunc = func() (*[]apiclient.Broker, error) {
+			return &[]apiclient.Broker{brokers[0], brokers[1], enterprise}, nil
+		}
+		// broker 1 has 2 checks, brokers 2 and 3 have 1 each
+		client.SearchCheckBundlesFunc = func(searchCriteria *apiclient.SearchQueryType, filterCriteria *apiclient.SearchFilterType) (*[]apiclient.CheckBundle, error) {
+			return &[]apiclient.CheckBundle{existing[0], existing[1], existing[3], existing[4]}, nil
+		}
+		tc := newTC(client, time.Minute)
+		tc.noEnterprisePref = true
+		pinBrokerSelection(t, 0) // /broker/2 sorts first, the enterprise broker wins the tie
+		if err := tc.getBroker("httptrap"); err != nil {
+			t.Fatalf("getBroker() unexpected error: %s", err)
+		}
+		if tc.broker.CID != "/broker/3" {
+			t.Errorf("selected broker = %s, want /broker/3", tc.broker.CID)
+		}
+	})
+
+	t.Run("search error, random", func(t *testing.T) {
+		resetBrokerLoadCache()
+		client := newClient()
+		client.SearchCheckBundlesFunc = func(searchCriteria *apiclient.SearchQueryType, filterCriteria *apiclient.SearchFilterType) (*[]apiclient.CheckBundle, error) {
+			return nil, fmt.Errorf("API 500")
+		}
+		tc := newTC(client, time.Minute)
+		if err := tc.getBroker("httptrap"); err != nil {
+			t.Fatalf("getBroker() unexpected error: %s", err)
+		}
+		if tc.broker == nil {
+			t.Fatal("no broker selected")
+		}
+	})
+}
+
+func TestLeastLoaded(t *testing.T) {
+	tests := []struct {
+		counts map[string]int
+		name   string
+		cids   []string
+		want   []string
+	}{
+		{name: "single least", cids: []string{"a", "b", "c"}, counts: map[string]int{"a": 2, "b": 1, "c": 3}, want: []string{"b"}},
+		{name: "tie", cids: []string{"a", "b", "c"}, counts: map[string]int{"a": 2, "b": 1, "c": 1}, want: []string{"b", "c"}},
+		{name: "no checks", cids: []string{"a", "b"}, counts: map[string]int{"a": 2}, want: []string{"b"}},
+		{name: "none counted", cids: []string{"a", "b"}, counts: map[string]int{}, want: []string{"a", "b"}},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			if got := leastLoaded(tt.cids, tt.counts); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("leastLoaded() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// BrokerProbeResult is the connectivity probe of the broker instance which
+// validated the broker, use ConnectTime to tune Config.BrokerMaxResponseTime.
+type BrokerProbeResult struct {
+	// BrokerCID the probed broker
+	BrokerCID string
+	// Instance the CN of the probed broker instance
+	Instance string
+	// Address the probed host:port
+	Address string
+	// ConnectTime measured time to connect to Address
+	ConnectTime time.Duration
+	// MaxResponseTime the enforced limit (Config.BrokerMaxResponseTime), 0 not enforced
+	MaxResponseTime time.Duration
+}
+
+// recordBrokerProbe saves the successful probe of a broker.
+func (tc *TrapCheck) recordBrokerProbe(probe BrokerProbeResult) {
+	if tc.brokerProbes == nil {
+		tc.brokerProbes = make(map[string]BrokerProbeResult)
+	}
+	tc.brokerProbes[probe.BrokerCID] = probe
+}
+
+// brokerProbe returns the probe of the broker in use, if it was probed (e.g.
+// not when restored from state or with SkipBrokerConnectivityCheck).
+func (tc *TrapCheck) brokerProbe() (BrokerProbeResult, bool) {
+	probe, ok := tc.brokerProbes[tc.brokerCID()]
+	return probe, ok
+}
+
+// logBrokerProbe logs the measured connect time of the selected broker.
+func (tc *TrapCheck) logBrokerProbe() {
+	if probe, ok := tc.brokerProbe(); ok {
+		tc.logger().Debugf("broker %s instance '%s' (%s) connect time %s (max %s)",
+			probe.BrokerCID, probe.Instance, probe.Address, probe.ConnectTime, probe.MaxResponseTime)
+	}
+}
+
+// recordSubmitConnectTime saves the connect time of the first successful
+// submission (see ConnInfo.SubmitConnectTime).
+func (tc *TrapCheck) recordSubmitConnectTime(result *TrapResult) {
+	if result.ConnectTime <= 0 {
+		return
+	}
+	atomic.StoreInt64(&tc.submitConnectTime, int64(result.ConnectTime))
+	tc.logger().Debugf("submission endpoint connect time %s", result.ConnectTime)
+}
@@ -0,0 +1,166 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/circonus-labs/go-apiclient"
+	"github.com/circonus-labs/go-trapcheck/testsupport"
+	"github.com/circonus-labs/go-trapcheck/trapchecktest"
+)
+
+// newSlowListener returns a listener which accepts connections and only
+// responds (writes a byte) after delay, see slowDial.
+func newSlowListener(t *testing.T, delay time.Duration) net.Listener {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				time.Sleep(delay)
+				_, _ = conn.Write([]byte{0})
+			}()
+		}
+	}()
+	return l
+}
+
+// slowDial returns a dial func which connects and waits, within timeout (0,
+// no limit), for the slow listener to respond - the connection is only
+// established once it has. The clock is advanced by delay on success, so the
+// measured connect time is deterministic.
+func slowDial(fc *testsupport.FakeClock, delay time.Duration) func(network, address string, timeout time.Duration) (net.Conn, error) {
+	return func(network, address string, timeout time.Duration) (net.Conn, error) {
+		start := time.Now()
+		conn, err := net.DialTimeout(network, address, timeout)
+		if err != nil {
+			return nil, err
+		}
+		if timeout > 0 {
+			_ = conn.SetReadDeadline(start.Add(timeout))
+		}
+		if _, err := conn.Read(make([]byte, 1)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		fc.Advance(delay)
+		return conn, nil
+	}
+}
+
+func TestTrapCheck_isValidBroker_brokerMaxResponseTime(t *testing.T) {
+	tests := []struct {
+		name      string
+		delay     time.Duration
+		maxResp   time.Duration
+		wantValid bool
+	}{
+		{name: "enforced, too slow", delay: 300 * time.Millisecond, maxResp: 50 * time.Millisecond},
+		{name: "enforced, within limit", delay: 50 * time.Millisecond, maxResp: 2 * time.Second, wantValid: true},
+		{name: "not enforced", delay: 300 * time.Millisecond, wantValid: true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			l := newSlowListener(t, tt.delay)
+			host, portStr, err := net.SplitHostPort(l.Addr().String())
+			if err != nil {
+				t.Fatalf("listener address: %v", err)
+			}
+			p, _ := strconv.Atoi(portStr)
+			port := uint16(p)
+
+			fc := testsupport.NewFakeClock(time.Now()) // no real connect retry delays
+			tc := &TrapCheck{
+				brokerMaxResponseTime: tt.maxResp,
+				probeDial:             slowDial(fc, tt.delay),
+				submissionURL:         "http://" + l.Addr().String() + "/module/httptrap/abc/secret",
+				clk:                   fc,
+			}
+			tc.Log = &LogWrapper{Log: log.New(io.Discard, "", log.LstdFlags)}
+
+			broker := &apiclient.Broker{
+				CID:  "/broker/1",
+				Name: "slow",
+				Type: circonusType,
+				Details: []apiclient.BrokerDetail{
+					{CN: "slow.example.com", Status: statusActive, Modules: []string{"httptrap"}, IP: &host, Port: &port},
+				},
+			}
+			valid, err := tc.isValidBroker(broker, "httptrap")
+			if valid != tt.wantValid {
+				t.Fatalf("TrapCheck.isValidBroker() = %t, %v, want %t", valid, err, tt.wantValid)
+			}
+
+			tc.broker = broker
+			info, err := tc.ConnectionInfo()
+			if err != nil {
+				t.Fatalf("TrapCheck.ConnectionInfo() error = %v", err)
+			}
+			probe := info.BrokerProbe
+			if !tt.wantValid {
+				if probe != (BrokerProbeResult{}) {
+					t.Errorf("BrokerProbe = %+v, want none", probe)
+				}
+				return
+			}
+			if probe.BrokerCID != broker.CID || probe.Instance != "slow.example.com" || probe.Address != l.Addr().String() {
+				t.Errorf("BrokerProbe = %+v, want broker %s instance slow.example.com at %s", probe, broker.CID, l.Addr())
+			}
+			if probe.ConnectTime != tt.delay {
+				t.Errorf("BrokerProbe.ConnectTime = %s, want %s", probe.ConnectTime, tt.delay)
+			}
+			if probe.MaxResponseTime != tt.maxResp {
+				t.Errorf("BrokerProbe.MaxResponseTime = %s, want %s", probe.MaxResponseTime, tt.maxResp)
+			}
+		})
+	}
+}
+
+func TestTrapCheck_ConnectionInfo_submitConnectTime(t *testing.T) {
+	fb := trapchecktest.NewFakeBroker(t)
+	tc := newAsyncTestTrapCheck(fb, 0)
+
+	info, err := tc.ConnectionInfo()
+	if err != nil {
+		t.Fatalf("TrapCheck.ConnectionInfo() error = %v", err)
+	}
+	if info.SubmitConnectTime != 0 {
+		t.Errorf("SubmitConnectTime before submitting = %s, want 0", info.SubmitConnectTime)
+	}
+
+	var metrics bytes.Buffer
+	metrics.WriteString(`{"foo":{"_type":"n","_value":1}}`)
+	result, err := tc.SendMetrics(context.Background(), metrics)
+	if err != nil {
+		t.Fatalf("TrapCheck.SendMetrics() error = %v", err)
+	}
+
+	info, err = tc.ConnectionInfo()
+	if err != nil {
+		t.Fatalf("TrapCheck.ConnectionInfo() error = %v", err)
+	}
+	if info.SubmitConnectTime <= 0 || info.SubmitConnectTime != result.ConnectTime {
+		t.Errorf("SubmitConnectTime = %s, want first submission connect time %s", info.SubmitConnectTime, result.ConnectTime)
+	}
+}
@@ -0,0 +1,39 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// BrokerSelectionError is returned (wrapped) when no valid broker could be
+// selected, Reasons contains why each broker (by CID) was rejected.
+type BrokerSelectionError struct {
+	Reasons    map[string]string
+	NumBrokers int
+}
+
+func (e *BrokerSelectionError) Error() string {
+	cids := make([]string, 0, len(e.Reasons))
+	for cid := range e.Reasons {
+		cids = append(cids, cid)
+	}
+	sort.Strings(cids)
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "found %d broker(s), zero are valid", e.NumBrokers)
+	for i, cid := range cids {
+		if i == 0 {
+			sb.WriteString(": ")
+		} else {
+			sb.WriteString(", ")
+		}
+		fmt.Fprintf(&sb, "%s [%s]", cid, e.Reasons[cid])
+	}
+	return sb.String()
+}
@@ -0,0 +1,50 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"fmt"
+	"time"
+)
+
+// BundleCreated returns when the check bundle was created. Bundles constructed
+// locally (e.g. for a custom submission url) have no creation time, an error
+// is returned.
+func (tc *TrapCheck) BundleCreated() (time.Time, error) {
+	tc.stateMu.RLock()
+	defer tc.stateMu.RUnlock()
+	if tc.checkBundle == nil {
+		return time.Time{}, fmt.Errorf("trap check not initialized/created")
+	}
+	if tc.checkBundle.Created == 0 {
+		return time.Time{}, fmt.Errorf("check bundle has no creation time (not fetched from the API)")
+	}
+	return time.Unix(int64(tc.checkBundle.Created), 0), nil
+}
+
+// BundleLastModified returns when the check bundle was last modified, an error
+// is returned if the bundle has no modification time (see BundleCreated).
+func (tc *TrapCheck) BundleLastModified() (time.Time, error) {
+	tc.stateMu.RLock()
+	defer tc.stateMu.RUnlock()
+	if tc.checkBundle == nil {
+		return time.Time{}, fmt.Errorf("trap check not initialized/created")
+	}
+	if tc.checkBundle.LastModified == 0 {
+		return time.Time{}, fmt.Errorf("check bundle has no last modified time (not fetched from the API)")
+	}
+	return time.Unix(int64(tc.checkBundle.LastModified), 0), nil
+}
+
+// BundleAge returns how long ago the check bundle was created - e.g. to
+// decide when a cached bundle (see ExportState) should be re-validated.
+func (tc *TrapCheck) BundleAge() (time.Duration, error) {
+	created, err := tc.BundleCreated()
+	if err != nil {
+		return 0, err
+	}
+	return tc.clock().Since(created), nil
+}
@@ -0,0 +1,120 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/circonus-labs/go-apiclient"
+	"github.com/circonus-labs/go-trapcheck/trapchecktest"
+)
+
+func TestTrapCheck_BundleAge(t *testing.T) {
+	created := time.Now().Add(-48 * time.Hour).Truncate(time.Second)
+	modified := time.Now().Add(-time.Hour).Truncate(time.Second)
+
+	tests := []struct {
+		bundle       *apiclient.CheckBundle
+		name         string
+		wantCreated  time.Time
+		wantModified time.Time
+		wantErr      bool
+	}{
+		{name: "nil bundle", wantErr: true},
+		{name: "synthetic bundle (custom submission url)", bundle: &apiclient.CheckBundle{}, wantErr: true},
+		{
+			name:         "fetched bundle",
+			bundle:       &apiclient.CheckBundle{CID: "/check_bundle/123", Created: uint(created.Unix()), LastModified: uint(modified.Unix())},
+			wantCreated:  created,
+			wantModified: modified,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			tc := &TrapCheck{checkBundle: tt.bundle}
+
+			gotCreated, err := tc.BundleCreated()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("TrapCheck.BundleCreated() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !gotCreated.Equal(tt.wantCreated) {
+				t.Errorf("TrapCheck.BundleCreated() = %s, want %s", gotCreated, tt.wantCreated)
+			}
+
+			gotModified, err := tc.BundleLastModified()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("TrapCheck.BundleLastModified() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !gotModified.Equal(tt.wantModified) {
+				t.Errorf("TrapCheck.BundleLastModified() = %s, want %s", gotModified, tt.wantModified)
+			}
+
+			age, err := tc.BundleAge()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("TrapCheck.BundleAge() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && (age < 48*time.Hour || age > 49*time.Hour) {
+				t.Errorf("TrapCheck.BundleAge() = %s, want ~48h", age)
+			}
+		})
+	}
+}
+
+func TestTrapCheck_BundleAge_created(t *testing.T) {
+	fb := trapchecktest.NewFakeBroker(t)
+	api := trapchecktest.NewFakeAPI(fb)
+	logger := &LogWrapper{Log: log.New(io.Discard, "", log.LstdFlags)}
+	initTestBrokerList(t, api, logger) // broker list is shared, use the fake api brokers
+
+	start := time.Now().Truncate(time.Second)
+	tc, err := New(&Config{
+		Client:      api,
+		CheckConfig: &apiclient.CheckBundle{Brokers: []string{"/broker/1"}, Target: "bundle-age"},
+		Logger:      logger,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if !tc.IsNewCheckBundle() {
+		t.Fatal("IsNewCheckBundle() = false, want created check")
+	}
+	created, err := tc.BundleCreated()
+	if err != nil {
+		t.Fatalf("TrapCheck.BundleCreated() error = %v", err)
+	}
+	if created.Before(start) || created.After(time.Now()) {
+		t.Errorf("TrapCheck.BundleCreated() = %s, want between %s and now", created, start)
+	}
+
+	// timestamps survive the exported (cached) state
+	st, err := tc.ExportState()
+	if err != nil {
+		t.Fatalf("TrapCheck.ExportState() error = %v", err)
+	}
+	data, err := json.Marshal(st)
+	if err != nil {
+		t.Fatalf("json marshal state: %s", err)
+	}
+	var cached State
+	if err := json.Unmarshal(data, &cached); err != nil {
+		t.Fatalf("json unmarshal state: %s", err)
+	}
+	restored, err := NewFromState(&Config{Client: api, Logger: logger}, cached)
+	if err != nil {
+		t.Fatalf("NewFromState() error = %v", err)
+	}
+	if got, err := restored.BundleCreated(); err != nil || !got.Equal(created) {
+		t.Errorf("restored TrapCheck.BundleCreated() = %s (%v), want %s", got, err, created)
+	}
+	if _, err := restored.BundleLastModified(); err != nil {
+		t.Errorf("restored TrapCheck.BundleLastModified() error = %v", err)
+	}
+}
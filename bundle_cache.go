@@ -0,0 +1,115 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/circonus-labs/go-apiclient"
+	"github.com/circonus-labs/go-apiclient/config"
+)
+
+// bundleCacheVersion schema version of the check bundle cache file.
+const bundleCacheVersion = 1
+
+// bundleCache is the content of the check bundle cache file.
+type bundleCache struct {
+	CheckBundle apiclient.CheckBundle `json:"check_bundle"`
+	Version     int                   `json:"version"`
+}
+
+// loadCachedBundle returns the check bundle from the cache file, or nil if
+// there is no usable cached bundle (missing, corrupt, or for another check).
+func (tc *TrapCheck) loadCachedBundle() *apiclient.CheckBundle {
+	if tc.bundleCacheFile == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(tc.bundleCacheFile)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			tc.logger().Warnf("reading check bundle cache (%s): %s -- ignoring", tc.bundleCacheFile, err)
+		}
+		return nil
+	}
+
+	var bc bundleCache
+	if err := json.Unmarshal(data, &bc); err != nil {
+		tc.logger().Warnf("parsing check bundle cache (%s): %s -- ignoring", tc.bundleCacheFile, err)
+		return nil
+	}
+	if bc.Version != bundleCacheVersion {
+		tc.logger().Warnf("check bundle cache (%s) version %d, expected %d -- ignoring", tc.bundleCacheFile, bc.Version, bundleCacheVersion)
+		return nil
+	}
+
+	bundle := bc.CheckBundle
+	switch {
+	case bundle.CID == "":
+		tc.logger().Warnf("check bundle cache (%s) invalid, no cid -- ignoring", tc.bundleCacheFile)
+		return nil
+	case bundle.Type != "" && !strings.HasPrefix(bundle.Type, "httptrap"):
+		tc.logger().Warnf("check bundle cache (%s) invalid, check type must be httptrap variant (%s) -- ignoring", tc.bundleCacheFile, bundle.Type)
+		return nil
+	case bundle.Config[config.SubmissionURL] == "":
+		tc.logger().Warnf("check bundle cache (%s) invalid, no submission url -- ignoring", tc.bundleCacheFile)
+		return nil
+	case tc.checkConfig != nil && tc.checkConfig.CID != "" && tc.checkConfig.CID != bundle.CID:
+		tc.logger().Warnf("check bundle cache (%s) is for %s, configured %s -- ignoring", tc.bundleCacheFile, bundle.CID, tc.checkConfig.CID)
+		return nil
+	}
+
+	return &bundle
+}
+
+// saveCachedBundle writes the check bundle to the cache file (temp file +
+// rename). Failures (e.g. read-only filesystems) are logged, not fatal.
+func (tc *TrapCheck) saveCachedBundle(bundle *apiclient.CheckBundle) {
+	if tc.bundleCacheFile == "" || bundle == nil {
+		return
+	}
+	if err := writeBundleCache(tc.bundleCacheFile, *bundle); err != nil {
+		tc.logger().Warnf("writing check bundle cache: %s -- continuing", err)
+	}
+}
+
+// saveCachedBundleLocked writes the current check bundle to the cache file
+// once stateMu is released (see unlockState). stateMu must be held (write lock).
+func (tc *TrapCheck) saveCachedBundleLocked() {
+	tc.stateSaveBundle = tc.checkBundle
+}
+
+// writeBundleCache atomically writes the check bundle to file.
+func writeBundleCache(file string, bundle apiclient.CheckBundle) error {
+	data, err := json.Marshal(bundleCache{Version: bundleCacheVersion, CheckBundle: bundle})
+	if err != nil {
+		return fmt.Errorf("encoding check bundle: %w", err)
+	}
+
+	tf, err := os.CreateTemp(filepath.Dir(file), "."+filepath.Base(file)+".*")
+	if err != nil {
+		return fmt.Errorf("creating temp file: %w", err)
+	}
+	defer os.Remove(tf.Name()) // no-op after a successful rename
+
+	if _, err := tf.Write(data); err != nil {
+		_ = tf.Close()
+		return fmt.Errorf("writing %s: %w", tf.Name(), err)
+	}
+	if err := tf.Close(); err != nil {
+		return fmt.Errorf("closing %s: %w", tf.Name(), err)
+	}
+	if err := os.Rename(tf.Name(), file); err != nil {
+		return fmt.Errorf("renaming %s: %w", tf.Name(), err)
+	}
+
+	return nil
+}
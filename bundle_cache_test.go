@@ -0,0 +1,220 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/circonus-labs/go-apiclient"
+)
+
+func TestNew_checkBundleCacheFile(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, `{"stats":1}`)
+	}))
+	defer ts.Close()
+
+	tsURL, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatalf("creating test broker: %s", err)
+	}
+	brokerIP := tsURL.Hostname()
+	bp, err := strconv.Atoi(tsURL.Port())
+	if err != nil {
+		t.Fatalf("parsing test broker port: %s", err)
+	}
+	brokerPort := uint16(bp)
+
+	broker := apiclient.Broker{
+		CID:  "/broker/123",
+		Name: "foo",
+		Type: circonusType,
+		Details: []apiclient.BrokerDetail{
+			{
+				Status:  statusActive,
+				Modules: []string{"httptrap"},
+				IP:      &brokerIP,
+				Port:    &brokerPort,
+			},
+		},
+	}
+	apiBundle := apiclient.CheckBundle{
+		CID:     "/check_bundle/123",
+		Brokers: []string{"/broker/123"},
+		Type:    "httptrap",
+		Config:  apiclient.CheckBundleConfig{"submission_url": ts.URL + "/module/httptrap/0c5b9a36-2a2e-4e3c-9c8f-0d1f2a3b4c5d/secret"},
+		Status:  statusActive,
+		Tags:    []string{"api:true"},
+	}
+	cachedBundle := apiBundle
+	cachedBundle.Tags = []string{"cached:true"}
+
+	newClient := func() *APIMock {
+		return &APIMock{
+			FetchCheckBundleFunc: func(cid apiclient.CIDType) (*apiclient.CheckBundle, error) {
+				b := apiBundle
+				return &b, nil
+			},
+			FetchBrokerFunc: func(cid apiclient.CIDType) (*apiclient.Broker, error) {
+				b := broker
+				return &b, nil
+			},
+			FetchBrokersFunc: func() (*[]apiclient.Broker, error) {
+				return &[]apiclient.Broker{broker}, nil
+			},
+		}
+	}
+
+	writeCache := func(t *testing.T, file string, data string) {
+		t.Helper()
+		if err := os.WriteFile(file, []byte(data), 0600); err != nil {
+			t.Fatalf("writing cache file: %s", err)
+		}
+	}
+	cached, err := json.Marshal(bundleCache{Version: bundleCacheVersion, CheckBundle: cachedBundle})
+	if err != nil {
+		t.Fatalf("encoding cache: %s", err)
+	}
+
+	tests := []struct {
+		setup       func(t *testing.T, file string)
+		name        string
+		wantTag     string
+		wantFetches int
+		wantWarn    bool
+		wantFileTag string
+		noDir       bool
+	}{
+		{
+			name:        "cold start",
+			wantTag:     "api:true",
+			wantFetches: 1,
+			wantFileTag: "api:true",
+		},
+		{
+			name:        "warm start",
+			setup:       func(t *testing.T, file string) { writeCache(t, file, string(cached)) },
+			wantTag:     "cached:true",
+			wantFileTag: "cached:true",
+		},
+		{
+			name:        "corrupt file",
+			setup:       func(t *testing.T, file string) { writeCache(t, file, `{"version":1,"check_bundle":`) },
+			wantTag:     "api:true",
+			wantFetches: 1,
+			wantWarn:    true,
+			wantFileTag: "api:true",
+		},
+		{
+			name:        "unknown version",
+			setup:       func(t *testing.T, file string) { writeCache(t, file, `{"version":99,"check_bundle":{}}`) },
+			wantTag:     "api:true",
+			wantFetches: 1,
+			wantWarn:    true,
+			wantFileTag: "api:true",
+		},
+		{
+			name:        "unwritable location",
+			noDir:       true,
+			wantTag:     "api:true",
+			wantFetches: 1,
+			wantWarn:    true,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if tt.noDir {
+				dir = filepath.Join(dir, "missing")
+			}
+			file := filepath.Join(dir, "bundle.json")
+			if tt.setup != nil {
+				tt.setup(t, file)
+			}
+
+			var logs bytes.Buffer
+			client := newClient()
+			tc, err := New(&Config{
+				Client:               client,
+				CheckConfig:          &apiclient.CheckBundle{CID: "/check_bundle/123"},
+				CheckBundleCacheFile: file,
+				Logger:               &LogWrapper{Log: log.New(&logs, "", 0)},
+			})
+			if err != nil {
+				t.Fatalf("New() unexpected error: %s", err)
+			}
+
+			if got := tc.checkBundle.Tags; len(got) != 1 || got[0] != tt.wantTag {
+				t.Errorf("check bundle tags = %v, want [%s]", got, tt.wantTag)
+			}
+			if n := len(client.FetchCheckBundleCalls()); n != tt.wantFetches {
+				t.Errorf("FetchCheckBundle calls = %d, want %d", n, tt.wantFetches)
+			}
+			if warned := strings.Contains(logs.String(), "check bundle cache"); warned != tt.wantWarn {
+				t.Errorf("cache warning logged = %v, want %v (%s)", warned, tt.wantWarn, logs.String())
+			}
+			if tt.wantFileTag != "" {
+				if got := readCachedTag(t, file); got != tt.wantFileTag {
+					t.Errorf("cache file tag = %q, want %q", got, tt.wantFileTag)
+				}
+			}
+		})
+	}
+
+	t.Run("refresh updates file", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "bundle.json")
+		writeCache(t, file, string(cached))
+
+		tc, err := New(&Config{
+			Client:               newClient(),
+			CheckConfig:          &apiclient.CheckBundle{CID: "/check_bundle/123"},
+			CheckBundleCacheFile: file,
+		})
+		if err != nil {
+			t.Fatalf("New() unexpected error: %s", err)
+		}
+		if _, err := tc.RefreshCheckBundle(); err != nil {
+			t.Fatalf("RefreshCheckBundle() unexpected error: %s", err)
+		}
+		if got := readCachedTag(t, file); got != "api:true" {
+			t.Errorf("cache file tag after refresh = %q, want api:true", got)
+		}
+		if matches, _ := filepath.Glob(filepath.Join(filepath.Dir(file), ".bundle.json.*")); len(matches) != 0 {
+			t.Errorf("temp files left behind: %v", matches)
+		}
+	})
+}
+
+func readCachedTag(t *testing.T, file string) string {
+	t.Helper()
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("reading cache file: %s", err)
+	}
+	var bc bundleCache
+	if err := json.Unmarshal(data, &bc); err != nil {
+		t.Fatalf("parsing cache file: %s", err)
+	}
+	if bc.Version != bundleCacheVersion {
+		t.Fatalf("cache file version = %d, want %d", bc.Version, bundleCacheVersion)
+	}
+	if len(bc.CheckBundle.Tags) != 1 {
+		return ""
+	}
+	return bc.CheckBundle.Tags[0]
+}
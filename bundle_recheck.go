@@ -0,0 +1,111 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/circonus-labs/go-apiclient"
+	"github.com/circonus-labs/go-apiclient/config"
+)
+
+const (
+	defaultBundleRecheckInterval = "0s"
+)
+
+// recheckBundle fetches the check bundle, at most once per bundle recheck
+// interval, and updates the local copy if it was modified externally (e.g.
+// tags added by other tooling). The broker tls config is only rebuilt if the
+// submission url or brokers changed. Errors are logged, they do not fail
+// the submission.
+func (tc *TrapCheck) recheckBundle() {
+	if tc.bundleRecheckInterval <= 0 || tc.client == nil {
+		return
+	}
+
+	tc.stateMu.Lock()
+	prev := tc.checkBundle
+	if tc.custSubmissionURL != "" || prev == nil ||
+		(!tc.lastBundleRecheck.IsZero() && tc.clock().Since(tc.lastBundleRecheck) < tc.bundleRecheckInterval) {
+		tc.unlockState()
+		return
+	}
+	tc.lastBundleRecheck = tc.clock().Now()
+	logger := tc.logWith(nil)
+	tc.unlockState()
+
+	cid := prev.CID
+	bundle, err := tc.client.FetchCheckBundle(apiclient.CIDType(&cid))
+	if err != nil {
+		tc.logger().Warnf("rechecking check bundle (%s): %s", cid, err)
+		return
+	}
+	if bundle == nil {
+		tc.logger().Warnf("rechecking check bundle (%s): nil bundle", cid)
+		return
+	}
+	if bundle.LastModified <= prev.LastModified {
+		return
+	}
+
+	changed := bundleChanges(prev, bundle)
+	oldModified := strconv.FormatUint(uint64(prev.LastModified), 10)
+	newModified := strconv.FormatUint(uint64(bundle.LastModified), 10)
+	logger.Infof("check bundle modified externally (last modified %s, was %s), changed: %s",
+		newModified, oldModified, strings.Join(changed, ","))
+
+	if bundle.Config[config.SubmissionURL] != prev.Config[config.SubmissionURL] || !reflect.DeepEqual(bundle.Brokers, prev.Brokers) {
+		// submission path changed, full refresh (broker and tls config)
+		if _, err := tc.refreshCheck(RefreshReasonBundleModified); err != nil {
+			tc.logger().Warnf("refreshing externally modified check bundle (%s): %s", cid, err)
+			return
+		}
+	} else {
+		tc.stateMu.Lock()
+		if tc.checkBundle != prev {
+			tc.unlockState()
+			return // refreshed concurrently
+		}
+		tc.recordRefresh(RefreshReasonBundleModified)
+		tc.checkBundle = bundle
+		tc.saveCachedBundleLocked()
+		tc.unlockState()
+	}
+
+	tc.emitEvent(EventCheckModified, strings.Join(changed, ","), oldModified, newModified)
+}
+
+// bundleChanges returns the names of the settings which differ between the bundles.
+func bundleChanges(a, b *apiclient.CheckBundle) []string {
+	fields := []struct {
+		a, b interface{}
+		name string
+	}{
+		{name: "brokers", a: a.Brokers, b: b.Brokers},
+		{name: "config", a: a.Config, b: b.Config},
+		{name: "display_name", a: a.DisplayName, b: b.DisplayName},
+		{name: "metric_filters", a: a.MetricFilters, b: b.MetricFilters},
+		{name: "metric_limit", a: a.MetricLimit, b: b.MetricLimit},
+		{name: "metrics", a: a.Metrics, b: b.Metrics},
+		{name: "notes", a: a.Notes, b: b.Notes},
+		{name: "period", a: a.Period, b: b.Period},
+		{name: "status", a: a.Status, b: b.Status},
+		{name: "tags", a: a.Tags, b: b.Tags},
+		{name: "target", a: a.Target, b: b.Target},
+		{name: "timeout", a: a.Timeout, b: b.Timeout},
+		{name: "type", a: a.Type, b: b.Type},
+	}
+
+	var changed []string
+	for _, f := range fields {
+		if !reflect.DeepEqual(f.a, f.b) {
+			changed = append(changed, f.name)
+		}
+	}
+	return changed
+}
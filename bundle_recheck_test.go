@@ -0,0 +1,146 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/circonus-labs/go-apiclient"
+	"github.com/circonus-labs/go-trapcheck/trapchecktest"
+)
+
+func TestTrapCheck_recheckBundle(t *testing.T) {
+	fb := trapchecktest.NewFakeBroker(t)
+	fb2 := trapchecktest.NewFakeBroker(t)
+	api := trapchecktest.NewFakeAPI(fb)
+	logger := &LogWrapper{Log: log.New(io.Discard, "", log.LstdFlags)}
+	initTestBrokerList(t, api, logger) // broker list is shared, use the fake api brokers
+
+	var mu sync.Mutex
+	var events []Event
+	tc, err := New(&Config{
+		Client:                api,
+		BundleRecheckInterval: "1h",
+		Logger:                logger,
+		EventHandler: func(ev Event) {
+			mu.Lock()
+			defer mu.Unlock()
+			events = append(events, ev)
+		},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	send := func() {
+		t.Helper()
+		var metrics bytes.Buffer
+		metrics.WriteString(`{"foo":{"_type":"n","_value":1}}`)
+		if _, err := tc.SendMetrics(context.Background(), metrics); err != nil {
+			t.Fatalf("TrapCheck.SendMetrics() error = %v", err)
+		}
+	}
+
+	// tag added by other tooling
+	bundle, err := tc.GetCheckBundle()
+	if err != nil {
+		t.Fatalf("GetCheckBundle() error = %v", err)
+	}
+	bundle.Tags = append(append(apiclient.TagType{}, bundle.Tags...), "team:ops")
+	if _, err := api.UpdateCheckBundle(&bundle); err != nil {
+		t.Fatalf("UpdateCheckBundle() error = %v", err)
+	}
+
+	fetches := api.Calls("FetchCheckBundle")
+	send()
+	if n := api.Calls("FetchCheckBundle") - fetches; n != 1 {
+		t.Errorf("FetchCheckBundle calls = %d, want 1", n)
+	}
+	got, _ := tc.GetCheckBundle()
+	if !reflect.DeepEqual(got.Tags, bundle.Tags) {
+		t.Errorf("check bundle tags = %v, want %v", got.Tags, bundle.Tags)
+	}
+	if n := tc.RefreshStats()[string(RefreshReasonBundleModified)]; n != 1 {
+		t.Errorf("RefreshStats() %s = %d, want 1", RefreshReasonBundleModified, n)
+	}
+
+	// within the interval, no api call
+	send()
+	if n := api.Calls("FetchCheckBundle") - fetches; n != 1 {
+		t.Errorf("FetchCheckBundle calls = %d, want 1 (rate limited)", n)
+	}
+	if n := len(fb.Submissions()); n != 2 {
+		t.Errorf("broker submissions = %d, want 2", n)
+	}
+
+	// check moved to another broker, the submission path is refreshed without a 404
+	api.AddBroker("/broker/2", fb2)
+	if err := api.MoveCheckBundle(got.CID, "/broker/2"); err != nil {
+		t.Fatalf("MoveCheckBundle() error = %v", err)
+	}
+	tc.lastBundleRecheck = tc.lastBundleRecheck.Add(-2 * tc.bundleRecheckInterval)
+	send()
+	if n := len(fb2.Submissions()); n != 1 {
+		t.Errorf("new broker submissions = %d, want 1", n)
+	}
+	if n := len(fb.Submissions()); n != 2 {
+		t.Errorf("old broker submissions = %d, want 2", n)
+	}
+
+	_ = tc.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	var modified []Event
+	for _, ev := range events {
+		if ev.Type == EventCheckModified {
+			modified = append(modified, ev)
+		}
+	}
+	if len(modified) != 2 {
+		t.Fatalf("check modified events = %d, want 2", len(modified))
+	}
+	if modified[0].Detail != "tags" {
+		t.Errorf("check modified event detail = %q, want %q", modified[0].Detail, "tags")
+	}
+	if modified[1].Detail != "brokers,config" {
+		t.Errorf("check modified event detail = %q, want %q", modified[1].Detail, "brokers,config")
+	}
+}
+
+func TestTrapCheck_recheckBundle_disabled(t *testing.T) {
+	client := &APIMock{
+		FetchCheckBundleFunc: func(cid apiclient.CIDType) (*apiclient.CheckBundle, error) {
+			t.Fatal("check bundle should not be fetched")
+			return nil, nil
+		},
+	}
+	tc := &TrapCheck{
+		client:      client,
+		checkBundle: &apiclient.CheckBundle{CID: "/check_bundle/123"},
+	}
+	tc.recheckBundle()
+}
+
+func Test_bundleChanges(t *testing.T) {
+	notes := "foo"
+	a := &apiclient.CheckBundle{Tags: []string{"a:b"}, Period: 60, Notes: &notes}
+	b := &apiclient.CheckBundle{Tags: []string{"a:b", "c:d"}, Period: 60, MetricFilters: [][]string{{"allow", ".", ""}}}
+
+	want := []string{"metric_filters", "notes", "tags"}
+	if got := bundleChanges(a, b); !reflect.DeepEqual(got, want) {
+		t.Errorf("bundleChanges() = %v, want %v", got, want)
+	}
+	if got := bundleChanges(a, a); len(got) != 0 {
+		t.Errorf("bundleChanges() = %v, want none", got)
+	}
+}
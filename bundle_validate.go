@@ -0,0 +1,73 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/circonus-labs/go-apiclient"
+	"github.com/circonus-labs/go-apiclient/config"
+)
+
+// ErrBundleGone is returned (wrapped) by NewFromCheckBundle, with Config.ValidateBundle
+// set, when the check bundle no longer exists - callers may fall back to New.
+var ErrBundleGone = errors.New("check bundle no longer exists")
+
+// isAPINotFound reports whether err is an API 404 response.
+func isAPINotFound(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "API response code 404")
+}
+
+// validateCheckBundle fetches the check bundle from the API and, if its
+// submission url or brokers differ from the supplied bundle, uses the
+// fetched bundle (logging the differences).
+func (tc *TrapCheck) validateCheckBundle() error {
+	if tc.client == nil {
+		return fmt.Errorf("validating check bundle: %w", ErrNoAPIClient)
+	}
+	if tc.checkBundle == nil || tc.checkBundle.CID == "" {
+		return fmt.Errorf("validating check bundle: invalid check bundle, no CID")
+	}
+
+	cid := tc.checkBundle.CID
+	bundle, err := tc.client.FetchCheckBundle(apiclient.CIDType(&cid))
+	if err != nil {
+		if isAPINotFound(err) {
+			return fmt.Errorf("%w: %s", ErrBundleGone, cid)
+		}
+		return fmt.Errorf("validating check bundle, fetching (%s): %w", cid, err)
+	}
+	if bundle == nil {
+		return fmt.Errorf("validating check bundle, fetching (%s): nil bundle", cid)
+	}
+	if bundle.Status == "deleted" {
+		return fmt.Errorf("%w: %s (status %s)", ErrBundleGone, cid, bundle.Status)
+	}
+
+	surl, ok := bundle.Config[config.SubmissionURL]
+	if !ok {
+		return fmt.Errorf("validating check bundle (%s): no submission url found", cid)
+	}
+
+	var diffs []string
+	if surl != tc.submissionURL {
+		diffs = append(diffs, fmt.Sprintf("submission url %s -> %s", redactSubmissionURL(tc.submissionURL), redactSubmissionURL(surl)))
+	}
+	if !reflect.DeepEqual(bundle.Brokers, tc.checkBundle.Brokers) {
+		diffs = append(diffs, fmt.Sprintf("brokers %v -> %v", tc.checkBundle.Brokers, bundle.Brokers))
+	}
+	if len(diffs) == 0 {
+		return nil
+	}
+
+	tc.logWith(nil).Warnf("check bundle %s changed, using current bundle: %s", cid, strings.Join(diffs, ", "))
+	tc.checkBundle = bundle
+	tc.submissionURL = surl
+	return nil
+}
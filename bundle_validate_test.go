@@ -0,0 +1,128 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"reflect"
+	"testing"
+
+	"github.com/circonus-labs/go-apiclient"
+)
+
+func TestNewFromCheckBundle_validateBundle(t *testing.T) {
+	cached := apiclient.CheckBundle{
+		CID:     "/check_bundle/123",
+		Brokers: []string{"/broker/1"},
+		Type:    "httptrap",
+		Config:  apiclient.CheckBundleConfig{"submission_url": "http://127.0.0.1:43191/module/httptrap/abc/secret"},
+		Status:  statusActive,
+	}
+	drifted := cached
+	drifted.Brokers = []string{"/broker/2"}
+	drifted.Config = apiclient.CheckBundleConfig{"submission_url": "http://127.0.0.1:43192/module/httptrap/abc/secret"}
+	deleted := cached
+	deleted.Status = "deleted"
+
+	tests := []struct {
+		fetch       func(cid apiclient.CIDType) (*apiclient.CheckBundle, error)
+		wantErrIs   error
+		name        string
+		wantURL     string
+		wantBrokers []string
+		wantCalls   int
+		validate    bool
+		wantErr     bool
+	}{
+		{
+			name:        "not validated",
+			wantURL:     cached.Config["submission_url"],
+			wantBrokers: cached.Brokers,
+		},
+		{
+			name:        "matching",
+			validate:    true,
+			fetch:       func(cid apiclient.CIDType) (*apiclient.CheckBundle, error) { b := cached; return &b, nil },
+			wantURL:     cached.Config["submission_url"],
+			wantBrokers: cached.Brokers,
+			wantCalls:   1,
+		},
+		{
+			name:        "drifted",
+			validate:    true,
+			fetch:       func(cid apiclient.CIDType) (*apiclient.CheckBundle, error) { b := drifted; return &b, nil },
+			wantURL:     drifted.Config["submission_url"],
+			wantBrokers: drifted.Brokers,
+			wantCalls:   1,
+		},
+		{
+			name:     "deleted, 404",
+			validate: true,
+			fetch: func(cid apiclient.CIDType) (*apiclient.CheckBundle, error) {
+				return nil, fmt.Errorf("API response code 404: {\"code\":\"ObjectError.NotFound\"}")
+			},
+			wantErr:   true,
+			wantErrIs: ErrBundleGone,
+			wantCalls: 1,
+		},
+		{
+			name:      "deleted, status",
+			validate:  true,
+			fetch:     func(cid apiclient.CIDType) (*apiclient.CheckBundle, error) { b := deleted; return &b, nil },
+			wantErr:   true,
+			wantErrIs: ErrBundleGone,
+			wantCalls: 1,
+		},
+		{
+			name:     "api error",
+			validate: true,
+			fetch: func(cid apiclient.CIDType) (*apiclient.CheckBundle, error) {
+				return nil, fmt.Errorf("API response code 500: oops")
+			},
+			wantErr:   true,
+			wantCalls: 1,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			client := &APIMock{FetchCheckBundleFunc: tt.fetch}
+			bundle := cached
+			tc, err := NewFromCheckBundle(&Config{
+				Client:         client,
+				Broker:         &apiclient.Broker{CID: "/broker/1"},
+				ValidateBundle: tt.validate,
+				Logger:         &LogWrapper{Log: log.New(io.Discard, "", 0)},
+			}, &bundle)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewFromCheckBundle() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErrIs != nil && !errors.Is(err, tt.wantErrIs) {
+				t.Errorf("NewFromCheckBundle() error = %v, want %v", err, tt.wantErrIs)
+			}
+			if tt.wantErrIs == nil && errors.Is(err, ErrBundleGone) {
+				t.Errorf("NewFromCheckBundle() error = %v, want not %v", err, ErrBundleGone)
+			}
+			if tt.fetch != nil {
+				if n := len(client.FetchCheckBundleCalls()); n != tt.wantCalls {
+					t.Errorf("FetchCheckBundle calls = %d, want %d", n, tt.wantCalls)
+				}
+			}
+			if tt.wantErr {
+				return
+			}
+			if tc.submissionURL != tt.wantURL {
+				t.Errorf("submission url = %s, want %s", tc.submissionURL, tt.wantURL)
+			}
+			if !reflect.DeepEqual(tc.checkBundle.Brokers, tt.wantBrokers) {
+				t.Errorf("brokers = %v, want %v", tc.checkBundle.Brokers, tt.wantBrokers)
+			}
+		})
+	}
+}
@@ -6,19 +6,33 @@
 package trapcheck
 
 import (
-	"crypto/rand"
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
-	"os"
-	"path/filepath"
+	"io"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/circonus-labs/go-apiclient"
 	"github.com/circonus-labs/go-apiclient/config"
 )
 
-func (tc *TrapCheck) initializeCheck() error {
+func (tc *TrapCheck) initializeCheck() (err error) {
+	ctx, span := tc.startSpan(context.Background(), SpanInitialize)
+	if span != nil {
+		tc.initSpanCtx = ctx // parent of the broker spans
+		defer func() {
+			tc.initSpanCtx = nil
+			if tc.checkBundle != nil {
+				span.setString(SpanAttrCheckCID, tc.checkBundle.CID)
+			}
+			span.setError(err)
+			span.end()
+		}()
+	}
+
 	cfg := tc.checkConfig
 	if cfg == nil {
 		cfg = &apiclient.CheckBundle{}
@@ -31,34 +45,191 @@ func (tc *TrapCheck) initializeCheck() error {
 	return tc.initCheckBundle(cfg)
 }
 
-func (tc *TrapCheck) refreshCheck() (bool, error) {
-	if tc.custSubmissionURL != "" {
+// refreshCheck refreshes the check bundle, concurrent callers (e.g. parallel
+// submissions all receiving a 404) share a single refresh - one API fetch,
+// one refresh recorded and emitted - and all observe its result.
+func (tc *TrapCheck) refreshCheck(reason RefreshReason) (bool, error) {
+	tc.refreshMu.Lock()
+	if f := tc.refreshFlight; f != nil {
+		f.shared++
+		tc.refreshMu.Unlock()
+		<-f.done
+		return f.refreshed, f.err
+	}
+	f := &refreshFlight{done: make(chan struct{})}
+	tc.refreshFlight = f
+	tc.refreshMu.Unlock()
+
+	f.refreshed, f.err = tc.refreshCheckBundle(reason)
+
+	tc.refreshMu.Lock()
+	tc.refreshFlight = nil
+	tc.refreshMu.Unlock()
+	if f.shared > 0 {
+		tc.logger().Debugf("check refresh (%s) shared with %d concurrent callers", reason, f.shared)
+	}
+	close(f.done)
+	return f.refreshed, f.err
+}
+
+// refreshCheckBundle fetches the check bundle and resets the submission url,
+// broker and tls config from it.
+func (tc *TrapCheck) refreshCheckBundle(reason RefreshReason) (bool, error) {
+	if tc.client == nil {
+		return false, fmt.Errorf("refreshing check bundle: %w", ErrNoAPIClient)
+	}
+
+	tc.stateMu.RLock()
+	refreshable, prev := tc.refreshable(), tc.checkBundle
+	logger := tc.logWith(nil)
+	tc.stateMu.RUnlock()
+
+	if !refreshable {
 		return false, nil // custom submission url provided, check can't be refreshed
 	}
-	if tc.checkBundle == nil {
+	if prev == nil {
 		return false, fmt.Errorf("invalid state check bundle nil")
 	}
 
-	cid := tc.checkBundle.CID
+	logger.Warnf("refreshing check bundle (%s)", reason)
+	tc.recordRefresh(reason)
+	tc.refreshMu.Lock()
+	tc.lastRefresh = tc.clock().Now()
+	tc.refreshMu.Unlock()
+
+	cid := prev.CID
 	bundle, err := tc.client.FetchCheckBundle(apiclient.CIDType(&cid))
 	if err != nil {
 		return false, fmt.Errorf("fetching check bundle: %w", err)
 	}
+	if bundle == nil {
+		return false, fmt.Errorf("fetching check bundle (%s): nil bundle", cid)
+	}
+
+	prevBroker, cur, prevModified, err := tc.applyRefreshedBundle(bundle)
+	if err != nil {
+		return false, err
+	}
+	if prevBroker != "" && cur != "" && cur != prevBroker {
+		logger.Warnf("check moved to broker %s (was %s)", cur, prevBroker)
+		tc.emitEvent(EventBrokerChanged, string(reason), prevBroker, cur)
+	}
+	tc.emitEvent(EventCheckRefreshed, string(reason), strconv.FormatUint(uint64(prevModified), 10), strconv.FormatUint(uint64(bundle.LastModified), 10))
+	tc.saveCachedBundle(bundle)
+	tc.resetCircuit()
+	return true, nil
+}
+
+// applyRefreshedBundle replaces the check bundle and resets the submission
+// url, broker and tls config from it. Returns the previous and current
+// broker cids and the previous check bundle last modified time. Submissions in flight keep the state they
+// started with, new submissions wait for the refreshed submission url and
+// tls config.
+func (tc *TrapCheck) applyRefreshedBundle(bundle *apiclient.CheckBundle) (string, string, uint, error) {
+	tc.stateMu.Lock()
+	defer tc.unlockState()
+
+	prevBroker := tc.brokerCID()
+	prevModified := tc.checkBundle.LastModified
 
 	tc.checkBundle = bundle
-	if surl, ok := tc.checkBundle.Config[config.SubmissionURL]; ok {
-		tc.submissionURL = surl
-	} else {
-		return false, fmt.Errorf("no submission url found in check bundle config")
+	surl, ok := tc.checkBundle.Config[config.SubmissionURL]
+	if !ok {
+		return "", "", 0, fmt.Errorf("no submission url found in check bundle config")
 	}
+	if tc.custSubmissionURL != "" {
+		var err error
+		if surl, err = tc.refreshedCustomURL(surl); err != nil {
+			return "", "", 0, err
+		}
+	}
+	tc.submissionURL = surl
 
-	// force refresh of broker and tls config as well
+	// force refresh of broker and tls config as well, the broker
+	// is derived from the refreshed bundle (it may have been moved)
 	tc.tlsConfig = nil
 	tc.broker = nil
-	if err := tc.setBrokerTLSConfig(); err != nil {
-		return false, err
+	tc.clearStaleBrokerPin()
+	if tc.caCertFromState {
+		// cached state proved invalid, use the api for the ca cert as well
+		tc.caCertPEM = nil
+		tc.caCertFromState = false
 	}
-	return true, nil
+	if err := tc.setBrokerTLSConfigLocked(); err != nil {
+		return "", "", 0, err
+	}
+	if err := tc.verifySubmissionURL(); err != nil {
+		return "", "", 0, err
+	}
+	return prevBroker, tc.brokerCID(), prevModified, nil
+}
+
+// refreshable returns true if the check can be refreshed, when a custom
+// submission url is in use only with Config.AllowRefreshWithCustomURL and a
+// check bundle CID.
+func (tc *TrapCheck) refreshable() bool {
+	if tc.agentMode {
+		return false
+	}
+	if tc.custSubmissionURL == "" {
+		return true
+	}
+	return tc.allowCustomRefresh && tc.checkBundle != nil && tc.checkBundle.CID != ""
+}
+
+// refreshedCustomURL returns the submission url to use, with a custom
+// submission url in use, after the check was refreshed - the custom url host
+// with the refreshed bundle path, or the bundle url with
+// Config.RefreshReplacesCustomURL.
+func (tc *TrapCheck) refreshedCustomURL(surl string) (string, error) {
+	if tc.refreshReplacesURL {
+		tc.logWith(nil).Warnf("check refreshed, replacing custom submission url with check bundle submission url %s", redactSubmissionURL(surl))
+		tc.custSubmissionURL = ""
+		return surl, nil
+	}
+	newURL, err := withCustomURLHost(surl, tc.custSubmissionURL)
+	if err != nil {
+		return "", fmt.Errorf("refreshed custom submission url: %w", err)
+	}
+	tc.custSubmissionURL = newURL
+	return newURL, nil
+}
+
+// brokerCID returns the cid of the broker in use, or the check bundle broker
+// if the broker has not been fetched yet.
+func (tc *TrapCheck) brokerCID() string {
+	if tc.broker != nil {
+		return tc.broker.CID
+	}
+	if tc.checkBundle != nil && len(tc.checkBundle.Brokers) > 0 {
+		return tc.checkBundle.Brokers[0]
+	}
+	return ""
+}
+
+// currentCheckBundle returns the check bundle in use. Bundles are replaced,
+// never modified in place, the returned bundle may be read without stateMu.
+func (tc *TrapCheck) currentCheckBundle() *apiclient.CheckBundle {
+	tc.stateMu.RLock()
+	defer tc.stateMu.RUnlock()
+	return tc.checkBundle
+}
+
+// clearStaleBrokerPin clears the check config broker (see getBroker) when it
+// is no longer one of the check bundle brokers, so subsequent operations do
+// not use the broker the check was moved from.
+func (tc *TrapCheck) clearStaleBrokerPin() {
+	if tc.checkConfig == nil || len(tc.checkConfig.Brokers) == 0 || tc.checkBundle == nil {
+		return
+	}
+	pinned := tc.checkConfig.Brokers[0]
+	for _, cid := range tc.checkBundle.Brokers {
+		if cid == pinned {
+			return
+		}
+	}
+	tc.logger().Infof("check config broker %s not used by check bundle %v, clearing", pinned, tc.checkBundle.Brokers)
+	tc.checkConfig.Brokers = nil
 }
 
 func (tc *TrapCheck) initCheckBundle(cfg *apiclient.CheckBundle) error {
@@ -72,22 +243,36 @@ func (tc *TrapCheck) initCheckBundle(cfg *apiclient.CheckBundle) error {
 		return fmt.Errorf("searching for check bundle: %w", err)
 	}
 
-	if !found {
+	if found {
+		tc.emitEvent(EventCheckFound, "", "", tc.checkBundle.CID)
+	} else {
 		if err := tc.createCheckBundle(cfg); err != nil {
 			return err
 		}
+		if tc.checkActiveTimeout > 0 {
+			if err := tc.waitForCheckActive(context.Background(), tc.checkActiveTimeout); err != nil {
+				return err
+			}
+		}
 	}
 
 	return nil
 }
 
+// searchCriteria returns the configured check search criteria, or the
+// default criteria (type, target, and search tags).
+func (tc *TrapCheck) searchCriteria(checkType, target string) (apiclient.SearchQueryType, error) {
+	if tc.checkSearchCriteria != "" {
+		return tc.checkSearchCriteria, nil
+	}
+	return checkSearchCriteria(checkType, target, tc.checkSearchTags)
+}
+
 func (tc *TrapCheck) findCheckBundle(cfg *apiclient.CheckBundle) (bool, error) {
-	// e.g. (active:1)(type:"httptrap:cua:host:linux")(host:"el7-cua-test")(tags:service:circonus-unified-agentd)
-	searchCriteria := apiclient.SearchQueryType(
-		fmt.Sprintf(`(active:1)(type:"%s")(target:"%s")(tags:%s)`,
-			cfg.Type,
-			cfg.Target,
-			strings.Join(tc.checkSearchTags, ",")))
+	searchCriteria, err := tc.searchCriteria(cfg.Type, cfg.Target)
+	if err != nil {
+		return false, err
+	}
 
 	bundles, err := tc.client.SearchCheckBundles(&searchCriteria, nil)
 	if err != nil {
@@ -102,29 +287,87 @@ func (tc *TrapCheck) findCheckBundle(cfg *apiclient.CheckBundle) (bool, error) {
 		tc.newCheckBundle = false // found existing one
 		return true, nil
 	case numBundles > 1:
-		found := 0
-		idx := -1
-		for i, bundle := range *bundles {
+		matches := make([]apiclient.CheckBundle, 0, numBundles)
+		for _, bundle := range *bundles {
 			if bundle.Type == cfg.Type {
-				found++
-				idx = i
+				matches = append(matches, bundle)
 			}
 		}
 		switch {
-		case found == 0:
+		case len(matches) == 0:
 			return false, fmt.Errorf("multiple (%d) bundles found matching '%s' none are type (%s)", numBundles, searchCriteria, cfg.Type)
-		case found == 1:
-			bundle := (*bundles)[idx]
+		case len(matches) == 1:
+			bundle := matches[0]
 			tc.checkBundle = &bundle
+			tc.newCheckBundle = false // found existing one
+			return true, nil
+		default:
+			bundle, err := tc.pickCheckBundle(matches)
+			if err != nil {
+				return false, fmt.Errorf("multiple (%d) check bundles found matching '%s': %w", len(matches), searchCriteria, err)
+			}
+			tc.checkBundle = bundle
+			tc.newCheckBundle = false // found existing one
 			return true, nil
-		case found > 1:
-			return false, fmt.Errorf("multiple (%d) check bundles found matching '%s'", found, searchCriteria)
 		}
 	}
 
 	return false, nil // trigger check create
 }
 
+// pickCheckBundle selects one of multiple matching bundles based on the
+// configured MultipleMatchBehavior, logging the bundles which were skipped.
+func (tc *TrapCheck) pickCheckBundle(bundles []apiclient.CheckBundle) (*apiclient.CheckBundle, error) {
+	cids := make([]string, len(bundles))
+	for i, b := range bundles {
+		cids[i] = b.CID
+	}
+
+	idx := -1
+	switch tc.multipleMatchBehavior {
+	case MultipleMatchOldest, MultipleMatchNewest:
+		sort.SliceStable(bundles, func(i, j int) bool {
+			if bundles[i].Created != bundles[j].Created {
+				return bundles[i].Created < bundles[j].Created
+			}
+			return bundles[i].CID < bundles[j].CID
+		})
+		idx = 0
+		if tc.multipleMatchBehavior == MultipleMatchNewest {
+			idx = len(bundles) - 1
+		}
+	case MultipleMatchTag:
+		for i, b := range bundles {
+			for _, tag := range b.Tags {
+				if tag != tc.multipleMatchTag {
+					continue
+				}
+				if idx != -1 {
+					return nil, fmt.Errorf("multiple bundles have tag (%s): %s", tc.multipleMatchTag, strings.Join(cids, ","))
+				}
+				idx = i
+				break
+			}
+		}
+		if idx == -1 {
+			return nil, fmt.Errorf("no bundles have tag (%s): %s", tc.multipleMatchTag, strings.Join(cids, ","))
+		}
+	default:
+		return nil, fmt.Errorf("%s", strings.Join(cids, ","))
+	}
+
+	bundle := bundles[idx]
+	skipped := make([]string, 0, len(bundles)-1)
+	for i, b := range bundles {
+		if i != idx {
+			skipped = append(skipped, b.CID)
+		}
+	}
+	tc.logger().Warnf("multiple matching check bundles, using %s (%s), skipped: %s", bundle.CID, tc.multipleMatchBehavior, strings.Join(skipped, ","))
+
+	return &bundle, nil
+}
+
 func (tc *TrapCheck) createCheckBundle(cfg *apiclient.CheckBundle) error {
 	if cfg == nil {
 		return fmt.Errorf("invalid check bundle config (nil)")
@@ -148,15 +391,25 @@ func (tc *TrapCheck) createCheckBundle(cfg *apiclient.CheckBundle) error {
 	if err != nil {
 		return fmt.Errorf("create check bundle: %w", err)
 	}
+	if bundle == nil {
+		return fmt.Errorf("create check bundle: nil bundle")
+	}
 	tc.checkBundle = bundle
+	tc.emitEvent(EventCheckCreated, "", "", bundle.CID)
 	return nil
 }
 
 func (tc *TrapCheck) fetchCheckBundle() error {
+	if tc.checkConfig == nil {
+		return fmt.Errorf("invalid state, check config is nil")
+	}
 	bundle, err := tc.client.FetchCheckBundle(&tc.checkConfig.CID)
 	if err != nil {
 		return fmt.Errorf("retrieving check bundle (%s): %w", tc.checkConfig.CID, err)
 	}
+	if bundle == nil {
+		return fmt.Errorf("retrieving check bundle (%s): nil bundle", tc.checkConfig.CID)
+	}
 
 	if bundle.Status != statusActive {
 		return fmt.Errorf("invalid check bundle (%s), not active", bundle.CID)
@@ -173,10 +426,12 @@ func (tc *TrapCheck) fetchCheckBundle() error {
 }
 
 func (tc *TrapCheck) applyCheckBundleDefaults(cfg *apiclient.CheckBundle) error {
-	_, an := filepath.Split(os.Args[0])
-	hn, err := os.Hostname()
-	if err != nil {
-		hn = "unknown"
+	instanceID := tc.checkInstanceID
+	service := instanceID
+	if instanceID == "" {
+		data := newInstanceIDData()
+		instanceID = fmt.Sprintf("%s:%s", data.Hostname, data.App)
+		service = data.App
 	}
 
 	// check type
@@ -192,7 +447,13 @@ func (tc *TrapCheck) applyCheckBundleDefaults(cfg *apiclient.CheckBundle) error
 	// must be set to an empty array...
 	cfg.Metrics = []apiclient.CheckBundleMetric{}
 
-	// metric filters
+	// metric filters (Config.MetricFilters and CheckDefaults.MetricFilters are mutually exclusive)
+	if len(cfg.MetricFilters) == 0 && len(tc.metricFilters) > 0 {
+		cfg.MetricFilters = copyMetricFilters(tc.metricFilters)
+	}
+	if len(cfg.MetricFilters) == 0 && len(tc.checkDefaults.MetricFilters) > 0 {
+		cfg.MetricFilters = copyMetricFilters(tc.checkDefaults.MetricFilters)
+	}
 	if len(cfg.MetricFilters) == 0 {
 		// cfg.MetricFilters = [][]string{{"deny", "^$", ""}, {"allow", "^.+$", ""}}
 		// NOTE: only, allow rule, so a deny is not evaluated by broker
@@ -203,7 +464,7 @@ func (tc *TrapCheck) applyCheckBundleDefaults(cfg *apiclient.CheckBundle) error
 
 	// search tag, and check tags
 	if len(tc.checkSearchTags) == 0 {
-		tc.checkSearchTags = apiclient.TagType{"service:" + an}
+		tc.checkSearchTags = apiclient.TagType{"service:" + service}
 	}
 	// NOTE: not needed, UI/API provide different results - see search above
 	// if strings.Count(cfg.Type, ":") > 0 {
@@ -211,14 +472,12 @@ func (tc *TrapCheck) applyCheckBundleDefaults(cfg *apiclient.CheckBundle) error
 	// 		tc.checkSearchTag = append(tc.checkSearchTag, "ext_type:"+cfg.Type)
 	// 	}
 	// }
-	if len(cfg.Tags) == 0 {
-		cfg.Tags = tc.checkSearchTags
-	} else {
-		cfg.Tags = append(cfg.Tags, tc.checkSearchTags...)
+	if len(cfg.Tags) == 0 && len(tc.checkDefaults.Tags) > 0 {
+		cfg.Tags = append(apiclient.TagType{}, tc.checkDefaults.Tags...)
 	}
+	cfg.Tags = NormalizeTags(append(append(apiclient.TagType{}, cfg.Tags...), tc.checkSearchTags...))
 
 	// display name, target, notes
-	instanceID := fmt.Sprintf("%s:%s", hn, an)
 	if cfg.DisplayName == "" {
 		cfg.DisplayName = instanceID
 	}
@@ -231,9 +490,15 @@ func (tc *TrapCheck) applyCheckBundleDefaults(cfg *apiclient.CheckBundle) error
 	}
 
 	// period & timeout
+	if cfg.Period == 0 {
+		cfg.Period = tc.checkDefaults.Period
+	}
 	if cfg.Period == 0 {
 		cfg.Period = 60
 	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = tc.checkDefaults.Timeout
+	}
 	if cfg.Timeout == 0 {
 		cfg.Timeout = 10
 	}
@@ -250,9 +515,12 @@ func (tc *TrapCheck) applyCheckBundleDefaults(cfg *apiclient.CheckBundle) error
 
 	// submission url secret
 	if val, ok := cfg.Config[config.Secret]; !ok || val == "" {
-		secret, err := makeSecret()
-		if err != nil {
-			secret = "myS3cr3t"
+		secret := tc.checkSecret
+		if secret == "" {
+			var err error
+			if secret, err = makeSecret(); err != nil {
+				return fmt.Errorf("generating check secret: %w", err)
+			}
 		}
 		cfg.Config[config.Secret] = secret
 	}
@@ -264,7 +532,7 @@ func (tc *TrapCheck) applyCheckBundleDefaults(cfg *apiclient.CheckBundle) error
 func makeSecret() (string, error) {
 	hash := sha256.New()
 	x := make([]byte, 2048)
-	if _, err := rand.Read(x); err != nil {
+	if _, err := io.ReadFull(secretRandReader, x); err != nil {
 		return "", fmt.Errorf("rand read: %w", err)
 	}
 	if _, err := hash.Write(x); err != nil {
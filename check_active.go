@@ -0,0 +1,101 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/circonus-labs/go-apiclient"
+	"github.com/circonus-labs/go-apiclient/config"
+)
+
+// ErrCheckNotActive is returned (wrapped) when a check bundle does not become
+// active, with a submission url, within the timeout.
+var ErrCheckNotActive = errors.New("check bundle not active")
+
+const (
+	defaultCheckActiveTimeout = "5s"
+	checkActivePollInterval   = 250 * time.Millisecond
+)
+
+// WaitForCheckActive polls the API until the check bundle is active and has
+// a submission url, or the timeout elapses. Not needed after New, which waits
+// for newly created checks (see Config.CheckActiveTimeout).
+func (tc *TrapCheck) WaitForCheckActive(ctx context.Context, timeout time.Duration) error {
+	if tc.client == nil {
+		return fmt.Errorf("wait for check active: %w", ErrNoAPIClient)
+	}
+	tc.stateMu.RLock()
+	custom := tc.custSubmissionURL != ""
+	tc.stateMu.RUnlock()
+	if custom {
+		return fmt.Errorf("check bundle managed externally, custom submission url in use")
+	}
+	if err := tc.waitForCheckActive(ctx, timeout); err != nil {
+		return err
+	}
+	tc.stateMu.Lock()
+	defer tc.unlockState()
+	if surl := tc.checkBundle.Config[config.SubmissionURL]; surl != tc.submissionURL {
+		tc.submissionURL = surl
+		tc.tlsConfig = nil // rebuilt for the new submission url
+	}
+	return nil
+}
+
+// waitForCheckActive re-fetches the check bundle, backing off between polls,
+// until it is active and has a submission url.
+func (tc *TrapCheck) waitForCheckActive(ctx context.Context, timeout time.Duration) error {
+	current := tc.currentCheckBundle()
+	if current == nil {
+		return fmt.Errorf("invalid state check bundle nil")
+	}
+	if checkBundleActive(current) {
+		return nil
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	cid := current.CID
+	lastStatus := current.Status
+	delay := checkActivePollInterval
+	for attempt := 1; ; attempt++ {
+		tc.logger().Debugf("check bundle (%s) not ready (status: %q), poll %d in %s", cid, lastStatus, attempt, delay)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w: %s status %q after %s", ErrCheckNotActive, cid, lastStatus, timeout)
+		case <-tc.clock().After(delay):
+		}
+
+		bundle, err := tc.client.FetchCheckBundle(apiclient.CIDType(&cid))
+		switch {
+		case err != nil:
+			tc.logger().Debugf("polling check bundle (%s): %s", cid, err)
+		case bundle == nil:
+			tc.logger().Debugf("polling check bundle (%s): nil bundle", cid)
+		default:
+			tc.stateMu.Lock()
+			tc.checkBundle = bundle
+			tc.unlockState()
+			if checkBundleActive(bundle) {
+				return nil
+			}
+			lastStatus = bundle.Status
+		}
+		delay *= 2
+	}
+}
+
+// checkBundleActive returns true if the bundle is active and has a submission url.
+func checkBundleActive(bundle *apiclient.CheckBundle) bool {
+	return bundle.Status == statusActive && bundle.Config[config.SubmissionURL] != ""
+}
@@ -0,0 +1,171 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/circonus-labs/go-apiclient"
+	"github.com/circonus-labs/go-apiclient/config"
+)
+
+func TestNew_waitForCheckActive(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/module/httptrap/1/secret" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprintln(w, `{"stats":1}`)
+	}))
+	defer ts.Close()
+
+	tsURL, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatalf("parsing test broker url: %s", err)
+	}
+	brokerIP := tsURL.Hostname()
+	bp, err := strconv.Atoi(tsURL.Port())
+	if err != nil {
+		t.Fatalf("parsing test broker port: %s", err)
+	}
+	brokerPort := uint16(bp)
+
+	broker := &apiclient.Broker{
+		CID:  "/broker/123",
+		Name: "foo",
+		Type: circonusType,
+		Details: []apiclient.BrokerDetail{
+			{
+				Status:  statusActive,
+				Modules: []string{"httptrap"},
+				IP:      &brokerIP,
+				Port:    &brokerPort,
+			},
+		},
+	}
+
+	tests := []struct {
+		name         string
+		readyAfter   int // fetches before the bundle is active
+		timeout      string
+		wantFetches  int
+		wantNotReady bool
+	}{
+		{name: "active on create", readyAfter: 0, wantFetches: 0},
+		{name: "pending then active", readyAfter: 2, wantFetches: 2},
+		{name: "timeout", readyAfter: 100, timeout: "600ms", wantNotReady: true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			fetches := 0
+			bundle := func(ready bool) *apiclient.CheckBundle {
+				b := &apiclient.CheckBundle{
+					CID:     "/check_bundle/1",
+					Brokers: []string{broker.CID},
+					Type:    "httptrap",
+					Status:  "pending",
+					Config:  apiclient.CheckBundleConfig{},
+				}
+				if ready {
+					b.Status = statusActive
+					b.Config[config.SubmissionURL] = ts.URL + "/module/httptrap/1/secret"
+				}
+				return b
+			}
+			client := &APIMock{
+				SearchCheckBundlesFunc: func(searchCriteria *apiclient.SearchQueryType, filterCriteria *apiclient.SearchFilterType) (*[]apiclient.CheckBundle, error) {
+					return &[]apiclient.CheckBundle{}, nil
+				},
+				CreateCheckBundleFunc: func(cfg *apiclient.CheckBundle) (*apiclient.CheckBundle, error) {
+					return bundle(tt.readyAfter == 0), nil
+				},
+				FetchCheckBundleFunc: func(cid apiclient.CIDType) (*apiclient.CheckBundle, error) {
+					fetches++
+					return bundle(fetches >= tt.readyAfter), nil
+				},
+			}
+
+			tc, err := New(&Config{
+				Client:             client,
+				Broker:             broker,
+				CheckActiveTimeout: tt.timeout,
+			})
+			if tt.wantNotReady {
+				if !errors.Is(err, ErrCheckNotActive) {
+					t.Fatalf("New() error = %v, want %v", err, ErrCheckNotActive)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			if fetches != tt.wantFetches {
+				t.Errorf("FetchCheckBundle calls = %d, want %d", fetches, tt.wantFetches)
+			}
+
+			var metrics bytes.Buffer
+			metrics.WriteString(`{"foo":{"_type":"n","_value":1}}`)
+			if _, err := tc.SendMetrics(context.Background(), metrics); err != nil {
+				t.Fatalf("SendMetrics() error = %v", err)
+			}
+			if stats := tc.RefreshStats(); len(stats) != 0 {
+				t.Errorf("RefreshStats() = %v, want no refreshes", stats)
+			}
+		})
+	}
+}
+
+func TestTrapCheck_WaitForCheckActive(t *testing.T) {
+	fetches := 0
+	client := &APIMock{
+		FetchCheckBundleFunc: func(cid apiclient.CIDType) (*apiclient.CheckBundle, error) {
+			fetches++
+			if fetches == 1 {
+				return nil, fmt.Errorf("api unavailable")
+			}
+			return &apiclient.CheckBundle{
+				CID:    "/check_bundle/1",
+				Status: statusActive,
+				Config: apiclient.CheckBundleConfig{config.SubmissionURL: "http://127.0.0.1:1/new"},
+			}, nil
+		},
+	}
+	tc := &TrapCheck{
+		client:        client,
+		checkBundle:   &apiclient.CheckBundle{CID: "/check_bundle/1", Status: "pending"},
+		submissionURL: "http://127.0.0.1:1/old",
+	}
+	tc.Log = &LogWrapper{
+		Log:   log.New(io.Discard, "", log.LstdFlags),
+		Debug: false,
+	}
+
+	if err := tc.WaitForCheckActive(context.Background(), 5*time.Second); err != nil {
+		t.Fatalf("WaitForCheckActive() error = %v", err)
+	}
+	if fetches != 2 {
+		t.Errorf("FetchCheckBundle calls = %d, want 2", fetches)
+	}
+	if tc.submissionURL != "http://127.0.0.1:1/new" {
+		t.Errorf("submission url = %s, want updated", tc.submissionURL)
+	}
+
+	if err := (&TrapCheck{}).WaitForCheckActive(context.Background(), time.Second); !errors.Is(err, ErrNoAPIClient) {
+		t.Errorf("WaitForCheckActive() error = %v, want %v", err, ErrNoAPIClient)
+	}
+}
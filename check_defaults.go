@@ -0,0 +1,54 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import "fmt"
+
+// CheckDefaults are used when creating a check for settings which are not
+// set in Config.CheckConfig, before the built-in defaults. Zero values use
+// the built-in defaults.
+type CheckDefaults struct {
+	// MetricFilters used if CheckConfig does not set any (default allow all), mutually exclusive
+	// with Config.MetricFilters
+	MetricFilters [][]string
+	// Tags used if CheckConfig does not set any, the check search tags are always added
+	Tags []string
+	// Timeout check timeout in seconds (default 10)
+	Timeout float32
+	// Period check period in seconds (default 60)
+	Period uint
+}
+
+// Validate verifies the check defaults.
+func (cd CheckDefaults) Validate() error {
+	if cd.Timeout < 0 {
+		return fmt.Errorf("invalid timeout (%f), must be >= 0", cd.Timeout)
+	}
+	if cd.Period > 0 && cd.Timeout >= float32(cd.Period) {
+		return fmt.Errorf("invalid timeout (%f), must be less than period (%d)", cd.Timeout, cd.Period)
+	}
+	for _, tag := range cd.Tags {
+		if tag == "" {
+			return fmt.Errorf("invalid tag (empty)")
+		}
+	}
+	if cd.MetricFilters != nil {
+		if err := validateMetricFilters(cd.MetricFilters); err != nil {
+			return fmt.Errorf("metric filters: %w", err)
+		}
+	}
+	return nil
+}
+
+// clone returns a deep copy of the check defaults.
+func (cd CheckDefaults) clone() CheckDefaults {
+	c := cd
+	c.MetricFilters = copyMetricFilters(cd.MetricFilters)
+	if cd.Tags != nil {
+		c.Tags = append([]string(nil), cd.Tags...)
+	}
+	return c
+}
@@ -0,0 +1,142 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"io"
+	"log"
+	"reflect"
+	"testing"
+
+	"github.com/circonus-labs/go-apiclient"
+)
+
+func TestTrapCheck_applyCheckBundleDefaults_checkDefaults(t *testing.T) {
+	allowAll := [][]string{{"allow", ".", ""}}
+	defaultFilters := [][]string{{"deny", "^debug", ""}, {"allow", ".", ""}}
+	cfgFilters := [][]string{{"allow", "^cfg", ""}}
+	bundleFilters := [][]string{{"allow", "^bundle", ""}}
+	searchTags := apiclient.TagType{"service:test"}
+	wantSearchTags := []string{"service:test"}
+
+	tests := []struct {
+		bundle        *apiclient.CheckBundle
+		name          string
+		defaults      CheckDefaults
+		metricFilters [][]string
+		wantFilters   [][]string
+		wantTags      []string
+		wantTimeout   float32
+		wantPeriod    uint
+	}{
+		{
+			name:        "built-in",
+			bundle:      &apiclient.CheckBundle{},
+			wantPeriod:  60,
+			wantTimeout: 10,
+			wantFilters: allowAll,
+			wantTags:    wantSearchTags,
+		},
+		{
+			name:        "check defaults",
+			bundle:      &apiclient.CheckBundle{},
+			defaults:    CheckDefaults{Period: 30, Timeout: 5, MetricFilters: defaultFilters, Tags: []string{"env:prod"}},
+			wantPeriod:  30,
+			wantTimeout: 5,
+			wantFilters: defaultFilters,
+			wantTags:    []string{"env:prod", "service:test"},
+		},
+		{
+			name:          "config metric filters",
+			bundle:        &apiclient.CheckBundle{},
+			metricFilters: cfgFilters,
+			wantPeriod:    60,
+			wantTimeout:   10,
+			wantFilters:   cfgFilters,
+			wantTags:      wantSearchTags,
+		},
+		{
+			name:        "check config wins",
+			bundle:      &apiclient.CheckBundle{Period: 120, Timeout: 20, MetricFilters: bundleFilters, Tags: apiclient.TagType{"team:a"}},
+			defaults:    CheckDefaults{Period: 30, Timeout: 5, MetricFilters: defaultFilters, Tags: []string{"env:prod"}},
+			wantPeriod:  120,
+			wantTimeout: 20,
+			wantFilters: bundleFilters,
+			wantTags:    []string{"team:a", "service:test"},
+		},
+		{
+			name:          "check config wins over config metric filters",
+			bundle:        &apiclient.CheckBundle{MetricFilters: bundleFilters},
+			metricFilters: cfgFilters,
+			wantPeriod:    60,
+			wantTimeout:   10,
+			wantFilters:   bundleFilters,
+			wantTags:      wantSearchTags,
+		},
+		{
+			name:        "partial check defaults",
+			bundle:      &apiclient.CheckBundle{Timeout: 15},
+			defaults:    CheckDefaults{Period: 30, Timeout: 5},
+			wantPeriod:  30,
+			wantTimeout: 15,
+			wantFilters: allowAll,
+			wantTags:    wantSearchTags,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			tc := &TrapCheck{
+				checkSearchTags: searchTags,
+				metricFilters:   copyMetricFilters(tt.metricFilters),
+				checkDefaults:   tt.defaults.clone(),
+			}
+			tc.Log = &LogWrapper{Log: log.New(io.Discard, "", 0)}
+
+			if err := tc.applyCheckBundleDefaults(tt.bundle); err != nil {
+				t.Fatalf("TrapCheck.applyCheckBundleDefaults() unexpected error: %s", err)
+			}
+			if tt.bundle.Period != tt.wantPeriod {
+				t.Errorf("Period = %d, want %d", tt.bundle.Period, tt.wantPeriod)
+			}
+			if tt.bundle.Timeout != tt.wantTimeout {
+				t.Errorf("Timeout = %f, want %f", tt.bundle.Timeout, tt.wantTimeout)
+			}
+			if !reflect.DeepEqual(tt.bundle.MetricFilters, tt.wantFilters) {
+				t.Errorf("MetricFilters = %v, want %v", tt.bundle.MetricFilters, tt.wantFilters)
+			}
+			if !reflect.DeepEqual([]string(tt.bundle.Tags), tt.wantTags) {
+				t.Errorf("Tags = %v, want %v", tt.bundle.Tags, tt.wantTags)
+			}
+		})
+	}
+}
+
+func TestCheckDefaults_Validate(t *testing.T) {
+	tests := []struct {
+		name     string
+		defaults CheckDefaults
+		wantErr  bool
+	}{
+		{name: "valid, zero", defaults: CheckDefaults{}},
+		{name: "valid", defaults: CheckDefaults{Period: 30, Timeout: 5, Tags: []string{"env:prod"}, MetricFilters: [][]string{{"allow", ".", ""}}}},
+		{name: "invalid, negative timeout", defaults: CheckDefaults{Timeout: -1}, wantErr: true},
+		{name: "invalid, timeout >= period", defaults: CheckDefaults{Period: 10, Timeout: 10}, wantErr: true},
+		{name: "invalid, empty tag", defaults: CheckDefaults{Tags: []string{""}}, wantErr: true},
+		{name: "invalid, metric filters", defaults: CheckDefaults{MetricFilters: [][]string{{"deny", ".", ""}}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.defaults.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("CheckDefaults.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err := (&Config{CheckDefaults: tt.defaults}).Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Config.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
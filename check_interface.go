@@ -0,0 +1,142 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/circonus-labs/go-apiclient"
+)
+
+// Check is the public surface of a TrapCheck, consumers can depend on it
+// (rather than *TrapCheck) to substitute a fake (e.g. NopCheck) in tests.
+type Check interface {
+	SendMetrics(ctx context.Context, metrics bytes.Buffer) (*TrapResult, error)
+	GetCheckBundle() (apiclient.CheckBundle, error)
+	RefreshCheckBundle() (apiclient.CheckBundle, error)
+	GetBrokerTLSConfig() (*tls.Config, error)
+	UpdateCheckTags(ctx context.Context, tags []string) (*apiclient.CheckBundle, error)
+	TraceMetrics(trace string) (string, error)
+	IsNewCheckBundle() bool
+}
+
+var _ Check = (*TrapCheck)(nil)
+var _ Check = (*NopCheck)(nil)
+
+// NopCheck is a Check which makes no network or API calls, metrics are
+// accepted and counted. It is safe for concurrent use.
+type NopCheck struct {
+	bundle      apiclient.CheckBundle
+	trace       string
+	submissions int
+	mu          sync.Mutex
+}
+
+// NewNopCheck returns a NopCheck using bundle as the check bundle.
+func NewNopCheck(bundle apiclient.CheckBundle) *NopCheck {
+	return &NopCheck{bundle: bundle}
+}
+
+// SendMetrics accepts the metrics, the result has Stats set to the number
+// of top-level metrics in the payload (0 if it is not a JSON object).
+func (nc *NopCheck) SendMetrics(_ context.Context, metrics bytes.Buffer) (*TrapResult, error) {
+	if metrics.Len() == 0 {
+		return nil, fmt.Errorf("no metrics to submit")
+	}
+
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	nc.submissions++
+
+	result := &TrapResult{
+		Error:             "none",
+		SubmitUUID:        "nop",
+		BytesSent:         metrics.Len(),
+		BytesSentGzip:     metrics.Len(),
+		UncompressedBytes: metrics.Len(),
+	}
+	if len(nc.bundle.CheckUUIDs) > 0 {
+		result.CheckUUID = nc.bundle.CheckUUIDs[0]
+	}
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(metrics.Bytes(), &m); err == nil {
+		result.Stats = uint64(len(m))
+	}
+	return result, nil
+}
+
+// GetCheckBundle returns the check bundle.
+func (nc *NopCheck) GetCheckBundle() (apiclient.CheckBundle, error) {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	return nc.bundle, nil
+}
+
+// RefreshCheckBundle returns the check bundle.
+func (nc *NopCheck) RefreshCheckBundle() (apiclient.CheckBundle, error) {
+	return nc.GetCheckBundle()
+}
+
+// GetBrokerTLSConfig returns a nil tls config (public CA).
+func (nc *NopCheck) GetBrokerTLSConfig() (*tls.Config, error) {
+	return nil, nil
+}
+
+// UpdateCheckTags adds missing tags to the check bundle, returning the updated
+// bundle, or nil if no tags were added.
+func (nc *NopCheck) UpdateCheckTags(_ context.Context, tags []string) (*apiclient.CheckBundle, error) {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+
+	update := false
+	for _, tag := range tags {
+		if tag == "" {
+			continue
+		}
+		found := false
+		for _, ctag := range nc.bundle.Tags {
+			if tag == ctag {
+				found = true
+				break
+			}
+		}
+		if !found {
+			nc.bundle.Tags = append(nc.bundle.Tags, tag)
+			update = true
+		}
+	}
+	if !update {
+		return nil, nil
+	}
+	b := nc.bundle
+	return &b, nil
+}
+
+// TraceMetrics records the trace setting, returning the previous one.
+func (nc *NopCheck) TraceMetrics(trace string) (string, error) {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	curr := nc.trace
+	nc.trace = trace
+	return curr, nil
+}
+
+// IsNewCheckBundle returns false.
+func (nc *NopCheck) IsNewCheckBundle() bool {
+	return false
+}
+
+// Submissions returns the number of SendMetrics calls which were accepted.
+func (nc *NopCheck) Submissions() int {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	return nc.submissions
+}
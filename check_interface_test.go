@@ -0,0 +1,72 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/circonus-labs/go-apiclient"
+)
+
+func TestNopCheck(t *testing.T) {
+	bundle := apiclient.CheckBundle{
+		CID:        "/check_bundle/123",
+		CheckUUIDs: []string{"abc-123"},
+		Tags:       []string{"service:foo"},
+	}
+	var check Check = NewNopCheck(bundle)
+	nc := check.(*NopCheck)
+
+	var metrics bytes.Buffer
+	metrics.WriteString(`{"foo":{"_type":"n","_value":1},"bar":{"_type":"n","_value":2}}`)
+	result, err := check.SendMetrics(context.Background(), metrics)
+	if err != nil {
+		t.Fatalf("SendMetrics() unexpected error: %s", err)
+	}
+	if result.Stats != 2 || result.CheckUUID != "abc-123" || result.BytesSent != metrics.Len() {
+		t.Errorf("SendMetrics() result = %+v", result)
+	}
+	if _, err := check.SendMetrics(context.Background(), bytes.Buffer{}); err == nil {
+		t.Error("SendMetrics() expected error for empty metrics")
+	}
+	if got := nc.Submissions(); got != 1 {
+		t.Errorf("Submissions() = %d, want 1", got)
+	}
+
+	if got, err := check.GetCheckBundle(); err != nil || got.CID != bundle.CID {
+		t.Errorf("GetCheckBundle() = %v, %v", got, err)
+	}
+	if got, err := check.RefreshCheckBundle(); err != nil || got.CID != bundle.CID {
+		t.Errorf("RefreshCheckBundle() = %v, %v", got, err)
+	}
+	if cfg, err := check.GetBrokerTLSConfig(); cfg != nil || err != nil {
+		t.Errorf("GetBrokerTLSConfig() = %v, %v", cfg, err)
+	}
+	if check.IsNewCheckBundle() {
+		t.Error("IsNewCheckBundle() = true")
+	}
+
+	if b, err := check.UpdateCheckTags(context.Background(), []string{"service:foo"}); b != nil || err != nil {
+		t.Errorf("UpdateCheckTags(existing) = %v, %v, want nil, nil", b, err)
+	}
+	b, err := check.UpdateCheckTags(context.Background(), []string{"", "env:test"})
+	if err != nil || b == nil {
+		t.Fatalf("UpdateCheckTags() = %v, %v", b, err)
+	}
+	if want := []string{"service:foo", "env:test"}; !reflect.DeepEqual(b.Tags, want) {
+		t.Errorf("UpdateCheckTags() tags = %v, want %v", b.Tags, want)
+	}
+
+	if prev, err := check.TraceMetrics("-"); prev != "" || err != nil {
+		t.Errorf("TraceMetrics() = %q, %v", prev, err)
+	}
+	if prev, _ := check.TraceMetrics(""); prev != "-" {
+		t.Errorf("TraceMetrics() previous = %q, want -", prev)
+	}
+}
@@ -8,14 +8,47 @@ import (
 	"github.com/circonus-labs/go-apiclient"
 )
 
+// NormalizeTags returns the tags the way the API stores them: whitespace is
+// trimmed (around the tag, category and value), category and value are
+// lowercased, empty tags and duplicates are dropped (the first occurrence
+// is kept). Returns nil if no tags remain.
+func NormalizeTags(tags []string) []string {
+	var normalized []string
+	seen := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		parts := strings.SplitN(tag, ":", 2)
+		empty := true
+		for i := range parts {
+			parts[i] = strings.ToLower(strings.TrimSpace(parts[i]))
+			empty = empty && parts[i] == ""
+		}
+		if empty {
+			continue
+		}
+		t := strings.Join(parts, ":")
+		if seen[t] {
+			continue
+		}
+		seen[t] = true
+		normalized = append(normalized, t)
+	}
+	return normalized
+}
+
+// UpdateCheckTags adds missing tags and modifies tags with the same category
+// but a different value (tags are normalized, see NormalizeTags). The check
+// bundle is only updated via the API if a tag changed.
 func (tc *TrapCheck) UpdateCheckTags(_ context.Context, tags []string) (*apiclient.CheckBundle, error) {
-	if tc.checkBundle == nil {
+	prev := tc.currentCheckBundle()
+	if prev == nil {
 		return nil, fmt.Errorf("invalid state, check bundle is nil")
 	}
+	tags = NormalizeTags(tags)
 	if len(tags) == 0 {
 		return nil, nil
 	}
 
+	current := append([]string(nil), prev.Tags...)
 	update := false
 	for _, tag := range tags {
 		if tag == "" {
@@ -23,7 +56,7 @@ func (tc *TrapCheck) UpdateCheckTags(_ context.Context, tags []string) (*apiclie
 		}
 		found := false
 		tagParts := strings.SplitN(tag, ":", 2)
-		for j, ctag := range tc.checkBundle.Tags {
+		for j, ctag := range current {
 			if tag == ctag {
 				found = true
 				break
@@ -33,8 +66,8 @@ func (tc *TrapCheck) UpdateCheckTags(_ context.Context, tags []string) (*apiclie
 			if len(tagParts) == len(ctagParts) {
 				if tagParts[0] == ctagParts[0] {
 					if tagParts[1] != ctagParts[1] {
-						tc.Log.Warnf("modifying tag: new: %v old: %v", tagParts, ctagParts)
-						tc.checkBundle.Tags[j] = tag
+						tc.logger().Warnf("modifying tag: new: %v old: %v", tagParts, ctagParts)
+						current[j] = tag
 						update = true // but force update since we're modifying a tag
 						found = true
 						break
@@ -44,19 +77,139 @@ func (tc *TrapCheck) UpdateCheckTags(_ context.Context, tags []string) (*apiclie
 			}
 		}
 		if !found {
-			tc.Log.Warnf("adding missing tag: %s curr: %v", tag, tc.checkBundle.Tags)
-			tc.checkBundle.Tags = append(tc.checkBundle.Tags, tag)
+			tc.logger().Warnf("adding missing tag: %s curr: %v", tag, current)
+			current = append(current, tag)
 			update = true
 		}
 	}
 
-	if update {
-		b, err := tc.client.UpdateCheckBundle(tc.checkBundle)
-		if err != nil {
-			return nil, fmt.Errorf("api updating check bundle tags: %w", err)
+	if !update {
+		return nil, nil
+	}
+
+	// the local bundle is only changed once the api accepted the new tags,
+	// so a failed update is retried by the next call
+	if tc.client == nil {
+		return nil, fmt.Errorf("api updating check bundle tags: %w", ErrNoAPIClient)
+	}
+	bundle := *prev
+	bundle.Tags = current
+	b, err := tc.client.UpdateCheckBundle(&bundle)
+	if err != nil {
+		return nil, fmt.Errorf("api updating check bundle tags: %w", err)
+	}
+	tc.stateMu.Lock()
+	if b != nil {
+		updated := *b
+		tc.checkBundle = &updated
+	} else {
+		tc.checkBundle = &bundle
+	}
+	tc.unlockState()
+	return b, nil
+}
+
+// ReconcileCheckTags makes the check bundle tags match the desired tags (normalized,
+// see NormalizeTags): missing tags are added and tags with the same category but a
+// different value are modified. With prune, tags not in desired are removed - except
+// the check search tags, removing them would orphan the check from future searches.
+// The check bundle is only updated via the API if a tag changed, returns nil if not.
+func (tc *TrapCheck) ReconcileCheckTags(_ context.Context, desired []string, prune bool) (*apiclient.CheckBundle, error) {
+	prev := tc.currentCheckBundle()
+	if prev == nil {
+		return nil, fmt.Errorf("invalid state, check bundle is nil")
+	}
+
+	tags, diff := reconcileTags(prev.Tags, NormalizeTags(desired), tc.checkSearchTags, prune)
+	if diff.empty() {
+		tc.logger().Debugf("check tags up to date: %v", prev.Tags)
+		return nil, nil
+	}
+	tc.logger().Infof("updating check tags: added %v modified %v removed %v", diff.added, diff.modified, diff.removed)
+
+	if tc.client == nil {
+		return nil, fmt.Errorf("api updating check bundle tags: %w", ErrNoAPIClient)
+	}
+	bundle := *prev
+	bundle.Tags = tags
+	b, err := tc.client.UpdateCheckBundle(&bundle)
+	if err != nil {
+		return nil, fmt.Errorf("api updating check bundle tags: %w", err)
+	}
+	tc.stateMu.Lock()
+	if b != nil {
+		updated := *b
+		tc.checkBundle = &updated
+	} else {
+		tc.checkBundle = &bundle
+	}
+	tc.unlockState()
+	return b, nil
+}
+
+// tagDiff the changes made by reconcileTags, modified tags are "old -> new".
+type tagDiff struct {
+	added    []string
+	modified []string
+	removed  []string
+}
+
+func (d tagDiff) empty() bool {
+	return len(d.added) == 0 && len(d.modified) == 0 && len(d.removed) == 0
+}
+
+// reconcileTags returns the current tags updated to match desired (see
+// ReconcileCheckTags), protected tags are never modified or removed.
+func reconcileTags(current, desired, protected []string, prune bool) ([]string, tagDiff) {
+	var diff tagDiff
+	tags := append([]string(nil), current...)
+	keep := make(map[int]bool, len(desired))
+	isProtected := make(map[string]bool, len(protected))
+	for _, tag := range protected {
+		isProtected[tag] = true
+	}
+
+	for _, tag := range desired {
+		idx := -1
+		for i, ctag := range tags {
+			if ctag == tag {
+				idx = i
+				break
+			}
+		}
+		if idx == -1 {
+			category := strings.SplitN(tag, ":", 2)[0]
+			for i, ctag := range tags {
+				if keep[i] || isProtected[ctag] || !strings.Contains(ctag, ":") || !strings.Contains(tag, ":") {
+					continue
+				}
+				if strings.SplitN(ctag, ":", 2)[0] == category {
+					diff.modified = append(diff.modified, ctag+" -> "+tag)
+					tags[i] = tag
+					idx = i
+					break
+				}
+			}
+		}
+		if idx == -1 {
+			diff.added = append(diff.added, tag)
+			tags = append(tags, tag)
+			idx = len(tags) - 1
 		}
-		return b, nil
+		keep[idx] = true
 	}
 
-	return nil, nil
+	if !prune {
+		return tags, diff
+	}
+
+	pruned := make([]string, 0, len(tags))
+	for i, tag := range tags {
+		if keep[i] || isProtected[tag] {
+			pruned = append(pruned, tag)
+			continue
+		}
+		diff.removed = append(diff.removed, tag)
+	}
+	return pruned, diff
 }
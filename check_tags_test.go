@@ -2,6 +2,7 @@ package trapcheck
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -111,6 +112,13 @@ func TestTrapCheck_UpdateCheckTags(t *testing.T) {
 				},
 			},
 		},
+		{
+			name:    "no update, mixed case and whitespace",
+			bundle:  &apiclient.CheckBundle{Tags: []string{"foo:bar"}},
+			newTags: []string{" Foo:Bar ", "FOO: bar"},
+			want:    nil,
+			wantErr: false,
+		},
 		{
 			name: "no update, multiple tag formats",
 			bundle: &apiclient.CheckBundle{
@@ -139,3 +147,213 @@ func TestTrapCheck_UpdateCheckTags(t *testing.T) {
 		})
 	}
 }
+
+func TestNormalizeTags(t *testing.T) {
+	tests := []struct {
+		name string
+		tags []string
+		want []string
+	}{
+		{name: "nil"},
+		{name: "empty tags", tags: []string{"", " ", ":"}},
+		{name: "lowercase", tags: []string{"Service:Foo", "QUX"}, want: []string{"service:foo", "qux"}},
+		{name: "trim", tags: []string{" service : foo ", "env:prod\t"}, want: []string{"service:foo", "env:prod"}},
+		{name: "dedupe", tags: []string{"service:foo", "Service:Foo", "env:prod", "service:foo"}, want: []string{"service:foo", "env:prod"}},
+		{name: "value with colons", tags: []string{"URL:HTTP://Example.com:8080"}, want: []string{"url:http://example.com:8080"}},
+		{name: "partial", tags: []string{"baz:", ":bar"}, want: []string{"baz:", ":bar"}},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NormalizeTags(tt.tags); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("NormalizeTags() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTrapCheck_findCheckBundle_mixedCaseSearchTags(t *testing.T) {
+	created := apiclient.CheckBundle{
+		CID:    "/check_bundle/123",
+		Type:   "httptrap",
+		Target: "web01",
+		Status: statusActive,
+		Tags:   []string{"service:foo"},
+	}
+	client := &APIMock{
+		SearchCheckBundlesFunc: func(searchCriteria *apiclient.SearchQueryType, filterCriteria *apiclient.SearchFilterType) (*[]apiclient.CheckBundle, error) {
+			// the api stores (and matches) lowercase tags
+			if *searchCriteria == `(active:1)(type:"httptrap")(target:"web01")(tags:service:foo)` {
+				return &[]apiclient.CheckBundle{created}, nil
+			}
+			return &[]apiclient.CheckBundle{}, nil
+		},
+	}
+
+	cfg := &Config{
+		Client:          client,
+		CheckSearchTags: apiclient.TagType{"Service:Foo", " service:foo"},
+		Logger:          &LogWrapper{Log: log.New(io.Discard, "", log.LstdFlags)},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Config.Validate() error = %v", err)
+	}
+	tc, err := newTrapCheck(cfg)
+	if err != nil {
+		t.Fatalf("newTrapCheck() error = %v", err)
+	}
+	found, err := tc.findCheckBundle(&apiclient.CheckBundle{Type: "httptrap", Target: "web01"})
+	if err != nil {
+		t.Fatalf("findCheckBundle() error = %v", err)
+	}
+	if !found || tc.checkBundle.CID != created.CID {
+		t.Fatalf("findCheckBundle() found = %t, want %s", found, created.CID)
+	}
+
+	// tags of a new check use the same normalized search tags
+	bundle := &apiclient.CheckBundle{Type: "httptrap", Tags: []string{"Env:Prod", "env:prod"}}
+	if err := tc.applyCheckBundleDefaults(bundle); err != nil {
+		t.Fatalf("applyCheckBundleDefaults() error = %v", err)
+	}
+	if want := []string{"env:prod", "service:foo"}; !reflect.DeepEqual([]string(bundle.Tags), want) {
+		t.Errorf("applyCheckBundleDefaults() tags = %q, want %q", bundle.Tags, want)
+	}
+}
+
+func TestTrapCheck_ReconcileCheckTags(t *testing.T) {
+	tests := []struct {
+		name     string
+		current  []string
+		desired  []string
+		want     []string // bundle tags sent to the api, nil if no update
+		prune    bool
+		wantErr  bool
+		apiError bool
+	}{
+		{
+			name:    "pure removal",
+			current: []string{"service:foo", "env:prod", "team:core"},
+			desired: []string{"env:prod"},
+			prune:   true,
+			want:    []string{"service:foo", "env:prod"},
+		},
+		{
+			name:    "search tag protected",
+			current: []string{"service:foo", "env:prod"},
+			desired: []string{"service:bar"},
+			prune:   true,
+			want:    []string{"service:foo", "service:bar"},
+		},
+		{
+			name:    "mixed add, modify and remove",
+			current: []string{"service:foo", "env:dev", "legacy", "team:core"},
+			desired: []string{"Env:Prod", "region:us-east-1", "team:core"},
+			prune:   true,
+			want:    []string{"service:foo", "env:prod", "team:core", "region:us-east-1"},
+		},
+		{
+			name:    "no prune keeps tags",
+			current: []string{"service:foo", "legacy"},
+			desired: []string{"env:prod"},
+			want:    []string{"service:foo", "legacy", "env:prod"},
+		},
+		{
+			name:    "no change",
+			current: []string{"service:foo", "env:prod"},
+			desired: []string{"env:prod"},
+			prune:   true,
+		},
+		{
+			name:     "api error",
+			current:  []string{"service:foo", "legacy"},
+			prune:    true,
+			apiError: true,
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			client := &APIMock{
+				UpdateCheckBundleFunc: func(cfg *apiclient.CheckBundle) (*apiclient.CheckBundle, error) {
+					if tt.apiError {
+						return nil, fmt.Errorf("api error 500")
+					}
+					return cfg, nil
+				},
+			}
+			tc := &TrapCheck{
+				client:          client,
+				checkBundle:     &apiclient.CheckBundle{CID: "/check_bundle/123", Tags: append([]string(nil), tt.current...)},
+				checkSearchTags: apiclient.TagType{"service:foo"},
+			}
+			tc.Log = &LogWrapper{Log: log.New(io.Discard, "", log.LstdFlags)}
+
+			got, err := tc.ReconcileCheckTags(context.Background(), tt.desired, tt.prune)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("TrapCheck.ReconcileCheckTags() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if !reflect.DeepEqual([]string(tc.checkBundle.Tags), tt.current) {
+					t.Errorf("check bundle tags = %q after api error, want unchanged %q", tc.checkBundle.Tags, tt.current)
+				}
+				return
+			}
+			if tt.want == nil {
+				if got != nil || len(client.UpdateCheckBundleCalls()) != 0 {
+					t.Errorf("TrapCheck.ReconcileCheckTags() = %v (%d api calls), want no update", got, len(client.UpdateCheckBundleCalls()))
+				}
+				return
+			}
+			if got == nil || !reflect.DeepEqual([]string(got.Tags), tt.want) {
+				t.Fatalf("TrapCheck.ReconcileCheckTags() = %v, want tags %q", got, tt.want)
+			}
+			if !reflect.DeepEqual([]string(tc.checkBundle.Tags), tt.want) {
+				t.Errorf("check bundle tags = %q, want %q", tc.checkBundle.Tags, tt.want)
+			}
+		})
+	}
+}
+
+func TestTrapCheck_UpdateCheckTags_failedUpdate(t *testing.T) {
+	tests := []struct {
+		client  API
+		name    string
+		wantErr error
+	}{
+		{name: "no api client", wantErr: ErrNoAPIClient},
+		{
+			name: "api error",
+			client: &APIMock{
+				UpdateCheckBundleFunc: func(cfg *apiclient.CheckBundle) (*apiclient.CheckBundle, error) {
+					return nil, fmt.Errorf("api error 500")
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			tc := &TrapCheck{
+				client:      tt.client,
+				checkBundle: &apiclient.CheckBundle{Tags: []string{"foo:bar"}},
+			}
+			tc.Log = &LogWrapper{Log: log.New(io.Discard, "", log.LstdFlags)}
+
+			for i := 0; i < 2; i++ { // still a change on the second call, retried
+				_, err := tc.UpdateCheckTags(context.Background(), []string{"foo:baz", "env:test"})
+				if err == nil || (tt.wantErr != nil && !errors.Is(err, tt.wantErr)) {
+					t.Fatalf("TrapCheck.UpdateCheckTags() error = %v, want %v", err, tt.wantErr)
+				}
+				if want := []string{"foo:bar"}; !reflect.DeepEqual(tc.checkBundle.Tags, want) {
+					t.Fatalf("check bundle tags = %v, want %v (unchanged)", tc.checkBundle.Tags, want)
+				}
+			}
+			if m, ok := tt.client.(*APIMock); ok {
+				if n := len(m.UpdateCheckBundleCalls()); n != 2 {
+					t.Errorf("UpdateCheckBundle calls = %d, want 2", n)
+				}
+			}
+		})
+	}
+}
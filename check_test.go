@@ -853,3 +853,97 @@ func TestTrapCheck_initializeCheck(t *testing.T) {
 		})
 	}
 }
+
+func TestTrapCheck_findCheckBundle_multipleMatchBehavior(t *testing.T) {
+	fixtures := []apiclient.CheckBundle{
+		{CID: "/check_bundle/2", Type: "httptrap", Created: 200, Tags: []string{"service:foo"}},
+		{CID: "/check_bundle/1", Type: "httptrap", Created: 100, Tags: []string{"service:foo", "primary:true"}},
+		{CID: "/check_bundle/3", Type: "httptrap", Created: 300, Tags: []string{"service:foo"}},
+		{CID: "/check_bundle/4", Type: "json", Created: 50},
+	}
+	client := &APIMock{
+		SearchCheckBundlesFunc: func(searchCriteria *apiclient.SearchQueryType, filterCriteria *apiclient.SearchFilterType) (*[]apiclient.CheckBundle, error) {
+			bundles := make([]apiclient.CheckBundle, len(fixtures))
+			copy(bundles, fixtures)
+			return &bundles, nil
+		},
+	}
+
+	tests := []struct {
+		name     string
+		behavior MultipleMatchBehavior
+		tag      string
+		wantCID  string
+		wantErr  bool
+	}{
+		{name: "default", wantErr: true},
+		{name: "error", behavior: MultipleMatchError, wantErr: true},
+		{name: "oldest", behavior: MultipleMatchOldest, wantCID: "/check_bundle/1"},
+		{name: "newest", behavior: MultipleMatchNewest, wantCID: "/check_bundle/3"},
+		{name: "tag", behavior: MultipleMatchTag, tag: "primary:true", wantCID: "/check_bundle/1"},
+		{name: "tag, none", behavior: MultipleMatchTag, tag: "primary:false", wantErr: true},
+		{name: "tag, multiple", behavior: MultipleMatchTag, tag: "service:foo", wantErr: true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			tc := &TrapCheck{
+				client:                client,
+				multipleMatchBehavior: tt.behavior,
+				multipleMatchTag:      tt.tag,
+				newCheckBundle:        true,
+			}
+			tc.Log = &LogWrapper{
+				Log:   log.New(io.Discard, "", log.LstdFlags),
+				Debug: false,
+			}
+			found, err := tc.findCheckBundle(&apiclient.CheckBundle{Type: "httptrap", Target: "foo"})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("TrapCheck.findCheckBundle() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !found {
+				t.Fatal("TrapCheck.findCheckBundle() not found")
+			}
+			if tc.checkBundle.CID != tt.wantCID {
+				t.Errorf("TrapCheck.findCheckBundle() = %s, want %s", tc.checkBundle.CID, tt.wantCID)
+			}
+			if tc.newCheckBundle {
+				t.Error("TrapCheck.findCheckBundle() newCheckBundle = true, want false")
+			}
+		})
+	}
+}
+
+func TestTrapCheck_nilBundleFromAPI(t *testing.T) {
+	client := &APIMock{
+		FetchCheckBundleFunc: func(cid apiclient.CIDType) (*apiclient.CheckBundle, error) {
+			return nil, nil
+		},
+		CreateCheckBundleFunc: func(cfg *apiclient.CheckBundle) (*apiclient.CheckBundle, error) {
+			return nil, nil
+		},
+	}
+	tc := &TrapCheck{
+		client:       client,
+		checkBundle:  &apiclient.CheckBundle{CID: "/check_bundle/123"},
+		checkConfig:  &apiclient.CheckBundle{CID: "/check_bundle/123", Type: "httptrap", Brokers: []string{"/broker/1"}},
+		refreshStats: map[RefreshReason]uint64{},
+	}
+	tc.Log = &LogWrapper{
+		Log:   log.New(io.Discard, "", log.LstdFlags),
+		Debug: false,
+	}
+
+	if _, err := tc.refreshCheck(RefreshReasonManual); err == nil {
+		t.Error("refreshCheck() expected error")
+	}
+	if err := tc.fetchCheckBundle(); err == nil {
+		t.Error("fetchCheckBundle() expected error")
+	}
+	if err := tc.createCheckBundle(tc.checkConfig); err == nil {
+		t.Error("createCheckBundle() expected error")
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// GetCheckUIURL returns a link to the check in the Circonus UI, e.g.
+// https://<account>.circonus.com/checks/<id>. The check is the one on the
+// selected broker if the bundle has checks on multiple brokers, otherwise the
+// first check in the bundle. baseURL is the UI base url (http or https).
+func (tc *TrapCheck) GetCheckUIURL(baseURL string) (string, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return "", fmt.Errorf("parsing base url: %w", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("invalid base url (%s), must be an absolute http or https url", baseURL)
+	}
+
+	tc.stateMu.RLock()
+	checkCID := tc.checkCID()
+	tc.stateMu.RUnlock()
+	if checkCID == "" {
+		return "", fmt.Errorf("check bundle has no checks (call RefreshCheckBundle to reload it)")
+	}
+	id := strings.TrimPrefix(checkCID, "/check/")
+	if id == checkCID || id == "" || strings.Contains(id, "/") {
+		return "", fmt.Errorf("invalid check cid (%s)", checkCID)
+	}
+
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/checks/" + id
+	u.RawPath = ""
+	u.RawQuery = ""
+	u.Fragment = ""
+	return u.String(), nil
+}
+
+// checkCID returns the cid of the check on the selected broker, or the first
+// check in the bundle, an empty string if the bundle has no checks.
+func (tc *TrapCheck) checkCID() string {
+	if tc.checkBundle == nil || len(tc.checkBundle.Checks) == 0 {
+		return ""
+	}
+	if tc.broker != nil && tc.broker.CID != "" {
+		for i, brokerCID := range tc.checkBundle.Brokers {
+			if brokerCID == tc.broker.CID && i < len(tc.checkBundle.Checks) {
+				return tc.checkBundle.Checks[i]
+			}
+		}
+	}
+	return tc.checkBundle.Checks[0]
+}
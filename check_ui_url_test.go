@@ -0,0 +1,64 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"testing"
+
+	"github.com/circonus-labs/go-apiclient"
+)
+
+func TestTrapCheck_GetCheckUIURL(t *testing.T) {
+	single := &apiclient.CheckBundle{
+		CID:     "/check_bundle/123",
+		Brokers: []string{"/broker/1"},
+		Checks:  []string{"/check/1001"},
+	}
+	multi := &apiclient.CheckBundle{
+		CID:     "/check_bundle/123",
+		Brokers: []string{"/broker/1", "/broker/2"},
+		Checks:  []string{"/check/1001", "/check/1002"},
+	}
+
+	tests := []struct {
+		name        string
+		checkBundle *apiclient.CheckBundle
+		broker      *apiclient.Broker
+		baseURL     string
+		want        string
+		wantErr     bool
+	}{
+		{name: "single check", checkBundle: single, baseURL: "https://acme.circonus.com", want: "https://acme.circonus.com/checks/1001"},
+		{name: "trailing slash", checkBundle: single, baseURL: "https://acme.circonus.com/", want: "https://acme.circonus.com/checks/1001"},
+		{name: "base path", checkBundle: single, baseURL: "https://ui.example.com/circonus?x=1", want: "https://ui.example.com/circonus/checks/1001"},
+		{name: "multiple checks, selected broker", checkBundle: multi, broker: &apiclient.Broker{CID: "/broker/2"}, baseURL: "https://acme.circonus.com", want: "https://acme.circonus.com/checks/1002"},
+		{name: "multiple checks, unknown broker", checkBundle: multi, broker: &apiclient.Broker{CID: "/broker/9"}, baseURL: "https://acme.circonus.com", want: "https://acme.circonus.com/checks/1001"},
+		{name: "multiple checks, no broker", checkBundle: multi, baseURL: "https://acme.circonus.com", want: "https://acme.circonus.com/checks/1001"},
+		{name: "invalid, no checks", checkBundle: &apiclient.CheckBundle{CID: "/check_bundle/123"}, baseURL: "https://acme.circonus.com", wantErr: true},
+		{name: "invalid, no bundle", baseURL: "https://acme.circonus.com", wantErr: true},
+		{name: "invalid, check cid", checkBundle: &apiclient.CheckBundle{Checks: []string{"1001"}}, baseURL: "https://acme.circonus.com", wantErr: true},
+		{name: "invalid, base url relative", checkBundle: single, baseURL: "acme.circonus.com", wantErr: true},
+		{name: "invalid, base url scheme", checkBundle: single, baseURL: "ftp://acme.circonus.com", wantErr: true},
+		{name: "invalid, base url malformed", checkBundle: single, baseURL: "https://acme.circonus.com:port", wantErr: true},
+		{name: "invalid, base url empty", checkBundle: single, baseURL: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			tc := &TrapCheck{
+				checkBundle: tt.checkBundle,
+				broker:      tt.broker,
+			}
+			got, err := tc.GetCheckUIURL(tt.baseURL)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("TrapCheck.GetCheckUIURL() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("TrapCheck.GetCheckUIURL() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import "fmt"
+
+// GetCheckUUID returns the check uuid from the check bundle, or extracted
+// from the submission url (/module/httptrap/<uuid>/<secret>) if the bundle
+// has none (e.g. a custom submission url).
+func (tc *TrapCheck) GetCheckUUID() (string, error) {
+	tc.stateMu.RLock()
+	id := tc.checkUUID()
+	tc.stateMu.RUnlock()
+	if id == "" {
+		return "", fmt.Errorf("check uuid not available")
+	}
+	return id, nil
+}
+
+func (tc *TrapCheck) checkUUID() string {
+	if tc.checkBundle != nil && len(tc.checkBundle.CheckUUIDs) > 0 {
+		return tc.checkBundle.CheckUUIDs[0]
+	}
+	if tc.agentMode {
+		return agentCheckID(tc.submissionURL)
+	}
+	return checkUUIDFromURL(tc.submissionURL)
+}
+
+// checkUUIDFromURL extracts the check uuid from a submission url, an empty
+// string is returned if the url is malformed or contains no uuid.
+func checkUUIDFromURL(surl string) string {
+	su, err := parseSubmissionURL(surl)
+	if err != nil {
+		return ""
+	}
+	return su.checkUUID
+}
@@ -0,0 +1,65 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"testing"
+
+	"github.com/circonus-labs/go-apiclient"
+)
+
+func TestCheckUUIDFromURL(t *testing.T) {
+	tests := []struct {
+		name string
+		url  string
+		want string
+	}{
+		{name: "standard broker url", url: "https://10.1.2.3:43191/module/httptrap/0c5b9a36-2a2e-4e3c-9c8f-0d1f2a3b4c5d/mys3cr3t", want: "0c5b9a36-2a2e-4e3c-9c8f-0d1f2a3b4c5d"},
+		{name: "public broker url", url: "https://api.circonus.com/module/httptrap/0c5b9a36-2a2e-4e3c-9c8f-0d1f2a3b4c5d/mys3cr3t", want: "0c5b9a36-2a2e-4e3c-9c8f-0d1f2a3b4c5d"},
+		{name: "proxied url with prefix", url: "http://proxy.example.com/circonus/module/httptrap/0c5b9a36-2a2e-4e3c-9c8f-0d1f2a3b4c5d/mys3cr3t", want: "0c5b9a36-2a2e-4e3c-9c8f-0d1f2a3b4c5d"},
+		{name: "agent url", url: "http://127.0.0.1:2609/write/myapp"},
+		{name: "no uuid component", url: "https://10.1.2.3:43191/module/httptrap/"},
+		{name: "not a uuid", url: "https://10.1.2.3:43191/module/httptrap/foo/bar"},
+		{name: "malformed", url: ":foo"},
+		{name: "empty"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			if got := checkUUIDFromURL(tt.url); got != tt.want {
+				t.Errorf("checkUUIDFromURL() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTrapCheck_GetCheckUUID(t *testing.T) {
+	tests := []struct {
+		bundle  *apiclient.CheckBundle
+		name    string
+		url     string
+		want    string
+		wantErr bool
+	}{
+		{name: "from bundle", bundle: &apiclient.CheckBundle{CheckUUIDs: []string{"abc"}}, url: "https://10.1.2.3/module/httptrap/0c5b9a36-2a2e-4e3c-9c8f-0d1f2a3b4c5d/s", want: "abc"},
+		{name: "from url", bundle: &apiclient.CheckBundle{}, url: "https://10.1.2.3/module/httptrap/0c5b9a36-2a2e-4e3c-9c8f-0d1f2a3b4c5d/s", want: "0c5b9a36-2a2e-4e3c-9c8f-0d1f2a3b4c5d"},
+		{name: "nil bundle, from url", url: "https://10.1.2.3/module/httptrap/0c5b9a36-2a2e-4e3c-9c8f-0d1f2a3b4c5d/s", want: "0c5b9a36-2a2e-4e3c-9c8f-0d1f2a3b4c5d"},
+		{name: "none", bundle: &apiclient.CheckBundle{}, url: "http://127.0.0.1:2609/write/myapp", wantErr: true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			tc := &TrapCheck{checkBundle: tt.bundle, submissionURL: tt.url}
+			got, err := tc.GetCheckUUID()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("TrapCheck.GetCheckUUID() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("TrapCheck.GetCheckUUID() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// SendMetricsChunked splits the top-level JSON object in metrics into chunks
+// of approximately chunkBytes (a single metric is never split) and submits
+// each chunk with SendMetrics. Submission stops at the first error, the
+// results of the chunks already accepted are returned with the error.
+func (tc *TrapCheck) SendMetricsChunked(ctx context.Context, metrics bytes.Buffer, chunkBytes int) ([]*TrapResult, error) {
+	if metrics.Len() == 0 {
+		return nil, fmt.Errorf("no metrics to submit")
+	}
+	if chunkBytes <= 0 {
+		return nil, fmt.Errorf("invalid chunk size (%d)", chunkBytes)
+	}
+
+	chunks, err := chunkMetrics(metrics.Bytes(), chunkBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]*TrapResult, 0, len(chunks))
+	for i, chunk := range chunks {
+		result, err := tc.SendMetrics(ctx, *chunk)
+		if err != nil {
+			if result != nil {
+				results = append(results, result)
+			}
+			return results, fmt.Errorf("submitting chunk %d of %d: %w", i+1, len(chunks), err)
+		}
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+// chunkMetrics partitions a JSON object into JSON objects of approximately
+// chunkBytes each, preserving the order of the metrics.
+func chunkMetrics(data []byte, chunkBytes int) ([]*bytes.Buffer, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, fmt.Errorf("parsing metrics: %w", err)
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '{' {
+		return nil, fmt.Errorf("parsing metrics: top-level value must be a JSON object")
+	}
+
+	chunks := []*bytes.Buffer{}
+	var chunk *bytes.Buffer
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, fmt.Errorf("parsing metrics: %w", err)
+		}
+		key, ok := tok.(string)
+		if !ok {
+			return nil, fmt.Errorf("parsing metrics: unexpected token %v", tok)
+		}
+		var val json.RawMessage
+		if err := dec.Decode(&val); err != nil {
+			return nil, fmt.Errorf("parsing metric (%s): %w", key, err)
+		}
+		name, err := json.Marshal(key)
+		if err != nil {
+			return nil, fmt.Errorf("encoding metric name (%s): %w", key, err)
+		}
+
+		entryLen := len(name) + 1 + len(val)
+		if chunk != nil && chunk.Len()+entryLen+2 > chunkBytes {
+			chunk.WriteByte('}')
+			chunks = append(chunks, chunk)
+			chunk = nil
+		}
+		if chunk == nil {
+			chunk = new(bytes.Buffer)
+			chunk.WriteByte('{')
+		} else {
+			chunk.WriteByte(',')
+		}
+		chunk.Write(name)
+		chunk.WriteByte(':')
+		chunk.Write(val)
+	}
+
+	if _, err := dec.Token(); err != nil {
+		return nil, fmt.Errorf("parsing metrics: %w", err)
+	}
+
+	if chunk != nil {
+		chunk.WriteByte('}')
+		chunks = append(chunks, chunk)
+	}
+	if len(chunks) == 0 {
+		return nil, fmt.Errorf("no metrics to submit")
+	}
+
+	return chunks, nil
+}
@@ -0,0 +1,135 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/circonus-labs/go-apiclient"
+)
+
+func TestTrapCheck_SendMetricsChunked(t *testing.T) {
+	const numMetrics = 3000
+
+	var mu sync.Mutex
+	seen := make(map[string]int)
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body io.Reader = r.Body
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			zr, err := gzip.NewReader(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			body = zr
+		}
+		var m map[string]json.RawMessage
+		if err := json.NewDecoder(body).Decode(&m); err != nil {
+			http.Error(w, err.Error(), http.StatusNotAcceptable)
+			return
+		}
+		mu.Lock()
+		requests++
+		for k := range m {
+			seen[k]++
+		}
+		mu.Unlock()
+		fmt.Fprintf(w, `{"stats":%d}`, len(m))
+	}))
+	defer ts.Close()
+
+	fixture := make(map[string]interface{}, numMetrics)
+	for i := 0; i < numMetrics; i++ {
+		fixture[fmt.Sprintf("metric_%04d", i)] = map[string]interface{}{"_type": "n", "_value": i}
+	}
+	data, err := json.Marshal(fixture)
+	if err != nil {
+		t.Fatalf("marshal fixture: %s", err)
+	}
+
+	tc := &TrapCheck{
+		checkBundle:       &apiclient.CheckBundle{},
+		custSubmissionURL: ts.URL,
+		submissionURL:     ts.URL,
+		submissionTimeout: 5 * time.Second,
+	}
+	tc.Log = &LogWrapper{
+		Log:   log.New(io.Discard, "", log.LstdFlags),
+		Debug: false,
+	}
+
+	var metrics bytes.Buffer
+	metrics.Write(data)
+	results, err := tc.SendMetricsChunked(context.Background(), metrics, 8192)
+	if err != nil {
+		t.Fatalf("TrapCheck.SendMetricsChunked() error = %v", err)
+	}
+
+	if requests < 2 || len(results) != requests {
+		t.Errorf("requests = %d results = %d, want > 1 and equal", requests, len(results))
+	}
+	var stats uint64
+	for _, r := range results {
+		stats += r.Stats
+	}
+	if stats != numMetrics {
+		t.Errorf("total stats = %d, want %d", stats, numMetrics)
+	}
+	if len(seen) != numMetrics {
+		t.Errorf("distinct metrics submitted = %d, want %d", len(seen), numMetrics)
+	}
+	for k, n := range seen {
+		if n != 1 {
+			t.Errorf("metric %s submitted %d times", k, n)
+		}
+	}
+}
+
+func TestChunkMetrics(t *testing.T) {
+	tests := []struct {
+		name       string
+		data       string
+		chunkBytes int
+		want       []string
+		wantErr    bool
+	}{
+		{name: "invalid, not an object", data: `[1,2]`, chunkBytes: 10, wantErr: true},
+		{name: "invalid, malformed", data: `{"a":`, chunkBytes: 10, wantErr: true},
+		{name: "invalid, empty object", data: `{}`, chunkBytes: 10, wantErr: true},
+		{name: "single chunk", data: `{"a":1,"b":2}`, chunkBytes: 100, want: []string{`{"a":1,"b":2}`}},
+		{name: "split", data: `{"a":1,"b":2,"c":3}`, chunkBytes: 13, want: []string{`{"a":1,"b":2}`, `{"c":3}`}},
+		{name: "metric larger than chunk", data: `{"a":"0123456789","b":2}`, chunkBytes: 5, want: []string{`{"a":"0123456789"}`, `{"b":2}`}},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := chunkMetrics([]byte(tt.data), tt.chunkBytes)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("chunkMetrics() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("chunkMetrics() = %d chunks, want %d", len(got), len(tt.want))
+			}
+			for i := range got {
+				if got[i].String() != tt.want[i] {
+					t.Errorf("chunk %d = %s, want %s", i, got[i].String(), tt.want[i])
+				}
+			}
+		})
+	}
+}
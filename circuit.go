@@ -0,0 +1,173 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultCircuitBreakerCooldown = "30s"
+)
+
+// ErrCircuitOpen is returned (wrapped in a *CircuitOpenError) by SendMetrics while the
+// circuit breaker is open (see Config.CircuitBreakerThreshold).
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+// CircuitOpenError is returned when a submission is rejected by the open circuit breaker,
+// Wait is how long until a probe submission is allowed (0 while a probe is in flight)
+// and Failures the number of consecutive failed submissions.
+type CircuitOpenError struct {
+	Wait     time.Duration
+	Failures int
+}
+
+func (e *CircuitOpenError) Error() string {
+	if e.Wait <= 0 {
+		return ErrCircuitOpen.Error() + ", probe submission in flight"
+	}
+	return ErrCircuitOpen.Error() + ", retry in " + e.Wait.String()
+}
+
+func (e *CircuitOpenError) Unwrap() error {
+	return ErrCircuitOpen
+}
+
+// CircuitState is the state of the submission circuit breaker.
+type CircuitState string
+
+const (
+	// CircuitClosed submissions are sent (default, and when the circuit breaker is disabled).
+	CircuitClosed CircuitState = "closed"
+	// CircuitOpen submissions fail fast with ErrCircuitOpen until the cooldown has elapsed.
+	CircuitOpen CircuitState = "open"
+	// CircuitHalfOpen the cooldown has elapsed, the next submission is sent as a probe.
+	CircuitHalfOpen CircuitState = "half-open"
+)
+
+// CircuitState returns the state of the submission circuit breaker.
+func (tc *TrapCheck) CircuitState() CircuitState {
+	tc.circuitMu.Lock()
+	defer tc.circuitMu.Unlock()
+
+	if tc.circuitState == CircuitOpen && !tc.clock().Now().Before(tc.circuitOpenUntil) {
+		return CircuitHalfOpen
+	}
+	if tc.circuitState == "" {
+		return CircuitClosed
+	}
+	return tc.circuitState
+}
+
+// circuitAllow returns a *CircuitOpenError if the circuit is open, or a probe
+// is in flight. When the cooldown has elapsed the caller is allowed through
+// as the probe (probe is true) and must call circuitRecord with the outcome.
+func (tc *TrapCheck) circuitAllow() (bool, error) {
+	if tc.circuitThreshold <= 0 {
+		return false, nil
+	}
+
+	tc.circuitMu.Lock()
+	defer tc.circuitMu.Unlock()
+
+	switch tc.circuitState {
+	case CircuitOpen:
+		if wait := tc.circuitOpenUntil.Sub(tc.clock().Now()); wait > 0 {
+			return false, &CircuitOpenError{Wait: wait, Failures: tc.circuitFailures}
+		}
+		tc.circuitState = CircuitHalfOpen
+	case CircuitHalfOpen:
+	default:
+		return false, nil
+	}
+
+	if tc.circuitProbing {
+		return false, &CircuitOpenError{Failures: tc.circuitFailures}
+	}
+	tc.circuitProbing = true
+	tc.logger().Infof("circuit breaker half-open, sending probe submission")
+	return true, nil
+}
+
+// circuitRecord records the outcome of a submission allowed by circuitAllow.
+func (tc *TrapCheck) circuitRecord(probe bool, err error) {
+	if tc.circuitThreshold <= 0 {
+		return
+	}
+
+	tc.circuitMu.Lock()
+	defer tc.circuitMu.Unlock()
+
+	if probe {
+		tc.circuitProbing = false
+	}
+
+	switch {
+	case circuitIgnored(err):
+		// not a broker outcome, a probe is sent with the next submission
+	case err != nil && circuitFailure(err):
+		tc.circuitFailures++
+		if tc.circuitState == CircuitHalfOpen || tc.circuitFailures >= tc.circuitThreshold {
+			if tc.circuitState != CircuitOpen {
+				tc.logger().Warnf("circuit breaker open after %d consecutive failed submissions, failing fast for %s: %s", tc.circuitFailures, tc.circuitCooldown, err)
+			}
+			tc.circuitState = CircuitOpen
+			tc.circuitOpenUntil = tc.clock().Now().Add(tc.circuitCooldown)
+		}
+	default:
+		// success, or the broker responded (e.g. rejected the payload)
+		if tc.circuitState == CircuitHalfOpen || tc.circuitState == CircuitOpen {
+			tc.logger().Infof("circuit breaker closed")
+		}
+		tc.circuitState = CircuitClosed
+		tc.circuitFailures = 0
+	}
+}
+
+// circuitFailure returns true if the submission failed without the broker
+// rejecting the payload (broker unreachable, timed out, 5xx, 404, 429).
+func circuitFailure(err error) bool {
+	var hookErr *requestHookError
+	var stErr *statusError
+	switch {
+	case errors.Is(err, ErrAllMetricsFiltered),
+		errors.Is(err, ErrBrokerReportedError),
+		errors.Is(err, ErrCertPinMismatch),
+		errors.Is(err, ErrPayloadTooLarge),
+		errors.As(err, &hookErr):
+		return false
+	case errors.As(err, &stErr):
+		switch stErr.code {
+		case http.StatusNotFound, http.StatusRequestTimeout, http.StatusTooManyRequests:
+			return true
+		}
+		return stErr.code >= 500
+	}
+	return true
+}
+
+// circuitIgnored returns true for submission errors which say nothing about
+// the broker (the submission was not sent, or was canceled by the caller).
+func circuitIgnored(err error) bool {
+	return errors.Is(err, ErrCheckDeactivated) ||
+		errors.Is(err, ErrCheckRefreshing) ||
+		errors.Is(err, ErrClosed) ||
+		errors.Is(err, context.Canceled)
+}
+
+// resetCircuit closes the circuit, e.g. after the check was refreshed the
+// submissions may go to a different broker.
+func (tc *TrapCheck) resetCircuit() {
+	tc.circuitMu.Lock()
+	defer tc.circuitMu.Unlock()
+
+	tc.circuitState = CircuitClosed
+	tc.circuitFailures = 0
+	tc.circuitOpenUntil = time.Time{}
+}
@@ -0,0 +1,211 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/circonus-labs/go-apiclient"
+	"github.com/circonus-labs/go-trapcheck/trapchecktest"
+)
+
+func TestTrapCheck_circuitBreaker(t *testing.T) {
+	fb := trapchecktest.NewFakeBroker(t)
+	submissionURL := fb.SubmissionURL("abc-123", "secret")
+	cooldown := 200 * time.Millisecond
+	tc := &TrapCheck{
+		checkBundle:       &apiclient.CheckBundle{},
+		custSubmissionURL: submissionURL,
+		submissionURL:     submissionURL,
+		submissionTimeout: 100 * time.Millisecond, // bounds the retries of failed submissions
+		circuitThreshold:  2,
+		circuitCooldown:   cooldown,
+	}
+	tc.Log = &LogWrapper{Log: log.New(io.Discard, "", log.LstdFlags)}
+
+	send := func() error {
+		var metrics bytes.Buffer
+		metrics.WriteString(`{"foo":{"_type":"n","_value":1}}`)
+		_, err := tc.SendMetrics(context.Background(), metrics)
+		return err
+	}
+	wantState := func(step string, want CircuitState) {
+		t.Helper()
+		if got := tc.CircuitState(); got != want {
+			t.Fatalf("%s: CircuitState() = %s, want %s", step, got, want)
+		}
+	}
+	wantOpen := func(step string, err error) {
+		t.Helper()
+		var coErr *CircuitOpenError
+		if !errors.Is(err, ErrCircuitOpen) || !errors.As(err, &coErr) {
+			t.Fatalf("%s: SendMetrics() error = %v, want %v", step, err, ErrCircuitOpen)
+		}
+	}
+
+	wantState("initial", CircuitClosed)
+	if err := send(); err != nil {
+		t.Fatalf("SendMetrics() error = %v", err)
+	}
+
+	// closed -> open
+	fb.SetResponse(http.StatusInternalServerError, "down")
+	for i := 0; i < 2; i++ {
+		if err := send(); err == nil || errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("failure %d: SendMetrics() error = %v, want broker error", i, err)
+		}
+	}
+	wantState("after failures", CircuitOpen)
+
+	n := len(fb.Submissions())
+	err := send()
+	wantOpen("open", err)
+	var coErr *CircuitOpenError
+	if errors.As(err, &coErr) && (coErr.Wait <= 0 || coErr.Wait > cooldown || coErr.Failures != 2) {
+		t.Errorf("CircuitOpenError = %+v, want wait in (0, %s] and 2 failures", coErr, cooldown)
+	}
+	if got := len(fb.Submissions()); got != n {
+		t.Errorf("broker submissions while open = %d, want %d", got, n)
+	}
+	if _, lastErr := tc.LastError(); !errors.Is(lastErr, ErrCircuitOpen) {
+		t.Errorf("LastError() = %v, want %v", lastErr, ErrCircuitOpen)
+	}
+
+	// open -> half-open -> open, failed probe
+	time.Sleep(cooldown)
+	wantState("cooldown elapsed", CircuitHalfOpen)
+	if err := send(); err == nil || errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("probe: SendMetrics() error = %v, want broker error", err)
+	}
+	wantState("failed probe", CircuitOpen)
+	wantOpen("failed probe", send())
+
+	// open -> half-open -> closed, successful probe (a concurrent submission fails fast)
+	time.Sleep(cooldown)
+	fb.SetResponse(http.StatusOK, `{"stats":1}`)
+	fb.SetDelay(30 * time.Millisecond)
+	n = len(fb.Submissions())
+	probeErr := make(chan error, 1)
+	go func() { probeErr <- send() }()
+	for deadline := time.Now().Add(time.Second); len(fb.Submissions()) == n && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	wantOpen("probe in flight", send())
+	if err := <-probeErr; err != nil {
+		t.Fatalf("probe: SendMetrics() error = %v", err)
+	}
+	wantState("successful probe", CircuitClosed)
+	fb.SetDelay(0)
+	if err := send(); err != nil {
+		t.Fatalf("closed: SendMetrics() error = %v", err)
+	}
+
+	// reset by Close
+	fb.SetResponse(http.StatusInternalServerError, "down")
+	for i := 0; i < 2; i++ {
+		_ = send()
+	}
+	wantState("reopened", CircuitOpen)
+	if err := tc.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	wantState("closed", CircuitClosed)
+}
+
+func TestTrapCheck_circuitBreakerDisabled(t *testing.T) {
+	fb := trapchecktest.NewFakeBroker(t)
+	fb.SetResponse(http.StatusBadRequest, "bad payload") // not retried
+	tc := newAsyncTestTrapCheck(fb, 0)
+
+	var metrics bytes.Buffer
+	metrics.WriteString(`{"foo":{"_type":"n","_value":1}}`)
+	for i := 0; i < 5; i++ {
+		if _, err := tc.SendMetrics(context.Background(), metrics); errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("SendMetrics() error = %v, circuit breaker disabled", err)
+		}
+	}
+	if got := tc.CircuitState(); got != CircuitClosed {
+		t.Errorf("CircuitState() = %s, want %s", got, CircuitClosed)
+	}
+}
+
+func TestTrapCheck_circuitBreakerSpool(t *testing.T) {
+	fb := trapchecktest.NewFakeBroker(t)
+	submissionURL := fb.SubmissionURL("abc-123", "secret")
+	cooldown := 200 * time.Millisecond
+	tc := &TrapCheck{
+		checkBundle:       &apiclient.CheckBundle{},
+		custSubmissionURL: submissionURL,
+		submissionURL:     submissionURL,
+		submissionTimeout: 100 * time.Millisecond,
+		circuitThreshold:  2,
+		circuitCooldown:   cooldown,
+		spoolMaxBytes:     1 << 20,
+		spoolMaxAge:       time.Minute,
+	}
+	tc.Log = &LogWrapper{Log: log.New(io.Discard, "", log.LstdFlags)}
+
+	send := func(i int) error {
+		_, err := tc.SendMetrics(context.Background(), spoolTestMetrics(i))
+		return err
+	}
+
+	// spooled failures open the circuit
+	fb.SetResponse(http.StatusInternalServerError, "down")
+	for i := 1; i <= 2; i++ {
+		if err := send(i); !errors.Is(err, ErrSpooled) || errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("failure %d: SendMetrics() error = %v, want %v", i, err, ErrSpooled)
+		}
+	}
+	if got := tc.CircuitState(); got != CircuitOpen {
+		t.Fatalf("CircuitState() = %s, want %s", got, CircuitOpen)
+	}
+
+	// open, the payload is spooled and the circuit error returned
+	n := len(fb.Submissions())
+	err := send(3)
+	var coErr *CircuitOpenError
+	if !errors.As(err, &coErr) || !errors.Is(err, ErrCircuitOpen) || !errors.Is(err, ErrSpooled) {
+		t.Fatalf("open: SendMetrics() error = %v, want spooled %v", err, ErrCircuitOpen)
+	}
+	if got := len(fb.Submissions()); got != n {
+		t.Errorf("broker submissions while open = %d, want %d", got, n)
+	}
+	if stats := tc.SpoolStats(); stats.Entries != 3 || stats.Spooled != 3 {
+		t.Fatalf("SpoolStats() = %+v, want 3 entries", stats)
+	}
+
+	// the probe drains the spool, oldest first
+	time.Sleep(cooldown)
+	fb.Reset()
+	fb.SetResponse(http.StatusOK, `{"stats":1}`)
+	if err := send(4); err != nil {
+		t.Fatalf("probe: SendMetrics() error = %v", err)
+	}
+	if got := tc.CircuitState(); got != CircuitClosed {
+		t.Errorf("CircuitState() = %s, want %s", got, CircuitClosed)
+	}
+	subs := fb.Submissions()
+	if len(subs) != 4 {
+		t.Fatalf("broker submissions = %d, want 4", len(subs))
+	}
+	for i, sub := range subs {
+		want := spoolTestMetrics(i + 1)
+		if string(sub.Payload) != want.String() {
+			t.Errorf("submission %d = %s, want %s", i, sub.Payload, want.String())
+		}
+	}
+	if stats := tc.SpoolStats(); stats.Entries != 0 || stats.Drained != 3 {
+		t.Errorf("SpoolStats() = %+v, want empty with 3 drained", stats)
+	}
+}
@@ -0,0 +1,19 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"github.com/circonus-labs/go-trapcheck/internal/clock"
+)
+
+// clock returns the time source of the trap check, set from the default
+// clock (see testsupport.SetClock) when the trap check is created.
+func (tc *TrapCheck) clock() clock.Clock {
+	if tc.clk == nil {
+		return clock.Default()
+	}
+	return tc.clk
+}
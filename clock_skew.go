@@ -0,0 +1,39 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrPossibleClockSkew is returned (wrapped) by submissions when the broker
+// certificate is expired or not yet valid and the local time is far outside
+// its validity window - the local clock is likely wrong (check NTP).
+var ErrPossibleClockSkew = errors.New("possible clock skew, broker certificate not valid at local time")
+
+// clockSkewMargin how far outside the certificate validity window the local
+// time must be for a validity failure to be reported as possible clock skew.
+const clockSkewMargin = 24 * time.Hour
+
+// clockSkewError returns an ErrPossibleClockSkew error if err is a certificate
+// validity (expired or not yet valid) failure and now is outside the
+// certificate validity window by more than clockSkewMargin, otherwise nil.
+func clockSkewError(err error, now time.Time) error {
+	var cie x509.CertificateInvalidError
+	if !errors.As(err, &cie) || cie.Reason != x509.Expired || cie.Cert == nil {
+		return nil
+	}
+	cert := cie.Cert
+	if now.After(cert.NotBefore.Add(-clockSkewMargin)) && now.Before(cert.NotAfter.Add(clockSkewMargin)) {
+		return nil
+	}
+	return fmt.Errorf("%w (local time: %s, certificate valid: %s - %s), check the system clock: %s",
+		ErrPossibleClockSkew, now.UTC().Format(time.RFC3339),
+		cert.NotBefore.UTC().Format(time.RFC3339), cert.NotAfter.UTC().Format(time.RFC3339), err)
+}
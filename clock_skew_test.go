@@ -0,0 +1,175 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/circonus-labs/go-apiclient"
+)
+
+// generateTestValidityCert creates a certificate for 127.0.0.1, signed by
+// the supplied CA, valid from notBefore to notAfter.
+func generateTestValidityCert(t *testing.T, ca *x509.Certificate, caKey *ecdsa.PrivateKey, notBefore, notAfter time.Time) (tls.Certificate, *x509.Certificate) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generating cert key: %s", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("creating cert: %s", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("parsing cert: %s", err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: cert}, cert
+}
+
+func Test_clockSkewError(t *testing.T) {
+	now := time.Now()
+	_, ca, caKey := generateTestCA(t, now.Add(10*365*24*time.Hour))
+	_, cert := generateTestValidityCert(t, ca, caKey, now.Add(-time.Hour), now.Add(time.Hour))
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+
+	// verification time manipulated as by a VerifyConnection hook on a host with a skewed clock
+	verifyErr := func(at time.Time) error {
+		tlsConfig := &tls.Config{
+			InsecureSkipVerify: true, //nolint:gosec
+			VerifyConnection: func(cs tls.ConnectionState) error {
+				_, err := cs.PeerCertificates[0].Verify(x509.VerifyOptions{Roots: roots, CurrentTime: at})
+				if err != nil {
+					return fmt.Errorf("peer cert verify: %w", err)
+				}
+				return nil
+			},
+		}
+		return tlsConfig.VerifyConnection(tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}})
+	}
+
+	tests := []struct {
+		err      error
+		at       time.Time
+		name     string
+		wantSkew bool
+	}{
+		{name: "valid", at: now, err: verifyErr(now)},
+		{name: "other error", at: now, err: fmt.Errorf("connection refused")},
+		{name: "clock ahead", at: now.Add(30 * 24 * time.Hour), err: verifyErr(now.Add(30 * 24 * time.Hour)), wantSkew: true},
+		{name: "clock behind", at: now.Add(-2 * 365 * 24 * time.Hour), err: verifyErr(now.Add(-2 * 365 * 24 * time.Hour)), wantSkew: true},
+		{name: "recently expired", at: now.Add(2 * time.Hour), err: verifyErr(now.Add(2 * time.Hour))},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			err := clockSkewError(tt.err, tt.at)
+			if tt.wantSkew != errors.Is(err, ErrPossibleClockSkew) {
+				t.Fatalf("clockSkewError() = %v, want skew %t", err, tt.wantSkew)
+			}
+			if !tt.wantSkew {
+				return
+			}
+			for _, want := range []string{tt.at.UTC().Format(time.RFC3339), cert.NotBefore.UTC().Format(time.RFC3339), cert.NotAfter.UTC().Format(time.RFC3339)} {
+				if !bytes.Contains([]byte(err.Error()), []byte(want)) {
+					t.Errorf("clockSkewError() = %v, want %s in message", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestTrapCheck_SendMetrics_clockSkew(t *testing.T) {
+	now := time.Now()
+	_, ca, caKey := generateTestCA(t, now.Add(10*365*24*time.Hour))
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	tests := []struct {
+		name      string
+		notBefore time.Time
+		notAfter  time.Time
+		wantSkew  bool
+	}{
+		{name: "broker cert not yet valid", notBefore: now.Add(72 * time.Hour), notAfter: now.Add(96 * time.Hour), wantSkew: true},
+		{name: "broker cert long expired", notBefore: now.Add(-96 * time.Hour), notAfter: now.Add(-72 * time.Hour), wantSkew: true},
+		{name: "broker cert just expired", notBefore: now.Add(-time.Hour), notAfter: now.Add(-time.Minute)},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			tlsCert, _ := generateTestValidityCert(t, ca, caKey, tt.notBefore, tt.notAfter)
+			var handshakes int32
+			ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprintln(w, `{"stats":1}`)
+			}))
+			ts.TLS = &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{tlsCert},
+				GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+					atomic.AddInt32(&handshakes, 1)
+					return nil, nil
+				},
+			}
+			ts.Config.ErrorLog = log.New(io.Discard, "", 0)
+			ts.StartTLS()
+			defer ts.Close()
+
+			submissionURL := ts.URL + "/write/test"
+			tc := &TrapCheck{
+				checkBundle:       &apiclient.CheckBundle{},
+				custSubmissionURL: submissionURL,
+				submissionURL:     submissionURL,
+				submissionTimeout: time.Second, // bounds the retries of the expired cert case
+				custTLSConfig:     &tls.Config{MinVersion: tls.VersionTLS12, RootCAs: roots},
+			}
+			tc.Log = &LogWrapper{Log: log.New(io.Discard, "", log.LstdFlags)}
+
+			var metrics bytes.Buffer
+			metrics.WriteString(`{"foo":{"_type":"n","_value":1}}`)
+			_, err := tc.SendMetrics(context.Background(), metrics)
+			if err == nil {
+				t.Fatal("SendMetrics() expected error")
+			}
+			if errors.Is(err, ErrPossibleClockSkew) != tt.wantSkew {
+				t.Fatalf("SendMetrics() error = %v, want clock skew %t", err, tt.wantSkew)
+			}
+			if tt.wantSkew {
+				if n := atomic.LoadInt32(&handshakes); n != 1 {
+					t.Errorf("handshakes = %d, want 1 (no retry)", n)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/circonus-labs/go-apiclient"
+	"github.com/circonus-labs/go-trapcheck/testsupport"
+	"github.com/circonus-labs/go-trapcheck/trapchecktest"
+)
+
+func TestSetClock(t *testing.T) {
+	fc := testsupport.NewFakeClock(time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC))
+	restore := testsupport.SetClock(fc)
+	defer restore()
+
+	fb := trapchecktest.NewFakeBroker(t)
+	traceDir := t.TempDir()
+	tc, err := NewFromSubmissionURL(&Config{
+		SubmissionURL: fb.SubmissionURL("abc-123", "secret"),
+		TraceMetrics:  traceDir,
+		Logger:        &LogWrapper{Log: log.New(io.Discard, "", log.LstdFlags)},
+	})
+	if err != nil {
+		t.Fatalf("NewFromSubmissionURL() error = %v", err)
+	}
+	if tc.clock() != fc {
+		t.Fatalf("clock = %T, want fake clock", tc.clock())
+	}
+
+	var metrics bytes.Buffer
+	metrics.WriteString(`{"foo":{"_type":"n","_value":1}}`)
+	result, err := tc.SendMetrics(context.Background(), metrics)
+	if err != nil {
+		t.Fatalf("SendMetrics() error = %v", err)
+	}
+	if result.SubmitDuration != 0 {
+		t.Errorf("SubmitDuration = %s, want 0 (frozen clock)", result.SubmitDuration)
+	}
+	want := filepath.Join(traceDir, fc.Now().UTC().Format(traceTSFormat)+"_"+result.SubmitUUID+".json")
+	if traces, _ := filepath.Glob(filepath.Join(traceDir, "*.json")); len(traces) != 1 || traces[0] != want {
+		t.Errorf("trace files = %v, want [%s]", traces, want)
+	}
+
+	restore()
+	if tc.clock() != fc {
+		t.Error("restoring the clock changed the clock of an existing trap check")
+	}
+}
+
+func TestSetClock_brokerListRefreshInterval(t *testing.T) {
+	client := &APIMock{
+		FetchBrokersFunc: func() (*[]apiclient.Broker, error) {
+			return &[]apiclient.Broker{{CID: "/broker/1"}}, nil
+		},
+	}
+	bl := initTestBrokerList(t, client, &LogWrapper{Log: log.New(io.Discard, "", log.LstdFlags)})
+
+	fc := testsupport.NewFakeClock(time.Now())
+	restore := testsupport.SetClock(fc)
+	defer restore()
+
+	if err := bl.FetchBrokers(); err != nil {
+		t.Fatalf("FetchBrokers() error = %v", err)
+	}
+	fetches := len(client.FetchBrokersCalls())
+
+	fc.Advance(4 * time.Minute)
+	if err := bl.RefreshBrokers(); err != nil {
+		t.Fatalf("RefreshBrokers() error = %v", err)
+	}
+	if n := len(client.FetchBrokersCalls()) - fetches; n != 0 {
+		t.Errorf("broker list fetches within the refresh interval = %d, want 0", n)
+	}
+
+	fc.Advance(2 * time.Minute)
+	if err := bl.RefreshBrokers(); err != nil {
+		t.Fatalf("RefreshBrokers() error = %v", err)
+	}
+	if n := len(client.FetchBrokersCalls()) - fetches; n != 1 {
+		t.Errorf("broker list fetches after the refresh interval = %d, want 1", n)
+	}
+}
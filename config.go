@@ -0,0 +1,161 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Validate verifies the configuration settings (durations, thresholds and
+// mutually exclusive options). It is called by all of the constructors.
+func (cfg *Config) Validate() error {
+	if cfg == nil {
+		return fmt.Errorf("invalid configuration  (nil)")
+	}
+
+	durations := []struct {
+		name    string
+		setting string
+		def     string
+	}{
+		{name: "broker max response time", setting: cfg.BrokerMaxResponseTime, def: defaultBrokerMaxResponseTime},
+		{name: "submission timeout", setting: cfg.SubmissionTimeout, def: defaultSubmissionTimeout},
+		{name: "ca cert refresh window", setting: cfg.CACertRefreshWindow, def: defaultCACertRefreshWindow},
+		{name: "refresh cooldown", setting: cfg.RefreshCooldown, def: defaultRefreshCooldown},
+		{name: "refresh retry delay", setting: cfg.RefreshRetryDelay, def: defaultRefreshRetryDelay},
+		{name: "refresh retry jitter", setting: cfg.RefreshRetryJitter, def: defaultRefreshRetryJitter},
+		{name: "check active timeout", setting: cfg.CheckActiveTimeout, def: defaultCheckActiveTimeout},
+		{name: "init jitter", setting: cfg.InitJitter, def: defaultInitJitter},
+		{name: "min submission interval", setting: cfg.MinSubmissionInterval, def: defaultMinSubmissionInterval},
+		{name: "spool max age", setting: cfg.SpoolMaxAge, def: defaultSpoolMaxAge},
+		{name: "bundle recheck interval", setting: cfg.BundleRecheckInterval, def: defaultBundleRecheckInterval},
+		{name: "broker load cache ttl", setting: cfg.BrokerLoadCacheTTL, def: defaultBrokerLoadCacheTTL},
+		{name: "circuit breaker cooldown", setting: cfg.CircuitBreakerCooldown, def: defaultCircuitBreakerCooldown},
+	}
+	for _, d := range durations {
+		if _, err := parseDurationSetting(d.setting, d.def); err != nil {
+			return fmt.Errorf("parsing %s %w", d.name, err)
+		}
+	}
+
+	if cfg.CheckSecret != "" {
+		if err := validateCheckSecret(cfg.CheckSecret); err != nil {
+			return err
+		}
+	}
+
+	if _, err := resolveInstanceID(cfg.CheckInstanceID); err != nil {
+		return err
+	}
+
+	if err := validateSearchTags(NormalizeTags(cfg.CheckSearchTags)); err != nil {
+		return fmt.Errorf("check search tags: %w", err)
+	}
+	if err := validateSearchTags(NormalizeTags(cfg.BrokerSelectTags)); err != nil {
+		return fmt.Errorf("broker select tags: %w", err)
+	}
+
+	switch cfg.MultipleMatchBehavior {
+	case "", MultipleMatchError, MultipleMatchOldest, MultipleMatchNewest:
+	case MultipleMatchTag:
+		if cfg.MultipleMatchTag == "" {
+			return fmt.Errorf("invalid configuration (MultipleMatchBehavior %s requires MultipleMatchTag)", cfg.MultipleMatchBehavior)
+		}
+	default:
+		return fmt.Errorf("invalid configuration (unknown MultipleMatchBehavior %q)", cfg.MultipleMatchBehavior)
+	}
+
+	switch cfg.BrokerSelectionStrategy {
+	case "", BrokerSelectionRandom, BrokerSelectionSpread:
+	default:
+		return fmt.Errorf("invalid configuration (unknown BrokerSelectionStrategy %q)", cfg.BrokerSelectionStrategy)
+	}
+
+	switch cfg.IPProtocol {
+	case "", IPProtocolAuto, IPProtocolIPv4, IPProtocolIPv6:
+	default:
+		return fmt.Errorf("invalid configuration (unknown IPProtocol %q)", cfg.IPProtocol)
+	}
+
+	if cfg.MetricFilters != nil {
+		if err := validateMetricFilters(cfg.MetricFilters); err != nil {
+			return fmt.Errorf("metric filters: %w", err)
+		}
+	}
+
+	for _, pin := range cfg.PinnedCertFingerprints {
+		if _, err := normalizeFingerprint(pin); err != nil {
+			return fmt.Errorf("pinned cert fingerprints: %w", err)
+		}
+	}
+
+	switch cfg.TraceLevel {
+	case "", TraceLevelPayload, TraceLevelFull:
+	default:
+		return fmt.Errorf("invalid configuration (unknown TraceLevel %q)", cfg.TraceLevel)
+	}
+
+	if cfg.MaxPayloadSize < 0 {
+		return fmt.Errorf("invalid max payload size (%d), must be >= 0", cfg.MaxPayloadSize)
+	}
+
+	if cfg.MaxResponseBytes < 0 {
+		return fmt.Errorf("invalid max response bytes (%d), must be >= 0", cfg.MaxResponseBytes)
+	}
+
+	if err := cfg.CheckDefaults.Validate(); err != nil {
+		return fmt.Errorf("check defaults: %w", err)
+	}
+
+	if err := cfg.TransportConfig.Validate(); err != nil {
+		return fmt.Errorf("transport config: %w", err)
+	}
+
+	if cfg.SpoolMaxBytes < 0 {
+		return fmt.Errorf("invalid spool max bytes (%d), must be >= 0", cfg.SpoolMaxBytes)
+	}
+
+	if cfg.MaxInflightSubmissions < 0 {
+		return fmt.Errorf("invalid max inflight submissions (%d), must be >= 0", cfg.MaxInflightSubmissions)
+	}
+
+	if cfg.BatchConcurrency < 0 {
+		return fmt.Errorf("invalid batch concurrency (%d), must be >= 0", cfg.BatchConcurrency)
+	}
+
+	if cfg.CircuitBreakerThreshold < 0 {
+		return fmt.Errorf("invalid circuit breaker threshold (%d), must be >= 0", cfg.CircuitBreakerThreshold)
+	}
+
+	if cfg.StreamRetryBufferBytes < 0 {
+		return fmt.Errorf("invalid stream retry buffer size (%d), must be >= 0", cfg.StreamRetryBufferBytes)
+	}
+
+	if cfg.Broker != nil && cfg.Broker.CID == "" {
+		return fmt.Errorf("invalid configuration (Broker has no CID)")
+	}
+
+	if cfg.PublicCA && cfg.SubmitTLSConfig != nil {
+		return fmt.Errorf("invalid configuration (PublicCA and SubmitTLSConfig are mutually exclusive)")
+	}
+
+	if len(cfg.MetricFilters) > 0 && len(cfg.CheckDefaults.MetricFilters) > 0 {
+		return fmt.Errorf("invalid configuration (MetricFilters and CheckDefaults.MetricFilters are mutually exclusive)")
+	}
+
+	if cfg.FilteredWarnThreshold < 0 || cfg.FilteredWarnThreshold > 1 {
+		return fmt.Errorf("invalid filtered warn threshold (%f), must be 0..1", cfg.FilteredWarnThreshold)
+	}
+
+	// verify that if the check type is set, it is a variant of httptrap
+	// this module ONLY deals with httptraps.
+	if cfg.CheckConfig != nil && cfg.CheckConfig.Type != "" && !strings.HasPrefix(cfg.CheckConfig.Type, "httptrap") {
+		return fmt.Errorf("check type must be httptrap variant (%s)", cfg.CheckConfig.Type)
+	}
+
+	return nil
+}
@@ -0,0 +1,120 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/circonus-labs/go-apiclient"
+)
+
+// Environment variables overlaid on a Config by ConfigFromEnv.
+const (
+	EnvSubmissionTimeout     = "TRAPCHECK_SUBMISSION_TIMEOUT"
+	EnvBrokerMaxResponseTime = "TRAPCHECK_BROKER_MAX_RESPONSE_TIME"
+	EnvTraceMetrics          = "TRAPCHECK_TRACE_METRICS"
+	EnvTraceLevel            = "TRAPCHECK_TRACE_LEVEL"
+	EnvBrokerSelectTags      = "TRAPCHECK_BROKER_SELECT_TAGS"
+	EnvCheckSearchTags       = "TRAPCHECK_CHECK_SEARCH_TAGS"
+	EnvPublicCA              = "TRAPCHECK_PUBLIC_CA"
+)
+
+// ConfigFromEnv returns a copy of base (or a new Config if base is nil) with the
+// TRAPCHECK_* environment variables which are set (and not empty) overlaid:
+//
+//	TRAPCHECK_SUBMISSION_TIMEOUT        SubmissionTimeout (duration)
+//	TRAPCHECK_BROKER_MAX_RESPONSE_TIME  BrokerMaxResponseTime (duration)
+//	TRAPCHECK_TRACE_METRICS             TraceMetrics
+//	TRAPCHECK_TRACE_LEVEL               TraceLevel (payload or full)
+//	TRAPCHECK_BROKER_SELECT_TAGS        BrokerSelectTags (comma separated)
+//	TRAPCHECK_CHECK_SEARCH_TAGS         CheckSearchTags (comma separated)
+//	TRAPCHECK_PUBLIC_CA                 PublicCA (bool)
+//
+// An invalid value returns an error naming the variable. The constructors
+// never read the environment, calling ConfigFromEnv is an explicit opt-in.
+func ConfigFromEnv(base *Config) (*Config, error) {
+	cfg := &Config{}
+	if base != nil {
+		c := *base
+		cfg = &c
+	}
+
+	durations := []struct {
+		setting *string
+		name    string
+	}{
+		{name: EnvSubmissionTimeout, setting: &cfg.SubmissionTimeout},
+		{name: EnvBrokerMaxResponseTime, setting: &cfg.BrokerMaxResponseTime},
+	}
+	for _, d := range durations {
+		val, ok := lookupEnv(d.name)
+		if !ok {
+			continue
+		}
+		if _, err := time.ParseDuration(val); err != nil {
+			return nil, fmt.Errorf("%s: invalid duration: %w", d.name, err)
+		}
+		*d.setting = val
+	}
+
+	if val, ok := lookupEnv(EnvTraceMetrics); ok {
+		cfg.TraceMetrics = val
+	}
+
+	if val, ok := lookupEnv(EnvTraceLevel); ok {
+		switch val {
+		case TraceLevelPayload, TraceLevelFull:
+			cfg.TraceLevel = val
+		default:
+			return nil, fmt.Errorf("%s: invalid trace level %q", EnvTraceLevel, val)
+		}
+	}
+
+	tags := []struct {
+		setting *apiclient.TagType
+		name    string
+	}{
+		{name: EnvBrokerSelectTags, setting: &cfg.BrokerSelectTags},
+		{name: EnvCheckSearchTags, setting: &cfg.CheckSearchTags},
+	}
+	for _, tt := range tags {
+		val, ok := lookupEnv(tt.name)
+		if !ok {
+			continue
+		}
+		var list apiclient.TagType
+		for _, tag := range strings.Split(val, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				list = append(list, tag)
+			}
+		}
+		if err := validateSearchTags(list); err != nil {
+			return nil, fmt.Errorf("%s: %w", tt.name, err)
+		}
+		*tt.setting = list
+	}
+
+	if val, ok := lookupEnv(EnvPublicCA); ok {
+		b, err := strconv.ParseBool(val)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid bool: %w", EnvPublicCA, err)
+		}
+		cfg.PublicCA = b
+	}
+
+	return cfg, nil
+}
+
+// lookupEnv returns the value of the environment variable, if set and not empty.
+func lookupEnv(name string) (string, bool) {
+	val, ok := os.LookupEnv(name)
+	val = strings.TrimSpace(val)
+	return val, ok && val != ""
+}
@@ -0,0 +1,110 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/circonus-labs/go-apiclient"
+)
+
+func TestConfigFromEnv(t *testing.T) {
+	tests := []struct {
+		base    *Config
+		env     map[string]string
+		want    *Config
+		name    string
+		wantErr string
+	}{
+		{
+			name: "nil base, no env",
+			want: &Config{},
+		},
+		{
+			name: "base preserved when unset or empty",
+			base: &Config{SubmissionTimeout: "5s", PublicCA: true},
+			env:  map[string]string{EnvSubmissionTimeout: "", EnvPublicCA: " "},
+			want: &Config{SubmissionTimeout: "5s", PublicCA: true},
+		},
+		{
+			name: "all settings",
+			base: &Config{SubmissionTimeout: "5s", TraceMetrics: "/tmp"},
+			env: map[string]string{
+				EnvSubmissionTimeout:     "20s",
+				EnvBrokerMaxResponseTime: "750ms",
+				EnvTraceMetrics:          "-",
+				EnvTraceLevel:            TraceLevelFull,
+				EnvBrokerSelectTags:      "region:us, ,env:prod",
+				EnvCheckSearchTags:       "service:foo",
+				EnvPublicCA:              "true",
+			},
+			want: &Config{
+				SubmissionTimeout:     "20s",
+				BrokerMaxResponseTime: "750ms",
+				TraceMetrics:          "-",
+				TraceLevel:            TraceLevelFull,
+				BrokerSelectTags:      apiclient.TagType{"region:us", "env:prod"},
+				CheckSearchTags:       apiclient.TagType{"service:foo"},
+				PublicCA:              true,
+			},
+		},
+		{
+			name:    "invalid submission timeout",
+			env:     map[string]string{EnvSubmissionTimeout: "10"},
+			wantErr: EnvSubmissionTimeout,
+		},
+		{
+			name:    "invalid broker max response time",
+			env:     map[string]string{EnvBrokerMaxResponseTime: "fast"},
+			wantErr: EnvBrokerMaxResponseTime,
+		},
+		{
+			name:    "invalid trace level",
+			env:     map[string]string{EnvTraceLevel: "verbose"},
+			wantErr: EnvTraceLevel,
+		},
+		{
+			name:    "invalid public ca",
+			env:     map[string]string{EnvPublicCA: "maybe"},
+			wantErr: EnvPublicCA,
+		},
+		{
+			name:    "invalid check search tags",
+			env:     map[string]string{EnvCheckSearchTags: `svc:"foo"`},
+			wantErr: EnvCheckSearchTags,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			var orig Config
+			if tt.base != nil {
+				orig = *tt.base
+			}
+			got, err := ConfigFromEnv(tt.base)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ConfigFromEnv() error = %v, want error naming %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ConfigFromEnv() unexpected error: %s", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ConfigFromEnv() = %+v, want %+v", got, tt.want)
+			}
+			if tt.base != nil && !reflect.DeepEqual(*tt.base, orig) {
+				t.Errorf("ConfigFromEnv() modified base = %+v, want %+v", *tt.base, orig)
+			}
+		})
+	}
+}
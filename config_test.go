@@ -0,0 +1,337 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/circonus-labs/go-apiclient"
+)
+
+func TestConfig_Validate(t *testing.T) {
+	tests := []struct {
+		cfg     *Config
+		name    string
+		wantErr bool
+	}{
+		{name: "invalid, nil", wantErr: true},
+		{name: "valid, defaults", cfg: &Config{}, wantErr: false},
+		{name: "invalid, broker max response time", cfg: &Config{BrokerMaxResponseTime: "foo"}, wantErr: true},
+		{name: "invalid, submission timeout", cfg: &Config{SubmissionTimeout: "foo"}, wantErr: true},
+		{name: "invalid, ca cert refresh window", cfg: &Config{CACertRefreshWindow: "foo"}, wantErr: true},
+		{name: "invalid, refresh cooldown", cfg: &Config{RefreshCooldown: "foo"}, wantErr: true},
+		{name: "invalid, refresh retry delay", cfg: &Config{RefreshRetryDelay: "foo"}, wantErr: true},
+		{name: "invalid, refresh retry jitter", cfg: &Config{RefreshRetryJitter: "foo"}, wantErr: true},
+		{name: "invalid, filtered warn threshold", cfg: &Config{FilteredWarnThreshold: 1.5}, wantErr: true},
+		{name: "invalid, max response bytes", cfg: &Config{MaxResponseBytes: -1}, wantErr: true},
+		{name: "valid, broker selection strategy", cfg: &Config{BrokerSelectionStrategy: BrokerSelectionSpread}, wantErr: false},
+		{name: "invalid, broker selection strategy", cfg: &Config{BrokerSelectionStrategy: "least"}, wantErr: true},
+		{name: "invalid, broker load cache ttl", cfg: &Config{BrokerLoadCacheTTL: "foo"}, wantErr: true},
+		{name: "invalid, min submission interval", cfg: &Config{MinSubmissionInterval: "foo"}, wantErr: true},
+		{name: "invalid, circuit breaker cooldown", cfg: &Config{CircuitBreakerCooldown: "foo"}, wantErr: true},
+		{name: "invalid, check type", cfg: &Config{CheckConfig: &apiclient.CheckBundle{Type: "json"}}, wantErr: true},
+		{name: "valid, check type", cfg: &Config{CheckConfig: &apiclient.CheckBundle{Type: "httptrap:foo"}}, wantErr: false},
+		{name: "valid, public ca", cfg: &Config{PublicCA: true}, wantErr: false},
+		{name: "valid, metric filters", cfg: &Config{MetricFilters: [][]string{{"allow", "^foo", ""}}}, wantErr: false},
+		{name: "invalid, metric filters", cfg: &Config{MetricFilters: [][]string{{"deny", "^foo", ""}}}, wantErr: true},
+		{
+			name: "invalid, metric filters and check default metric filters",
+			cfg: &Config{
+				MetricFilters: [][]string{{"allow", "^foo", ""}},
+				CheckDefaults: CheckDefaults{MetricFilters: [][]string{{"allow", "^bar", ""}}},
+			},
+			wantErr: true,
+		},
+		{name: "valid, submit tls config", cfg: &Config{SubmitTLSConfig: &tls.Config{MinVersion: tls.VersionTLS12}}, wantErr: false},
+		{
+			name:    "invalid, public ca and submit tls config",
+			cfg:     &Config{PublicCA: true, SubmitTLSConfig: &tls.Config{MinVersion: tls.VersionTLS12}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.cfg.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Config.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNew_NewFromCheckBundle_equivalent(t *testing.T) {
+	bundle := &apiclient.CheckBundle{
+		CID:        "/check_bundle/123",
+		Brokers:    []string{"/broker/123"},
+		CheckUUIDs: []string{"abc-123"},
+		Type:       "httptrap",
+		Config:     apiclient.CheckBundleConfig{"submission_url": "https://trap.example.com:443/module/httptrap/abc-123/secret"},
+		Status:     "active",
+	}
+
+	newClient := func() *APIMock {
+		return &APIMock{
+			FetchCheckBundleFunc: func(cid apiclient.CIDType) (*apiclient.CheckBundle, error) {
+				b := *bundle
+				return &b, nil
+			},
+			FetchBrokersFunc: func() (*[]apiclient.Broker, error) {
+				return &[]apiclient.Broker{}, nil
+			},
+		}
+	}
+
+	tests := []struct {
+		cfg  func() *Config
+		name string
+	}{
+		{
+			name: "public ca",
+			cfg: func() *Config {
+				return &Config{Client: newClient(), PublicCA: true}
+			},
+		},
+		{
+			name: "submit tls config",
+			cfg: func() *Config {
+				return &Config{Client: newClient(), SubmitTLSConfig: &tls.Config{ServerName: "foobar", MinVersion: tls.VersionTLS12}}
+			},
+		},
+		{
+			name: "durations",
+			cfg: func() *Config {
+				return &Config{
+					Client:                newClient(),
+					PublicCA:              true,
+					BrokerMaxResponseTime: "1s",
+					SubmissionTimeout:     "3s",
+					CACertRefreshWindow:   "1h",
+					RefreshCooldown:       "5m",
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			cfgNew := tt.cfg()
+			cfgNew.CheckConfig = &apiclient.CheckBundle{CID: bundle.CID}
+			tcNew, err := New(cfgNew)
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			tcBundle, err := NewFromCheckBundle(tt.cfg(), bundle)
+			if err != nil {
+				t.Fatalf("NewFromCheckBundle() error = %v", err)
+			}
+
+			if tcNew.usingPublicCA != tcBundle.usingPublicCA {
+				t.Errorf("usingPublicCA New=%t NewFromCheckBundle=%t", tcNew.usingPublicCA, tcBundle.usingPublicCA)
+			}
+			if (tcNew.custTLSConfig == nil) != (tcBundle.custTLSConfig == nil) {
+				t.Errorf("custTLSConfig New=%v NewFromCheckBundle=%v", tcNew.custTLSConfig, tcBundle.custTLSConfig)
+			}
+			if (tcNew.tlsConfig == nil) != (tcBundle.tlsConfig == nil) {
+				t.Errorf("tlsConfig New=%v NewFromCheckBundle=%v", tcNew.tlsConfig, tcBundle.tlsConfig)
+			}
+			if tcNew.submissionURL != tcBundle.submissionURL {
+				t.Errorf("submissionURL New=%s NewFromCheckBundle=%s", tcNew.submissionURL, tcBundle.submissionURL)
+			}
+			durations := []struct {
+				name string
+				a, b time.Duration
+			}{
+				{"brokerMaxResponseTime", tcNew.brokerMaxResponseTime, tcBundle.brokerMaxResponseTime},
+				{"submissionTimeout", tcNew.submissionTimeout, tcBundle.submissionTimeout},
+				{"caCertRefreshWindow", tcNew.caCertRefreshWindow, tcBundle.caCertRefreshWindow},
+				{"refreshCooldown", tcNew.refreshCooldown, tcBundle.refreshCooldown},
+				{"refreshRetryDelay", tcNew.refreshRetryDelay, tcBundle.refreshRetryDelay},
+			}
+			for _, d := range durations {
+				if d.a != d.b {
+					t.Errorf("%s New=%s NewFromCheckBundle=%s", d.name, d.a, d.b)
+				}
+			}
+			if tcBundle.usingPublicCA && tcBundle.tlsConfig != nil {
+				t.Errorf("tlsConfig should be nil with PublicCA, got %v", tcBundle.tlsConfig)
+			}
+		})
+	}
+}
+
+func TestConstructors_caCertRefreshWindow(t *testing.T) {
+	bundle := &apiclient.CheckBundle{
+		CID:    "/check_bundle/123",
+		Type:   "httptrap",
+		Config: apiclient.CheckBundleConfig{"submission_url": "http://trap.example.com/module/httptrap/abc-123/secret"},
+		Status: statusActive,
+	}
+	newConfig := func(window string) *Config {
+		return &Config{
+			Client: &APIMock{
+				FetchCheckBundleFunc: func(cid apiclient.CIDType) (*apiclient.CheckBundle, error) {
+					b := *bundle
+					return &b, nil
+				},
+				FetchBrokersFunc: func() (*[]apiclient.Broker, error) {
+					return &[]apiclient.Broker{}, nil
+				},
+			},
+			CheckConfig:         &apiclient.CheckBundle{CID: bundle.CID},
+			CACertRefreshWindow: window,
+		}
+	}
+	// the window is parsed once, in the setup shared by the constructors
+	constructors := map[string]func(cfg *Config) (*TrapCheck, error){
+		"New":                New,
+		"NewFromCheckBundle": func(cfg *Config) (*TrapCheck, error) { return NewFromCheckBundle(cfg, bundle) },
+		"NewFromSubmissionURL": func(cfg *Config) (*TrapCheck, error) {
+			cfg.Client = nil
+			cfg.CheckConfig = nil
+			cfg.SubmissionURL = bundle.Config["submission_url"]
+			return NewFromSubmissionURL(cfg)
+		},
+	}
+	for cname, construct := range constructors {
+		construct := construct
+		t.Run(cname, func(t *testing.T) {
+			tc, err := construct(newConfig(""))
+			if err != nil {
+				t.Fatalf("%s() unexpected error: %s", cname, err)
+			}
+			if want, _ := time.ParseDuration(defaultCACertRefreshWindow); tc.caCertRefreshWindow != want {
+				t.Errorf("caCertRefreshWindow = %s, want %s (default)", tc.caCertRefreshWindow, want)
+			}
+			if tc, err = construct(newConfig("2h")); err != nil {
+				t.Fatalf("%s() unexpected error: %s", cname, err)
+			}
+			if tc.caCertRefreshWindow != 2*time.Hour {
+				t.Errorf("caCertRefreshWindow = %s, want 2h", tc.caCertRefreshWindow)
+			}
+			if _, err := construct(newConfig("foo")); err == nil {
+				t.Errorf("%s() expected error for invalid ca cert refresh window", cname)
+			}
+		})
+	}
+}
+
+func TestConstructors_publicCASubmitTLSConfig(t *testing.T) {
+	caPEM, _, _ := generateTestCA(t, time.Now().Add(24*time.Hour))
+	host := "trap.example.com"
+	broker := &apiclient.Broker{
+		CID:  "/broker/123",
+		Name: "trap",
+		Type: circonusType,
+		Details: []apiclient.BrokerDetail{
+			{CN: host, ExternalHost: &host, ExternalPort: 443, Status: statusActive, Modules: []string{"httptrap"}},
+		},
+	}
+	bundle := &apiclient.CheckBundle{
+		CID:     "/check_bundle/123",
+		Brokers: []string{broker.CID},
+		Type:    "httptrap",
+		Config:  apiclient.CheckBundleConfig{"submission_url": "https://trap.example.com/module/httptrap/abc-123/secret"},
+		Status:  statusActive,
+	}
+	roots := x509.NewCertPool()
+
+	tests := []struct {
+		name           string
+		publicCA       bool
+		submitTLS      bool
+		wantErr        bool
+		wantServerName string
+	}{
+		{name: "neither", wantServerName: host},
+		{name: "public ca", publicCA: true},
+		{name: "submit tls config", submitTLS: true, wantServerName: "custom.example.com"},
+		{name: "both", publicCA: true, submitTLS: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		newConfig := func() *Config {
+			cfg := &Config{
+				Client: &APIMock{
+					FetchCheckBundleFunc: func(cid apiclient.CIDType) (*apiclient.CheckBundle, error) {
+						b := *bundle
+						return &b, nil
+					},
+				},
+				Broker:                      broker,
+				BrokerCACertPEM:             caPEM,
+				SkipBrokerConnectivityCheck: true,
+				PublicCA:                    tt.publicCA,
+				CheckConfig:                 &apiclient.CheckBundle{CID: bundle.CID},
+			}
+			if tt.submitTLS {
+				cfg.SubmitTLSConfig = &tls.Config{ServerName: "custom.example.com", RootCAs: roots, MinVersion: tls.VersionTLS12}
+			}
+			return cfg
+		}
+		constructors := map[string]func() (*TrapCheck, error){
+			"New":                func() (*TrapCheck, error) { return New(newConfig()) },
+			"NewFromCheckBundle": func() (*TrapCheck, error) { return NewFromCheckBundle(newConfig(), bundle) },
+		}
+		for cname, construct := range constructors {
+			construct := construct
+			t.Run(tt.name+"/"+cname, func(t *testing.T) {
+				tc, err := construct()
+				if tt.wantErr {
+					if err == nil || !strings.Contains(err.Error(), "mutually exclusive") {
+						t.Fatalf("%s() error = %v, want mutually exclusive error", cname, err)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("%s() unexpected error: %s", cname, err)
+				}
+				if tc.usingPublicCA != tt.publicCA {
+					t.Errorf("usingPublicCA = %t, want %t", tc.usingPublicCA, tt.publicCA)
+				}
+				if tt.wantServerName == "" {
+					if tc.tlsConfig != nil {
+						t.Errorf("tlsConfig = %v, want nil (public ca)", tc.tlsConfig)
+					}
+					return
+				}
+				if tc.tlsConfig == nil {
+					t.Fatal("tlsConfig is nil")
+				}
+				if tc.tlsConfig.ServerName != tt.wantServerName {
+					t.Errorf("ServerName = %q, want %q", tc.tlsConfig.ServerName, tt.wantServerName)
+				}
+				if tt.submitTLS && tc.tlsConfig.RootCAs != roots {
+					t.Error("caller supplied RootCAs not used")
+				}
+			})
+		}
+	}
+}
+
+func TestConfig_Validate_multipleMatchBehavior(t *testing.T) {
+	tests := []struct {
+		cfg     *Config
+		name    string
+		wantErr bool
+	}{
+		{name: "valid, oldest", cfg: &Config{MultipleMatchBehavior: MultipleMatchOldest}},
+		{name: "valid, tag", cfg: &Config{MultipleMatchBehavior: MultipleMatchTag, MultipleMatchTag: "primary:true"}},
+		{name: "invalid, tag w/o tag", cfg: &Config{MultipleMatchBehavior: MultipleMatchTag}, wantErr: true},
+		{name: "invalid, unknown", cfg: &Config{MultipleMatchBehavior: "random"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.cfg.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Config.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// ConnInfo describes how metrics are submitted (see ConnectionInfo).
+type ConnInfo struct {
+	// ServerName the name the broker certificate is verified against (CN) or
+	// Config.TLSServerName if set, or the ServerName of the caller supplied SubmitTLSConfig
+	ServerName string
+	// BrokerCID the broker in use, if known
+	BrokerCID string
+	// SubmissionHost the submission url host:port
+	SubmissionHost string
+	// CACertSubject subject of the broker CA cert, when the broker CA cert pool is in use
+	CACertSubject string
+	// UsesTLS the submission url uses https
+	UsesTLS bool
+	// PublicCA the broker certificate is verified with the system roots
+	PublicCA bool
+	// BrokerProbe the connectivity probe of the broker, when it was selected/validated
+	BrokerProbe BrokerProbeResult
+	// SubmitConnectTime measured connect time of the first successful submission
+	SubmitConnectTime time.Duration
+}
+
+func (ci ConnInfo) String() string {
+	return fmt.Sprintf("tls:%t server_name:%q public_ca:%t broker:%q host:%q ca:%q",
+		ci.UsesTLS, ci.ServerName, ci.PublicCA, ci.BrokerCID, ci.SubmissionHost, ci.CACertSubject)
+}
+
+// ConnectionInfo returns how metrics are currently submitted - whether tls is
+// used, the name the broker certificate is verified against and the CA. It
+// reflects the current state, e.g. after a check refresh rebuilt the tls config.
+func (tc *TrapCheck) ConnectionInfo() (ConnInfo, error) {
+	tc.stateMu.RLock()
+	defer tc.stateMu.RUnlock()
+	su, err := parseSubmissionURL(tc.submissionURL)
+	if err != nil {
+		return ConnInfo{}, err
+	}
+
+	info := ConnInfo{
+		UsesTLS:        !su.isHTTP(),
+		SubmissionHost: su.hostPort(),
+		BrokerCID:      tc.brokerCID(),
+	}
+	info.BrokerProbe, _ = tc.brokerProbe()
+	info.SubmitConnectTime = time.Duration(atomic.LoadInt64(&tc.submitConnectTime))
+	if !info.UsesTLS || tc.transport != nil {
+		// caller supplied transport handles tls
+		return info, nil
+	}
+
+	if tc.custTLSConfig != nil {
+		info.ServerName = tc.custTLSConfig.ServerName
+		return info, nil
+	}
+
+	if tc.checkBundle != nil {
+		public, err := tc.isPublicBroker()
+		if err != nil {
+			return ConnInfo{}, err
+		}
+		if public {
+			info.PublicCA = true
+			info.ServerName = su.host
+			return info, nil
+		}
+	}
+
+	if tc.tlsConfig == nil {
+		return info, fmt.Errorf("tls config has not been initialized")
+	}
+	info.ServerName = tc.tlsConfig.ServerName
+	info.CACertSubject = caCertSubject(tc.caCertInUse)
+
+	return info, nil
+}
+
+// caCertSubject returns the subject of the first certificate in the PEM data.
+func caCertSubject(data []byte) string {
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return ""
+		}
+		return cert.Subject.String()
+	}
+	return ""
+}
+
+// logConnectionInfo logs how metrics are submitted, once after the first successful submission.
+func (tc *TrapCheck) logConnectionInfo() {
+	info, err := tc.ConnectionInfo()
+	if err != nil {
+		tc.logger().Debugf("connection info: %s", err)
+		return
+	}
+	tc.logger().Infof("submitting metrics - %s", info)
+}
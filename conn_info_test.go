@@ -0,0 +1,130 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/circonus-labs/go-apiclient"
+)
+
+func TestTrapCheck_ConnectionInfo(t *testing.T) {
+	caPEM, _, _ := generateTestCA(t, time.Now().Add(24*time.Hour))
+	ip := "127.0.0.1"
+	port := uint16(43191)
+	broker := &apiclient.Broker{
+		CID:  "/broker/123",
+		Name: "test",
+		Type: circonusType,
+		Details: []apiclient.BrokerDetail{
+			{CN: "broker.example.com", IP: &ip, Port: &port, Status: statusActive, Modules: []string{"httptrap"}},
+		},
+	}
+
+	tests := []struct {
+		custTLS *tls.Config
+		want    ConnInfo
+		name    string
+		surl    string
+		wantErr bool
+	}{
+		{
+			name: "http",
+			surl: "http://127.0.0.1:43191/module/httptrap/abc/secret",
+			want: ConnInfo{SubmissionHost: "127.0.0.1:43191", BrokerCID: broker.CID},
+		},
+		{
+			name: "public ca https",
+			surl: "https://api.circonus.com/module/httptrap/abc/secret",
+			want: ConnInfo{UsesTLS: true, PublicCA: true, ServerName: "api.circonus.com", SubmissionHost: "api.circonus.com:443", BrokerCID: broker.CID},
+		},
+		{
+			name: "custom ca https",
+			surl: "https://127.0.0.1:43191/module/httptrap/abc/secret",
+			want: ConnInfo{
+				UsesTLS:        true,
+				ServerName:     "broker.example.com",
+				SubmissionHost: "127.0.0.1:43191",
+				BrokerCID:      broker.CID,
+				CACertSubject:  "CN=Test Certificate Authority",
+			},
+		},
+		{
+			name:    "submit tls config",
+			surl:    "https://127.0.0.1:43191/module/httptrap/abc/secret",
+			custTLS: &tls.Config{ServerName: "custom.example.com", MinVersion: tls.VersionTLS12},
+			want:    ConnInfo{UsesTLS: true, ServerName: "custom.example.com", SubmissionHost: "127.0.0.1:43191", BrokerCID: broker.CID},
+		},
+		{
+			name:    "invalid submission url",
+			surl:    ":foo",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			tc := &TrapCheck{
+				broker:        broker,
+				checkBundle:   &apiclient.CheckBundle{Brokers: []string{broker.CID}, Config: apiclient.CheckBundleConfig{"submission_url": tt.surl}},
+				submissionURL: tt.surl,
+				caCertPEM:     caPEM,
+				custTLSConfig: tt.custTLS,
+			}
+			tc.Log = &LogWrapper{Log: log.New(io.Discard, "", 0)}
+			if !tt.wantErr {
+				if err := tc.setBrokerTLSConfig(); err != nil {
+					t.Fatalf("setBrokerTLSConfig() unexpected error: %s", err)
+				}
+			}
+
+			got, err := tc.ConnectionInfo()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ConnectionInfo() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ConnectionInfo() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTrapCheck_ConnectionInfo_loggedOnce(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, `{"stats":1}`)
+	}))
+	defer ts.Close()
+
+	var logs bytes.Buffer
+	tc := &TrapCheck{
+		checkBundle:       &apiclient.CheckBundle{},
+		custSubmissionURL: ts.URL,
+		submissionURL:     ts.URL,
+		submissionTimeout: 5 * time.Second,
+	}
+	tc.Log = &LogWrapper{Log: log.New(&logs, "", 0)}
+
+	for i := 0; i < 3; i++ {
+		var metrics bytes.Buffer
+		metrics.WriteString(`{"foo":{"_type":"n","_value":1}}`)
+		if _, err := tc.SendMetrics(context.Background(), metrics); err != nil {
+			t.Fatalf("SendMetrics() unexpected error: %s", err)
+		}
+	}
+	if n := strings.Count(logs.String(), "submitting metrics - tls:false"); n != 1 {
+		t.Errorf("connection info logged %d times, want 1 (%s)", n, logs.String())
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/circonus-labs/go-apiclient"
+)
+
+// ErrCheckDeactivated is returned by SendMetrics after DeactivateCheck.
+var ErrCheckDeactivated = errors.New("check deactivated")
+
+// DeactivateCheck sets the status of the check bundle in use to disabled
+// (bundles are never deleted) and returns the updated bundle. Subsequent
+// submissions return ErrCheckDeactivated. Not available with a custom
+// SubmissionURL or without an API client (ErrNoAPIClient).
+func (tc *TrapCheck) DeactivateCheck(ctx context.Context) (*apiclient.CheckBundle, error) {
+	bundle, err := tc.setCheckStatus(ctx, statusDisabled)
+	if err != nil {
+		return nil, fmt.Errorf("deactivate check: %w", err)
+	}
+	atomic.StoreInt32(&tc.deactivated, 1)
+	tc.logger().Infof("deactivated check bundle %s", bundle.CID)
+	return bundle, nil
+}
+
+// ReactivateCheck sets the status of the check bundle in use to active and
+// returns the updated bundle, submissions are accepted again.
+func (tc *TrapCheck) ReactivateCheck(ctx context.Context) (*apiclient.CheckBundle, error) {
+	bundle, err := tc.setCheckStatus(ctx, statusActive)
+	if err != nil {
+		return nil, fmt.Errorf("reactivate check: %w", err)
+	}
+	atomic.StoreInt32(&tc.deactivated, 0)
+	tc.logger().Infof("reactivated check bundle %s", bundle.CID)
+	return bundle, nil
+}
+
+// checkDeactivated returns true if DeactivateCheck was called.
+func (tc *TrapCheck) checkDeactivated() bool {
+	return atomic.LoadInt32(&tc.deactivated) == 1
+}
+
+// setCheckStatus updates the status of the check bundle in use.
+func (tc *TrapCheck) setCheckStatus(ctx context.Context, status string) (*apiclient.CheckBundle, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	tc.stateMu.RLock()
+	custom, current := tc.custSubmissionURL != "", tc.checkBundle
+	tc.stateMu.RUnlock()
+	if custom {
+		return nil, fmt.Errorf("custom submission url in use, no check bundle to update")
+	}
+	if tc.client == nil {
+		return nil, ErrNoAPIClient
+	}
+	if current == nil || current.CID == "" {
+		return nil, fmt.Errorf("invalid state, check bundle not initialized")
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	update := *current
+	update.Status = status
+	bundle, err := tc.client.UpdateCheckBundle(&update)
+	if err != nil {
+		return nil, fmt.Errorf("api updating check bundle (%s): %w", update.CID, err)
+	}
+	if bundle == nil {
+		return nil, fmt.Errorf("api updating check bundle (%s): empty response", update.CID)
+	}
+
+	tc.stateMu.Lock()
+	tc.checkBundle = bundle
+	tc.unlockState()
+	result := *bundle
+	return &result, nil
+}
@@ -0,0 +1,165 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/circonus-labs/go-apiclient"
+)
+
+func TestTrapCheck_DeactivateCheck(t *testing.T) {
+	var submissions int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		submissions++
+		fmt.Fprintln(w, `{"stats":1}`)
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		name          string
+		client        API
+		checkBundle   *apiclient.CheckBundle
+		custURL       string
+		wantErr       bool
+		wantErrIs     error
+		wantSubmitErr error
+	}{
+		{
+			name: "valid",
+			client: &APIMock{
+				UpdateCheckBundleFunc: func(cfg *apiclient.CheckBundle) (*apiclient.CheckBundle, error) {
+					b := *cfg
+					return &b, nil
+				},
+			},
+			checkBundle:   &apiclient.CheckBundle{CID: "/check_bundle/123", Status: "active"},
+			wantSubmitErr: ErrCheckDeactivated,
+		},
+		{
+			name: "api error",
+			client: &APIMock{
+				UpdateCheckBundleFunc: func(cfg *apiclient.CheckBundle) (*apiclient.CheckBundle, error) {
+					return nil, fmt.Errorf("API 500")
+				},
+			},
+			checkBundle: &apiclient.CheckBundle{CID: "/check_bundle/123", Status: "active"},
+			wantErr:     true,
+		},
+		{
+			name:        "invalid, custom submission url",
+			client:      &APIMock{},
+			checkBundle: &apiclient.CheckBundle{CID: "/check_bundle/123"},
+			custURL:     ts.URL,
+			wantErr:     true,
+		},
+		{
+			name:    "invalid, nil bundle",
+			client:  &APIMock{},
+			wantErr: true,
+		},
+		{
+			name:        "invalid, no api client",
+			checkBundle: &apiclient.CheckBundle{CID: "/check_bundle/123"},
+			wantErr:     true,
+			wantErrIs:   ErrNoAPIClient,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			tc := &TrapCheck{
+				client:            tt.client,
+				checkBundle:       tt.checkBundle,
+				custSubmissionURL: tt.custURL,
+				submissionURL:     ts.URL,
+				submissionTimeout: 5 * time.Second,
+			}
+			tc.Log = &LogWrapper{Log: log.New(io.Discard, "", 0)}
+
+			got, err := tc.DeactivateCheck(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("TrapCheck.DeactivateCheck() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErrIs != nil && !errors.Is(err, tt.wantErrIs) {
+				t.Errorf("TrapCheck.DeactivateCheck() error = %v, want %v", err, tt.wantErrIs)
+			}
+			if !tt.wantErr {
+				if got == nil || got.Status != statusDisabled {
+					t.Fatalf("TrapCheck.DeactivateCheck() = %v, want disabled bundle", got)
+				}
+				if tc.checkBundle.Status != statusDisabled {
+					t.Errorf("check bundle status = %s, want %s", tc.checkBundle.Status, statusDisabled)
+				}
+			}
+
+			before := submissions
+			var metrics bytes.Buffer
+			metrics.WriteString(`{"foo":{"_type":"n","_value":1}}`)
+			_, err = tc.SendMetrics(context.Background(), metrics)
+			if tt.wantSubmitErr != nil {
+				if !errors.Is(err, tt.wantSubmitErr) {
+					t.Errorf("TrapCheck.SendMetrics() error = %v, want %v", err, tt.wantSubmitErr)
+				}
+				if submissions != before {
+					t.Error("TrapCheck.SendMetrics() submitted after deactivation")
+				}
+			} else if err != nil {
+				t.Errorf("TrapCheck.SendMetrics() unexpected error: %s", err)
+			}
+		})
+	}
+}
+
+func TestTrapCheck_ReactivateCheck(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, `{"stats":1}`)
+	}))
+	defer ts.Close()
+
+	client := &APIMock{
+		UpdateCheckBundleFunc: func(cfg *apiclient.CheckBundle) (*apiclient.CheckBundle, error) {
+			b := *cfg
+			return &b, nil
+		},
+	}
+	tc := &TrapCheck{
+		client:            client,
+		checkBundle:       &apiclient.CheckBundle{CID: "/check_bundle/123", Status: "active"},
+		submissionURL:     ts.URL,
+		submissionTimeout: 5 * time.Second,
+	}
+	tc.Log = &LogWrapper{Log: log.New(io.Discard, "", 0)}
+
+	if _, err := tc.DeactivateCheck(context.Background()); err != nil {
+		t.Fatalf("TrapCheck.DeactivateCheck() unexpected error: %s", err)
+	}
+	got, err := tc.ReactivateCheck(context.Background())
+	if err != nil {
+		t.Fatalf("TrapCheck.ReactivateCheck() unexpected error: %s", err)
+	}
+	if got.Status != statusActive {
+		t.Errorf("TrapCheck.ReactivateCheck() status = %s, want %s", got.Status, statusActive)
+	}
+
+	var metrics bytes.Buffer
+	metrics.WriteString(`{"foo":{"_type":"n","_value":1}}`)
+	if _, err := tc.SendMetrics(context.Background(), metrics); err != nil {
+		t.Errorf("TrapCheck.SendMetrics() after reactivation unexpected error: %s", err)
+	}
+	if n := len(client.UpdateCheckBundleCalls()); n != 2 {
+		t.Errorf("UpdateCheckBundle calls = %d, want 2", n)
+	}
+}
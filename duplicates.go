@@ -0,0 +1,103 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/circonus-labs/go-apiclient"
+)
+
+const statusDisabled = "disabled"
+
+// FindDuplicateChecks runs the search used to find the check bundle and
+// returns all active bundles of the same type other than the one currently
+// in use. Check search tags must be set, to avoid overly broad matches.
+func (tc *TrapCheck) FindDuplicateChecks(ctx context.Context) ([]apiclient.CheckBundle, error) {
+	current, err := tc.verifyDuplicateCheckOp()
+	if err != nil {
+		return nil, err
+	}
+
+	searchCriteria, err := tc.searchCriteria(current.Type, current.Target)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("find duplicate checks: %w", err)
+	}
+
+	bundles, err := tc.client.SearchCheckBundles(&searchCriteria, nil)
+	if err != nil {
+		return nil, fmt.Errorf("search check bundles (%s): %w", searchCriteria, err)
+	}
+
+	dups := []apiclient.CheckBundle{}
+	for _, bundle := range *bundles {
+		if bundle.CID == current.CID || bundle.Type != current.Type {
+			continue
+		}
+		dups = append(dups, bundle)
+	}
+
+	return dups, nil
+}
+
+// DeactivateChecks sets the status of each check bundle to disabled
+// (bundles are never deleted). The check bundle currently in use is
+// refused. Check search tags must be set.
+func (tc *TrapCheck) DeactivateChecks(ctx context.Context, cids []string) error {
+	current, err := tc.verifyDuplicateCheckOp()
+	if err != nil {
+		return err
+	}
+
+	for _, cid := range cids {
+		if cid == current.CID {
+			return fmt.Errorf("refusing to deactivate check bundle in use (%s)", cid)
+		}
+	}
+
+	for _, cid := range cids {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("deactivate checks: %w", err)
+		}
+
+		cid := cid
+		bundle, err := tc.client.FetchCheckBundle(apiclient.CIDType(&cid))
+		if err != nil {
+			return fmt.Errorf("fetching check bundle (%s): %w", cid, err)
+		}
+		if bundle.Status == statusDisabled {
+			continue
+		}
+		bundle.Status = statusDisabled
+		if _, err := tc.client.UpdateCheckBundle(bundle); err != nil {
+			return fmt.Errorf("deactivating check bundle (%s): %w", cid, err)
+		}
+		tc.logger().Infof("deactivated check bundle %s", cid)
+	}
+
+	return nil
+}
+
+// verifyDuplicateCheckOp returns the check bundle in use, if duplicate
+// checks can be searched for.
+func (tc *TrapCheck) verifyDuplicateCheckOp() (*apiclient.CheckBundle, error) {
+	if tc.client == nil {
+		return nil, fmt.Errorf("duplicate checks: %w", ErrNoAPIClient)
+	}
+	current := tc.currentCheckBundle()
+	if current == nil || current.CID == "" {
+		return nil, fmt.Errorf("invalid state, check bundle not initialized")
+	}
+	if len(tc.checkSearchTags) == 0 {
+		return nil, fmt.Errorf("check search tags required")
+	}
+	return current, nil
+}
@@ -0,0 +1,123 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"reflect"
+	"testing"
+
+	"github.com/circonus-labs/go-apiclient"
+)
+
+func TestTrapCheck_FindDuplicateChecks(t *testing.T) {
+	current := apiclient.CheckBundle{CID: "/check_bundle/1", Type: "httptrap", Target: "foo", Status: statusActive}
+	client := &APIMock{
+		SearchCheckBundlesFunc: func(searchCriteria *apiclient.SearchQueryType, filterCriteria *apiclient.SearchFilterType) (*[]apiclient.CheckBundle, error) {
+			return &[]apiclient.CheckBundle{
+				current,
+				{CID: "/check_bundle/2", Type: "httptrap", Target: "foo"},
+				{CID: "/check_bundle/3", Type: "httptrap:other", Target: "foo"},
+				{CID: "/check_bundle/4", Type: "httptrap", Target: "foo"},
+			}, nil
+		},
+	}
+
+	tests := []struct {
+		client  API
+		name    string
+		tags    apiclient.TagType
+		want    []string
+		wantErr bool
+	}{
+		{name: "invalid, no api client", tags: apiclient.TagType{"service:foo"}, wantErr: true},
+		{name: "invalid, no search tags", client: client, wantErr: true},
+		{name: "valid", client: client, tags: apiclient.TagType{"service:foo"}, want: []string{"/check_bundle/2", "/check_bundle/4"}},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			bundle := current
+			tc := &TrapCheck{
+				client:          tt.client,
+				checkBundle:     &bundle,
+				checkSearchTags: tt.tags,
+				Log:             &LogWrapper{Log: log.New(io.Discard, "", log.LstdFlags)},
+			}
+			got, err := tc.FindDuplicateChecks(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("TrapCheck.FindDuplicateChecks() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			cids := make([]string, len(got))
+			for i, b := range got {
+				cids[i] = b.CID
+			}
+			if !reflect.DeepEqual(cids, tt.want) {
+				t.Errorf("TrapCheck.FindDuplicateChecks() = %v, want %v", cids, tt.want)
+			}
+		})
+	}
+}
+
+func TestTrapCheck_DeactivateChecks(t *testing.T) {
+	newClient := func(updated *[]apiclient.CheckBundle) *APIMock {
+		return &APIMock{
+			FetchCheckBundleFunc: func(cid apiclient.CIDType) (*apiclient.CheckBundle, error) {
+				if *cid == "/check_bundle/404" {
+					return nil, errors.New("not found")
+				}
+				return &apiclient.CheckBundle{CID: *cid, Status: statusActive}, nil
+			},
+			UpdateCheckBundleFunc: func(cfg *apiclient.CheckBundle) (*apiclient.CheckBundle, error) {
+				*updated = append(*updated, *cfg)
+				return cfg, nil
+			},
+		}
+	}
+
+	tests := []struct {
+		name        string
+		cids        []string
+		tags        apiclient.TagType
+		wantUpdated int
+		wantErr     bool
+	}{
+		{name: "invalid, no search tags", cids: []string{"/check_bundle/2"}, wantErr: true},
+		{name: "invalid, bundle in use", cids: []string{"/check_bundle/2", "/check_bundle/1"}, tags: apiclient.TagType{"service:foo"}, wantErr: true},
+		{name: "invalid, fetch error", cids: []string{"/check_bundle/404"}, tags: apiclient.TagType{"service:foo"}, wantErr: true},
+		{name: "valid", cids: []string{"/check_bundle/2", "/check_bundle/3"}, tags: apiclient.TagType{"service:foo"}, wantUpdated: 2},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			var updated []apiclient.CheckBundle
+			tc := &TrapCheck{
+				client:          newClient(&updated),
+				checkBundle:     &apiclient.CheckBundle{CID: "/check_bundle/1"},
+				checkSearchTags: tt.tags,
+				Log:             &LogWrapper{Log: log.New(io.Discard, "", log.LstdFlags)},
+			}
+			err := tc.DeactivateChecks(context.Background(), tt.cids)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("TrapCheck.DeactivateChecks() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(updated) != tt.wantUpdated {
+				t.Fatalf("TrapCheck.DeactivateChecks() updated %d, want %d", len(updated), tt.wantUpdated)
+			}
+			for _, b := range updated {
+				if b.Status != statusDisabled {
+					t.Errorf("TrapCheck.DeactivateChecks() %s status = %s, want %s", b.CID, b.Status, statusDisabled)
+				}
+			}
+		})
+	}
+}
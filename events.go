@@ -0,0 +1,149 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"time"
+)
+
+// EventType identifies a lifecycle event (see Config.EventHandler).
+type EventType string
+
+const (
+	// EventCheckCreated a check bundle was created (NewValue is the check bundle cid).
+	EventCheckCreated EventType = "check-created"
+	// EventCheckFound an existing check bundle was found by searching (NewValue is the check bundle cid).
+	EventCheckFound EventType = "check-found"
+	// EventCheckRefreshed the check bundle was refreshed from the API (Detail is the refresh reason,
+	// OldValue and NewValue are the previous and current check bundle last modified times).
+	EventCheckRefreshed EventType = "check-refreshed"
+	// EventCheckModified the check bundle was modified externally (Detail is the comma separated changed
+	// settings, OldValue and NewValue are the previous and current check bundle last modified times).
+	EventCheckModified EventType = "check-modified"
+	// EventBrokerSelected a broker was selected for a new check (NewValue is the broker cid).
+	EventBrokerSelected EventType = "broker-selected"
+	// EventBrokerChanged the check moved brokers (OldValue and NewValue are the broker cids).
+	EventBrokerChanged EventType = "broker-changed"
+	// EventTLSRebuilt the broker tls config was (re)built (Detail is the reason if rebuilt, NewValue the broker CN).
+	EventTLSRebuilt EventType = "tls-rebuilt"
+	// EventSubmissionFailed a submission failed after retries (Detail is the error, check secret redacted).
+	EventSubmissionFailed EventType = "submission-failed"
+)
+
+// eventQueueSize number of events queued for the handler, further events are dropped.
+const eventQueueSize = 100
+
+// Event is a lifecycle event passed to Config.EventHandler.
+type Event struct {
+	Timestamp time.Time
+	Type      EventType
+	Detail    string
+	OldValue  string
+	NewValue  string
+}
+
+// emitEvent queues an event for the event handler, without blocking. Events
+// are delivered in order by a single goroutine, which exits when the queue
+// is empty. If the queue is full the event is dropped.
+func (tc *TrapCheck) emitEvent(typ EventType, detail, oldValue, newValue string) {
+	if tc.eventHandler == nil {
+		return
+	}
+	tc.queueEvent(tc.newEvent(typ, detail, oldValue, newValue))
+}
+
+// emitEventLocked collects an event, emitted by unlockState after stateMu is
+// released. stateMu must be held (write lock).
+func (tc *TrapCheck) emitEventLocked(typ EventType, detail, oldValue, newValue string) {
+	if tc.eventHandler == nil {
+		return
+	}
+	tc.stateEvents = append(tc.stateEvents, tc.newEvent(typ, detail, oldValue, newValue))
+}
+
+// unlockState releases the stateMu write lock, then emits the events and
+// writes the check bundle cache collected while it was held.
+func (tc *TrapCheck) unlockState() {
+	events, bundle := tc.stateEvents, tc.stateSaveBundle
+	tc.stateEvents, tc.stateSaveBundle = nil, nil
+	tc.stateMu.Unlock()
+
+	for _, ev := range events {
+		tc.queueEvent(ev)
+	}
+	if bundle != nil {
+		tc.saveCachedBundle(bundle)
+	}
+}
+
+func (tc *TrapCheck) newEvent(typ EventType, detail, oldValue, newValue string) Event {
+	return Event{
+		Type:      typ,
+		Timestamp: tc.clock().Now(),
+		Detail:    detail,
+		OldValue:  oldValue,
+		NewValue:  newValue,
+	}
+}
+
+// queueEvent queues the event and starts the dispatcher if it is not running.
+func (tc *TrapCheck) queueEvent(ev Event) {
+	tc.eventsMu.Lock()
+	defer tc.eventsMu.Unlock()
+
+	if tc.eventsClosed {
+		return
+	}
+	if len(tc.eventQueue) >= eventQueueSize {
+		tc.logger().Warnf("event queue full, dropping %s event", ev.Type)
+		return
+	}
+	tc.eventQueue = append(tc.eventQueue, ev)
+	if !tc.eventsDispatching {
+		tc.eventsDispatching = true
+		tc.eventsWG.Add(1)
+		go tc.dispatchEvents()
+	}
+}
+
+// dispatchEvents calls the event handler for each queued event, it exits
+// when the queue is empty (queueEvent starts it again).
+func (tc *TrapCheck) dispatchEvents() {
+	defer tc.eventsWG.Done()
+	for {
+		tc.eventsMu.Lock()
+		if len(tc.eventQueue) == 0 {
+			tc.eventsDispatching = false
+			tc.eventsMu.Unlock()
+			return
+		}
+		ev := tc.eventQueue[0]
+		tc.eventQueue[0] = Event{}
+		tc.eventQueue = tc.eventQueue[1:]
+		tc.eventsMu.Unlock()
+
+		tc.handleEvent(ev)
+	}
+}
+
+// handleEvent calls the event handler, recovering from panics in the handler.
+func (tc *TrapCheck) handleEvent(ev Event) {
+	defer func() {
+		if r := recover(); r != nil {
+			tc.logger().Warnf("event handler panic (%s event): %v", ev.Type, r)
+		}
+	}()
+	tc.eventHandler(ev)
+}
+
+// closeEvents stops accepting events and waits for queued events to be delivered.
+func (tc *TrapCheck) closeEvents() {
+	tc.eventsMu.Lock()
+	tc.eventsClosed = true
+	tc.eventsMu.Unlock()
+
+	tc.eventsWG.Wait()
+}
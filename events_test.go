@@ -0,0 +1,243 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/circonus-labs/go-apiclient"
+)
+
+// eventRecorder collects events passed to the event handler.
+type eventRecorder struct {
+	events []Event
+	mu     sync.Mutex
+}
+
+func (r *eventRecorder) handler(ev Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, ev)
+}
+
+func (r *eventRecorder) types() []EventType {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	types := make([]EventType, 0, len(r.events))
+	for _, ev := range r.events {
+		types = append(types, ev.Type)
+	}
+	return types
+}
+
+func TestEvents_createThenRefresh(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, `{"stats":1}`)
+	}))
+	defer ts.Close()
+
+	tsURL, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatalf("creating test broker: %s", err)
+	}
+	brokerIP := tsURL.Hostname()
+	bp, err := strconv.Atoi(tsURL.Port())
+	if err != nil {
+		t.Fatalf("parsing test broker port: %s", err)
+	}
+	brokerPort := uint16(bp)
+
+	caPEM, _, _ := generateTestCA(t, time.Now().Add(24*time.Hour))
+	caJSON, err := json.Marshal(caCert{Contents: string(caPEM)})
+	if err != nil {
+		t.Fatalf("marshal ca cert: %s", err)
+	}
+
+	newBroker := func(cid, cn string, tags []string) apiclient.Broker {
+		return apiclient.Broker{
+			CID:  cid,
+			Name: cn,
+			Type: circonusType,
+			Tags: tags,
+			Details: []apiclient.BrokerDetail{
+				{CN: cn, Status: statusActive, Modules: []string{"httptrap"}, IP: &brokerIP, Port: &brokerPort},
+			},
+		}
+	}
+	broker123 := newBroker("/broker/123", "broker-123.example.com", []string{"events:test"})
+	broker456 := newBroker("/broker/456", "broker-456.example.com", nil)
+
+	surl := fmt.Sprintf("https://%s:%d/module/httptrap/0c5b9a36-2a2e-4e3c-9c8f-0d1f2a3b4c5d/secret", brokerIP, brokerPort)
+	var created apiclient.CheckBundle
+	client := &APIMock{
+		GetFunc: func(requrl string) ([]byte, error) {
+			return caJSON, nil
+		},
+		FetchBrokersFunc: func() (*[]apiclient.Broker, error) {
+			return &[]apiclient.Broker{broker123, broker456}, nil
+		},
+		SearchCheckBundlesFunc: func(searchCriteria *apiclient.SearchQueryType, filterCriteria *apiclient.SearchFilterType) (*[]apiclient.CheckBundle, error) {
+			return &[]apiclient.CheckBundle{}, nil
+		},
+		CreateCheckBundleFunc: func(cfg *apiclient.CheckBundle) (*apiclient.CheckBundle, error) {
+			created = *cfg
+			created.CID = "/check_bundle/123"
+			created.Status = statusActive
+			created.LastModified = 1
+			created.Config = apiclient.CheckBundleConfig{"submission_url": surl}
+			bundle := created
+			return &bundle, nil
+		},
+		FetchCheckBundleFunc: func(cid apiclient.CIDType) (*apiclient.CheckBundle, error) {
+			bundle := created
+			bundle.Brokers = []string{broker456.CID}
+			bundle.LastModified = 2
+			return &bundle, nil
+		},
+	}
+
+	logger := &LogWrapper{Log: log.New(io.Discard, "", 0)}
+	initTestBrokerList(t, client, logger)
+
+	rec := &eventRecorder{}
+	tc, err := New(&Config{
+		Client:           client,
+		Logger:           logger,
+		BrokerSelectTags: apiclient.TagType{"events:test"},
+		EventHandler:     rec.handler,
+	})
+	if err != nil {
+		t.Fatalf("New() unexpected error: %s", err)
+	}
+	if _, err := tc.RefreshCheckBundle(); err != nil {
+		t.Fatalf("RefreshCheckBundle() unexpected error: %s", err)
+	}
+	if err := tc.Close(); err != nil {
+		t.Fatalf("Close() unexpected error: %s", err)
+	}
+
+	want := []EventType{
+		EventBrokerSelected,
+		EventCheckCreated,
+		EventTLSRebuilt,
+		EventTLSRebuilt,
+		EventBrokerChanged,
+		EventCheckRefreshed,
+	}
+	if got := rec.types(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("events = %v, want %v", got, want)
+	}
+
+	events := rec.events
+	if events[0].NewValue != broker123.CID {
+		t.Errorf("broker selected = %q, want %s", events[0].NewValue, broker123.CID)
+	}
+	if events[1].NewValue != "/check_bundle/123" {
+		t.Errorf("check created = %q, want /check_bundle/123", events[1].NewValue)
+	}
+	if events[2].NewValue != "broker-123.example.com" || events[3].NewValue != "broker-456.example.com" {
+		t.Errorf("tls rebuilt CNs = %q, %q", events[2].NewValue, events[3].NewValue)
+	}
+	if events[4].OldValue != broker123.CID || events[4].NewValue != broker456.CID {
+		t.Errorf("broker changed = %q -> %q, want %s -> %s", events[4].OldValue, events[4].NewValue, broker123.CID, broker456.CID)
+	}
+	if ev := events[5]; ev.Detail != string(RefreshReasonManual) || ev.OldValue != "1" || ev.NewValue != "2" {
+		t.Errorf("check refreshed = %+v, want manual 1 -> 2", ev)
+	}
+	for _, ev := range events {
+		if ev.Timestamp.IsZero() {
+			t.Errorf("%s event has no timestamp", ev.Type)
+		}
+	}
+}
+
+func TestEvents_submissionFailedHandlerPanic(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad request", http.StatusBadRequest)
+	}))
+	defer ts.Close()
+
+	var logs bytes.Buffer
+	rec := &eventRecorder{}
+	calls := 0
+	surl := ts.URL + "/module/httptrap/0c5b9a36-2a2e-4e3c-9c8f-0d1f2a3b4c5d/s3cr3t"
+	tc := &TrapCheck{
+		checkBundle:       &apiclient.CheckBundle{},
+		custSubmissionURL: surl,
+		submissionURL:     surl,
+		submissionTimeout: 5 * time.Second,
+		eventHandler: func(ev Event) {
+			calls++
+			if calls == 1 {
+				panic("handler bug")
+			}
+			rec.handler(ev)
+		},
+	}
+	tc.Log = &LogWrapper{Log: log.New(&logs, "", 0)}
+
+	for i := 0; i < 2; i++ {
+		var metrics bytes.Buffer
+		metrics.WriteString(`{"foo":{"_type":"n","_value":1}}`)
+		if _, err := tc.SendMetrics(context.Background(), metrics); err == nil {
+			t.Fatal("SendMetrics() expected error")
+		}
+	}
+	if err := tc.Close(); err != nil {
+		t.Fatalf("Close() unexpected error: %s", err)
+	}
+
+	if got := rec.types(); !reflect.DeepEqual(got, []EventType{EventSubmissionFailed}) {
+		t.Fatalf("events = %v, want [%s]", got, EventSubmissionFailed)
+	}
+	if detail := rec.events[0].Detail; strings.Contains(detail, "s3cr3t") || !strings.Contains(detail, "400") {
+		t.Errorf("submission failed detail = %q, want 400 with secret redacted", detail)
+	}
+	if !strings.Contains(logs.String(), "event handler panic") {
+		t.Errorf("handler panic not logged: %s", logs.String())
+	}
+
+	// events after close are dropped
+	tc.emitEvent(EventCheckFound, "", "", "")
+}
+
+func TestEvents_dispatcherExitsWhenIdle(t *testing.T) {
+	rec := &eventRecorder{}
+	tc := newTestTrapCheck("")
+	tc.eventHandler = rec.handler
+
+	// never closed, the dispatcher must not outlive the queued events
+	for round := 1; round <= 2; round++ {
+		tc.emitEvent(EventCheckFound, "", "", "/check_bundle/1")
+		done := make(chan struct{})
+		go func() {
+			tc.eventsWG.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("round %d: event dispatcher still running with an empty queue", round)
+		}
+		if n := len(rec.types()); n != round {
+			t.Fatalf("round %d: events = %d, want %d", round, n, round)
+		}
+	}
+}
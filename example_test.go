@@ -0,0 +1,326 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/circonus-labs/go-apiclient"
+	trapcheck "github.com/circonus-labs/go-trapcheck"
+	"github.com/circonus-labs/go-trapcheck/trapchecktest"
+)
+
+// exampleTB lets the examples (which have no *testing.T) use the trapchecktest
+// fakes, failures panic and cleanups run when the example returns.
+type exampleTB struct {
+	testing.TB
+	cleanups []func()
+}
+
+func (tb *exampleTB) Helper() {}
+
+func (tb *exampleTB) Fatalf(format string, args ...interface{}) {
+	panic(fmt.Sprintf(format, args...))
+}
+
+func (tb *exampleTB) Cleanup(f func()) {
+	tb.cleanups = append(tb.cleanups, f)
+}
+
+func (tb *exampleTB) done() {
+	for i := len(tb.cleanups) - 1; i >= 0; i-- {
+		tb.cleanups[i]()
+	}
+}
+
+// exampleLogger discards the trap check log output, so it does not
+// interfere with the example output.
+func exampleLogger() trapcheck.Logger {
+	return &trapcheck.LogWrapper{Log: log.New(io.Discard, "", log.LstdFlags)}
+}
+
+// The check is searched for by its search tags and created (with the tags)
+// when it does not exist, subsequent runs find and reuse it.
+func ExampleNew() {
+	tb := &exampleTB{}
+	defer tb.done()
+
+	fb := trapchecktest.NewFakeBroker(tb)
+	api := trapchecktest.NewFakeAPI(nil)
+	broker := api.AddBroker("/broker/1", fb)
+
+	cfg := &trapcheck.Config{
+		Client:          api,
+		CheckSearchTags: apiclient.TagType{"service:example", "env:test"},
+		CheckConfig:     &apiclient.CheckBundle{Target: "example-host"},
+		Broker:          &broker, // skip broker selection, use the fake broker
+		Logger:          exampleLogger(),
+	}
+
+	tc, err := trapcheck.New(cfg)
+	if err != nil {
+		fmt.Println("New:", err)
+		return
+	}
+	fmt.Println("new check:", tc.IsNewCheckBundle())
+
+	var metrics bytes.Buffer
+	metrics.WriteString(`{"requests":{"_type":"L","_value":42}}`)
+	result, err := tc.SendMetrics(context.Background(), metrics)
+	if err != nil {
+		fmt.Println("SendMetrics:", err)
+		return
+	}
+	fmt.Println("stats:", result.Stats)
+	fmt.Println("broker received:", string(fb.Submissions()[0].Payload))
+
+	// e.g. after a restart, the check is found by its search tags
+	again, err := trapcheck.New(cfg)
+	if err != nil {
+		fmt.Println("New:", err)
+		return
+	}
+	first, _ := tc.GetCheckBundle()
+	found, _ := again.GetCheckBundle()
+	fmt.Println("new check:", again.IsNewCheckBundle())
+	fmt.Println("same check:", found.CID == first.CID)
+	fmt.Println("check bundles:", len(api.CheckBundles()))
+
+	// Output:
+	// new check: true
+	// stats: 1
+	// broker received: {"requests":{"_type":"L","_value":42}}
+	// new check: false
+	// same check: true
+	// check bundles: 1
+}
+
+// Caching the check bundle (e.g. in a file) and restoring it with
+// NewFromCheckBundle avoids searching for the check on every start.
+func ExampleNewFromCheckBundle() {
+	tb := &exampleTB{}
+	defer tb.done()
+
+	fb := trapchecktest.NewFakeBroker(tb)
+	api := trapchecktest.NewFakeAPI(nil)
+	broker := api.AddBroker("/broker/1", fb)
+
+	cfg := &trapcheck.Config{
+		Client:          api,
+		CheckSearchTags: apiclient.TagType{"service:cached"},
+		CheckConfig:     &apiclient.CheckBundle{Target: "cached-host"},
+		Broker:          &broker,
+		Logger:          exampleLogger(),
+	}
+
+	tc, err := trapcheck.New(cfg)
+	if err != nil {
+		fmt.Println("New:", err)
+		return
+	}
+	bundle, err := tc.GetCheckBundle()
+	if err != nil {
+		fmt.Println("GetCheckBundle:", err)
+		return
+	}
+	cached, err := json.Marshal(bundle)
+	if err != nil {
+		fmt.Println("caching bundle:", err)
+		return
+	}
+
+	// on the next start, restore the check from the cached bundle
+	var restored apiclient.CheckBundle
+	if err := json.Unmarshal(cached, &restored); err != nil {
+		fmt.Println("reading cached bundle:", err)
+		return
+	}
+	calls := api.TotalCalls()
+	tc, err = trapcheck.NewFromCheckBundle(cfg, &restored)
+	if err != nil {
+		fmt.Println("NewFromCheckBundle:", err)
+		return
+	}
+	fmt.Println("api calls:", api.TotalCalls()-calls)
+	fmt.Println("new check:", tc.IsNewCheckBundle())
+
+	var metrics bytes.Buffer
+	metrics.WriteString(`{"cache_hits":{"_type":"L","_value":7}}`)
+	result, err := tc.SendMetrics(context.Background(), metrics)
+	if err != nil {
+		fmt.Println("SendMetrics:", err)
+		return
+	}
+	fmt.Println("stats:", result.Stats)
+
+	// Output:
+	// api calls: 0
+	// new check: false
+	// stats: 1
+}
+
+// A SubmissionURL with a SubmitTLSConfig submits directly (e.g. through a
+// load balancer in front of the broker) without an API client.
+func ExampleNewFromSubmissionURL() {
+	tb := &exampleTB{}
+	defer tb.done()
+
+	fb := trapchecktest.NewFakeTLSBroker(tb, "broker.example.com")
+
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(fb.CACertPEM()) {
+		fmt.Println("invalid broker ca cert")
+		return
+	}
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		// broker certificates only carry the broker CN (no SANs), verify
+		// the chain and the CN in VerifyConnection instead
+		InsecureSkipVerify: true, //nolint:gosec
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) == 0 {
+				return fmt.Errorf("no peer certificates")
+			}
+			opts := x509.VerifyOptions{Roots: roots, Intermediates: x509.NewCertPool()}
+			for _, cert := range cs.PeerCertificates[1:] {
+				opts.Intermediates.AddCert(cert)
+			}
+			if _, err := cs.PeerCertificates[0].Verify(opts); err != nil {
+				return err
+			}
+			if cn := cs.PeerCertificates[0].Subject.CommonName; cn != fb.CN() {
+				return fmt.Errorf("unexpected broker cn %q", cn)
+			}
+			return nil
+		},
+	}
+
+	tc, err := trapcheck.NewFromSubmissionURL(&trapcheck.Config{
+		SubmissionURL:   fb.SubmissionURL("abc-123", "secret"),
+		SubmitTLSConfig: tlsConfig,
+		Logger:          exampleLogger(),
+	})
+	if err != nil {
+		fmt.Println("NewFromSubmissionURL:", err)
+		return
+	}
+
+	var metrics bytes.Buffer
+	metrics.WriteString(`{"logins":{"_type":"L","_value":3},"errors":{"_type":"L","_value":0}}`)
+	result, err := tc.SendMetrics(context.Background(), metrics)
+	if err != nil {
+		fmt.Println("SendMetrics:", err)
+		return
+	}
+	fmt.Println("stats:", result.Stats)
+
+	info, err := tc.ConnectionInfo()
+	if err != nil {
+		fmt.Println("ConnectionInfo:", err)
+		return
+	}
+	fmt.Println("tls:", info.UsesTLS)
+
+	// without an API client the check cannot be refreshed
+	_, err = tc.RefreshCheckBundle()
+	fmt.Println("refresh without api:", errors.Is(err, trapcheck.ErrNoAPIClient))
+
+	// Output:
+	// stats: 2
+	// tls: true
+	// refresh without api: true
+}
+
+// outageAPI is an API whose check bundle fetches fail while err is set.
+type outageAPI struct {
+	*trapchecktest.FakeAPI
+	err error
+}
+
+func (api *outageAPI) FetchCheckBundle(cid apiclient.CIDType) (*apiclient.CheckBundle, error) {
+	if api.err != nil {
+		return nil, api.err
+	}
+	return api.FakeAPI.FetchCheckBundle(cid)
+}
+
+// When the broker answers 404 (e.g. the check moved) the check is refreshed
+// and the metrics resubmitted. If the refresh fails the refresh error is
+// returned and, within RefreshCooldown, further 404s return
+// ErrRefreshSuppressed instead of calling the API again.
+func ExampleTrapCheck_SendMetrics_refreshFailed() {
+	tb := &exampleTB{}
+	defer tb.done()
+
+	errUnavailable := errors.New("API response code 503: service unavailable")
+
+	fb := trapchecktest.NewFakeBroker(tb)
+	api := &outageAPI{FakeAPI: trapchecktest.NewFakeAPI(nil)}
+	broker := api.AddBroker("/broker/1", fb)
+
+	tc, err := trapcheck.New(&trapcheck.Config{
+		Client:            api,
+		CheckSearchTags:   apiclient.TagType{"service:refresh"},
+		CheckConfig:       &apiclient.CheckBundle{Target: "refresh-host"},
+		Broker:            &broker,
+		RefreshCooldown:   "100ms",
+		RefreshRetryDelay: "10ms",
+		Logger:            exampleLogger(),
+	})
+	if err != nil {
+		fmt.Println("New:", err)
+		return
+	}
+
+	send := func() error {
+		var metrics bytes.Buffer
+		metrics.WriteString(`{"jobs":{"_type":"L","_value":1}}`)
+		_, err := tc.SendMetrics(context.Background(), metrics)
+		return err
+	}
+
+	fb.RespondNotFound(3)
+	api.err = errUnavailable
+
+	err = send()
+	switch {
+	case errors.Is(err, errUnavailable):
+		fmt.Println("refresh failed, metrics not submitted (keep them for the next submission)")
+	case err != nil:
+		fmt.Println("SendMetrics:", err)
+	}
+
+	err = send()
+	if errors.Is(err, trapcheck.ErrRefreshSuppressed) {
+		fmt.Println("refresh suppressed within the cooldown")
+	}
+
+	// the API recovers, after the cooldown the check is refreshed and the metrics resubmitted
+	api.err = nil
+	time.Sleep(150 * time.Millisecond)
+	if err := send(); err != nil {
+		fmt.Println("SendMetrics:", err)
+		return
+	}
+	fmt.Println("submitted after refresh")
+	fmt.Println("refreshes:", tc.RefreshStats())
+
+	// Output:
+	// refresh failed, metrics not submitted (keep them for the next submission)
+	// refresh suppressed within the cooldown
+	// submitted after refresh
+	// refreshes: map[http-404:2]
+}
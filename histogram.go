@@ -0,0 +1,168 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// MetricTypeHistogram httptrap metric type for histograms, the value is an
+// array of "H[<bin>]=<count>" strings.
+const MetricTypeHistogram = "h"
+
+const (
+	histMinExp = -128
+	histMaxExp = 127
+)
+
+// histBin is a log-linear bin, two significant digits (val, -99..99,
+// |val| >= 10 unless zero) and a base 10 exponent. The bin covers
+// [val/10 * 10^exp, (val+1)/10 * 10^exp).
+type histBin struct {
+	val int8
+	exp int8
+}
+
+// newHistBin returns the bin for v, false if v can not be represented
+// (NaN, Inf or too large). Values too small to represent use the zero bin.
+func newHistBin(v float64) (histBin, bool) {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return histBin{}, false
+	}
+	if v == 0 {
+		return histBin{}, true
+	}
+	sign := 1
+	if v < 0 {
+		sign = -1
+		v = -v
+	}
+	// use the shortest decimal representation (d.ddde±XX) rather than
+	// Log10/Pow, which mis-bin values such as 0.0042 (4.1999...e-03)
+	parts := strings.SplitN(strconv.FormatFloat(v, 'e', -1, 64), "e", 2)
+	if len(parts) != 2 {
+		return histBin{}, false
+	}
+	exp, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return histBin{}, false
+	}
+	digits := strings.Replace(parts[0], ".", "", 1) + "0"
+	val := int(digits[0]-'0')*10 + int(digits[1]-'0')
+	if exp < histMinExp {
+		return histBin{}, true
+	}
+	if exp > histMaxExp {
+		return histBin{}, false
+	}
+	return histBin{val: int8(sign * val), exp: int8(exp)}, true
+}
+
+// value returns the lower bound (magnitude) of the bin.
+func (b histBin) value() float64 {
+	return float64(b.val) / 10 * math.Pow(10, float64(b.exp))
+}
+
+// String returns the bin in broker format, e.g. 1.2e+01.
+func (b histBin) String() string {
+	if b.val == 0 {
+		return "0.0e+00"
+	}
+	val := int(b.val)
+	sign := ""
+	if val < 0 {
+		sign = "-"
+		val = -val
+	}
+	return fmt.Sprintf("%s%d.%de%+03d", sign, val/10, val%10, b.exp)
+}
+
+// Histogram accumulates values in log-linear bins (two significant digits)
+// for submission as an httptrap histogram metric. Values are accumulated
+// until Reset is called. It is safe for concurrent use.
+type Histogram struct {
+	bins map[histBin]int64
+	mu   sync.Mutex
+}
+
+// NewHistogram returns an empty histogram.
+func NewHistogram() *Histogram {
+	return &Histogram{bins: make(map[histBin]int64)}
+}
+
+// Record adds a single sample of value v.
+func (h *Histogram) Record(v float64) error {
+	return h.RecordN(v, 1)
+}
+
+// RecordN adds count samples of value v. NaN, Inf, values too large to
+// represent and negative counts are rejected.
+func (h *Histogram) RecordN(v float64, count int64) error {
+	if count < 0 {
+		return fmt.Errorf("invalid histogram count (%d), must be >= 0", count)
+	}
+	bin, ok := newHistBin(v)
+	if !ok {
+		return fmt.Errorf("invalid histogram value (%v)", v)
+	}
+	if count == 0 {
+		return nil
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.bins == nil {
+		h.bins = make(map[histBin]int64)
+	}
+	h.bins[bin] += count
+	return nil
+}
+
+// Count returns the total number of samples recorded.
+func (h *Histogram) Count() int64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var n int64
+	for _, c := range h.bins {
+		n += c
+	}
+	return n
+}
+
+// Reset removes all samples.
+func (h *Histogram) Reset() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.bins = make(map[histBin]int64)
+}
+
+// DecStrings returns the bins, in ascending order, in the format accepted by
+// the broker for httptrap histograms, e.g. ["H[1.0e+00]=3","H[1.2e+01]=1"].
+func (h *Histogram) DecStrings() []string {
+	h.mu.Lock()
+	bins := make([]histBin, 0, len(h.bins))
+	counts := make(map[histBin]int64, len(h.bins))
+	for b, c := range h.bins {
+		bins = append(bins, b)
+		counts[b] = c
+	}
+	h.mu.Unlock()
+
+	sort.Slice(bins, func(i, j int) bool {
+		return bins[i].value() < bins[j].value()
+	})
+
+	out := make([]string, len(bins))
+	for i, b := range bins {
+		out[i] = fmt.Sprintf("H[%s]=%d", b, counts[b])
+	}
+	return out
+}
@@ -0,0 +1,161 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"math"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestNewHistBin(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  float64
+		want   string
+		wantOK bool
+	}{
+		{name: "zero", value: 0, want: "0.0e+00", wantOK: true},
+		{name: "one", value: 1, want: "1.0e+00", wantOK: true},
+		{name: "truncated not rounded", value: 1.29, want: "1.2e+00", wantOK: true},
+		{name: "two digits", value: 12.34, want: "1.2e+01", wantOK: true},
+		{name: "upper edge", value: 99.9, want: "9.9e+01", wantOK: true},
+		{name: "power of ten", value: 100, want: "1.0e+02", wantOK: true},
+		{name: "power of ten, small", value: 0.001, want: "1.0e-03", wantOK: true},
+		{name: "fraction", value: 0.123, want: "1.2e-01", wantOK: true},
+		{name: "negative", value: -12.34, want: "-1.2e+01", wantOK: true},
+		{name: "large", value: 3.5e100, want: "3.5e+100", wantOK: true},
+		{name: "too small, zero bin", value: 1e-130, want: "0.0e+00", wantOK: true},
+		{name: "too large", value: 1e130},
+		{name: "nan", value: math.NaN()},
+		{name: "inf", value: math.Inf(1)},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := newHistBin(tt.value)
+			if ok != tt.wantOK {
+				t.Fatalf("newHistBin(%v) ok = %t, want %t", tt.value, ok, tt.wantOK)
+			}
+			if ok && got.String() != tt.want {
+				t.Errorf("newHistBin(%v) = %s, want %s", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	h := NewHistogram()
+	for _, v := range []float64{12, 12.9, 0.5, 1, 1.05, -3} {
+		if err := h.Record(v); err != nil {
+			t.Fatalf("Record(%v) unexpected error: %s", v, err)
+		}
+	}
+	if err := h.RecordN(250, 10); err != nil {
+		t.Fatalf("RecordN() unexpected error: %s", err)
+	}
+	if err := h.RecordN(0, 0); err != nil {
+		t.Fatalf("RecordN(0, 0) unexpected error: %s", err)
+	}
+
+	want := []string{"H[-3.0e+00]=1", "H[5.0e-01]=1", "H[1.0e+00]=2", "H[1.2e+01]=2", "H[2.5e+02]=10"}
+	if got := h.DecStrings(); !reflect.DeepEqual(got, want) {
+		t.Errorf("DecStrings() = %v, want %v", got, want)
+	}
+	if got := h.Count(); got != 16 {
+		t.Errorf("Count() = %d, want 16", got)
+	}
+
+	for _, bad := range []struct {
+		value float64
+		count int64
+	}{
+		{value: math.NaN(), count: 1},
+		{value: 1e200, count: 1},
+		{value: 1, count: -1},
+	} {
+		if err := h.RecordN(bad.value, bad.count); err == nil {
+			t.Errorf("RecordN(%v, %d) expected error", bad.value, bad.count)
+		}
+	}
+
+	h.Reset()
+	if got := h.DecStrings(); len(got) != 0 {
+		t.Errorf("DecStrings() after Reset = %v, want none", got)
+	}
+
+	var zero Histogram
+	if err := zero.Record(1); err != nil {
+		t.Fatalf("zero value Record() unexpected error: %s", err)
+	}
+	if got := zero.DecStrings(); !reflect.DeepEqual(got, []string{"H[1.0e+00]=1"}) {
+		t.Errorf("zero value DecStrings() = %v", got)
+	}
+}
+
+func TestHistogram_concurrent(t *testing.T) {
+	h := NewHistogram()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				_ = h.Record(float64(j))
+			}
+		}()
+	}
+	wg.Wait()
+	if got := h.Count(); got != 8000 {
+		t.Errorf("Count() = %d, want 8000", got)
+	}
+}
+
+func TestMetrics_AddHistogram(t *testing.T) {
+	// payloads in the format accepted by the broker for httptrap histograms
+	tests := []struct {
+		name    string
+		samples map[float64]int64
+		want    string
+	}{
+		{
+			name:    "latency",
+			samples: map[float64]int64{0.0042: 3, 0.012: 7, 0.25: 1},
+			want:    `{"latency":{"_type":"h","_value":["H[4.2e-03]=3","H[1.2e-02]=7","H[2.5e-01]=1"]}}` + "\n",
+		},
+		{
+			name:    "zero and large",
+			samples: map[float64]int64{0: 5, 1500: 2},
+			want:    `{"zero and large":{"_type":"h","_value":["H[0.0e+00]=5","H[1.5e+03]=2"]}}` + "\n",
+		},
+		{
+			name: "empty",
+			want: "{}\n",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHistogram()
+			for v, n := range tt.samples {
+				if err := h.RecordN(v, n); err != nil {
+					t.Fatalf("RecordN() unexpected error: %s", err)
+				}
+			}
+			m := Metrics{}
+			m.AddHistogram(tt.name, h)
+			m.AddHistogram("nil", nil)
+			buf, err := m.Encode()
+			if err != nil {
+				t.Fatalf("Encode() unexpected error: %s", err)
+			}
+			if got := buf.String(); got != tt.want {
+				t.Errorf("Encode() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"crypto/rand"
+	"math/big"
+	"time"
+)
+
+const (
+	defaultInitJitter = "0s"
+)
+
+// initJitterSleep waits for the initialization jitter if set (tests), otherwise
+// the trap check clock is used.
+var initJitterSleep func(time.Duration)
+
+// randomDuration returns a cryptographically random duration in [0, max).
+func randomDuration(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(max)))
+	if err != nil {
+		return 0
+	}
+	return time.Duration(n.Int64())
+}
+
+// waitInitJitter sleeps a random duration in [0, InitJitter) before the
+// first API call, to spread out API and broker requests when many
+// instances start at the same time.
+func (tc *TrapCheck) waitInitJitter() {
+	if tc.initJitter <= 0 {
+		return
+	}
+	wait := randomDuration(tc.initJitter)
+	tc.logger().Debugf("initialization jitter, waiting %s (max %s)", wait, tc.initJitter)
+	if initJitterSleep != nil {
+		initJitterSleep(wait)
+		return
+	}
+	tc.clock().Sleep(wait)
+}
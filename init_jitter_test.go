@@ -0,0 +1,111 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/circonus-labs/go-apiclient"
+)
+
+func TestRandomDuration(t *testing.T) {
+	if d := randomDuration(0); d != 0 {
+		t.Errorf("randomDuration(0) = %s, want 0", d)
+	}
+	if d := randomDuration(-time.Second); d != 0 {
+		t.Errorf("randomDuration(-1s) = %s, want 0", d)
+	}
+	max := 10 * time.Millisecond
+	for i := 0; i < 1000; i++ {
+		if d := randomDuration(max); d < 0 || d >= max {
+			t.Fatalf("randomDuration(%s) = %s, want [0, %s)", max, d, max)
+		}
+	}
+}
+
+func TestNew_initJitter(t *testing.T) {
+	errStop := errors.New("stop after first api call")
+
+	tests := []struct {
+		name      string
+		jitter    string
+		wantSleep bool
+		wantErr   bool
+	}{
+		{name: "disabled", jitter: ""},
+		{name: "zero", jitter: "0s"},
+		{name: "enabled", jitter: "50ms", wantSleep: true},
+		{name: "invalid", jitter: "abc", wantErr: true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			var slept []time.Duration
+			apiCallsAtSleep := -1
+			client := &APIMock{}
+			client.FetchCheckBundleFunc = func(cid apiclient.CIDType) (*apiclient.CheckBundle, error) {
+				return nil, errStop
+			}
+
+			orig := initJitterSleep
+			defer func() { initJitterSleep = orig }()
+			initJitterSleep = func(d time.Duration) {
+				slept = append(slept, d)
+				apiCallsAtSleep = len(client.FetchCheckBundleCalls())
+			}
+
+			_, err := New(&Config{
+				Client:      client,
+				CheckConfig: &apiclient.CheckBundle{CID: "/check_bundle/123"},
+				InitJitter:  tt.jitter,
+			})
+			if tt.wantErr {
+				if err == nil || errors.Is(err, errStop) {
+					t.Fatalf("New() error = %v, want config error", err)
+				}
+				if len(client.FetchCheckBundleCalls()) != 0 {
+					t.Error("New() made API calls with an invalid config")
+				}
+				return
+			}
+			if !errors.Is(err, errStop) {
+				t.Fatalf("New() error = %v, want %v", err, errStop)
+			}
+
+			if !tt.wantSleep {
+				if len(slept) != 0 {
+					t.Errorf("slept %v, want no jitter", slept)
+				}
+				return
+			}
+			if len(slept) != 1 {
+				t.Fatalf("slept %d times, want 1", len(slept))
+			}
+			if slept[0] < 0 || slept[0] >= 50*time.Millisecond {
+				t.Errorf("jitter = %s, want [0, 50ms)", slept[0])
+			}
+			if apiCallsAtSleep != 0 {
+				t.Errorf("API calls before jitter = %d, want 0", apiCallsAtSleep)
+			}
+		})
+	}
+}
+
+func TestNewFromState_noInitJitter(t *testing.T) {
+	orig := initJitterSleep
+	defer func() { initJitterSleep = orig }()
+	initJitterSleep = func(d time.Duration) {
+		t.Errorf("unexpected jitter sleep (%s)", d)
+	}
+
+	state := State{
+		CheckBundle:   apiclient.CheckBundle{CID: "/check_bundle/123", CheckUUIDs: []string{"abc"}},
+		SubmissionURL: "http://127.0.0.1:2609/module/httptrap/0c5b9a36-2a2e-4e3c-9c8f-0d1f2a3b4c5d/secret",
+	}
+	_, _ = NewFromState(&Config{Client: &APIMock{}, InitJitter: "1h"}, state)
+}
@@ -0,0 +1,62 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// InstanceIDData is the data available to a Config.CheckInstanceID template.
+type InstanceIDData struct {
+	Hostname string
+	App      string
+}
+
+// Env returns the value of the named environment variable
+// e.g. `{{.Hostname}}-{{.App}}-{{.Env "POD_NAME"}}`.
+func (InstanceIDData) Env(name string) string {
+	return os.Getenv(name)
+}
+
+func newInstanceIDData() InstanceIDData {
+	_, an := filepath.Split(os.Args[0])
+	hn, err := os.Hostname()
+	if err != nil {
+		hn = "unknown"
+	}
+	return InstanceIDData{Hostname: hn, App: an}
+}
+
+// resolveInstanceID returns the instance id for the check (display name,
+// target, notes and default search tag). If id is empty the default
+// hostname:app is used, otherwise id is executed as a template.
+func resolveInstanceID(id string) (string, error) {
+	data := newInstanceIDData()
+	if id == "" {
+		return fmt.Sprintf("%s:%s", data.Hostname, data.App), nil
+	}
+	if !strings.Contains(id, "{{") {
+		return id, nil
+	}
+
+	tmpl, err := template.New("instance_id").Option("missingkey=error").Parse(id)
+	if err != nil {
+		return "", fmt.Errorf("parsing check instance id (%s): %w", id, err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("executing check instance id (%s): %w", id, err)
+	}
+	if buf.Len() == 0 {
+		return "", fmt.Errorf("check instance id (%s) resolved to empty string", id)
+	}
+	return buf.String(), nil
+}
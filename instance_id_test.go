@@ -0,0 +1,95 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/circonus-labs/go-apiclient"
+)
+
+func TestResolveInstanceID(t *testing.T) {
+	t.Setenv("TC_TEST_POD", "pod-1")
+	hn, err := os.Hostname()
+	if err != nil {
+		hn = "unknown"
+	}
+	_, an := filepath.Split(os.Args[0])
+
+	tests := []struct {
+		name    string
+		id      string
+		want    string
+		wantErr bool
+	}{
+		{name: "default", id: "", want: hn + ":" + an},
+		{name: "literal", id: "tenant-a", want: "tenant-a"},
+		{name: "template", id: `{{.Hostname}}-{{.App}}-{{.Env "TC_TEST_POD"}}`, want: hn + "-" + an + "-pod-1"},
+		{name: "invalid template", id: "{{.Hostname", wantErr: true},
+		{name: "unknown field", id: "{{.Foo}}", wantErr: true},
+		{name: "empty result", id: `{{.Env "TC_TEST_UNSET"}}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveInstanceID(tt.id)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolveInstanceID() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("resolveInstanceID() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckInstanceID_noCollision(t *testing.T) {
+	run := func(id string) (apiclient.CheckBundle, string) {
+		var search string
+		client := &APIMock{
+			SearchCheckBundlesFunc: func(searchCriteria *apiclient.SearchQueryType, filterCriteria *apiclient.SearchFilterType) (*[]apiclient.CheckBundle, error) {
+				search = string(*searchCriteria)
+				return &[]apiclient.CheckBundle{}, nil
+			},
+		}
+		tc, err := newTrapCheck(&Config{
+			Client:          client,
+			CheckInstanceID: id,
+			Logger:          &LogWrapper{Log: log.New(io.Discard, "", log.LstdFlags)},
+		})
+		if err != nil {
+			t.Fatalf("newTrapCheck() error = %v", err)
+		}
+		cfg := &apiclient.CheckBundle{}
+		if err := tc.applyCheckBundleDefaults(cfg); err != nil {
+			t.Fatalf("applyCheckBundleDefaults() error = %v", err)
+		}
+		if _, err := tc.findCheckBundle(cfg); err != nil {
+			t.Fatalf("findCheckBundle() error = %v", err)
+		}
+		return *cfg, search
+	}
+
+	b1, s1 := run("pod-a")
+	b2, s2 := run("pod-b")
+
+	if s1 == s2 {
+		t.Errorf("search criteria collide: %s", s1)
+	}
+	if b1.DisplayName != "pod-a" || b1.Target != "pod-a" || *b1.Notes != "tcid:pod-a" {
+		t.Errorf("bundle = %+v, want display name, target and notes from instance id", b1)
+	}
+	if b1.DisplayName == b2.DisplayName || b1.Target == b2.Target || *b1.Notes == *b2.Notes {
+		t.Errorf("bundles collide: %+v %+v", b1, b2)
+	}
+	if len(b1.Tags) != 1 || b1.Tags[0] != "service:pod-a" {
+		t.Errorf("tags = %v, want [service:pod-a]", b1.Tags)
+	}
+}
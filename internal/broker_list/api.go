@@ -4,9 +4,13 @@ package brokerlist
 
 import "github.com/circonus-labs/go-apiclient"
 
+// API is the subset of the Circonus API client used by the broker list.
 type API interface {
 	// broker methods
 	FetchBroker(cid apiclient.CIDType) (*apiclient.Broker, error)
 	FetchBrokers() (*[]apiclient.Broker, error)
 	SearchBrokers(searchCriteria *apiclient.SearchQueryType, filterCriteria *apiclient.SearchFilterType) (*[]apiclient.Broker, error)
 }
+
+// ensure the real API client satisfies API
+var _ API = (*apiclient.API)(nil)
@@ -1,16 +1,24 @@
 package brokerlist
 
 import (
+	"errors"
 	"fmt"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/circonus-labs/go-apiclient"
+	"github.com/circonus-labs/go-trapcheck/internal/clock"
 )
 
 // var once sync.Once
 
+// refreshInterval minimum time between RefreshBrokers fetches.
+const refreshInterval = 5 * time.Minute
+
+// ErrBrokerNotFound is returned (wrapped) by GetBroker when no broker in the list has the cid.
+var ErrBrokerNotFound = errors.New("no broker with CID found")
+
 type BrokerList interface {
 	RefreshBrokers() error
 	FetchBrokers() error
@@ -18,6 +26,8 @@ type BrokerList interface {
 	GetBroker(cid string) (apiclient.Broker, error)
 	SearchBrokerList(searchTags apiclient.TagType) (*[]apiclient.Broker, error)
 	SetClient(API) error
+	SetLogger(Logger) error
+	WithLogger(Logger) BrokerList
 }
 
 type brokerList struct {
@@ -25,6 +35,8 @@ type brokerList struct {
 	logger      Logger
 	client      API
 	brokers     *[]apiclient.Broker
+	clock       clock.Clock
+	loggerMu    sync.RWMutex
 	sync.Mutex
 }
 
@@ -46,6 +58,7 @@ func Init(client API, logger Logger) error {
 	brokerListInstance = &brokerList{
 		client: client,
 		logger: logger,
+		clock:  clock.Default(),
 	}
 	return brokerListInstance.FetchBrokers()
 }
@@ -57,6 +70,25 @@ func GetInstance() (BrokerList, error) { //nolint:revive
 	return brokerListInstance, nil
 }
 
+// SetClock replaces the clock of the broker list instance, if initialized.
+func SetClock(c clock.Clock) {
+	if brokerListInstance == nil || c == nil {
+		return
+	}
+	brokerListInstance.Lock()
+	defer brokerListInstance.Unlock()
+	brokerListInstance.clock = c
+}
+
+// WithLogger returns a view of the broker list which logs with logger
+// (e.g. a logger identifying the caller), the brokers are shared.
+func (bl *brokerList) WithLogger(logger Logger) BrokerList {
+	if logger == nil {
+		return bl
+	}
+	return &loggedBrokerList{brokerList: bl, logger: logger}
+}
+
 func (bl *brokerList) SetClient(client API) error {
 	if client == nil {
 		return fmt.Errorf("invalid init call, client is nil")
@@ -67,26 +99,62 @@ func (bl *brokerList) SetClient(client API) error {
 	return nil
 }
 
+// SetLogger replaces the logger used by the broker list.
+func (bl *brokerList) SetLogger(logger Logger) error {
+	if logger == nil {
+		return fmt.Errorf("invalid logger, logger is nil")
+	}
+
+	bl.loggerMu.Lock()
+	bl.logger = logger
+	bl.loggerMu.Unlock()
+
+	return nil
+}
+
+func (bl *brokerList) log() Logger {
+	bl.loggerMu.RLock()
+	defer bl.loggerMu.RUnlock()
+	return bl.logger
+}
+
 func (bl *brokerList) RefreshBrokers() error {
+	return bl.refreshBrokers(bl.log())
+}
+
+func (bl *brokerList) refreshBrokers(logger Logger) error {
 	// only refresh if it's been at least five minutes since last refresh
 	// to prevent API request storms.
-	if time.Since(bl.lastRefresh) > 5*time.Minute {
-		return bl.FetchBrokers()
+	bl.Lock()
+	stale := bl.clock.Since(bl.lastRefresh) > refreshInterval
+	bl.Unlock()
+	if stale {
+		return bl.fetchBrokers(logger)
 	}
 	return nil
 }
 
 func (bl *brokerList) FetchBrokers() error {
+	return bl.fetchBrokers(bl.log())
+}
+
+func (bl *brokerList) fetchBrokers(logger Logger) error {
 	bl.Lock()
 	defer bl.Unlock()
 
-	bl.logger.Infof("fetching broker list")
+	return bl.fetchBrokersLocked(logger)
+}
+
+// fetchBrokersLocked fetches the broker list, the caller must hold the lock.
+func (bl *brokerList) fetchBrokersLocked(logger Logger) error {
+	logger.Infof("fetching broker list")
 	list, err := bl.client.FetchBrokers()
 	if err != nil {
 		return fmt.Errorf("error fetching broker list: %w", err)
 	}
 
 	bl.brokers = list
+	bl.lastRefresh = bl.clock.Now()
 
 	return nil
 }
@@ -109,6 +177,10 @@ func (bl *brokerList) GetBrokerList() (*[]apiclient.Broker, error) {
 }
 
 func (bl *brokerList) GetBroker(cid string) (apiclient.Broker, error) {
+	return bl.getBroker(cid, bl.log())
+}
+
+func (bl *brokerList) getBroker(cid string, logger Logger) (apiclient.Broker, error) {
 	if cid == "" {
 		return apiclient.Broker{}, fmt.Errorf("invalid cid (empty)")
 	}
@@ -121,7 +193,7 @@ func (bl *brokerList) GetBroker(cid string) (apiclient.Broker, error) {
 	}
 
 	if len(*bl.brokers) == 0 {
-		if err := bl.FetchBrokers(); err != nil {
+		if err := bl.fetchBrokersLocked(logger); err != nil {
 			return apiclient.Broker{}, fmt.Errorf("invalid state, broker list len is 0, unable to fetch broker list: %w", err)
 		}
 		if len(*bl.brokers) == 0 {
@@ -131,12 +203,12 @@ func (bl *brokerList) GetBroker(cid string) (apiclient.Broker, error) {
 
 	for _, b := range *bl.brokers {
 		if b.CID == cid {
-			bl.logger.Infof("using cached broker %s", b.CID)
+			logger.Infof("using cached broker %s", b.CID)
 			return b, nil
 		}
 	}
 
-	return apiclient.Broker{}, fmt.Errorf("no broker with CID (%s) found", cid)
+	return apiclient.Broker{}, fmt.Errorf("%w (%s)", ErrBrokerNotFound, cid)
 }
 
 func (bl *brokerList) SearchBrokerList(searchTags apiclient.TagType) (*[]apiclient.Broker, error) {
@@ -170,3 +242,43 @@ func (bl *brokerList) SearchBrokerList(searchTags apiclient.TagType) (*[]apiclie
 
 	return &list, nil
 }
+
+// loggedBrokerList is a view of the shared broker list using a different logger.
+type loggedBrokerList struct {
+	*brokerList
+	logger Logger
+	mu     sync.RWMutex
+}
+
+func (lbl *loggedBrokerList) WithLogger(logger Logger) BrokerList {
+	return lbl.brokerList.WithLogger(logger)
+}
+
+// SetLogger replaces the logger of the view, the shared broker list logger is unchanged.
+func (lbl *loggedBrokerList) SetLogger(logger Logger) error {
+	if logger == nil {
+		return fmt.Errorf("invalid logger, logger is nil")
+	}
+	lbl.mu.Lock()
+	lbl.logger = logger
+	lbl.mu.Unlock()
+	return nil
+}
+
+func (lbl *loggedBrokerList) log() Logger {
+	lbl.mu.RLock()
+	defer lbl.mu.RUnlock()
+	return lbl.logger
+}
+
+func (lbl *loggedBrokerList) RefreshBrokers() error {
+	return lbl.refreshBrokers(lbl.log())
+}
+
+func (lbl *loggedBrokerList) FetchBrokers() error {
+	return lbl.fetchBrokers(lbl.log())
+}
+
+func (lbl *loggedBrokerList) GetBroker(cid string) (apiclient.Broker, error) {
+	return lbl.getBroker(cid, lbl.log())
+}
@@ -1,9 +1,5 @@
 package brokerlist
 
-type Logger interface {
-	Printf(fmt string, v ...interface{})
-	Debugf(fmt string, v ...interface{})
-	Infof(fmt string, v ...interface{})
-	Warnf(fmt string, v ...interface{})
-	Errorf(fmt string, v ...interface{})
-}
+import "github.com/circonus-labs/go-trapcheck/internal/logger"
+
+type Logger = logger.Logger
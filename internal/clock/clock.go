@@ -0,0 +1,55 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+// Package clock defines the time source shared by trapcheck and its
+// internal packages, the default can be replaced (see testsupport.SetClock).
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is the time source for time based behavior (intervals, delays,
+// trace file names, durations).
+type Clock interface {
+	Now() time.Time
+	Since(t time.Time) time.Duration
+	Sleep(d time.Duration)
+	After(d time.Duration) <-chan time.Time
+}
+
+// Real is the wall clock.
+type Real struct{}
+
+func (Real) Now() time.Time                         { return time.Now() }
+func (Real) Since(t time.Time) time.Duration        { return time.Since(t) }
+func (Real) Sleep(d time.Duration)                  { time.Sleep(d) }
+func (Real) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+var (
+	current Clock = Real{}
+	mu      sync.RWMutex
+)
+
+// Default returns the clock used by new trap checks and the broker list.
+func Default() Clock {
+	mu.RLock()
+	defer mu.RUnlock()
+	return current
+}
+
+// SetDefault replaces the default clock (nil restores the wall clock),
+// returns the previous default.
+func SetDefault(c Clock) Clock {
+	if c == nil {
+		c = Real{}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	prev := current
+	current = c
+	return prev
+}
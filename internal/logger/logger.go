@@ -0,0 +1,17 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+// Package logger defines the logging interface shared by trapcheck and its
+// internal packages.
+package logger
+
+// Logger is a generic logging interface.
+type Logger interface {
+	Printf(fmt string, v ...interface{})
+	Debugf(fmt string, v ...interface{})
+	Infof(fmt string, v ...interface{})
+	Warnf(fmt string, v ...interface{})
+	Errorf(fmt string, v ...interface{})
+}
@@ -0,0 +1,79 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+)
+
+// IP protocols for broker connections (see Config.IPProtocol).
+const (
+	IPProtocolAuto = "auto"
+	IPProtocolIPv4 = "ipv4"
+	IPProtocolIPv6 = "ipv6"
+)
+
+// dialNetwork returns the network to use for broker connections.
+func (tc *TrapCheck) dialNetwork() string {
+	switch tc.ipProtocol {
+	case IPProtocolIPv4:
+		return "tcp4"
+	case IPProtocolIPv6:
+		return "tcp6"
+	default:
+		return "tcp"
+	}
+}
+
+// dialContext returns a DialContext func which constrains the network to the configured ip protocol.
+func (tc *TrapCheck) dialContext(dialer *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	if tc.dialNetwork() == "tcp" {
+		return dialer.DialContext
+	}
+	return func(ctx context.Context, _, addr string) (net.Conn, error) {
+		return dialer.DialContext(ctx, tc.dialNetwork(), addr)
+	}
+}
+
+// dialTimeout dials host:port using the configured ip protocol.
+func (tc *TrapCheck) dialTimeout(host, port string, timeout time.Duration) (net.Conn, error) {
+	if err := tc.verifyIPProtocol(host); err != nil {
+		return nil, err
+	}
+	dial := tc.probeDial
+	if dial == nil {
+		dial = net.DialTimeout
+	}
+	conn, err := dial(tc.dialNetwork(), net.JoinHostPort(host, port), timeout)
+	if err != nil {
+		return nil, fmt.Errorf("dial: %w", err)
+	}
+	return conn, nil
+}
+
+// verifyIPProtocol returns an error if host is an ip address of
+// a different family than the configured ip protocol.
+func (tc *TrapCheck) verifyIPProtocol(host string) error {
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil
+	}
+	isV4 := ip.To4() != nil
+	switch tc.ipProtocol {
+	case IPProtocolIPv4:
+		if !isV4 {
+			return fmt.Errorf("address %s is not ipv4 (IPProtocol %s)", host, tc.ipProtocol)
+		}
+	case IPProtocolIPv6:
+		if isV4 {
+			return fmt.Errorf("address %s is not ipv6 (IPProtocol %s)", host, tc.ipProtocol)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/circonus-labs/go-apiclient"
+)
+
+func newTestServerOn(t *testing.T, addr string) *httptest.Server {
+	t.Helper()
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Skipf("unable to listen on %s: %s", addr, err)
+	}
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, `{"stats":1}`)
+	}))
+	ts.Listener.Close()
+	ts.Listener = l
+	ts.Start()
+	return ts
+}
+
+func TestTrapCheck_isValidBroker_ipProtocol(t *testing.T) {
+	ts := newTestServerOn(t, "127.0.0.1:0")
+	defer ts.Close()
+
+	tsURL, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatalf("parsing test broker url: %s", err)
+	}
+	brokerIP := tsURL.Hostname()
+	bp, err := strconv.Atoi(tsURL.Port())
+	if err != nil {
+		t.Fatalf("parsing test broker port: %s", err)
+	}
+	brokerPort := uint16(bp)
+
+	broker := &apiclient.Broker{
+		CID:  "/broker/1",
+		Name: "foo",
+		Type: circonusType,
+		Details: []apiclient.BrokerDetail{
+			{Status: statusActive, Modules: []string{"httptrap"}, IP: &brokerIP, Port: &brokerPort},
+		},
+	}
+
+	tests := []struct {
+		name       string
+		ipProtocol string
+		wantErr    string
+		want       bool
+	}{
+		{name: "auto", ipProtocol: IPProtocolAuto, want: true},
+		{name: "ipv4", ipProtocol: IPProtocolIPv4, want: true},
+		{name: "ipv6, ipv4 only broker", ipProtocol: IPProtocolIPv6, wantErr: "not ipv6"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			tc := &TrapCheck{
+				brokerMaxResponseTime: 500 * time.Millisecond,
+				ipProtocol:            tt.ipProtocol,
+			}
+			tc.Log = &LogWrapper{
+				Log:   log.New(io.Discard, "", log.LstdFlags),
+				Debug: false,
+			}
+			got, err := tc.isValidBroker(broker, "httptrap")
+			if got != tt.want {
+				t.Errorf("TrapCheck.isValidBroker() = %t, want %t (%v)", got, tt.want, err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("TrapCheck.isValidBroker() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTrapCheck_submit_ipProtocol(t *testing.T) {
+	ts4 := newTestServerOn(t, "127.0.0.1:0")
+	defer ts4.Close()
+	ts6 := newTestServerOn(t, "[::1]:0")
+	defer ts6.Close()
+
+	tests := []struct {
+		name       string
+		url        string
+		ipProtocol string
+		wantErr    bool
+	}{
+		{name: "ipv4 server, ipv4", url: ts4.URL, ipProtocol: IPProtocolIPv4},
+		{name: "ipv4 server, ipv6", url: ts4.URL, ipProtocol: IPProtocolIPv6, wantErr: true},
+		{name: "ipv6 server, ipv6", url: ts6.URL, ipProtocol: IPProtocolIPv6},
+		{name: "ipv6 server, ipv4", url: ts6.URL, ipProtocol: IPProtocolIPv4, wantErr: true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			tc := &TrapCheck{
+				checkBundle:       &apiclient.CheckBundle{},
+				custSubmissionURL: tt.url,
+				submissionURL:     tt.url,
+				submissionTimeout: 500 * time.Millisecond,
+				ipProtocol:        tt.ipProtocol,
+			}
+			tc.Log = &LogWrapper{
+				Log:   log.New(io.Discard, "", log.LstdFlags),
+				Debug: false,
+			}
+			var metrics bytes.Buffer
+			metrics.WriteString(`{"foo":{"_type":"n","_value":1}}`)
+			_, _, err := tc.submit(context.Background(), metrics)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("TrapCheck.submit() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
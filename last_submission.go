@@ -0,0 +1,60 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrNoSubmissions is returned by LastResult when no submission has succeeded yet.
+var ErrNoSubmissions = errors.New("no successful submissions yet")
+
+// LastResult returns a copy of the result of the most recent successful
+// submission and when it completed - e.g. for health checks. Returns
+// ErrNoSubmissions if there have been no successful submissions.
+func (tc *TrapCheck) LastResult() (*TrapResult, time.Time, error) {
+	tc.lastSubmissionMu.Lock()
+	defer tc.lastSubmissionMu.Unlock()
+
+	if tc.lastResult == nil {
+		return nil, time.Time{}, ErrNoSubmissions
+	}
+	result := *tc.lastResult
+	return &result, tc.lastResultTime, nil
+}
+
+// LastError returns when the most recent failed submission occurred and its
+// error. Returns the zero time and nil if no submission has failed.
+func (tc *TrapCheck) LastError() (time.Time, error) {
+	tc.lastSubmissionMu.Lock()
+	defer tc.lastSubmissionMu.Unlock()
+
+	return tc.lastErrorTime, tc.lastError
+}
+
+// recordSubmission saves the outcome of a submission.
+func (tc *TrapCheck) recordSubmission(result *TrapResult, err error) {
+	tc.lastSubmissionMu.Lock()
+	defer tc.lastSubmissionMu.Unlock()
+
+	if err != nil {
+		tc.lastError = err
+		tc.lastErrorTime = tc.clock().Now()
+		tc.emitEvent(EventSubmissionFailed, redactSecret(err.Error(), tc.submissionSecret()), "", "")
+		return
+	}
+	if result != nil {
+		r := *result
+		tc.lastResult = &r
+		tc.lastResultTime = tc.clock().Now()
+		if !tc.connInfoLogged {
+			tc.connInfoLogged = true
+			tc.recordSubmitConnectTime(result)
+			tc.logConnectionInfo()
+		}
+	}
+}
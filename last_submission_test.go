@@ -0,0 +1,83 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/circonus-labs/go-apiclient"
+)
+
+func TestTrapCheck_LastResult(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/bad" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		fmt.Fprintln(w, `{"stats":1}`)
+	}))
+	defer ts.Close()
+
+	tc := &TrapCheck{
+		checkBundle:       &apiclient.CheckBundle{CheckUUIDs: []string{"abc-123"}},
+		custSubmissionURL: ts.URL,
+		submissionURL:     ts.URL,
+		submissionTimeout: 5 * time.Second,
+	}
+	tc.Log = &LogWrapper{
+		Log:   log.New(io.Discard, "", log.LstdFlags),
+		Debug: false,
+	}
+
+	if _, _, err := tc.LastResult(); !errors.Is(err, ErrNoSubmissions) {
+		t.Fatalf("TrapCheck.LastResult() error = %v, want %v", err, ErrNoSubmissions)
+	}
+	if when, err := tc.LastError(); err != nil || !when.IsZero() {
+		t.Fatalf("TrapCheck.LastError() = %s, %v, want zero time and nil", when, err)
+	}
+
+	var metrics bytes.Buffer
+	metrics.WriteString(`{"foo":{"_type":"n","_value":1}}`)
+	if _, err := tc.SendMetrics(context.Background(), metrics); err != nil {
+		t.Fatalf("TrapCheck.SendMetrics() error = %v", err)
+	}
+
+	result, when, err := tc.LastResult()
+	if err != nil {
+		t.Fatalf("TrapCheck.LastResult() error = %v", err)
+	}
+	if when.IsZero() {
+		t.Error("TrapCheck.LastResult() time is zero")
+	}
+	if result.Stats != 1 || result.CheckUUID != "abc-123" {
+		t.Errorf("TrapCheck.LastResult() = %+v, want stats 1 check uuid abc-123", result)
+	}
+
+	result.Stats = 100 // must not change internal state
+	if r, _, _ := tc.LastResult(); r.Stats != 1 {
+		t.Errorf("TrapCheck.LastResult() stats = %d, want 1", r.Stats)
+	}
+
+	tc.submissionURL = ts.URL + "/bad"
+	if _, err := tc.SendMetrics(context.Background(), metrics); err == nil {
+		t.Fatal("TrapCheck.SendMetrics() expected error")
+	}
+	if when, err := tc.LastError(); err == nil || when.IsZero() {
+		t.Errorf("TrapCheck.LastError() = %s, %v, want error", when, err)
+	}
+	if r, _, err := tc.LastResult(); err != nil || r.Stats != 1 {
+		t.Errorf("TrapCheck.LastResult() = %+v, %v, want previous successful result", r, err)
+	}
+}
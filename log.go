@@ -5,15 +5,91 @@
 
 package trapcheck
 
-import "log"
+import (
+	"fmt"
+	"log"
+	"strings"
 
-// Logger is a generic logging interface.
-type Logger interface {
-	Printf(fmt string, v ...interface{})
-	Debugf(fmt string, v ...interface{})
-	Infof(fmt string, v ...interface{})
-	Warnf(fmt string, v ...interface{})
-	Errorf(fmt string, v ...interface{})
+	"github.com/circonus-labs/go-trapcheck/internal/logger"
+)
+
+// Logger is a generic logging interface (Printf, Debugf, Infof, Warnf and Errorf),
+// it is shared with the internal broker list.
+type Logger = logger.Logger
+
+// LoggerWithFields is an optional extension of Logger for structured loggers.
+// When the configured Logger implements it, stable fields (check_cid, check_uuid,
+// broker_cid, submit_uuid) are attached to log lines instead of only being
+// embedded in the messages.
+type LoggerWithFields interface {
+	Logger
+	WithFields(fields map[string]interface{}) Logger
+}
+
+// Structured log field names.
+const (
+	LogFieldCheckCID   = "check_cid"
+	LogFieldCheckUUID  = "check_uuid"
+	LogFieldBrokerCID  = "broker_cid"
+	LogFieldSubmitUUID = "submit_uuid"
+)
+
+// SetLogger replaces the Logger, e.g. to enable tracing to the log ("-") when the
+// TrapCheck was created without one. The broker list lines logged for this
+// TrapCheck use the new logger as well.
+func (tc *TrapCheck) SetLogger(l Logger) error {
+	if l == nil {
+		return fmt.Errorf("invalid logger (nil)")
+	}
+
+	tc.logMu.Lock()
+	tc.Log = l
+	tc.logMu.Unlock()
+
+	if tc.brokerList != nil {
+		if err := tc.brokerList.SetLogger(tc.brokerListLogger()); err != nil {
+			return fmt.Errorf("setting broker list logger: %w", err)
+		}
+	}
+
+	l.Infof("trap check logger set")
+	return nil
+}
+
+// logger returns the current Logger.
+func (tc *TrapCheck) logger() Logger {
+	tc.logMu.RLock()
+	defer tc.logMu.RUnlock()
+	return tc.Log
+}
+
+// logWith returns a logger with the current check and broker fields, plus any
+// extra fields, attached if the Logger supports fields, otherwise the Logger.
+func (tc *TrapCheck) logWith(extra map[string]interface{}) Logger {
+	log := tc.logger()
+	lf, ok := log.(LoggerWithFields)
+	if !ok {
+		return log
+	}
+	fields := make(map[string]interface{}, len(extra)+3)
+	if tc.checkBundle != nil {
+		if tc.checkBundle.CID != "" {
+			fields[LogFieldCheckCID] = tc.checkBundle.CID
+		}
+	}
+	if id := tc.checkUUID(); id != "" {
+		fields[LogFieldCheckUUID] = id
+	}
+	if tc.broker != nil && tc.broker.CID != "" {
+		fields[LogFieldBrokerCID] = tc.broker.CID
+	}
+	for k, v := range extra {
+		fields[k] = v
+	}
+	if len(fields) == 0 {
+		return log
+	}
+	return lf.WithFields(fields)
 }
 
 // LogWrapper is a wrapper around Go's log.Logger.
@@ -39,3 +115,45 @@ func (lw *LogWrapper) Warnf(fmt string, v ...interface{}) {
 func (lw *LogWrapper) Errorf(fmt string, v ...interface{}) {
 	lw.Log.Printf("[error] "+fmt, v...)
 }
+
+// brokerListLogger returns the logger used for broker list messages, prefixed with
+// the check instance id or check search tags so that lines from the shared broker
+// list can be attributed to a trap check.
+func (tc *TrapCheck) brokerListLogger() Logger {
+	return &prefixLogger{log: tc.logger(), prefix: tc.logPrefix}
+}
+
+// logPrefix identifies the trap check, the instance id if set, otherwise the check search tags.
+func (tc *TrapCheck) logPrefix() string {
+	switch {
+	case tc.checkInstanceID != "":
+		return "[" + tc.checkInstanceID + "] "
+	case len(tc.checkSearchTags) > 0:
+		return "[" + strings.Join(tc.checkSearchTags, ",") + "] "
+	default:
+		return ""
+	}
+}
+
+// prefixLogger prefixes messages, the prefix is resolved when logging as
+// the check search tags may be set after the logger is created.
+type prefixLogger struct {
+	log    Logger
+	prefix func() string
+}
+
+func (pl *prefixLogger) Printf(fmt string, v ...interface{}) {
+	pl.log.Printf(pl.prefix()+fmt, v...)
+}
+func (pl *prefixLogger) Debugf(fmt string, v ...interface{}) {
+	pl.log.Debugf(pl.prefix()+fmt, v...)
+}
+func (pl *prefixLogger) Infof(fmt string, v ...interface{}) {
+	pl.log.Infof(pl.prefix()+fmt, v...)
+}
+func (pl *prefixLogger) Warnf(fmt string, v ...interface{}) {
+	pl.log.Warnf(pl.prefix()+fmt, v...)
+}
+func (pl *prefixLogger) Errorf(fmt string, v ...interface{}) {
+	pl.log.Errorf(pl.prefix()+fmt, v...)
+}
@@ -0,0 +1,69 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+//go:build go1.21
+
+package trapcheck
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+)
+
+// SlogLogger adapts a log/slog Logger to the Logger and LoggerWithFields interfaces.
+type SlogLogger struct {
+	Log *slog.Logger
+}
+
+// NewSlogLogger returns a Logger backed by l, if l is nil slog.Default() is used.
+func NewSlogLogger(l *slog.Logger) *SlogLogger {
+	if l == nil {
+		l = slog.Default()
+	}
+	return &SlogLogger{Log: l}
+}
+
+// Printf logs at info level.
+func (sl *SlogLogger) Printf(f string, v ...interface{}) {
+	sl.Log.Info(fmt.Sprintf(f, v...))
+}
+
+// Debugf logs at debug level.
+func (sl *SlogLogger) Debugf(f string, v ...interface{}) {
+	sl.Log.Debug(fmt.Sprintf(f, v...))
+}
+
+// Infof logs at info level.
+func (sl *SlogLogger) Infof(f string, v ...interface{}) {
+	sl.Log.Info(fmt.Sprintf(f, v...))
+}
+
+// Warnf logs at warn level.
+func (sl *SlogLogger) Warnf(f string, v ...interface{}) {
+	sl.Log.Warn(fmt.Sprintf(f, v...))
+}
+
+// Errorf logs at error level.
+func (sl *SlogLogger) Errorf(f string, v ...interface{}) {
+	sl.Log.Error(fmt.Sprintf(f, v...))
+}
+
+// WithFields returns a Logger with fields attached as slog attributes (in key order).
+func (sl *SlogLogger) WithFields(fields map[string]interface{}) Logger {
+	if len(fields) == 0 {
+		return sl
+	}
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	args := make([]interface{}, 0, len(keys)*2)
+	for _, k := range keys {
+		args = append(args, k, fields[k])
+	}
+	return &SlogLogger{Log: sl.Log.With(args...)}
+}
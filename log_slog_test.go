@@ -0,0 +1,92 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+//go:build go1.21
+
+package trapcheck
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/circonus-labs/go-apiclient"
+)
+
+func TestSlogLogger(t *testing.T) {
+	var buf bytes.Buffer
+	sl := NewSlogLogger(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+
+	tc := &TrapCheck{
+		Log:         sl,
+		checkBundle: &apiclient.CheckBundle{CID: "/check_bundle/123", CheckUUIDs: []string{"abc-123"}},
+		broker:      &apiclient.Broker{CID: "/broker/1"},
+	}
+
+	tc.logWith(map[string]interface{}{LogFieldSubmitUUID: "sub-1"}).Warnf("refreshing %s", "check")
+
+	out := buf.String()
+	for _, want := range []string{
+		"level=WARN",
+		`msg="refreshing check"`,
+		"broker_cid=/broker/1",
+		"check_cid=/check_bundle/123",
+		"check_uuid=abc-123",
+		"submit_uuid=sub-1",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output %q missing %q", out, want)
+		}
+	}
+
+	buf.Reset()
+	sl.Debugf("debug %d", 1)
+	if !strings.Contains(buf.String(), "level=DEBUG") || !strings.Contains(buf.String(), `msg="debug 1"`) {
+		t.Errorf("Debugf output = %q", buf.String())
+	}
+}
+
+func TestTrapCheck_submitLogsSubmitUUID(t *testing.T) {
+	var gotID string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotID = r.Header.Get(SubmitIDHeader)
+		w.WriteHeader(http.StatusNotAcceptable)
+	}))
+	defer ts.Close()
+
+	var buf bytes.Buffer
+	tc := &TrapCheck{
+		Log:               NewSlogLogger(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))),
+		checkBundle:       &apiclient.CheckBundle{},
+		custSubmissionURL: ts.URL,
+		submissionURL:     ts.URL,
+		submissionTimeout: 5 * time.Second,
+		traceMetrics:      "-",
+	}
+
+	var metrics bytes.Buffer
+	metrics.WriteString(`{"foo":{"_type":"n","_value":1}}`)
+	if _, err := tc.SendMetrics(context.Background(), metrics); err == nil {
+		t.Fatal("TrapCheck.SendMetrics() expected error (406)")
+	}
+
+	if gotID == "" {
+		t.Fatalf("%s header not sent", SubmitIDHeader)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) < 2 {
+		t.Fatalf("expected submission log lines, got %q", buf.String())
+	}
+	for _, line := range lines {
+		if !strings.Contains(line, "submit_uuid="+gotID) {
+			t.Errorf("log line missing submit_uuid=%s: %s", gotID, line)
+		}
+	}
+}
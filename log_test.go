@@ -0,0 +1,186 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/circonus-labs/go-apiclient"
+	"github.com/circonus-labs/go-trapcheck/trapchecktest"
+)
+
+type fieldsLogger struct {
+	LogWrapper
+	fields map[string]interface{}
+}
+
+func (fl *fieldsLogger) WithFields(fields map[string]interface{}) Logger {
+	return &fieldsLogger{LogWrapper: fl.LogWrapper, fields: fields}
+}
+
+func TestTrapCheck_logWith(t *testing.T) {
+	var buf bytes.Buffer
+	plain := &LogWrapper{Log: log.New(&buf, "", 0)}
+
+	tc := &TrapCheck{
+		checkBundle: &apiclient.CheckBundle{CID: "/check_bundle/123", CheckUUIDs: []string{"abc-123"}},
+		broker:      &apiclient.Broker{CID: "/broker/1"},
+	}
+
+	t.Run("plain logger", func(t *testing.T) {
+		tc.Log = plain
+		l := tc.logWith(map[string]interface{}{LogFieldSubmitUUID: "sub-1"})
+		if l != plain {
+			t.Fatalf("logWith() = %v, want configured logger", l)
+		}
+		l.Infof("hello")
+		if !strings.Contains(buf.String(), "hello") {
+			t.Errorf("logWith() output = %q", buf.String())
+		}
+	})
+
+	t.Run("fields logger", func(t *testing.T) {
+		tc.Log = &fieldsLogger{LogWrapper: *plain}
+		l, ok := tc.logWith(map[string]interface{}{LogFieldSubmitUUID: "sub-1"}).(*fieldsLogger)
+		if !ok {
+			t.Fatal("logWith() did not return fields logger")
+		}
+		want := map[string]interface{}{
+			LogFieldCheckCID:   "/check_bundle/123",
+			LogFieldCheckUUID:  "abc-123",
+			LogFieldBrokerCID:  "/broker/1",
+			LogFieldSubmitUUID: "sub-1",
+		}
+		if !reflect.DeepEqual(l.fields, want) {
+			t.Errorf("logWith() fields = %v, want %v", l.fields, want)
+		}
+	})
+
+	t.Run("fields logger, no fields", func(t *testing.T) {
+		fl := &fieldsLogger{LogWrapper: *plain}
+		tc2 := &TrapCheck{Log: fl}
+		if l := tc2.logWith(nil); l != fl {
+			t.Errorf("logWith() = %v, want configured logger", l)
+		}
+	})
+}
+
+func TestTrapCheck_brokerListLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := &LogWrapper{Log: log.New(&buf, "", 0)}
+
+	client := &APIMock{
+		FetchBrokersFunc: func() (*[]apiclient.Broker, error) {
+			return &[]apiclient.Broker{{CID: "/broker/1"}}, nil
+		},
+	}
+	bl := initTestBrokerList(t, client, logger)
+
+	tcA := &TrapCheck{checkSearchTags: apiclient.TagType{"service:a", "tenant:1"}}
+	tcA.Log = logger
+	tcA.brokerList = bl.WithLogger(tcA.brokerListLogger())
+	tcB := &TrapCheck{checkInstanceID: "host-b:app"}
+	tcB.Log = logger
+	tcB.brokerList = bl.WithLogger(tcB.brokerListLogger())
+
+	buf.Reset()
+	for _, tc := range []*TrapCheck{tcA, tcB} {
+		if _, err := tc.brokerList.GetBroker("/broker/1"); err != nil {
+			t.Fatalf("GetBroker() error = %v", err)
+		}
+		if err := tc.brokerList.FetchBrokers(); err != nil {
+			t.Fatalf("FetchBrokers() error = %v", err)
+		}
+	}
+
+	want := []string{
+		"[info] [service:a,tenant:1] using cached broker /broker/1",
+		"[info] [service:a,tenant:1] fetching broker list",
+		"[info] [host-b:app] using cached broker /broker/1",
+		"[info] [host-b:app] fetching broker list",
+	}
+	got := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("broker list log lines = %q, want %q", got, want)
+	}
+}
+
+func TestTrapCheck_SetLogger(t *testing.T) {
+	var oldBuf, newBuf bytes.Buffer
+	oldLogger := &LogWrapper{Log: log.New(&oldBuf, "", 0), Debug: true}
+	newLogger := &LogWrapper{Log: log.New(&newBuf, "", 0), Debug: true}
+
+	fb := trapchecktest.NewFakeBroker(t)
+	submissionURL := fb.SubmissionURL("abc-123", "secret")
+	client := &APIMock{
+		FetchBrokersFunc: func() (*[]apiclient.Broker, error) {
+			return &[]apiclient.Broker{{CID: "/broker/1"}}, nil
+		},
+		FetchCheckBundleFunc: func(cid apiclient.CIDType) (*apiclient.CheckBundle, error) {
+			return &apiclient.CheckBundle{
+				CID:        "/check_bundle/123",
+				CheckUUIDs: []string{"abc-123"},
+				Type:       "httptrap",
+				Config:     apiclient.CheckBundleConfig{"submission_url": submissionURL},
+				Status:     statusActive,
+			}, nil
+		},
+	}
+	bl := initTestBrokerList(t, client, oldLogger)
+
+	tc := &TrapCheck{
+		client:             client,
+		checkBundle:        &apiclient.CheckBundle{CID: "/check_bundle/123", Type: "httptrap"},
+		checkInstanceID:    "host-a:app",
+		custSubmissionURL:  submissionURL,
+		submissionURL:      submissionURL,
+		submissionTimeout:  5 * time.Second,
+		allowCustomRefresh: true,
+	}
+	tc.Log = oldLogger
+	tc.brokerList = bl.WithLogger(tc.brokerListLogger())
+
+	if err := tc.SetLogger(nil); err == nil {
+		t.Fatal("SetLogger(nil) expected error")
+	}
+	if err := tc.SetLogger(newLogger); err != nil {
+		t.Fatalf("SetLogger() error = %v", err)
+	}
+	oldBuf.Reset()
+
+	fb.RespondNotFound(1) // submit, refresh and resubmit
+	var metrics bytes.Buffer
+	metrics.WriteString(`{"foo":{"_type":"n","_value":1}}`)
+	if _, err := tc.SendMetrics(context.Background(), metrics); err != nil {
+		t.Fatalf("SendMetrics() error = %v", err)
+	}
+	if n := tc.RefreshStats()[string(RefreshReasonHTTP404)]; n != 1 {
+		t.Errorf("404 refreshes = %d, want 1", n)
+	}
+	if err := tc.brokerList.FetchBrokers(); err != nil {
+		t.Fatalf("FetchBrokers() error = %v", err)
+	}
+
+	if oldBuf.Len() > 0 {
+		t.Errorf("old logger output = %q, want none", oldBuf.String())
+	}
+	got := newBuf.String()
+	for _, want := range []string{
+		"[info] trap check logger set",
+		"refreshing check",
+		"[info] [host-a:app] fetching broker list",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("new logger output missing %q:\n%s", want, got)
+		}
+	}
+}
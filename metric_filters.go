@@ -0,0 +1,103 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+// ErrInvalidMetricFilter is returned (wrapped) when a metric filter rule is
+// malformed, its pattern is not a valid RE2 expression, or a filter set has
+// no allow rule (which would deny every metric).
+var ErrInvalidMetricFilter = errors.New("invalid metric filter")
+
+const (
+	metricFilterAllow = "allow"
+	metricFilterDeny  = "deny"
+)
+
+// MetricFilterBuilder builds check bundle metric_filters from allow/deny
+// rules. The broker evaluates rules in the order they are added, the first
+// matching rule wins - so deny rules added before an allow rule take precedence.
+// Metrics not matching any rule are denied.
+//
+//	filters, err := new(trapcheck.MetricFilterBuilder).
+//		Deny(`^app\.debug\.`, "no debug metrics").
+//		Allow(`^app\.`, "app metrics").
+//		Build()
+type MetricFilterBuilder struct {
+	rules [][]string
+}
+
+// Allow adds a rule allowing metrics with names matching pattern.
+func (b *MetricFilterBuilder) Allow(pattern, note string) *MetricFilterBuilder {
+	b.rules = append(b.rules, []string{metricFilterAllow, pattern, note})
+	return b
+}
+
+// Deny adds a rule denying metrics with names matching pattern.
+func (b *MetricFilterBuilder) Deny(pattern, note string) *MetricFilterBuilder {
+	b.rules = append(b.rules, []string{metricFilterDeny, pattern, note})
+	return b
+}
+
+// Build validates the rules and returns them in the metric_filters format
+// (e.g. for Config.MetricFilters).
+func (b *MetricFilterBuilder) Build() ([][]string, error) {
+	if err := validateMetricFilters(b.rules); err != nil {
+		return nil, err
+	}
+	filters := make([][]string, len(b.rules))
+	for i, rule := range b.rules {
+		filters[i] = append([]string(nil), rule...)
+	}
+	return filters, nil
+}
+
+// validateMetricFilters verifies each rule is an allow/deny rule with a
+// valid RE2 pattern and that there is at least one allow rule.
+func validateMetricFilters(filters [][]string) error {
+	if len(filters) == 0 {
+		return fmt.Errorf("%w: no rules", ErrInvalidMetricFilter)
+	}
+	haveAllow := false
+	for i, rule := range filters {
+		if len(rule) < 2 {
+			return fmt.Errorf("%w: rule %d (%v) requires a type and pattern", ErrInvalidMetricFilter, i, rule)
+		}
+		switch rule[0] {
+		case metricFilterAllow:
+			haveAllow = true
+		case metricFilterDeny:
+		default:
+			return fmt.Errorf("%w: rule %d unknown type %q (allow|deny)", ErrInvalidMetricFilter, i, rule[0])
+		}
+		if rule[1] == "" {
+			return fmt.Errorf("%w: rule %d empty pattern", ErrInvalidMetricFilter, i)
+		}
+		if _, err := regexp.Compile(rule[1]); err != nil {
+			return fmt.Errorf("%w: rule %d pattern %q: %s", ErrInvalidMetricFilter, i, rule[1], err)
+		}
+	}
+	if !haveAllow {
+		return fmt.Errorf("%w: at least one allow rule is required", ErrInvalidMetricFilter)
+	}
+	return nil
+}
+
+// copyMetricFilters returns a deep copy of the metric filter rules.
+func copyMetricFilters(filters [][]string) [][]string {
+	if filters == nil {
+		return nil
+	}
+	c := make([][]string, len(filters))
+	for i, rule := range filters {
+		c[i] = append([]string(nil), rule...)
+	}
+	return c
+}
@@ -0,0 +1,126 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"errors"
+	"io"
+	"log"
+	"reflect"
+	"testing"
+
+	"github.com/circonus-labs/go-apiclient"
+)
+
+func TestMetricFilterBuilder_Build(t *testing.T) {
+	tests := []struct {
+		build   func(b *MetricFilterBuilder)
+		name    string
+		want    [][]string
+		wantErr bool
+	}{
+		{
+			name:  "allow only",
+			build: func(b *MetricFilterBuilder) { b.Allow(`^app\.`, "app") },
+			want:  [][]string{{"allow", `^app\.`, "app"}},
+		},
+		{
+			name: "deny before allow, order preserved",
+			build: func(b *MetricFilterBuilder) {
+				b.Deny(`^app\.debug\.`, "no debug").Allow(`^app\.`, "app").Deny(`^sys\.`, "")
+			},
+			want: [][]string{
+				{"deny", `^app\.debug\.`, "no debug"},
+				{"allow", `^app\.`, "app"},
+				{"deny", `^sys\.`, ""},
+			},
+		},
+		{name: "invalid, no rules", build: func(b *MetricFilterBuilder) {}, wantErr: true},
+		{name: "invalid, deny only", build: func(b *MetricFilterBuilder) { b.Deny(".", "") }, wantErr: true},
+		{name: "invalid, bad regex", build: func(b *MetricFilterBuilder) { b.Allow("^(foo", "") }, wantErr: true},
+		{name: "invalid, non re2 regex", build: func(b *MetricFilterBuilder) { b.Allow(`^(?!foo)`, "") }, wantErr: true},
+		{name: "invalid, empty pattern", build: func(b *MetricFilterBuilder) { b.Allow("", "") }, wantErr: true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			b := &MetricFilterBuilder{}
+			tt.build(b)
+			got, err := b.Build()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("MetricFilterBuilder.Build() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidMetricFilter) {
+					t.Errorf("MetricFilterBuilder.Build() error = %v, want %v", err, ErrInvalidMetricFilter)
+				}
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("MetricFilterBuilder.Build() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateMetricFilters(t *testing.T) {
+	tests := []struct {
+		name    string
+		filters [][]string
+		wantErr bool
+	}{
+		{name: "valid, with tag rule fields", filters: [][]string{{"allow", ".", "tags", "and(env:prod)", ""}}},
+		{name: "invalid, unknown type", filters: [][]string{{"permit", ".", ""}}, wantErr: true},
+		{name: "invalid, missing pattern", filters: [][]string{{"allow"}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateMetricFilters(tt.filters); (err != nil) != tt.wantErr {
+				t.Errorf("validateMetricFilters() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTrapCheck_applyCheckBundleDefaults_metricFilters(t *testing.T) {
+	filters, err := new(MetricFilterBuilder).Deny(`^debug\.`, "").Allow(".", "").Build()
+	if err != nil {
+		t.Fatalf("MetricFilterBuilder.Build() error = %v", err)
+	}
+
+	tests := []struct {
+		cfg   *apiclient.CheckBundle
+		name  string
+		tcCfg [][]string
+		want  [][]string
+	}{
+		{name: "default allow all", cfg: &apiclient.CheckBundle{}, want: [][]string{{"allow", ".", ""}}},
+		{name: "configured filters", cfg: &apiclient.CheckBundle{}, tcCfg: filters, want: filters},
+		{
+			name:  "check config filters take precedence",
+			cfg:   &apiclient.CheckBundle{MetricFilters: [][]string{{"allow", "^foo", ""}}},
+			tcCfg: filters,
+			want:  [][]string{{"allow", "^foo", ""}},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			tc := &TrapCheck{metricFilters: tt.tcCfg}
+			tc.Log = &LogWrapper{
+				Log:   log.New(io.Discard, "", log.LstdFlags),
+				Debug: false,
+			}
+			if err := tc.applyCheckBundleDefaults(tt.cfg); err != nil {
+				t.Fatalf("TrapCheck.applyCheckBundleDefaults() error = %v", err)
+			}
+			if !reflect.DeepEqual(tt.cfg.MetricFilters, tt.want) {
+				t.Errorf("metric filters = %v, want %v", tt.cfg.MetricFilters, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// Metric is an httptrap metric with an explicit type.
+type Metric struct {
+	Type  string      `json:"_type"`
+	Value interface{} `json:"_value"`
+}
+
+// Metrics is an httptrap payload, metric name to metric. Encode it for
+// SendMetrics.
+type Metrics map[string]Metric
+
+// AddHistogram adds the current samples of h as histogram metric name,
+// histograms without samples are not added.
+func (m Metrics) AddHistogram(name string, h *Histogram) {
+	if h == nil {
+		return
+	}
+	bins := h.DecStrings()
+	if len(bins) == 0 {
+		return
+	}
+	m[name] = Metric{Type: MetricTypeHistogram, Value: bins}
+}
+
+// Encode returns the metrics JSON encoded for SendMetrics.
+func (m Metrics) Encode() (bytes.Buffer, error) {
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(m); err != nil {
+		return bytes.Buffer{}, fmt.Errorf("encoding metrics: %w", err)
+	}
+	return buf, nil
+}
@@ -0,0 +1,67 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+)
+
+// ErrPayloadTooLarge is returned (wrapped in a *PayloadTooLargeError) when the
+// metrics exceed Config.MaxPayloadSize - no network call is made.
+var ErrPayloadTooLarge = errors.New("payload too large")
+
+// PayloadTooLargeError provides the details of a rejected payload so callers
+// can tune batching.
+type PayloadTooLargeError struct {
+	Size           int64 // uncompressed size of the payload
+	MaxSize        int64 // configured MaxPayloadSize
+	CompressedSize int64 // size which would have been sent (-1 if unknown)
+}
+
+func (e *PayloadTooLargeError) Error() string {
+	return fmt.Sprintf("%s: %d bytes exceeds max payload size %d bytes (compressed %d bytes)", ErrPayloadTooLarge, e.Size, e.MaxSize, e.CompressedSize)
+}
+
+func (e *PayloadTooLargeError) Unwrap() error {
+	return ErrPayloadTooLarge
+}
+
+// checkPayloadSize returns a *PayloadTooLargeError if the payload exceeds
+// the configured maximum. For uncompressed payloads larger than the
+// compression threshold the compressed size is calculated (not buffered).
+func (tc *TrapCheck) checkPayloadSize(payload []byte, encoding string) error {
+	size := int64(len(payload))
+	if tc.maxPayloadSize <= 0 || size <= tc.maxPayloadSize {
+		return nil
+	}
+
+	compressedSize := size
+	if encoding == "" && size > compressionThreshold {
+		var cw countWriter
+		zw := gzip.NewWriter(&cw)
+		if _, err := zw.Write(payload); err != nil {
+			compressedSize = -1
+		} else if err := zw.Close(); err != nil {
+			compressedSize = -1
+		} else {
+			compressedSize = cw.n
+		}
+	}
+
+	return &PayloadTooLargeError{Size: size, MaxSize: tc.maxPayloadSize, CompressedSize: compressedSize}
+}
+
+// countWriter counts the bytes written to it.
+type countWriter struct {
+	n int64
+}
+
+func (cw *countWriter) Write(p []byte) (int, error) {
+	cw.n += int64(len(p))
+	return len(p), nil
+}
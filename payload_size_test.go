@@ -0,0 +1,89 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/circonus-labs/go-apiclient"
+)
+
+func TestTrapCheck_SendMetrics_maxPayloadSize(t *testing.T) {
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		fmt.Fprintln(w, `{"stats":1}`)
+	}))
+	defer ts.Close()
+
+	large := `{"foo":{"_type":"s","_value":"` + strings.Repeat("a", 4096) + `"}}`
+
+	tests := []struct {
+		name         string
+		payload      string
+		maxSize      int64
+		wantErr      bool
+		wantRequests int32
+	}{
+		{name: "unlimited", payload: large, maxSize: 0, wantRequests: 1},
+		{name: "under cap", payload: large, maxSize: 8192, wantRequests: 1},
+		{name: "over cap", payload: large, maxSize: 2048, wantErr: true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			atomic.StoreInt32(&requests, 0)
+			tc := &TrapCheck{
+				checkBundle:       &apiclient.CheckBundle{},
+				custSubmissionURL: ts.URL,
+				submissionURL:     ts.URL,
+				submissionTimeout: 5 * time.Second,
+				maxPayloadSize:    tt.maxSize,
+			}
+			tc.Log = &LogWrapper{
+				Log:   log.New(io.Discard, "", log.LstdFlags),
+				Debug: false,
+			}
+
+			var metrics bytes.Buffer
+			metrics.WriteString(tt.payload)
+			_, err := tc.SendMetrics(context.Background(), metrics)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("TrapCheck.SendMetrics() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := atomic.LoadInt32(&requests); got != tt.wantRequests {
+				t.Errorf("requests = %d, want %d", got, tt.wantRequests)
+			}
+			if !tt.wantErr {
+				return
+			}
+			if !errors.Is(err, ErrPayloadTooLarge) {
+				t.Fatalf("TrapCheck.SendMetrics() error = %v, want %v", err, ErrPayloadTooLarge)
+			}
+			var ptl *PayloadTooLargeError
+			if !errors.As(err, &ptl) {
+				t.Fatalf("TrapCheck.SendMetrics() error = %T, want *PayloadTooLargeError", err)
+			}
+			if ptl.Size != int64(len(tt.payload)) || ptl.MaxSize != tt.maxSize {
+				t.Errorf("PayloadTooLargeError = %+v", ptl)
+			}
+			if ptl.CompressedSize <= 0 || ptl.CompressedSize >= ptl.Size {
+				t.Errorf("PayloadTooLargeError.CompressedSize = %d, want 0 < n < %d", ptl.CompressedSize, ptl.Size)
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// ErrCertPinMismatch is returned (wrapped) when the broker certificate
+// fingerprint is not one of Config.PinnedCertFingerprints.
+var ErrCertPinMismatch = errors.New("broker certificate fingerprint not pinned")
+
+// certFingerprint returns the hex SHA-256 fingerprint of the certificate DER.
+func certFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// normalizeFingerprint lower cases the fingerprint and removes ':' separators.
+func normalizeFingerprint(fp string) (string, error) {
+	fp = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(fp), ":", ""))
+	if len(fp) != sha256.Size*2 {
+		return "", fmt.Errorf("invalid certificate fingerprint %q, must be hex sha256", fp)
+	}
+	if _, err := hex.DecodeString(fp); err != nil {
+		return "", fmt.Errorf("invalid certificate fingerprint %q: %w", fp, err)
+	}
+	return fp, nil
+}
+
+// setPinnedFingerprints validates and saves the pinned certificate fingerprints.
+func (tc *TrapCheck) setPinnedFingerprints(pins []string) error {
+	if len(pins) == 0 {
+		return nil
+	}
+	tc.pinnedFingerprints = make(map[string]bool, len(pins))
+	for _, pin := range pins {
+		fp, err := normalizeFingerprint(pin)
+		if err != nil {
+			return err
+		}
+		tc.pinnedFingerprints[fp] = true
+	}
+	return nil
+}
+
+// verifyPinnedCert verifies the leaf certificate fingerprint is pinned, if pins are configured.
+func (tc *TrapCheck) verifyPinnedCert(cs tls.ConnectionState) error {
+	if len(tc.pinnedFingerprints) == 0 {
+		return nil
+	}
+	if len(cs.PeerCertificates) == 0 {
+		return fmt.Errorf("%w: no peer certificates", ErrCertPinMismatch)
+	}
+	fp := certFingerprint(cs.PeerCertificates[0])
+	if !tc.pinnedFingerprints[fp] {
+		return fmt.Errorf("%w: presented %s (cn: %q)", ErrCertPinMismatch, fp, cs.PeerCertificates[0].Subject.CommonName)
+	}
+	return nil
+}
+
+// GetBrokerCertFingerprint connects to the submission url and returns the hex
+// SHA-256 fingerprint of the broker's leaf certificate, e.g. to bootstrap
+// Config.PinnedCertFingerprints. The certificate is verified as it would be
+// for a submission, except the fingerprint is not required to be pinned.
+func (tc *TrapCheck) GetBrokerCertFingerprint(ctx context.Context) (string, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	st, err := tc.prepareSubmit("")
+	if err != nil {
+		return "", err
+	}
+
+	su, err := parseSubmissionURL(st.url)
+	if err != nil {
+		return "", err
+	}
+	if su.isHTTP() {
+		return "", fmt.Errorf("submission url (%s) not using tls", redactSecret(st.url, su.secret))
+	}
+
+	var cfg *tls.Config
+	if st.tlsConfig != nil {
+		cfg = st.tlsConfig.Clone()
+	} else {
+		cfg = &tls.Config{ServerName: su.host, MinVersion: tls.VersionTLS12}
+	}
+
+	var fingerprint string
+	verify := cfg.VerifyConnection
+	cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+		if len(cs.PeerCertificates) > 0 {
+			fingerprint = certFingerprint(cs.PeerCertificates[0])
+		}
+		if verify == nil {
+			return nil
+		}
+		if err := verify(cs); err != nil && !errors.Is(err, ErrCertPinMismatch) {
+			return err
+		}
+		return nil
+	}
+
+	dial := tc.dialContext(&net.Dialer{Timeout: tc.transportConfig.withDefaults().DialTimeout})
+	conn, err := dial(ctx, "tcp", su.hostPort())
+	if err != nil {
+		return "", fmt.Errorf("connecting to broker: %w", err)
+	}
+	defer conn.Close()
+
+	tlsConn := tls.Client(conn, cfg)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		return "", fmt.Errorf("broker tls handshake: %w", err)
+	}
+	_ = tlsConn.Close()
+
+	return fingerprint, nil
+}
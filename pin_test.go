@@ -0,0 +1,170 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/circonus-labs/go-apiclient"
+)
+
+// newPinTestTrapCheck returns a trap check submitting to a local tls broker
+// using a locally generated cert chain, and the broker leaf cert fingerprint.
+func newPinTestTrapCheck(t *testing.T) (*TrapCheck, string) {
+	t.Helper()
+
+	caPEM, ca, caKey := generateTestCA(t, time.Now().Add(time.Hour))
+	cert := generateTestCert(t, ca, caKey, "foo")
+	sum := sha256.Sum256(cert.Certificate[0])
+	fingerprint := hex.EncodeToString(sum[:])
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, `{"stats":1}`)
+	}))
+	ts.TLS = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	ts.StartTLS()
+	t.Cleanup(ts.Close)
+
+	tsURL, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatalf("creating test broker: %s", err)
+	}
+	brokerIP := tsURL.Hostname()
+	bp, err := strconv.Atoi(tsURL.Port())
+	if err != nil {
+		t.Fatalf("parsing test broker port: %s", err)
+	}
+	brokerPort := uint16(bp)
+
+	tc := newTestTrapCheck(ts.URL)
+	tc.caCertPEM = caPEM
+	tc.checkBundle = &apiclient.CheckBundle{
+		Brokers:    []string{"/broker/123"},
+		CheckUUIDs: []string{"abc-123"},
+		Type:       "httptrap",
+		Config:     apiclient.CheckBundleConfig{"submission_url": ts.URL},
+	}
+	tc.preselectedBroker = &apiclient.Broker{
+		CID:  "/broker/123",
+		Name: "foo",
+		Type: circonusType,
+		Details: []apiclient.BrokerDetail{
+			{CN: "foo", Status: statusActive, Modules: []string{"httptrap"}, IP: &brokerIP, Port: &brokerPort},
+		},
+	}
+	return tc, fingerprint
+}
+
+func TestTrapCheck_SendMetrics_pinnedCert(t *testing.T) {
+	otherPin := strings.Repeat("ab", sha256.Size)
+
+	tests := []struct {
+		pins    func(fp string) []string
+		name    string
+		wantErr bool
+	}{
+		{name: "no pins", pins: func(string) []string { return nil }},
+		{name: "match", pins: func(fp string) []string { return []string{otherPin, fp} }},
+		{
+			name: "match, upper case with separators",
+			pins: func(fp string) []string {
+				var parts []string
+				for i := 0; i < len(fp); i += 2 {
+					parts = append(parts, strings.ToUpper(fp[i:i+2]))
+				}
+				return []string{strings.Join(parts, ":")}
+			},
+		},
+		{name: "mismatch", pins: func(string) []string { return []string{otherPin} }, wantErr: true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			tc, fingerprint := newPinTestTrapCheck(t)
+			if err := tc.setPinnedFingerprints(tt.pins(fingerprint)); err != nil {
+				t.Fatalf("setPinnedFingerprints() error = %v", err)
+			}
+
+			var metrics bytes.Buffer
+			metrics.WriteString(`{"foo":{"_type":"n","_value":1}}`)
+			result, err := tc.SendMetrics(context.Background(), metrics)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("SendMetrics() expected error")
+				}
+				if !errors.Is(err, ErrCertPinMismatch) {
+					t.Errorf("SendMetrics() error = %v, want %v", err, ErrCertPinMismatch)
+				}
+				if !strings.Contains(err.Error(), fingerprint) {
+					t.Errorf("SendMetrics() error = %v, want presented fingerprint %s", err, fingerprint)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("SendMetrics() error = %v", err)
+			}
+			if result.Stats != 1 {
+				t.Errorf("SendMetrics() stats = %d, want 1", result.Stats)
+			}
+		})
+	}
+}
+
+func TestTrapCheck_GetBrokerCertFingerprint(t *testing.T) {
+	tc, fingerprint := newPinTestTrapCheck(t)
+	// bootstrap works while the configured pin does not match
+	if err := tc.setPinnedFingerprints([]string{strings.Repeat("ab", sha256.Size)}); err != nil {
+		t.Fatalf("setPinnedFingerprints() error = %v", err)
+	}
+
+	got, err := tc.GetBrokerCertFingerprint(context.Background())
+	if err != nil {
+		t.Fatalf("GetBrokerCertFingerprint() error = %v", err)
+	}
+	if got != fingerprint {
+		t.Errorf("GetBrokerCertFingerprint() = %s, want %s", got, fingerprint)
+	}
+
+	// still validates the chain
+	tc.caCertPEM, _, _ = generateTestCA(t, time.Now().Add(time.Hour))
+	tc.tlsConfig = nil
+	if _, err := tc.GetBrokerCertFingerprint(context.Background()); err == nil {
+		t.Error("GetBrokerCertFingerprint() expected error for untrusted cert")
+	}
+
+	tc.submissionURL = "http://127.0.0.1:1/"
+	tc.tlsConfig = nil
+	if _, err := tc.GetBrokerCertFingerprint(context.Background()); err == nil {
+		t.Error("GetBrokerCertFingerprint() expected error for http submission url")
+	}
+}
+
+func TestConfig_Validate_pinnedCertFingerprints(t *testing.T) {
+	cfg := &Config{PinnedCertFingerprints: []string{"abc"}}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Config.Validate() expected error for invalid fingerprint")
+	}
+	cfg.PinnedCertFingerprints = []string{strings.Repeat("0f", sha256.Size)}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Config.Validate() error = %v", err)
+	}
+}
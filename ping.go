@@ -0,0 +1,97 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+// ErrCheckNotFound is returned (wrapped) by Ping when the broker does not
+// know the check (404).
+var ErrCheckNotFound = errors.New("check not found on broker")
+
+// ErrBrokerUnreachable is returned (wrapped) by Ping when a connection to
+// the submission url cannot be established or times out.
+var ErrBrokerUnreachable = errors.New("broker unreachable")
+
+// pingPayload is an empty metric submission, accepted by the broker without
+// recording any metrics.
+const pingPayload = "{}"
+
+// Ping verifies the check is wired correctly (url, TLS, broker up) by making
+// a single submission (no retries) of an empty set of metrics with the same
+// TLS config and url used by SendMetrics. Returns nil when the broker
+// accepts the submission, ErrCheckNotFound on a 404, ErrBrokerUnreachable on
+// connection errors, ErrRateLimited on a 429, otherwise an error with the
+// response status. Ping does not trace the submission or update LastResult.
+func (tc *TrapCheck) Ping(ctx context.Context) error { //nolint:contextcheck
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	st, err := tc.prepareSubmit("")
+	if err != nil {
+		return redactError(err, tc.submissionSecret())
+	}
+
+	return redactError(tc.ping(ctx, st), st.secret)
+}
+
+func (tc *TrapCheck) ping(ctx context.Context, st submitState) error {
+	client := tc.submitClient(st.tlsConfig)
+	if client.Transport != nil && tc.transport == nil {
+		defer client.CloseIdleConnections()
+	}
+	if tc.requestHook != nil {
+		client.Transport = &hookTransport{next: client.Transport, hook: tc.requestHook}
+	}
+
+	if tc.submissionTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, tc.submissionTimeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "PUT", st.url, bytes.NewBufferString(pingPayload))
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("User-Agent", userAgent())
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Connection", "close")
+	req.Header.Set("Content-Length", strconv.Itoa(len(pingPayload)))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		var opErr *net.OpError
+		var netErr net.Error
+		if errors.As(err, &opErr) || (errors.As(err, &netErr) && netErr.Timeout()) || errors.Is(err, context.DeadlineExceeded) {
+			return fmt.Errorf("%w: %s", ErrBrokerUnreachable, err)
+		}
+		return fmt.Errorf("making request: %w", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.CopyN(io.Discard, resp.Body, tc.responseLimit())
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusNotFound:
+		return fmt.Errorf("%w: %s - %s", ErrCheckNotFound, resp.Status, req.URL.String())
+	case http.StatusTooManyRequests:
+		return fmt.Errorf("%w: %s", ErrRateLimited, resp.Status)
+	default:
+		return fmt.Errorf("%s - %s", resp.Status, req.URL.String())
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	apiclient "github.com/circonus-labs/go-apiclient"
+)
+
+func TestTrapCheck_Ping(t *testing.T) {
+	const path = "/module/httptrap/0c5b9a36-2a2e-4e3c-9c8f-0d1f2a3b4c5d/s3cr3tv4lu3"
+
+	newTestServer := func(status int) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != "PUT" {
+				t.Errorf("method = %s, want PUT", r.Method)
+			}
+			if r.URL.Path != path {
+				t.Errorf("path = %s, want %s", r.URL.Path, path)
+			}
+			body, _ := io.ReadAll(r.Body)
+			if string(body) != pingPayload {
+				t.Errorf("body = %q, want %q", string(body), pingPayload)
+			}
+			w.WriteHeader(status)
+			if status == http.StatusOK {
+				_, _ = w.Write([]byte(`{"stats":0}`))
+			}
+		}))
+	}
+
+	// reserve a port, then close the listener so nothing is listening on it
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %s", err)
+	}
+	deadURL := "http://" + l.Addr().String()
+	l.Close()
+
+	tests := []struct {
+		name    string
+		status  int
+		url     string
+		wantErr error
+		errMsg  string
+	}{
+		{name: "ok", status: http.StatusOK},
+		{name: "not found", status: http.StatusNotFound, wantErr: ErrCheckNotFound},
+		{name: "rate limited", status: http.StatusTooManyRequests, wantErr: ErrRateLimited},
+		{name: "server error", status: http.StatusInternalServerError, errMsg: "500 Internal Server Error"},
+		{name: "unreachable", url: deadURL, wantErr: ErrBrokerUnreachable},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			baseURL := tt.url
+			if baseURL == "" {
+				ts := newTestServer(tt.status)
+				defer ts.Close()
+				baseURL = ts.URL
+			}
+
+			traceDir := t.TempDir()
+			tc := &TrapCheck{
+				checkBundle:       &apiclient.CheckBundle{},
+				submissionURL:     baseURL + path,
+				submissionTimeout: 5 * time.Second,
+				traceMetrics:      traceDir,
+			}
+			tc.Log = &LogWrapper{Log: log.New(io.Discard, "", 0)}
+
+			err := tc.Ping(context.Background())
+			switch {
+			case tt.wantErr == nil && tt.errMsg == "":
+				if err != nil {
+					t.Fatalf("Ping() unexpected error: %s", err)
+				}
+			case tt.wantErr != nil:
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Ping() error = %v, want %v", err, tt.wantErr)
+				}
+			default:
+				if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+					t.Fatalf("Ping() error = %v, want %q", err, tt.errMsg)
+				}
+			}
+			if err != nil && strings.Contains(err.Error(), "s3cr3tv4lu3") {
+				t.Errorf("Ping() error contains secret: %s", err)
+			}
+
+			if _, _, err := tc.LastResult(); !errors.Is(err, ErrNoSubmissions) {
+				t.Errorf("LastResult() error = %v, want %v", err, ErrNoSubmissions)
+			}
+			if entries, err := os.ReadDir(traceDir); err != nil || len(entries) != 0 {
+				t.Errorf("trace dir entries = %d (%v), want 0", len(entries), err)
+			}
+		})
+	}
+}
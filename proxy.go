@@ -0,0 +1,76 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// setProxy validates and saves the caller supplied proxy configuration.
+func (tc *TrapCheck) setProxy(proxyURL, noProxy string) error {
+	if proxyURL == "" {
+		return nil
+	}
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return fmt.Errorf("parse proxy URL: %w", err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return fmt.Errorf("invalid proxy URL (%s), unsupported scheme %q", proxyURL, u.Scheme)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("invalid proxy URL (%s), no host", proxyURL)
+	}
+	tc.proxyURL = u
+	tc.noProxy = nil
+	for _, host := range strings.Split(noProxy, ",") {
+		if host = strings.ToLower(strings.TrimSpace(host)); host != "" {
+			tc.noProxy = append(tc.noProxy, host)
+		}
+	}
+	return nil
+}
+
+// proxyFunc returns the proxy function to use for submissions, the caller
+// supplied proxy if configured, otherwise the environment (HTTP[S]_PROXY).
+func (tc *TrapCheck) proxyFunc() func(*http.Request) (*url.URL, error) {
+	if tc.proxyURL == nil {
+		return http.ProxyFromEnvironment
+	}
+	return func(req *http.Request) (*url.URL, error) {
+		if !tc.useProxy(req.URL.Hostname()) {
+			return nil, nil
+		}
+		return tc.proxyURL, nil
+	}
+}
+
+// useProxy returns true if the caller supplied proxy should be used for host.
+func (tc *TrapCheck) useProxy(host string) bool {
+	if tc.proxyURL == nil {
+		return false
+	}
+	host = strings.ToLower(host)
+	for _, np := range tc.noProxy {
+		switch {
+		case np == "*":
+			return false
+		case np == host:
+			return false
+		case strings.HasPrefix(np, ".") && strings.HasSuffix(host, np):
+			return false
+		case net.ParseIP(host) == nil && strings.HasSuffix(host, "."+np):
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,141 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/circonus-labs/go-apiclient"
+)
+
+func TestTrapCheck_setProxy(t *testing.T) {
+	tests := []struct {
+		name     string
+		proxyURL string
+		noProxy  string
+		wantErr  bool
+	}{
+		{name: "none"},
+		{name: "invalid url", proxyURL: ":foo", wantErr: true},
+		{name: "invalid scheme", proxyURL: "ftp://proxy.example.com:3128", wantErr: true},
+		{name: "no host", proxyURL: "http://", wantErr: true},
+		{name: "valid", proxyURL: "http://proxy.example.com:3128", noProxy: "localhost, .internal"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			tc := &TrapCheck{}
+			if err := tc.setProxy(tt.proxyURL, tt.noProxy); (err != nil) != tt.wantErr {
+				t.Errorf("TrapCheck.setProxy() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	if _, err := New(&Config{Client: &APIMock{}, ProxyURL: "ftp://proxy.example.com"}); err == nil {
+		t.Error("New() expected error, invalid proxy url")
+	}
+}
+
+func TestTrapCheck_useProxy(t *testing.T) {
+	tc := &TrapCheck{}
+	if tc.useProxy("broker.example.com") {
+		t.Fatal("TrapCheck.useProxy() = true, want false with no proxy configured")
+	}
+	if err := tc.setProxy("http://proxy.example.com:3128", "localhost,.internal,example.net,10.1.2.3"); err != nil {
+		t.Fatalf("TrapCheck.setProxy() error = %v", err)
+	}
+
+	tests := []struct {
+		host string
+		want bool
+	}{
+		{host: "broker.example.com", want: true},
+		{host: "localhost", want: false},
+		{host: "broker.internal", want: false},
+		{host: "example.net", want: false},
+		{host: "broker.example.net", want: false},
+		{host: "10.1.2.3", want: false},
+		{host: "10.1.2.4", want: true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.host, func(t *testing.T) {
+			if got := tc.useProxy(tt.host); got != tt.want {
+				t.Errorf("TrapCheck.useProxy(%s) = %v, want %v", tt.host, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTrapCheck_submitProxy(t *testing.T) {
+	var proxied string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.String() // absolute-form request uri when proxied
+		fmt.Fprintln(w, `{"stats":1}`)
+	}))
+	defer proxy.Close()
+
+	submissionURL := "http://broker.example.com:43191/module/httptrap/abc/foo"
+	tc := &TrapCheck{
+		checkBundle:       &apiclient.CheckBundle{},
+		custSubmissionURL: submissionURL,
+		submissionURL:     submissionURL,
+		submissionTimeout: 5 * time.Second,
+	}
+	tc.Log = &LogWrapper{
+		Log:   log.New(io.Discard, "", log.LstdFlags),
+		Debug: false,
+	}
+	if err := tc.setProxy(proxy.URL, ""); err != nil {
+		t.Fatalf("TrapCheck.setProxy() error = %v", err)
+	}
+
+	var metrics bytes.Buffer
+	metrics.WriteString(`{"foo":{"_type":"n","_value":1}}`)
+	if _, _, err := tc.submit(context.Background(), metrics); err != nil {
+		t.Fatalf("TrapCheck.submit() error = %v", err)
+	}
+	if proxied != submissionURL {
+		t.Errorf("proxied request = %q, want %q", proxied, submissionURL)
+	}
+}
+
+func TestTrapCheck_isValidBrokerProxy(t *testing.T) {
+	tc := &TrapCheck{brokerMaxResponseTime: 10 * time.Millisecond}
+	tc.Log = &LogWrapper{
+		Log:   log.New(io.Discard, "", log.LstdFlags),
+		Debug: false,
+	}
+	if err := tc.setProxy("http://proxy.example.com:3128", ""); err != nil {
+		t.Fatalf("TrapCheck.setProxy() error = %v", err)
+	}
+
+	// unreachable broker, valid because the connection test is skipped when proxied
+	brokerHost := "broker.invalid"
+	broker := &apiclient.Broker{
+		Name: "foo",
+		Type: circonusType,
+		Details: []apiclient.BrokerDetail{
+			{CN: "foo", Status: statusActive, Modules: []string{"httptrap"}, ExternalHost: &brokerHost, ExternalPort: 43191},
+		},
+	}
+	start := time.Now()
+	valid, err := tc.isValidBroker(broker, "httptrap")
+	if err != nil || !valid {
+		t.Fatalf("TrapCheck.isValidBroker() = %v, %v, want true", valid, err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("TrapCheck.isValidBroker() took %s, expected connection test to be skipped", elapsed)
+	}
+}
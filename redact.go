@@ -0,0 +1,107 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// secretMask replaces the check secret in log lines, errors and traces.
+const secretMask = "****"
+
+// submissionURLSecret returns the check secret, the path segment following
+// the check uuid in an httptrap submission url, or "" if there is none.
+func submissionURLSecret(submissionURL string) string {
+	u, err := url.Parse(submissionURL)
+	if err != nil {
+		return ""
+	}
+	segments := strings.Split(u.Path, "/")
+	for i := 0; i < len(segments)-1; i++ {
+		if _, err := uuid.Parse(segments[i]); err == nil {
+			return segments[i+1]
+		}
+	}
+	return ""
+}
+
+// redactSecret masks the secret path segment wherever it appears in s.
+func redactSecret(s, secret string) string {
+	if secret == "" {
+		return s
+	}
+	return strings.ReplaceAll(s, "/"+secret, "/"+secretMask)
+}
+
+// redactingLogger masks the check secret in messages before they are logged.
+type redactingLogger struct {
+	log    Logger
+	secret string
+}
+
+// redactLogger returns a logger masking the check secret of the current
+// submission url, or logger if there is no secret. stateMu must be held.
+func (tc *TrapCheck) redactLogger(logger Logger) Logger {
+	secret := submissionURLSecret(tc.submissionURL)
+	if secret == "" {
+		return logger
+	}
+	return &redactingLogger{log: logger, secret: secret}
+}
+
+func (rl *redactingLogger) Printf(format string, v ...interface{}) {
+	rl.log.Printf("%s", redactSecret(fmt.Sprintf(format, v...), rl.secret))
+}
+func (rl *redactingLogger) Debugf(format string, v ...interface{}) {
+	rl.log.Debugf("%s", redactSecret(fmt.Sprintf(format, v...), rl.secret))
+}
+func (rl *redactingLogger) Infof(format string, v ...interface{}) {
+	rl.log.Infof("%s", redactSecret(fmt.Sprintf(format, v...), rl.secret))
+}
+func (rl *redactingLogger) Warnf(format string, v ...interface{}) {
+	rl.log.Warnf("%s", redactSecret(fmt.Sprintf(format, v...), rl.secret))
+}
+func (rl *redactingLogger) Errorf(format string, v ...interface{}) {
+	rl.log.Errorf("%s", redactSecret(fmt.Sprintf(format, v...), rl.secret))
+}
+
+// redactedError masks the check secret in the error message, the original
+// error remains available to errors.Is/As.
+type redactedError struct {
+	err    error
+	secret string
+}
+
+// redactError returns err with the check secret (of the submission url the
+// error is about) masked, or err if there is no secret.
+func redactError(err error, secret string) error {
+	if err == nil {
+		return nil
+	}
+	if secret == "" || !strings.Contains(err.Error(), "/"+secret) {
+		return err
+	}
+	return &redactedError{err: err, secret: secret}
+}
+
+// submissionSecret returns the check secret of the current submission url.
+func (tc *TrapCheck) submissionSecret() string {
+	tc.stateMu.RLock()
+	defer tc.stateMu.RUnlock()
+	return submissionURLSecret(tc.submissionURL)
+}
+
+func (e *redactedError) Error() string {
+	return redactSecret(e.err.Error(), e.secret)
+}
+
+func (e *redactedError) Unwrap() error {
+	return e.err
+}
@@ -0,0 +1,167 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	apiclient "github.com/circonus-labs/go-apiclient"
+)
+
+func TestSubmissionURLSecret(t *testing.T) {
+	tests := []struct {
+		name string
+		url  string
+		want string
+	}{
+		{name: "broker", url: "https://127.0.0.1:43191/module/httptrap/0c5b9a36-2a2e-4e3c-9c8f-0d1f2a3b4c5d/s3cr3t", want: "s3cr3t"},
+		{name: "trailing path", url: "https://127.0.0.1:43191/module/httptrap/0c5b9a36-2a2e-4e3c-9c8f-0d1f2a3b4c5d/s3cr3t/extra", want: "s3cr3t"},
+		{name: "no secret", url: "https://127.0.0.1:43191/module/httptrap/0c5b9a36-2a2e-4e3c-9c8f-0d1f2a3b4c5d", want: ""},
+		{name: "custom url", url: "http://127.0.0.1:2609/write/foo", want: ""},
+		{name: "invalid", url: ":foo", want: ""},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			if got := submissionURLSecret(tt.url); got != tt.want {
+				t.Errorf("submissionURLSecret() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTrapCheck_submitRedactsSecret(t *testing.T) {
+	const secret = "s3cr3tv4lu3"
+	const path = "/module/httptrap/0c5b9a36-2a2e-4e3c-9c8f-0d1f2a3b4c5d/" + secret
+
+	tests := []struct {
+		name        string
+		statuses    []int
+		wantRefresh bool
+	}{
+		{name: "404", statuses: []int{http.StatusNotFound}, wantRefresh: true},
+		{name: "retry then non-200", statuses: []int{http.StatusInternalServerError, http.StatusBadRequest}},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			var reqs int32
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := int(atomic.AddInt32(&reqs, 1)) - 1
+				if r.URL.Path != path {
+					t.Errorf("request path = %s, want %s", r.URL.Path, path)
+				}
+				if n >= len(tt.statuses) {
+					n = len(tt.statuses) - 1
+				}
+				w.WriteHeader(tt.statuses[n])
+			}))
+			defer ts.Close()
+
+			var logs bytes.Buffer
+			submissionURL := ts.URL + path
+			tc := &TrapCheck{
+				checkBundle:       &apiclient.CheckBundle{},
+				submissionURL:     submissionURL,
+				submissionTimeout: 10 * time.Second,
+			}
+			tc.Log = &LogWrapper{Log: log.New(&logs, "", 0), Debug: true}
+
+			var metrics bytes.Buffer
+			metrics.WriteString(`{"foo":{"_type":"n","_value":1}}`)
+
+			_, refresh, err := tc.submit(context.Background(), metrics)
+			if err == nil {
+				t.Fatal("TrapCheck.submit() expected error")
+			}
+			if refresh != tt.wantRefresh {
+				t.Errorf("refresh = %t, want %t", refresh, tt.wantRefresh)
+			}
+			if strings.Contains(err.Error(), secret) {
+				t.Errorf("error contains secret: %s", err)
+			}
+			if !strings.Contains(err.Error(), "/"+secretMask) {
+				t.Errorf("error missing mask: %s", err)
+			}
+			if logs.Len() == 0 {
+				t.Fatal("expected log output")
+			}
+			if strings.Contains(logs.String(), secret) {
+				t.Errorf("log output contains secret:\n%s", logs.String())
+			}
+			if int(atomic.LoadInt32(&reqs)) < len(tt.statuses) {
+				t.Errorf("requests = %d, want %d", reqs, len(tt.statuses))
+			}
+		})
+	}
+}
+
+func TestRedactError(t *testing.T) {
+	const secret = "s3cr3tv4lu3"
+	submissionURL := "https://127.0.0.1:43191/module/httptrap/0c5b9a36-2a2e-4e3c-9c8f-0d1f2a3b4c5d/" + secret
+
+	if err := redactError(nil, secret); err != nil {
+		t.Errorf("redactError(nil) = %v", err)
+	}
+
+	plain := errors.New("no url here")
+	if err := redactError(plain, secret); err != plain { //nolint:errorlint
+		t.Errorf("redactError() = %v, want original error", err)
+	}
+
+	err := redactError(&requestHookError{err: errors.New("rejected " + submissionURL)}, secret)
+	if strings.Contains(err.Error(), secret) {
+		t.Errorf("error contains secret: %s", err)
+	}
+	var hookErr *requestHookError
+	if !errors.As(err, &hookErr) {
+		t.Errorf("errors.As(requestHookError) = false, want true")
+	}
+}
+
+func TestTrapCheck_submitRedactsSubmittedSecret(t *testing.T) {
+	const (
+		oldSecret = "0ldS3cr3t"
+		newSecret = "n3wS3cr3t"
+	)
+	var tc *TrapCheck
+	var surl string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the secret is rotated while the submission is in flight
+		tc.stateMu.Lock()
+		tc.submissionURL = strings.Replace(surl, oldSecret, newSecret, 1)
+		tc.stateMu.Unlock()
+		http.Error(w, "bad request", http.StatusBadRequest)
+	}))
+	defer ts.Close()
+
+	surl = ts.URL + "/module/httptrap/0c5b9a36-2a2e-4e3c-9c8f-0d1f2a3b4c5d/" + oldSecret
+	tc = newTestTrapCheck(surl)
+	tc.custSubmissionURL = surl
+
+	var metrics bytes.Buffer
+	metrics.WriteString(`{"foo":{"_type":"n","_value":1}}`)
+	_, err := tc.SendMetrics(context.Background(), metrics)
+	if err == nil {
+		t.Fatal("SendMetrics() expected error")
+	}
+	if strings.Contains(err.Error(), oldSecret) {
+		t.Errorf("error contains the submitted secret: %s", err)
+	}
+	if _, lastErr := tc.LastError(); lastErr == nil || strings.Contains(lastErr.Error(), oldSecret) {
+		t.Errorf("LastError() = %v, want the submitted secret masked", lastErr)
+	}
+}
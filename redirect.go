@@ -0,0 +1,40 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// maxSubmissionRedirects maximum number of same host redirects followed
+// when submitting, unless Config.AllowRedirects is set.
+const maxSubmissionRedirects = 3
+
+// ErrRedirectedSubmission is returned (wrapped, naming the target) when the
+// broker redirects a submission to a different host and Config.AllowRedirects
+// is not set. The metrics (and the check secret in the url) are not re-sent.
+var ErrRedirectedSubmission = errors.New("submission redirected to a different host")
+
+// checkRedirect is the submission client redirect policy: same host redirects
+// are followed (up to maxSubmissionRedirects), redirects to a different host
+// or from https to http are refused.
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) == 0 {
+		return nil
+	}
+	if len(via) >= maxSubmissionRedirects {
+		return fmt.Errorf("stopped after %d redirects", maxSubmissionRedirects)
+	}
+	orig := via[0].URL
+	target := req.URL
+	if !strings.EqualFold(target.Hostname(), orig.Hostname()) || (orig.Scheme == "https" && target.Scheme != "https") {
+		return fmt.Errorf("%w (%s://%s)", ErrRedirectedSubmission, target.Scheme, target.Host)
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/circonus-labs/go-apiclient"
+)
+
+func TestTrapCheck_submitRedirects(t *testing.T) {
+	var otherHits int32
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&otherHits, 1)
+		fmt.Fprintln(w, `{"stats":1}`)
+	}))
+	defer other.Close()
+	// same address, different host name
+	otherURL := strings.Replace(other.URL, "127.0.0.1", "localhost", 1)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/same":
+			http.Redirect(w, r, "/final", http.StatusFound)
+		case "/loop":
+			http.Redirect(w, r, "/loop", http.StatusFound)
+		case "/cross":
+			http.Redirect(w, r, otherURL+"/final", http.StatusFound)
+		default:
+			fmt.Fprintln(w, `{"stats":1}`)
+		}
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		wantErr        error
+		name           string
+		path           string
+		wantFinal      string
+		allowRedirects bool
+		wantOtherHits  int32
+		wantAnyErr     bool
+	}{
+		{name: "no redirect", path: "/ok", wantFinal: ts.URL + "/ok"},
+		{name: "same host", path: "/same", wantFinal: ts.URL + "/final"},
+		{name: "same host, too many", path: "/loop", wantAnyErr: true},
+		{name: "cross host refused", path: "/cross", wantErr: ErrRedirectedSubmission},
+		{name: "cross host allowed", path: "/cross", allowRedirects: true, wantFinal: otherURL + "/final", wantOtherHits: 1},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			atomic.StoreInt32(&otherHits, 0)
+			tc := &TrapCheck{
+				checkBundle:       &apiclient.CheckBundle{},
+				custSubmissionURL: ts.URL + tt.path,
+				submissionURL:     ts.URL + tt.path,
+				submissionTimeout: 5 * time.Second,
+				allowRedirects:    tt.allowRedirects,
+			}
+			tc.Log = &LogWrapper{
+				Log:   log.New(io.Discard, "", log.LstdFlags),
+				Debug: false,
+			}
+
+			var metrics bytes.Buffer
+			metrics.WriteString(`{"foo":{"_type":"n","_value":1}}`)
+			result, _, err := tc.submit(context.Background(), metrics)
+
+			switch {
+			case tt.wantErr != nil:
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("TrapCheck.submit() error = %v, want %v", err, tt.wantErr)
+				}
+				if !strings.Contains(err.Error(), "localhost") {
+					t.Errorf("TrapCheck.submit() error = %v, want redirect target", err)
+				}
+			case tt.wantAnyErr:
+				if err == nil {
+					t.Fatal("TrapCheck.submit() expected error")
+				}
+			default:
+				if err != nil {
+					t.Fatalf("TrapCheck.submit() error = %v", err)
+				}
+				if result.FinalURL != tt.wantFinal {
+					t.Errorf("TrapCheck.submit() final url = %s, want %s", result.FinalURL, tt.wantFinal)
+				}
+			}
+			if n := atomic.LoadInt32(&otherHits); n != tt.wantOtherHits {
+				t.Errorf("other host requests = %d, want %d", n, tt.wantOtherHits)
+			}
+		})
+	}
+}
+
+func Test_checkRedirect_schemeDowngrade(t *testing.T) {
+	orig, err := http.NewRequest(http.MethodPut, "https://127.0.0.1:43191/module/httptrap/abc/secret", nil)
+	if err != nil {
+		t.Fatalf("creating request: %s", err)
+	}
+	req, err := http.NewRequest(http.MethodGet, "http://127.0.0.1:43191/module/httptrap/abc/secret", nil)
+	if err != nil {
+		t.Fatalf("creating request: %s", err)
+	}
+	if err := checkRedirect(req, []*http.Request{orig}); !errors.Is(err, ErrRedirectedSubmission) {
+		t.Errorf("checkRedirect() error = %v, want %v", err, ErrRedirectedSubmission)
+	}
+}
@@ -0,0 +1,180 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+const (
+	defaultRefreshCooldown    = "60s"
+	defaultRefreshRetryDelay  = "2s"
+	defaultRefreshRetryJitter = "0s"
+	maxRefreshCooldown        = time.Hour
+)
+
+// RefreshReason identifies why the check bundle or broker TLS config was refreshed.
+type RefreshReason string
+
+const (
+	// RefreshReasonHTTP404 broker returned 404 for the submission url (check moved/deleted).
+	RefreshReasonHTTP404 RefreshReason = "http-404"
+	// RefreshReasonHTTPUnauthorized broker returned 401/403 for the submission url (check secret rotated).
+	RefreshReasonHTTPUnauthorized RefreshReason = "http-unauthorized"
+	// RefreshReasonTLSNameMismatch broker cert cn did not match an instance of the broker.
+	RefreshReasonTLSNameMismatch RefreshReason = "tls-name-mismatch"
+	// RefreshReasonCACertExpiry broker CA cert is expired or within the refresh window.
+	RefreshReasonCACertExpiry RefreshReason = "ca-cert-expiry"
+	// RefreshReasonManual caller requested refresh via RefreshCheckBundle.
+	RefreshReasonManual RefreshReason = "manual"
+	// RefreshReasonSecretRotation check secret was rotated via RotateCheckSecret.
+	RefreshReasonSecretRotation RefreshReason = "secret-rotation"
+	// RefreshReasonBundleModified check bundle was modified externally (see Config.BundleRecheckInterval).
+	RefreshReasonBundleModified RefreshReason = "bundle-modified"
+	// RefreshReasonStaleBroker check bundle broker not in the broker list (see Config.RefreshOnStaleBroker).
+	RefreshReasonStaleBroker RefreshReason = "stale-broker"
+)
+
+// RefreshStats returns a copy of the number of refreshes performed, by reason.
+func (tc *TrapCheck) RefreshStats() map[string]uint64 {
+	tc.refreshStatsMu.Lock()
+	defer tc.refreshStatsMu.Unlock()
+
+	stats := make(map[string]uint64, len(tc.refreshStats))
+	for reason, count := range tc.refreshStats {
+		stats[string(reason)] = count
+	}
+	return stats
+}
+
+// ResetRefreshStats clears the refresh counters.
+func (tc *TrapCheck) ResetRefreshStats() {
+	tc.refreshStatsMu.Lock()
+	defer tc.refreshStatsMu.Unlock()
+
+	tc.refreshStats = nil
+}
+
+// recordRefresh increments the counter for the refresh reason.
+func (tc *TrapCheck) recordRefresh(reason RefreshReason) {
+	tc.refreshStatsMu.Lock()
+	defer tc.refreshStatsMu.Unlock()
+
+	if tc.refreshStats == nil {
+		tc.refreshStats = make(map[RefreshReason]uint64)
+	}
+	tc.refreshStats[reason]++
+}
+
+// submitRefreshReason returns the reason a failed submission requires a check refresh.
+func submitRefreshReason(submitErr error) RefreshReason {
+	if errors.Is(submitErr, ErrSubmissionUnauthorized) {
+		return RefreshReasonHTTPUnauthorized
+	}
+	return RefreshReasonHTTP404
+}
+
+// setRefreshOptions parses the refresh cooldown and retry delay settings.
+func (tc *TrapCheck) setRefreshOptions(cfg *Config) error {
+	var err error
+	if tc.refreshCooldown, err = parseDurationSetting(cfg.RefreshCooldown, defaultRefreshCooldown); err != nil {
+		return fmt.Errorf("parsing refresh cooldown: %w", err)
+	}
+	if tc.refreshRetryDelay, err = parseDurationSetting(cfg.RefreshRetryDelay, defaultRefreshRetryDelay); err != nil {
+		return fmt.Errorf("parsing refresh retry delay: %w", err)
+	}
+	if tc.refreshRetryJitter, err = parseDurationSetting(cfg.RefreshRetryJitter, defaultRefreshRetryJitter); err != nil {
+		return fmt.Errorf("parsing refresh retry jitter: %w", err)
+	}
+	return nil
+}
+
+// refreshCooldownRemaining returns how long until a broker triggered refresh
+// is allowed, at most one refresh per cooldown (from the last refresh
+// attempt). The cooldown doubles for each consecutive refresh which did not
+// result in a successful submission, up to maxRefreshCooldown.
+func (tc *TrapCheck) refreshCooldownRemaining() time.Duration {
+	tc.refreshMu.Lock()
+	defer tc.refreshMu.Unlock()
+	if tc.refreshFlight != nil {
+		return 0 // join the refresh in progress
+	}
+	if tc.lastRefresh.IsZero() {
+		return 0
+	}
+	cooldown := tc.refreshCooldown
+	for i := 1; i < tc.refreshFailures && cooldown < maxRefreshCooldown; i++ {
+		cooldown *= 2
+	}
+	if cooldown > maxRefreshCooldown {
+		cooldown = maxRefreshCooldown
+	}
+	return tc.lastRefresh.Add(cooldown).Sub(tc.clock().Now())
+}
+
+// refreshSuppressedError is the submission error which needed a refresh
+// within the cooldown, it matches ErrRefreshSuppressed and unwraps to the
+// submission error (e.g. the broker 404).
+type refreshSuppressedError struct {
+	err  error
+	wait time.Duration
+}
+
+func (e *refreshSuppressedError) Error() string {
+	return fmt.Sprintf("%s (next refresh in %s): %s", e.err, e.wait, ErrRefreshSuppressed)
+}
+
+func (e *refreshSuppressedError) Is(target error) bool {
+	return target == ErrRefreshSuppressed
+}
+
+func (e *refreshSuppressedError) Unwrap() error {
+	return e.err
+}
+
+// refreshFlight is a check refresh in progress, shared by concurrent callers.
+type refreshFlight struct {
+	done      chan struct{}
+	err       error
+	refreshed bool
+	shared    int // callers waiting on the refresh
+}
+
+// recordRefreshOutcome tracks consecutive refreshes which did not result in
+// a successful submission (see refreshCooldownRemaining), ok resets the count.
+func (tc *TrapCheck) recordRefreshOutcome(ok bool) {
+	tc.refreshMu.Lock()
+	defer tc.refreshMu.Unlock()
+	if ok {
+		tc.refreshFailures = 0
+		return
+	}
+	tc.refreshFailures++
+}
+
+// refreshRetryDelayWithJitter returns the delay before retrying a submission after a refresh.
+func (tc *TrapCheck) refreshRetryDelayWithJitter() time.Duration {
+	delay := tc.refreshRetryDelay
+	if tc.refreshRetryJitter > 0 {
+		delay += time.Duration(rand.Int63n(int64(tc.refreshRetryJitter)))
+	}
+	return delay
+}
+
+// parseDurationSetting parses a duration setting, using def if the setting is empty.
+func parseDurationSetting(setting, def string) (time.Duration, error) {
+	if setting == "" {
+		setting = def
+	}
+	dur, err := time.ParseDuration(setting)
+	if err != nil {
+		return 0, fmt.Errorf("(%s): %w", setting, err)
+	}
+	return dur, nil
+}
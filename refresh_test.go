@@ -0,0 +1,599 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/circonus-labs/go-apiclient"
+	"github.com/circonus-labs/go-trapcheck/testsupport"
+	"github.com/circonus-labs/go-trapcheck/trapchecktest"
+)
+
+func TestTrapCheck_RefreshStats(t *testing.T) {
+	tc := &TrapCheck{}
+
+	if got := tc.RefreshStats(); len(got) != 0 {
+		t.Errorf("TrapCheck.RefreshStats() = %v, want empty", got)
+	}
+
+	tc.recordRefresh(RefreshReasonHTTP404)
+	tc.recordRefresh(RefreshReasonHTTP404)
+	tc.recordRefresh(RefreshReasonManual)
+
+	want := map[string]uint64{"http-404": 2, "manual": 1}
+	got := tc.RefreshStats()
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("TrapCheck.RefreshStats() = %v, want %v", got, want)
+	}
+
+	got["manual"] = 10 // must not change internal state
+	if n := tc.RefreshStats()["manual"]; n != 1 {
+		t.Errorf("TrapCheck.RefreshStats() manual = %d, want 1", n)
+	}
+
+	tc.ResetRefreshStats()
+	if got := tc.RefreshStats(); len(got) != 0 {
+		t.Errorf("TrapCheck.RefreshStats() after reset = %v, want empty", got)
+	}
+}
+
+func TestTrapCheck_RefreshStats_http404(t *testing.T) {
+	fb := trapchecktest.NewFakeBroker(t)
+	fb.RespondNotFound(1)
+	submissionURL := fb.SubmissionURL("abc-123", "secret")
+
+	bundle := &apiclient.CheckBundle{
+		CID:        "/check_bundle/123",
+		CheckUUIDs: []string{"abc-123"},
+		Type:       "httptrap",
+		Config:     apiclient.CheckBundleConfig{"submission_url": submissionURL},
+		Status:     statusActive,
+	}
+
+	tc := &TrapCheck{
+		client: &APIMock{
+			FetchCheckBundleFunc: func(cid apiclient.CIDType) (*apiclient.CheckBundle, error) {
+				return bundle, nil
+			},
+		},
+		checkBundle:       bundle,
+		submissionURL:     submissionURL,
+		submissionTimeout: 5 * time.Second,
+	}
+	tc.Log = &LogWrapper{
+		Log:   log.New(io.Discard, "", log.LstdFlags),
+		Debug: false,
+	}
+	tc.brokerList = initTestBrokerList(t, &APIMock{
+		FetchBrokersFunc: func() (*[]apiclient.Broker, error) {
+			return &[]apiclient.Broker{}, nil
+		},
+	}, tc.Log)
+
+	var metrics bytes.Buffer
+	metrics.WriteString(`{"foo":{"_type":"n","_value":1}}`)
+	if _, err := tc.SendMetrics(context.Background(), metrics); err != nil {
+		t.Fatalf("TrapCheck.SendMetrics() error = %v", err)
+	}
+	if n := len(fb.Submissions()); n != 2 {
+		t.Errorf("broker submissions = %d, want 2", n)
+	}
+
+	if n := tc.RefreshStats()[string(RefreshReasonHTTP404)]; n != 1 {
+		t.Errorf("TrapCheck.RefreshStats() %s = %d, want 1", RefreshReasonHTTP404, n)
+	}
+}
+
+func TestTrapCheck_RefreshStats_manual(t *testing.T) {
+	bundle := &apiclient.CheckBundle{
+		CID:    "/check_bundle/123",
+		Type:   "httptrap",
+		Config: apiclient.CheckBundleConfig{"submission_url": "http://127.0.0.1:12345/"},
+		Status: statusActive,
+	}
+
+	tc := &TrapCheck{
+		client: &APIMock{
+			FetchCheckBundleFunc: func(cid apiclient.CIDType) (*apiclient.CheckBundle, error) {
+				return bundle, nil
+			},
+		},
+		checkBundle: bundle,
+	}
+	tc.Log = &LogWrapper{
+		Log:   log.New(io.Discard, "", log.LstdFlags),
+		Debug: false,
+	}
+
+	if _, err := tc.RefreshCheckBundle(); err != nil {
+		t.Fatalf("TrapCheck.RefreshCheckBundle() error = %v", err)
+	}
+
+	if n := tc.RefreshStats()[string(RefreshReasonManual)]; n != 1 {
+		t.Errorf("TrapCheck.RefreshStats() %s = %d, want 1", RefreshReasonManual, n)
+	}
+}
+
+func TestTrapCheck_RefreshStats_tlsNameMismatch(t *testing.T) {
+	tc := &TrapCheck{}
+	tc.Log = &LogWrapper{
+		Log:   log.New(io.Discard, "", log.LstdFlags),
+		Debug: false,
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "beep boop")
+	}))
+	defer ts.Close()
+
+	tsURL, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatalf("creating test broker: %s", err)
+	}
+	brokerIP := tsURL.Hostname()
+	bp, err := strconv.Atoi(tsURL.Port())
+	if err != nil {
+		t.Fatalf("parsing test broker port: %s", err)
+	}
+	brokerPort := uint16(bp)
+
+	caPEM, ca, caKey := generateTestCA(t, time.Now().Add(time.Hour))
+	client := &APIMock{
+		FetchBrokersFunc: func() (*[]apiclient.Broker, error) {
+			return &[]apiclient.Broker{
+				{
+					CID:  "/broker/123",
+					Name: "foo",
+					Type: circonusType,
+					Details: []apiclient.BrokerDetail{
+						{CN: "foo", Status: statusActive, Modules: []string{"httptrap"}, IP: &brokerIP, Port: &brokerPort},
+					},
+				},
+			}, nil
+		},
+	}
+
+	tc.brokerList = initTestBrokerList(t, client, tc.Log)
+	tc.client = client
+	tc.caCertPEM = caPEM
+	tc.submissionURL = "https://" + tsURL.Host
+	tc.checkBundle = &apiclient.CheckBundle{
+		Brokers: []string{"/broker/123"},
+		Type:    "httptrap",
+		Config:  apiclient.CheckBundleConfig{"submission_url": tc.submissionURL},
+	}
+
+	if err := tc.setBrokerTLSConfig(); err != nil {
+		t.Fatalf("TrapCheck.setBrokerTLSConfig() error = %v", err)
+	}
+
+	// broker presents a cert for an unknown instance
+	cert := generateTestCert(t, ca, caKey, "bar")
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatalf("parsing test cert: %s", err)
+	}
+	if err := tc.tlsConfig.VerifyConnection(tls.ConnectionState{PeerCertificates: []*x509.Certificate{leaf}}); err == nil {
+		t.Fatal("VerifyConnection() expected name mismatch error")
+	}
+
+	if err := tc.setBrokerTLSConfig(); err != nil {
+		t.Fatalf("TrapCheck.setBrokerTLSConfig() error = %v", err)
+	}
+
+	if n := tc.RefreshStats()[string(RefreshReasonTLSNameMismatch)]; n != 1 {
+		t.Errorf("TrapCheck.RefreshStats() %s = %d, want 1", RefreshReasonTLSNameMismatch, n)
+	}
+}
+
+func TestTrapCheck_SendMetrics_refreshCooldown(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/deleted" {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		fmt.Fprintln(w, `{"stats":1}`)
+	}))
+	defer ts.Close()
+
+	bundle := &apiclient.CheckBundle{
+		CID:        "/check_bundle/123",
+		CheckUUIDs: []string{"abc-123"},
+		Type:       "httptrap",
+		Config:     apiclient.CheckBundleConfig{"submission_url": ts.URL + "/deleted"},
+		Status:     statusActive,
+	}
+
+	client := &APIMock{
+		FetchCheckBundleFunc: func(cid apiclient.CIDType) (*apiclient.CheckBundle, error) {
+			return bundle, nil
+		},
+	}
+
+	fc := testsupport.NewFakeClock(time.Now())
+	tc := &TrapCheck{
+		client:            client,
+		checkBundle:       bundle,
+		submissionURL:     ts.URL + "/deleted",
+		submissionTimeout: 5 * time.Second,
+		refreshCooldown:   time.Minute,
+		clk:               fc,
+	}
+	tc.Log = &LogWrapper{
+		Log:   log.New(io.Discard, "", log.LstdFlags),
+		Debug: false,
+	}
+	tc.brokerList = initTestBrokerList(t, &APIMock{
+		FetchBrokersFunc: func() (*[]apiclient.Broker, error) {
+			return &[]apiclient.Broker{}, nil
+		},
+	}, tc.Log)
+
+	send := func() error {
+		var metrics bytes.Buffer
+		metrics.WriteString(`{"foo":{"_type":"n","_value":1}}`)
+		_, err := tc.SendMetrics(context.Background(), metrics)
+		return err
+	}
+
+	// first 404 refreshes, retry also 404s
+	if err := send(); err == nil || errors.Is(err, ErrRefreshSuppressed) {
+		t.Fatalf("TrapCheck.SendMetrics() error = %v, want unsuppressed error", err)
+	}
+	// subsequent 404s within cooldown do not refresh, the 404 is returned
+	for i := 0; i < 3; i++ {
+		err := send()
+		if !errors.Is(err, ErrRefreshSuppressed) {
+			t.Fatalf("TrapCheck.SendMetrics() error = %v, want %v", err, ErrRefreshSuppressed)
+		}
+		var stErr *statusError
+		if !errors.As(err, &stErr) || stErr.code != http.StatusNotFound {
+			t.Fatalf("TrapCheck.SendMetrics() error = %v, want the 404 status error", err)
+		}
+	}
+	if n := len(client.FetchCheckBundleCalls()); n != 1 {
+		t.Errorf("FetchCheckBundle calls = %d, want 1", n)
+	}
+
+	// cooldown doubles after the second consecutive failure
+	fc.Advance(2 * time.Minute)
+	if err := send(); err == nil || errors.Is(err, ErrRefreshSuppressed) {
+		t.Fatalf("TrapCheck.SendMetrics() error = %v, want unsuppressed error", err)
+	}
+	if n := len(client.FetchCheckBundleCalls()); n != 2 {
+		t.Errorf("FetchCheckBundle calls = %d, want 2", n)
+	}
+	if wait := tc.refreshCooldownRemaining(); wait != 2*time.Minute {
+		t.Errorf("TrapCheck.refreshCooldownRemaining() = %s, want 2m", wait)
+	}
+
+	// check recreated, successful refresh+submit resets the doubling, at
+	// most one refresh per cooldown still applies
+	fc.Advance(3 * time.Minute)
+	bundle.Config = apiclient.CheckBundleConfig{"submission_url": ts.URL}
+	if err := send(); err != nil {
+		t.Fatalf("TrapCheck.SendMetrics() error = %v", err)
+	}
+	if wait := tc.refreshCooldownRemaining(); wait != time.Minute {
+		t.Errorf("TrapCheck.refreshCooldownRemaining() = %s, want 1m", wait)
+	}
+	fc.Advance(time.Minute)
+	if wait := tc.refreshCooldownRemaining(); wait != 0 {
+		t.Errorf("TrapCheck.refreshCooldownRemaining() = %s, want 0", wait)
+	}
+}
+
+func TestTrapCheck_SendMetrics_postRefreshDelay(t *testing.T) {
+	fb := trapchecktest.NewFakeBroker(t)
+	fb.RespondNotFound(1) // submit, refresh and resubmit after the delay
+	submissionURL := fb.SubmissionURL("abc-123", "secret")
+
+	client := &APIMock{
+		FetchCheckBundleFunc: func(cid apiclient.CIDType) (*apiclient.CheckBundle, error) {
+			return &apiclient.CheckBundle{
+				CID:        "/check_bundle/123",
+				CheckUUIDs: []string{"abc-123"},
+				Type:       "httptrap",
+				Config:     apiclient.CheckBundleConfig{"submission_url": submissionURL},
+				Status:     statusActive,
+			}, nil
+		},
+	}
+	fc := testsupport.NewFakeClock(time.Now())
+	tc := &TrapCheck{
+		client:            client,
+		checkBundle:       &apiclient.CheckBundle{CID: "/check_bundle/123", Type: "httptrap"},
+		submissionURL:     submissionURL,
+		submissionTimeout: 5 * time.Second,
+		refreshRetryDelay: 2 * time.Second,
+		clk:               fc,
+	}
+	tc.Log = &LogWrapper{Log: log.New(io.Discard, "", log.LstdFlags)}
+
+	start := time.Now()
+	var metrics bytes.Buffer
+	metrics.WriteString(`{"foo":{"_type":"n","_value":1}}`)
+	if _, err := tc.SendMetrics(context.Background(), metrics); err != nil {
+		t.Fatalf("TrapCheck.SendMetrics() error = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed >= tc.refreshRetryDelay {
+		t.Errorf("SendMetrics() took %s, want the post-refresh delay on the fake clock", elapsed)
+	}
+	if sleeps := fc.Sleeps(); !reflect.DeepEqual(sleeps, []time.Duration{2 * time.Second}) {
+		t.Errorf("clock sleeps = %v, want [2s]", sleeps)
+	}
+	if n := len(fb.Submissions()); n != 2 {
+		t.Errorf("broker submissions = %d, want 2", n)
+	}
+}
+
+func TestTrapCheck_refreshCheck_brokerMoved(t *testing.T) {
+	caPEM, _, _ := generateTestCA(t, time.Now().Add(24*time.Hour))
+
+	ip := "127.0.0.1"
+	port := uint16(43191)
+	newBroker := func(cid, cn string) apiclient.Broker {
+		return apiclient.Broker{
+			CID:  cid,
+			Name: cn,
+			Type: circonusType,
+			Details: []apiclient.BrokerDetail{
+				{CN: cn, IP: &ip, Port: &port, Status: statusActive, Modules: []string{"httptrap"}},
+			},
+		}
+	}
+	broker123 := newBroker("/broker/123", "broker-123.example.com")
+	broker456 := newBroker("/broker/456", "broker-456.example.com")
+
+	surl := "https://127.0.0.1:43191/module/httptrap/abc/secret"
+	newBundle := func(brokerCID string) *apiclient.CheckBundle {
+		return &apiclient.CheckBundle{
+			CID:     "/check_bundle/123",
+			Brokers: []string{brokerCID},
+			Type:    "httptrap",
+			Config:  apiclient.CheckBundleConfig{"submission_url": surl},
+			Status:  statusActive,
+		}
+	}
+
+	client := &APIMock{
+		FetchCheckBundleFunc: func(cid apiclient.CIDType) (*apiclient.CheckBundle, error) {
+			return newBundle("/broker/456"), nil
+		},
+		FetchBrokersFunc: func() (*[]apiclient.Broker, error) {
+			return &[]apiclient.Broker{broker123, broker456}, nil
+		},
+	}
+	bl := &refreshTestBrokerList{client: client}
+	if err := bl.FetchBrokers(); err != nil {
+		t.Fatalf("fetching brokers: %s", err)
+	}
+
+	var logs bytes.Buffer
+	tc := &TrapCheck{
+		client:              client,
+		brokerList:          bl,
+		checkConfig:         &apiclient.CheckBundle{Brokers: []string{"/broker/123"}},
+		checkBundle:         newBundle("/broker/123"),
+		submissionURL:       surl,
+		caCertPEM:           caPEM,
+		skipBrokerConnCheck: true,
+	}
+	tc.Log = &LogWrapper{Log: log.New(&logs, "", 0)}
+
+	if err := tc.setBrokerTLSConfig(); err != nil {
+		t.Fatalf("setBrokerTLSConfig() unexpected error: %s", err)
+	}
+	if tc.tlsConfig.ServerName != "broker-123.example.com" {
+		t.Fatalf("initial ServerName = %q, want broker-123.example.com", tc.tlsConfig.ServerName)
+	}
+
+	refreshed, err := tc.refreshCheck(RefreshReasonManual)
+	if err != nil || !refreshed {
+		t.Fatalf("refreshCheck() = %v, %v, want true, nil", refreshed, err)
+	}
+
+	if tc.broker == nil || tc.broker.CID != "/broker/456" {
+		t.Fatalf("broker after refresh = %+v, want /broker/456", tc.broker)
+	}
+	if len(tc.checkConfig.Brokers) != 0 {
+		t.Errorf("check config brokers = %v, want pin cleared", tc.checkConfig.Brokers)
+	}
+	if !strings.Contains(logs.String(), "check moved to broker /broker/456 (was /broker/123)") {
+		t.Errorf("broker change not logged: %s", logs.String())
+	}
+
+	// a subsequent tls setup (e.g. after a name mismatch) must use the bundle broker
+	tc.clearTLSConfig(RefreshReasonTLSNameMismatch)
+	if err := tc.setBrokerTLSConfig(); err != nil {
+		t.Fatalf("setBrokerTLSConfig() unexpected error: %s", err)
+	}
+	if tc.tlsConfig.ServerName != "broker-456.example.com" {
+		t.Errorf("ServerName = %q, want broker-456.example.com", tc.tlsConfig.ServerName)
+	}
+	if tc.broker.CID != "/broker/456" {
+		t.Errorf("broker = %s, want /broker/456", tc.broker.CID)
+	}
+}
+
+func TestTrapCheck_SendMetrics_refreshCustomURL(t *testing.T) {
+	front := trapchecktest.NewFakeBroker(t)  // e.g. stable dns name in front of the broker
+	broker := trapchecktest.NewFakeBroker(t) // the check bundle broker
+	frontURL := front.SubmissionURL("abc-123", "secret")
+	refreshedURL := broker.SubmissionURL("abc-123", "newsecret")
+	refreshedPath := "/module/httptrap/abc-123/newsecret"
+
+	tests := []struct {
+		name          string
+		cid           string
+		wantURL       string
+		wantFetches   int
+		allowRefresh  bool
+		replaceURL    bool
+		wantErr       bool
+		wantCustomURL bool
+	}{
+		{name: "legacy, not refreshed", cid: "/check_bundle/123", wantErr: true, wantURL: frontURL, wantCustomURL: true},
+		{name: "allowed, no cid", allowRefresh: true, wantErr: true, wantURL: frontURL, wantCustomURL: true},
+		{name: "allowed, custom host kept", cid: "/check_bundle/123", allowRefresh: true, wantFetches: 1, wantURL: front.URL() + refreshedPath, wantCustomURL: true},
+		{name: "allowed, replaced", cid: "/check_bundle/123", allowRefresh: true, replaceURL: true, wantFetches: 1, wantURL: refreshedURL},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			front.Reset()
+			broker.Reset()
+			front.RespondNotFound(1)
+
+			client := &APIMock{
+				FetchCheckBundleFunc: func(cid apiclient.CIDType) (*apiclient.CheckBundle, error) {
+					return &apiclient.CheckBundle{
+						CID:        "/check_bundle/123",
+						CheckUUIDs: []string{"abc-123"},
+						Type:       "httptrap",
+						Config:     apiclient.CheckBundleConfig{"submission_url": refreshedURL},
+						Status:     statusActive,
+					}, nil
+				},
+			}
+			tc := &TrapCheck{
+				client:             client,
+				checkBundle:        &apiclient.CheckBundle{CID: tt.cid, Type: "httptrap"},
+				custSubmissionURL:  frontURL,
+				submissionURL:      frontURL,
+				submissionTimeout:  5 * time.Second,
+				allowCustomRefresh: tt.allowRefresh,
+				refreshReplacesURL: tt.replaceURL,
+			}
+			tc.Log = &LogWrapper{Log: log.New(io.Discard, "", log.LstdFlags)}
+
+			var metrics bytes.Buffer
+			metrics.WriteString(`{"foo":{"_type":"n","_value":1}}`)
+			_, err := tc.SendMetrics(context.Background(), metrics)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("TrapCheck.SendMetrics() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if n := len(client.FetchCheckBundleCalls()); n != tt.wantFetches {
+				t.Errorf("FetchCheckBundle calls = %d, want %d", n, tt.wantFetches)
+			}
+			if tc.submissionURL != tt.wantURL {
+				t.Errorf("submission url = %s, want %s", tc.submissionURL, tt.wantURL)
+			}
+			if (tc.custSubmissionURL != "") != tt.wantCustomURL {
+				t.Errorf("custom submission url = %q, want set %v", tc.custSubmissionURL, tt.wantCustomURL)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			// resubmitted to the refreshed url
+			var last trapchecktest.Submission
+			if tt.replaceURL {
+				subs := broker.Submissions()
+				if len(subs) != 1 {
+					t.Fatalf("broker submissions = %d, want 1", len(subs))
+				}
+				last = subs[0]
+			} else {
+				subs := front.Submissions()
+				if len(subs) != 2 {
+					t.Fatalf("front submissions = %d, want 2", len(subs))
+				}
+				last = subs[1]
+			}
+			if last.Path != refreshedPath {
+				t.Errorf("resubmission path = %s, want %s", last.Path, refreshedPath)
+			}
+		})
+	}
+}
+
+func TestTrapCheck_SendMetrics_concurrentRefresh(t *testing.T) {
+	const (
+		submitters = 20
+		stagger    = 5 * time.Millisecond
+		fetchDelay = 300 * time.Millisecond // the staggered submitters all arrive during the refresh
+	)
+
+	fb := trapchecktest.NewFakeBroker(t)
+	fb.RespondNotFound(submitters) // every submitter triggers a refresh
+	submissionURL := fb.SubmissionURL("abc-123", "secret")
+	refreshedURL := fb.SubmissionURL("abc-123", "rotated") // refresh changes the submission url
+
+	client := &APIMock{
+		FetchCheckBundleFunc: func(cid apiclient.CIDType) (*apiclient.CheckBundle, error) {
+			time.Sleep(fetchDelay)
+			return &apiclient.CheckBundle{
+				CID:        "/check_bundle/123",
+				CheckUUIDs: []string{"abc-123"},
+				Type:       "httptrap",
+				Config:     apiclient.CheckBundleConfig{"submission_url": refreshedURL},
+				Status:     statusActive,
+			}, nil
+		},
+	}
+	tc := &TrapCheck{
+		client:            client,
+		checkBundle:       &apiclient.CheckBundle{CID: "/check_bundle/123", Type: "httptrap"},
+		submissionURL:     submissionURL,
+		submissionTimeout: 5 * time.Second,
+	}
+	tc.Log = &LogWrapper{Log: log.New(io.Discard, "", log.LstdFlags)}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, submitters)
+	for i := 0; i < submitters; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			time.Sleep(time.Duration(i) * stagger)
+			var metrics bytes.Buffer
+			metrics.WriteString(`{"foo":{"_type":"n","_value":1}}`)
+			_, err := tc.SendMetrics(context.Background(), metrics)
+			errs <- err
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("TrapCheck.SendMetrics() error = %v", err)
+		}
+	}
+	if n := len(client.FetchCheckBundleCalls()); n != 1 {
+		t.Errorf("FetchCheckBundle calls = %d, want 1", n)
+	}
+	if n := tc.RefreshStats()[string(RefreshReasonHTTP404)]; n != 1 {
+		t.Errorf("TrapCheck.RefreshStats() %s = %d, want 1", RefreshReasonHTTP404, n)
+	}
+	subs := fb.Submissions()
+	if len(subs) != 2*submitters {
+		t.Fatalf("broker submissions = %d, want %d", len(subs), 2*submitters)
+	}
+	for _, sub := range subs[submitters:] {
+		if !strings.HasSuffix(sub.Path, "/rotated") {
+			t.Errorf("resubmission path = %s, want refreshed submission url", sub.Path)
+		}
+	}
+}
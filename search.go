@@ -0,0 +1,74 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/circonus-labs/go-apiclient"
+)
+
+// ErrInvalidSearchValue is returned (wrapped) when a value used to build a
+// search (check type, target, search/select tags) contains characters the
+// Circonus search grammar treats specially.
+var ErrInvalidSearchValue = errors.New("invalid search value")
+
+// checkSearchCriteria builds the search query used to find an existing check bundle
+// e.g. (active:1)(type:"httptrap:cua:host:linux")(target:"el7-cua-test")(tags:service:circonus-unified-agentd).
+func checkSearchCriteria(checkType, target string, tags apiclient.TagType) (apiclient.SearchQueryType, error) {
+	if err := validateQuotedSearchValue("type", checkType); err != nil {
+		return "", err
+	}
+	if err := validateQuotedSearchValue("target", target); err != nil {
+		return "", err
+	}
+	if err := validateSearchTags(tags); err != nil {
+		return "", err
+	}
+
+	return apiclient.SearchQueryType(
+		fmt.Sprintf(`(active:1)(type:"%s")(target:"%s")(tags:%s)`,
+			checkType,
+			target,
+			strings.Join(tags, ","))), nil
+}
+
+// validateQuotedSearchValue verifies a value which will be double quoted in
+// a search expression - quotes, backslashes, and control characters cannot
+// be represented.
+func validateQuotedSearchValue(field, val string) error {
+	for _, r := range val {
+		if r == '"' || r == '\\' || unicode.IsControl(r) {
+			return fmt.Errorf("%s (%q) contains %q: %w", field, val, r, ErrInvalidSearchValue)
+		}
+	}
+	return nil
+}
+
+// validateSearchTags verifies tags which are used unquoted in search
+// expressions (and when matching broker tags) - in addition to the quoted
+// value restrictions, whitespace, parentheses, and commas (the tag
+// separator) are not permitted.
+func validateSearchTags(tags apiclient.TagType) error {
+	for _, tag := range tags {
+		if tag == "" {
+			return fmt.Errorf("tag is empty: %w", ErrInvalidSearchValue)
+		}
+		for _, r := range tag {
+			switch {
+			case r == '"' || r == '\\' || r == '(' || r == ')' || r == ',':
+			case unicode.IsSpace(r) || unicode.IsControl(r):
+			default:
+				continue
+			}
+			return fmt.Errorf("tag (%q) contains %q: %w", tag, r, ErrInvalidSearchValue)
+		}
+	}
+	return nil
+}
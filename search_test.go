@@ -0,0 +1,131 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"errors"
+	"io"
+	"log"
+	"testing"
+
+	"github.com/circonus-labs/go-apiclient"
+)
+
+func TestCheckSearchCriteria(t *testing.T) {
+	tests := []struct {
+		name      string
+		checkType string
+		target    string
+		want      apiclient.SearchQueryType
+		tags      apiclient.TagType
+		wantErr   bool
+	}{
+		{
+			name:      "valid",
+			checkType: "httptrap",
+			target:    "web01",
+			tags:      apiclient.TagType{"service:foo"},
+			want:      `(active:1)(type:"httptrap")(target:"web01")(tags:service:foo)`,
+		},
+		{
+			name:      "valid, colons spaces parens unicode in target",
+			checkType: "httptrap:cua:host:linux",
+			target:    "web 01 (prod):ünïcødé",
+			tags:      apiclient.TagType{"service:foo", "env:prod", "région:東京"},
+			want:      `(active:1)(type:"httptrap:cua:host:linux")(target:"web 01 (prod):ünïcødé")(tags:service:foo,env:prod,région:東京)`,
+		},
+		{name: "invalid, quote in target", checkType: "httptrap", target: `web"01`, wantErr: true},
+		{name: "invalid, backslash in target", checkType: "httptrap", target: `web\01`, wantErr: true},
+		{name: "invalid, newline in target", checkType: "httptrap", target: "web\n01", wantErr: true},
+		{name: "invalid, quote in type", checkType: `httptrap"`, target: "web01", wantErr: true},
+		{name: "invalid, space in tag", checkType: "httptrap", target: "web01", tags: apiclient.TagType{"service:foo bar"}, wantErr: true},
+		{name: "invalid, paren in tag", checkType: "httptrap", target: "web01", tags: apiclient.TagType{"service:foo)"}, wantErr: true},
+		{name: "invalid, quote in tag", checkType: "httptrap", target: "web01", tags: apiclient.TagType{`service:"foo"`}, wantErr: true},
+		{name: "invalid, comma in tag", checkType: "httptrap", target: "web01", tags: apiclient.TagType{"service:foo,bar"}, wantErr: true},
+		{name: "invalid, empty tag", checkType: "httptrap", target: "web01", tags: apiclient.TagType{""}, wantErr: true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := checkSearchCriteria(tt.checkType, tt.target, tt.tags)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("checkSearchCriteria() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidSearchValue) {
+					t.Errorf("checkSearchCriteria() error = %v, want %v", err, ErrInvalidSearchValue)
+				}
+				return
+			}
+			if got != tt.want {
+				t.Errorf("checkSearchCriteria() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTrapCheck_findCheckBundle_invalidSearchValue(t *testing.T) {
+	tc := &TrapCheck{
+		client: &APIMock{}, // SearchCheckBundlesFunc nil, panics if the api is called
+		Log:    &LogWrapper{Log: log.New(io.Discard, "", log.LstdFlags)},
+	}
+	_, err := tc.findCheckBundle(&apiclient.CheckBundle{Type: "httptrap", Target: `web"01`})
+	if !errors.Is(err, ErrInvalidSearchValue) {
+		t.Errorf("findCheckBundle() error = %v, want %v", err, ErrInvalidSearchValue)
+	}
+}
+
+func TestConfig_Validate_searchTags(t *testing.T) {
+	if err := (&Config{CheckSearchTags: apiclient.TagType{"service:a b"}}).Validate(); !errors.Is(err, ErrInvalidSearchValue) {
+		t.Errorf("Validate() check search tags error = %v, want %v", err, ErrInvalidSearchValue)
+	}
+	if err := (&Config{BrokerSelectTags: apiclient.TagType{"(cn:foo)"}}).Validate(); !errors.Is(err, ErrInvalidSearchValue) {
+		t.Errorf("Validate() broker select tags error = %v, want %v", err, ErrInvalidSearchValue)
+	}
+}
+
+func TestTrapCheck_findCheckBundle_customCriteria(t *testing.T) {
+	legacy := apiclient.CheckBundle{
+		CID:    "/check_bundle/123",
+		Type:   "httptrap",
+		Target: "old-hostname",
+		Notes:  func() *string { n := "tcid:abc-123"; return &n }(),
+	}
+	client := &APIMock{
+		SearchCheckBundlesFunc: func(searchCriteria *apiclient.SearchQueryType, filterCriteria *apiclient.SearchFilterType) (*[]apiclient.CheckBundle, error) {
+			if *searchCriteria == `(active:1)(notes:"tcid:abc-123")` {
+				return &[]apiclient.CheckBundle{legacy}, nil
+			}
+			return &[]apiclient.CheckBundle{}, nil
+		},
+	}
+
+	cfg := &apiclient.CheckBundle{Type: "httptrap", Target: "new-hostname"}
+
+	tc := &TrapCheck{
+		client: client,
+		Log:    &LogWrapper{Log: log.New(io.Discard, "", log.LstdFlags)},
+	}
+	found, err := tc.findCheckBundle(cfg)
+	if err != nil {
+		t.Fatalf("findCheckBundle() default error = %v", err)
+	}
+	if found {
+		t.Fatalf("findCheckBundle() default criteria found bundle, want not found")
+	}
+
+	tc.checkSearchCriteria = `(active:1)(notes:"tcid:abc-123")`
+	found, err = tc.findCheckBundle(cfg)
+	if err != nil {
+		t.Fatalf("findCheckBundle() custom error = %v", err)
+	}
+	if !found {
+		t.Fatalf("findCheckBundle() custom criteria did not find bundle")
+	}
+	if tc.checkBundle.CID != legacy.CID {
+		t.Errorf("findCheckBundle() bundle = %s, want %s", tc.checkBundle.CID, legacy.CID)
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/circonus-labs/go-apiclient"
+	"github.com/circonus-labs/go-apiclient/config"
+	"github.com/google/uuid"
+)
+
+// minCheckSecretLen minimum length of a caller supplied check secret (Config.CheckSecret).
+const minCheckSecretLen = 16
+
+// secretRandReader source of randomness for generated check secrets.
+var secretRandReader = rand.Reader
+
+// validateCheckSecret verifies a caller supplied check secret is long enough
+// and only uses characters which are safe in the submission url path.
+func validateCheckSecret(secret string) error {
+	if len(secret) < minCheckSecretLen {
+		return fmt.Errorf("invalid check secret, must be at least %d characters", minCheckSecretLen)
+	}
+	for _, c := range secret {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_', c == '.', c == '~':
+		default:
+			return fmt.Errorf("invalid check secret, character %q not allowed (a-z, A-Z, 0-9, '-', '_', '.', '~')", c)
+		}
+	}
+	return nil
+}
+
+// RotateCheckSecret generates a new check secret, updates the check bundle
+// and refreshes it so subsequent submissions use the new submission url.
+// If the refresh fails, the new secret is applied to the submission url
+// locally. Not available when a custom submission url is in use.
+func (tc *TrapCheck) RotateCheckSecret(ctx context.Context) (*apiclient.CheckBundle, error) {
+	if tc.client == nil {
+		return nil, fmt.Errorf("rotating check secret: %w", ErrNoAPIClient)
+	}
+	tc.stateMu.RLock()
+	custom, current := tc.custSubmissionURL != "", tc.checkBundle
+	tc.stateMu.RUnlock()
+	if custom {
+		return nil, fmt.Errorf("rotating check secret: custom submission url in use")
+	}
+	if current == nil {
+		return nil, fmt.Errorf("invalid state, check bundle is nil")
+	}
+
+	secret, err := makeSecret()
+	if err != nil {
+		return nil, fmt.Errorf("rotating check secret: %w", err)
+	}
+
+	bundle := *current
+	bundle.Config = make(apiclient.CheckBundleConfig, len(current.Config)+1)
+	for k, v := range current.Config {
+		bundle.Config[k] = v
+	}
+	bundle.Config[config.Secret] = secret
+
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("rotating check secret: %w", err)
+	}
+	if _, err := tc.client.UpdateCheckBundle(&bundle); err != nil {
+		return nil, fmt.Errorf("api updating check bundle secret: %w", err)
+	}
+
+	if _, err := tc.refreshCheck(RefreshReasonSecretRotation); err != nil {
+		// the bundle was updated, the old secret is no longer valid
+		tc.stateMu.Lock()
+		tc.applyCheckSecretLocked(secret)
+		tc.unlockState()
+		return nil, fmt.Errorf("refreshing check after secret rotation: %w", err)
+	}
+	tc.stateMu.RLock()
+	defer tc.stateMu.RUnlock()
+	if !strings.HasSuffix(strings.TrimSuffix(tc.submissionURL, "/"), "/"+secret) {
+		return nil, fmt.Errorf("refreshed submission url does not contain the new secret")
+	}
+
+	updated := *tc.checkBundle
+	return &updated, nil
+}
+
+// applyCheckSecretLocked sets the secret in the check bundle and submission
+// url locally, when the check could not be refreshed after a secret rotation.
+// stateMu must be held.
+func (tc *TrapCheck) applyCheckSecretLocked(secret string) {
+	if tc.checkBundle != nil {
+		bundle := *tc.checkBundle
+		bundle.Config = make(apiclient.CheckBundleConfig, len(tc.checkBundle.Config)+1)
+		for k, v := range tc.checkBundle.Config {
+			bundle.Config[k] = v
+		}
+		bundle.Config[config.Secret] = secret
+		if surl, ok := bundle.Config[config.SubmissionURL]; ok {
+			bundle.Config[config.SubmissionURL] = withCheckSecret(surl, secret)
+		}
+		tc.checkBundle = &bundle
+	}
+	tc.submissionURL = withCheckSecret(tc.submissionURL, secret)
+}
+
+// withCheckSecret returns the submission url with the secret path segment
+// (following the check uuid) replaced.
+func withCheckSecret(submissionURL, secret string) string {
+	u, err := url.Parse(submissionURL)
+	if err != nil {
+		return submissionURL
+	}
+	segments := strings.Split(u.Path, "/")
+	for i := 0; i < len(segments)-1; i++ {
+		if _, err := uuid.Parse(segments[i]); err == nil {
+			segments[i+1] = secret
+			u.Path = strings.Join(segments, "/")
+			return u.String()
+		}
+	}
+	return submissionURL
+}
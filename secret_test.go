@@ -0,0 +1,285 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/circonus-labs/go-apiclient"
+	"github.com/circonus-labs/go-apiclient/config"
+)
+
+func TestTrapCheck_RotateCheckSecret(t *testing.T) {
+	var mu sync.Mutex
+	var lastPath string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		lastPath = r.URL.Path
+		mu.Unlock()
+		fmt.Fprintln(w, `{"stats":1}`)
+	}))
+	defer ts.Close()
+
+	const checkUUID = "0c5b9a36-2a2e-4e3c-9c8f-0d1f2a3b4c5d"
+	secret := "oldsecret"
+	newBundle := func() *apiclient.CheckBundle {
+		return &apiclient.CheckBundle{
+			CID:        "/check_bundle/123",
+			Brokers:    []string{"/broker/123"},
+			CheckUUIDs: []string{checkUUID},
+			Type:       "httptrap",
+			Status:     statusActive,
+			Config: apiclient.CheckBundleConfig{
+				config.Secret:        secret,
+				config.SubmissionURL: ts.URL + "/module/httptrap/" + checkUUID + "/" + secret,
+			},
+		}
+	}
+
+	client := &APIMock{
+		FetchCheckBundleFunc: func(cid apiclient.CIDType) (*apiclient.CheckBundle, error) {
+			return newBundle(), nil
+		},
+		UpdateCheckBundleFunc: func(cfg *apiclient.CheckBundle) (*apiclient.CheckBundle, error) {
+			secret = cfg.Config[config.Secret]
+			return newBundle(), nil
+		},
+	}
+
+	bundle := newBundle()
+	tc := &TrapCheck{
+		client:            client,
+		checkBundle:       bundle,
+		submissionURL:     bundle.Config[config.SubmissionURL],
+		submissionTimeout: 5 * time.Second,
+		refreshStats:      map[RefreshReason]uint64{},
+	}
+	tc.Log = &LogWrapper{
+		Log:   log.New(io.Discard, "", log.LstdFlags),
+		Debug: false,
+	}
+
+	oldURL := tc.submissionURL
+
+	updated, err := tc.RotateCheckSecret(context.Background())
+	if err != nil {
+		t.Fatalf("TrapCheck.RotateCheckSecret() error = %v", err)
+	}
+
+	calls := client.UpdateCheckBundleCalls()
+	if len(calls) != 1 {
+		t.Fatalf("UpdateCheckBundle calls = %d, want 1", len(calls))
+	}
+	sentSecret := calls[0].Cfg.Config[config.Secret]
+	if sentSecret == "" || sentSecret == "oldsecret" {
+		t.Fatalf("UpdateCheckBundle secret = %q, want new secret", sentSecret)
+	}
+	if updated.Config[config.Secret] != sentSecret {
+		t.Errorf("returned bundle secret = %q, want %q", updated.Config[config.Secret], sentSecret)
+	}
+	if tc.submissionURL == oldURL || !strings.HasSuffix(tc.submissionURL, "/"+sentSecret) {
+		t.Errorf("submission url = %s, want new secret (old %s)", tc.submissionURL, oldURL)
+	}
+	if tc.RefreshStats()[string(RefreshReasonSecretRotation)] != 1 {
+		t.Errorf("RefreshStats() = %v, want secret-rotation 1", tc.RefreshStats())
+	}
+
+	var metrics bytes.Buffer
+	metrics.WriteString(`{"foo":{"_type":"n","_value":1}}`)
+	if _, err := tc.SendMetrics(context.Background(), metrics); err != nil {
+		t.Fatalf("TrapCheck.SendMetrics() error = %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if !strings.HasSuffix(lastPath, "/"+sentSecret) {
+		t.Errorf("submitted to %s, want new secret", lastPath)
+	}
+}
+
+func TestTrapCheck_RotateCheckSecret_refreshError(t *testing.T) {
+	const checkUUID = "0c5b9a36-2a2e-4e3c-9c8f-0d1f2a3b4c5d"
+	submissionURL := "http://127.0.0.1:43191/module/httptrap/" + checkUUID + "/oldsecret"
+	client := &APIMock{
+		FetchCheckBundleFunc: func(cid apiclient.CIDType) (*apiclient.CheckBundle, error) {
+			return nil, fmt.Errorf("api unavailable")
+		},
+		UpdateCheckBundleFunc: func(cfg *apiclient.CheckBundle) (*apiclient.CheckBundle, error) {
+			return cfg, nil
+		},
+	}
+
+	tc := newTestTrapCheck(submissionURL)
+	tc.client = client
+	tc.checkBundle = &apiclient.CheckBundle{
+		CID:        "/check_bundle/123",
+		CheckUUIDs: []string{checkUUID},
+		Config: apiclient.CheckBundleConfig{
+			config.Secret:        "oldsecret",
+			config.SubmissionURL: submissionURL,
+		},
+	}
+
+	if _, err := tc.RotateCheckSecret(context.Background()); err == nil {
+		t.Fatal("TrapCheck.RotateCheckSecret() expected error")
+	}
+
+	calls := client.UpdateCheckBundleCalls()
+	if len(calls) != 1 {
+		t.Fatalf("UpdateCheckBundle calls = %d, want 1", len(calls))
+	}
+	sentSecret := calls[0].Cfg.Config[config.Secret]
+	want := "http://127.0.0.1:43191/module/httptrap/" + checkUUID + "/" + sentSecret
+	if tc.submissionURL != want {
+		t.Errorf("submission url = %s, want %s", tc.submissionURL, want)
+	}
+	if tc.checkBundle.Config[config.Secret] != sentSecret || tc.checkBundle.Config[config.SubmissionURL] != want {
+		t.Errorf("check bundle config = %v, want new secret", tc.checkBundle.Config)
+	}
+}
+
+func TestTrapCheck_RotateCheckSecret_invalid(t *testing.T) {
+	tests := []struct {
+		tc      *TrapCheck
+		wantErr error
+		name    string
+	}{
+		{name: "no api client", tc: &TrapCheck{checkBundle: &apiclient.CheckBundle{}}, wantErr: ErrNoAPIClient},
+		{name: "custom submission url", tc: &TrapCheck{client: &APIMock{}, custSubmissionURL: "http://127.0.0.1:2609/write/foo", checkBundle: &apiclient.CheckBundle{}}},
+		{name: "nil bundle", tc: &TrapCheck{client: &APIMock{}}},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.tc.RotateCheckSecret(context.Background())
+			if err == nil {
+				t.Fatal("TrapCheck.RotateCheckSecret() expected error")
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("TrapCheck.RotateCheckSecret() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+type failingReader struct{}
+
+func (failingReader) Read(p []byte) (int, error) {
+	return 0, fmt.Errorf("entropy unavailable")
+}
+
+func TestTrapCheck_applyCheckBundleDefaults_secret(t *testing.T) {
+	tests := []struct {
+		name        string
+		checkSecret string
+		cfgSecret   string
+		want        string
+		failRand    bool
+		wantErr     bool
+	}{
+		{name: "generated", want: ""},
+		{name: "caller supplied", checkSecret: "0123456789abcdef-_.~", want: "0123456789abcdef-_.~"},
+		{name: "check config secret wins", checkSecret: "0123456789abcdef", cfgSecret: "fromcheckconfig0", want: "fromcheckconfig0"},
+		{name: "rand failure, error (no fallback secret)", failRand: true, wantErr: true},
+		{name: "rand failure, caller supplied", checkSecret: "0123456789abcdef", failRand: true, want: "0123456789abcdef"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.failRand {
+				orig := secretRandReader
+				secretRandReader = failingReader{}
+				defer func() { secretRandReader = orig }()
+			}
+
+			tc := &TrapCheck{checkSecret: tt.checkSecret}
+			tc.Log = &LogWrapper{Log: log.New(io.Discard, "", 0)}
+
+			cfg := &apiclient.CheckBundle{}
+			if tt.cfgSecret != "" {
+				cfg.Config = apiclient.CheckBundleConfig{config.Secret: tt.cfgSecret}
+			}
+			err := tc.applyCheckBundleDefaults(cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("TrapCheck.applyCheckBundleDefaults() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			got := cfg.Config[config.Secret]
+			if tt.want == "" {
+				if len(got) != 16 {
+					t.Errorf("generated secret = %q, want 16 characters", got)
+				}
+				return
+			}
+			if got != tt.want {
+				t.Errorf("secret = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTrapCheck_initCheckBundle_secretError(t *testing.T) {
+	orig := secretRandReader
+	secretRandReader = failingReader{}
+	defer func() { secretRandReader = orig }()
+
+	client := &APIMock{
+		SearchCheckBundlesFunc: func(searchCriteria *apiclient.SearchQueryType, filterCriteria *apiclient.SearchFilterType) (*[]apiclient.CheckBundle, error) {
+			return &[]apiclient.CheckBundle{}, nil
+		},
+		CreateCheckBundleFunc: func(cfg *apiclient.CheckBundle) (*apiclient.CheckBundle, error) {
+			return cfg, nil
+		},
+	}
+	tc := &TrapCheck{client: client}
+	tc.Log = &LogWrapper{Log: log.New(io.Discard, "", 0)}
+
+	err := tc.initCheckBundle(&apiclient.CheckBundle{Brokers: []string{"/broker/123"}})
+	if err == nil || !strings.Contains(err.Error(), "entropy unavailable") {
+		t.Fatalf("TrapCheck.initCheckBundle() error = %v, want secret generation error", err)
+	}
+	if n := len(client.CreateCheckBundleCalls()); n != 0 {
+		t.Errorf("CreateCheckBundle calls = %d, want 0", n)
+	}
+}
+
+func TestValidateCheckSecret(t *testing.T) {
+	tests := []struct {
+		name    string
+		secret  string
+		wantErr bool
+	}{
+		{name: "valid", secret: "0123456789abcdef"},
+		{name: "valid, url safe punctuation", secret: "Abc-def_ghi.jkl~mno"},
+		{name: "invalid, short", secret: "myS3cr3t", wantErr: true},
+		{name: "invalid, slash", secret: "0123456789abcdef/", wantErr: true},
+		{name: "invalid, space", secret: "0123456789 abcdef", wantErr: true},
+		{name: "invalid, non-ascii", secret: "0123456789abcdeé", wantErr: true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateCheckSecret(tt.secret); (err != nil) != tt.wantErr {
+				t.Errorf("validateCheckSecret() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err := (&Config{CheckSecret: tt.secret}).Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Config.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// Span names used with Config.TraceProvider.
+const (
+	SpanInitialize         = "trapcheck.initialize"
+	SpanGetBroker          = "trapcheck.get_broker"
+	SpanSetBrokerTLSConfig = "trapcheck.set_broker_tls_config"
+	SpanSubmit             = "trapcheck.submit"
+	SpanSubmitAttempt      = "trapcheck.submit.attempt"
+)
+
+// Span attribute keys used with Config.TraceProvider.
+const (
+	SpanAttrCheckCID   = "check_cid"
+	SpanAttrBrokerCID  = "broker_cid"
+	SpanAttrBytesSent  = "bytes_sent"
+	SpanAttrStatusCode = "status_code"
+	SpanAttrRetries    = "retries"
+	SpanAttrAttempt    = "attempt"
+	SpanAttrError      = "error"
+)
+
+// Tracer starts spans around check initialization and metric submission, e.g.
+// an adapter for an OpenTelemetry tracer (see README). Set Config.TraceProvider
+// to enable spans.
+type Tracer interface {
+	StartSpan(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is a span started by a Tracer.
+type Span interface {
+	SetAttributes(attrs ...SpanAttribute)
+	End()
+}
+
+// SpanAttribute is a span attribute, Value is a string or an int.
+type SpanAttribute struct {
+	Value interface{}
+	Key   string
+}
+
+// span wraps a started Span, a nil span (no Tracer) does nothing so
+// instrumentation costs nothing unless Config.TraceProvider is set.
+type span struct {
+	s Span
+}
+
+// startSpan starts a span if a tracer is configured.
+func (tc *TrapCheck) startSpan(ctx context.Context, name string) (context.Context, *span) {
+	if tc.tracer == nil {
+		return ctx, nil
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	sctx, s := tc.tracer.StartSpan(ctx, name)
+	if s == nil {
+		return ctx, nil
+	}
+	if sctx == nil {
+		sctx = ctx
+	}
+	return sctx, &span{s: s}
+}
+
+// spanParent returns the parent context for initialization spans, which are
+// started without a caller context.
+func (tc *TrapCheck) spanParent() context.Context {
+	if tc.initSpanCtx != nil {
+		return tc.initSpanCtx
+	}
+	return context.Background()
+}
+
+func (s *span) setString(key, value string) {
+	if s == nil || value == "" {
+		return
+	}
+	s.s.SetAttributes(SpanAttribute{Key: key, Value: value})
+}
+
+func (s *span) setInt(key string, value int) {
+	if s == nil {
+		return
+	}
+	s.s.SetAttributes(SpanAttribute{Key: key, Value: value})
+}
+
+func (s *span) setError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.s.SetAttributes(SpanAttribute{Key: SpanAttrError, Value: err.Error()})
+}
+
+func (s *span) end() {
+	if s == nil {
+		return
+	}
+	s.s.End()
+}
+
+// endSubmitSpan records the submission outcome and ends the submit span.
+func (tc *TrapCheck) endSubmitSpan(s *span, result *TrapResult, err error) {
+	if s == nil {
+		return
+	}
+	tc.stateMu.RLock()
+	if tc.checkBundle != nil {
+		s.setString(SpanAttrCheckCID, tc.checkBundle.CID)
+	}
+	s.setString(SpanAttrBrokerCID, tc.brokerCID())
+	tc.stateMu.RUnlock()
+	if result != nil {
+		s.setInt(SpanAttrBytesSent, result.BytesSentGzip)
+		if n := len(result.Attempts); n > 0 {
+			s.setInt(SpanAttrStatusCode, result.Attempts[n-1].StatusCode)
+			s.setInt(SpanAttrRetries, n-1)
+		}
+	}
+	var stErr *statusError
+	if errors.As(err, &stErr) {
+		s.setInt(SpanAttrStatusCode, stErr.code)
+	}
+	s.setError(err)
+	s.end()
+}
+
+// spanTransport starts a span for each submission attempt.
+type spanTransport struct {
+	next    http.RoundTripper
+	tc      *TrapCheck
+	attempt int
+}
+
+func (st *spanTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, s := st.tc.startSpan(req.Context(), SpanSubmitAttempt)
+	defer s.end()
+
+	s.setInt(SpanAttrAttempt, st.attempt)
+	st.attempt++
+	if req.ContentLength > 0 {
+		s.setInt(SpanAttrBytesSent, int(req.ContentLength))
+	}
+	resp, err := st.next.RoundTrip(req.WithContext(ctx))
+	if resp != nil {
+		s.setInt(SpanAttrStatusCode, resp.StatusCode)
+	}
+	s.setError(err)
+	return resp, err //nolint:wrapcheck
+}
@@ -0,0 +1,199 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/circonus-labs/go-apiclient"
+	"github.com/circonus-labs/go-trapcheck/trapchecktest"
+)
+
+type spanKey struct{}
+
+// recordedSpan is a span recorded by recordingTracer.
+type recordedSpan struct {
+	attrs  map[string]interface{}
+	name   string
+	parent string
+	ended  bool
+}
+
+// recordingTracer records spans (and their parent) in start order.
+type recordingTracer struct {
+	spans []*recordedSpan
+	mu    sync.Mutex
+}
+
+func (rt *recordingTracer) StartSpan(ctx context.Context, name string) (context.Context, Span) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	s := &recordedSpan{name: name, attrs: make(map[string]interface{})}
+	if parent, ok := ctx.Value(spanKey{}).(*recordedSpan); ok {
+		s.parent = parent.name
+	}
+	rt.spans = append(rt.spans, s)
+	return context.WithValue(ctx, spanKey{}, s), &recordingSpan{rt: rt, s: s}
+}
+
+func (rt *recordingTracer) find(name string) []*recordedSpan {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	var found []*recordedSpan
+	for _, s := range rt.spans {
+		if s.name == name {
+			found = append(found, s)
+		}
+	}
+	return found
+}
+
+type recordingSpan struct {
+	rt *recordingTracer
+	s  *recordedSpan
+}
+
+func (rs *recordingSpan) SetAttributes(attrs ...SpanAttribute) {
+	rs.rt.mu.Lock()
+	defer rs.rt.mu.Unlock()
+	for _, a := range attrs {
+		rs.s.attrs[a.Key] = a.Value
+	}
+}
+
+func (rs *recordingSpan) End() {
+	rs.rt.mu.Lock()
+	defer rs.rt.mu.Unlock()
+	rs.s.ended = true
+}
+
+func TestTrapCheck_spans(t *testing.T) {
+	fb := trapchecktest.NewFakeTLSBroker(t, "broker.example.com")
+	api := trapchecktest.NewFakeAPI(fb)
+	logger := &LogWrapper{Log: log.New(io.Discard, "", log.LstdFlags)}
+	initTestBrokerList(t, api, logger) // broker list is shared, use the fake api brokers
+
+	tracer := &recordingTracer{}
+	tc, err := New(&Config{
+		Client:        api,
+		CheckConfig:   &apiclient.CheckBundle{Target: "spans"},
+		TraceProvider: tracer,
+		Logger:        logger,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	bundle, err := tc.GetCheckBundle()
+	if err != nil {
+		t.Fatalf("GetCheckBundle() error = %v", err)
+	}
+
+	fb.QueueResponse(http.StatusServiceUnavailable, "busy")
+	var metrics bytes.Buffer
+	metrics.WriteString(`{"foo":{"_type":"n","_value":1}}`)
+	if _, err := tc.SendMetrics(context.Background(), metrics); err != nil {
+		t.Fatalf("SendMetrics() error = %v", err)
+	}
+
+	tests := []struct {
+		attrs  map[string]interface{}
+		name   string
+		parent string
+		count  int
+	}{
+		{name: SpanInitialize, count: 1, attrs: map[string]interface{}{SpanAttrCheckCID: bundle.CID}},
+		{name: SpanGetBroker, count: 1, parent: SpanInitialize, attrs: map[string]interface{}{SpanAttrBrokerCID: "/broker/1"}},
+		{name: SpanSetBrokerTLSConfig, count: 1, attrs: map[string]interface{}{SpanAttrBrokerCID: "/broker/1"}},
+		{
+			name:  SpanSubmit,
+			count: 1,
+			attrs: map[string]interface{}{
+				SpanAttrCheckCID:   bundle.CID,
+				SpanAttrBrokerCID:  "/broker/1",
+				SpanAttrBytesSent:  metrics.Len(),
+				SpanAttrStatusCode: http.StatusOK,
+				SpanAttrRetries:    1,
+			},
+		},
+		{name: SpanSubmitAttempt, count: 2, parent: SpanSubmit},
+	}
+	for _, tt := range tests {
+		spans := tracer.find(tt.name)
+		if len(spans) != tt.count {
+			t.Errorf("%s spans = %d, want %d", tt.name, len(spans), tt.count)
+			continue
+		}
+		for _, s := range spans {
+			if !s.ended {
+				t.Errorf("%s span not ended", tt.name)
+			}
+			if s.parent != tt.parent {
+				t.Errorf("%s span parent = %q, want %q", tt.name, s.parent, tt.parent)
+			}
+			for k, v := range tt.attrs {
+				if s.attrs[k] != v {
+					t.Errorf("%s span attribute %s = %v, want %v", tt.name, k, s.attrs[k], v)
+				}
+			}
+			if e, ok := s.attrs[SpanAttrError]; ok {
+				t.Errorf("%s span error = %v", tt.name, e)
+			}
+		}
+	}
+
+	attempts := tracer.find(SpanSubmitAttempt)
+	if len(attempts) == 2 {
+		if attempts[0].attrs[SpanAttrAttempt] != 0 || attempts[0].attrs[SpanAttrStatusCode] != http.StatusServiceUnavailable {
+			t.Errorf("first attempt span attributes = %v", attempts[0].attrs)
+		}
+		if attempts[1].attrs[SpanAttrAttempt] != 1 || attempts[1].attrs[SpanAttrStatusCode] != http.StatusOK {
+			t.Errorf("second attempt span attributes = %v", attempts[1].attrs)
+		}
+	}
+}
+
+func TestTrapCheck_spansDisabled(t *testing.T) {
+	fb := trapchecktest.NewFakeBroker(t)
+	submissionURL := fb.SubmissionURL("abc-123", "secret")
+	tc := &TrapCheck{
+		checkBundle:       &apiclient.CheckBundle{},
+		custSubmissionURL: submissionURL,
+		submissionURL:     submissionURL,
+		submissionTimeout: 5 * time.Second,
+	}
+	tc.Log = &LogWrapper{Log: log.New(io.Discard, "", log.LstdFlags)}
+
+	ctx, s := tc.startSpan(context.Background(), SpanSubmit)
+	if s != nil || ctx != context.Background() {
+		t.Fatalf("startSpan() without tracer = %v, want nil span and the same context", s)
+	}
+	// nil span methods are no-ops
+	s.setString(SpanAttrCheckCID, "/check_bundle/1")
+	s.setInt(SpanAttrBytesSent, 1)
+	s.end()
+
+	allocs := testing.AllocsPerRun(100, func() {
+		_, s := tc.startSpan(ctx, SpanSubmit)
+		s.setInt(SpanAttrStatusCode, http.StatusOK)
+		tc.endSubmitSpan(s, nil, nil)
+	})
+	if allocs != 0 {
+		t.Errorf("span instrumentation without tracer allocates %.0f times, want 0", allocs)
+	}
+
+	var metrics bytes.Buffer
+	metrics.WriteString(`{"foo":{"_type":"n","_value":1}}`)
+	if _, err := tc.SendMetrics(context.Background(), metrics); err != nil {
+		t.Fatalf("SendMetrics() error = %v", err)
+	}
+}
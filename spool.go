@@ -0,0 +1,254 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// ErrSpooled is returned (wrapped, with a nil TrapResult) by SendMetrics when the
+// submission failed and the metrics were queued for resubmission (Config.SpoolMaxBytes).
+var ErrSpooled = errors.New("submission failed, metrics spooled")
+
+const (
+	defaultSpoolMaxAge = "10m"
+)
+
+// SpoolStats describes the state of the submission spool.
+type SpoolStats struct {
+	Oldest  time.Time // when the oldest queued submission was spooled (zero if empty)
+	Entries int       // submissions currently queued
+	Bytes   int64     // bytes currently queued
+	Spooled uint64    // total submissions queued
+	Drained uint64    // total queued submissions successfully resubmitted
+	Dropped uint64    // total queued submissions dropped (size/age caps or rejected by the broker)
+}
+
+type spoolEntry struct {
+	queued   time.Time
+	encoding string
+	payload  []byte
+}
+
+// SpoolStats returns the current spool statistics.
+func (tc *TrapCheck) SpoolStats() SpoolStats {
+	tc.spoolMu.Lock()
+	defer tc.spoolMu.Unlock()
+
+	stats := tc.spoolStats
+	stats.Entries = len(tc.spool)
+	stats.Bytes = tc.spoolBytes
+	if len(tc.spool) > 0 {
+		stats.Oldest = tc.spool[0].queued
+	}
+	return stats
+}
+
+// sendMetricsSpooled submits the metrics, draining any spooled submissions
+// first (oldest first). If the broker is unavailable the metrics are spooled
+// and ErrSpooled is returned. Returns ErrCheckDeactivated after DeactivateCheck.
+func (tc *TrapCheck) sendMetricsSpooled(ctx context.Context, metrics bytes.Buffer, encoding string) (*TrapResult, error) {
+	if tc.checkDeactivated() {
+		return nil, ErrCheckDeactivated
+	}
+
+	if tc.spoolMaxBytes == 0 {
+		return tc.sendMetrics(ctx, metrics, encoding)
+	}
+
+	if err := tc.drainSpool(ctx); err != nil {
+		return nil, tc.spoolSubmission(metrics.Bytes(), encoding, err)
+	}
+
+	result, err := tc.sendMetrics(ctx, metrics, encoding)
+	if err != nil && spoolable(err) {
+		return nil, tc.spoolSubmission(metrics.Bytes(), encoding, err)
+	}
+	return result, err
+}
+
+// spoolCircuitOpen spools a submission rejected by the open circuit breaker,
+// the returned error matches ErrSpooled and wraps the *CircuitOpenError.
+// Returns circuitErr when spooling is disabled.
+func (tc *TrapCheck) spoolCircuitOpen(payload []byte, encoding string, circuitErr error) error {
+	if tc.spoolMaxBytes == 0 || tc.checkDeactivated() {
+		return circuitErr
+	}
+	return tc.spoolSubmission(payload, encoding, circuitErr)
+}
+
+// spoolable returns true if the submission failed before the broker accepted
+// the payload in a way which may succeed later: the broker could not be
+// reached (connect failure, or the deadline passed before the request was
+// sent), it responded 5xx or it rate limited the submission. Anything else,
+// e.g. errors after the broker accepted the payload (an unreadable or too
+// large response) or permanent tls errors, is not spooled.
+func spoolable(err error) bool {
+	var unsent *unsentError
+	var stErr *statusError
+	switch {
+	case errors.As(err, &unsent), errors.Is(err, ErrRateLimited):
+		return true
+	case errors.As(err, &stErr):
+		return stErr.code == http.StatusTooManyRequests || stErr.code >= 500
+	}
+	return false
+}
+
+// spoolSubmission queues a failed submission, dropping the oldest entries to
+// stay within the byte and age caps. Returns an error matching ErrSpooled
+// which wraps the submission error, or the submission error if the payload
+// cannot be spooled.
+func (tc *TrapCheck) spoolSubmission(payload []byte, encoding string, submitErr error) error {
+	if int64(len(payload)) > tc.spoolMaxBytes {
+		tc.logger().Warnf("spool: payload (%d bytes) exceeds spool max bytes (%d), not spooled", len(payload), tc.spoolMaxBytes)
+		return submitErr
+	}
+
+	entry := spoolEntry{
+		queued:   tc.clock().Now(),
+		encoding: encoding,
+		payload:  append([]byte(nil), payload...),
+	}
+
+	tc.spoolMu.Lock()
+	defer tc.spoolMu.Unlock()
+
+	tc.expireSpoolLocked()
+	tc.spool = append(tc.spool, entry)
+	tc.spoolBytes += int64(len(entry.payload))
+	tc.spoolStats.Spooled++
+	for tc.spoolBytes > tc.spoolMaxBytes {
+		tc.logger().Warnf("spool: max bytes (%d) exceeded, dropping submission spooled %s", tc.spoolMaxBytes, tc.spool[0].queued.Format(time.RFC3339))
+		tc.dropSpoolHeadLocked()
+	}
+
+	return &spooledError{err: submitErr, queued: len(tc.spool)}
+}
+
+// spooledError is returned for a spooled submission, it matches ErrSpooled
+// and wraps the submission error.
+type spooledError struct {
+	err    error
+	queued int
+}
+
+func (e *spooledError) Error() string {
+	return fmt.Sprintf("%s (%d queued): %s", ErrSpooled, e.queued, e.err)
+}
+
+func (e *spooledError) Is(target error) bool {
+	return target == ErrSpooled
+}
+
+func (e *spooledError) Unwrap() error {
+	return e.err
+}
+
+// expireSpoolLocked drops entries older than the spool max age, spoolMu must be held.
+func (tc *TrapCheck) expireSpoolLocked() {
+	for len(tc.spool) > 0 && tc.clock().Since(tc.spool[0].queued) > tc.spoolMaxAge {
+		tc.logger().Warnf("spool: max age (%s) exceeded, dropping submission spooled %s", tc.spoolMaxAge, tc.spool[0].queued.Format(time.RFC3339))
+		tc.dropSpoolHeadLocked()
+	}
+}
+
+// dropSpoolHeadLocked removes the oldest entry, spoolMu must be held.
+func (tc *TrapCheck) dropSpoolHeadLocked() {
+	tc.spoolBytes -= int64(len(tc.spool[0].payload))
+	tc.spool[0] = spoolEntry{}
+	tc.spool = tc.spool[1:]
+	tc.spoolStats.Dropped++
+}
+
+// nextSpoolEntry removes and returns the oldest unexpired entry, returns
+// false if the spool is empty.
+func (tc *TrapCheck) nextSpoolEntry() (spoolEntry, bool) {
+	tc.spoolMu.Lock()
+	defer tc.spoolMu.Unlock()
+
+	tc.expireSpoolLocked()
+	if len(tc.spool) == 0 {
+		return spoolEntry{}, false
+	}
+	entry := tc.spool[0]
+	tc.spool[0] = spoolEntry{}
+	tc.spool = tc.spool[1:]
+	tc.spoolBytes -= int64(len(entry.payload))
+	return entry, true
+}
+
+// drainSpool resubmits spooled submissions, oldest first. Returns the
+// submission error if the broker is still unavailable (the entry is requeued).
+// If another submission is draining the spool, drainSpool waits for it so
+// new submissions are not sent ahead of the spooled ones.
+func (tc *TrapCheck) drainSpool(ctx context.Context) error {
+	tc.spoolMu.Lock()
+	for tc.spoolDrain != nil {
+		draining := tc.spoolDrain
+		tc.spoolMu.Unlock()
+		select {
+		case <-draining:
+		case <-ctx.Done():
+			return fmt.Errorf("waiting for spool drain: %w", ctx.Err())
+		}
+		tc.spoolMu.Lock()
+	}
+	tc.spoolDrain = make(chan struct{})
+	tc.spoolMu.Unlock()
+
+	defer func() {
+		tc.spoolMu.Lock()
+		close(tc.spoolDrain)
+		tc.spoolDrain = nil
+		tc.spoolMu.Unlock()
+	}()
+
+	for {
+		entry, ok := tc.nextSpoolEntry()
+		if !ok {
+			return nil
+		}
+
+		_, err := tc.sendMetrics(ctx, *bytes.NewBuffer(entry.payload), entry.encoding)
+
+		tc.spoolMu.Lock()
+		switch {
+		case err == nil:
+			tc.spoolStats.Drained++
+		case spoolable(err):
+			tc.spool = append([]spoolEntry{entry}, tc.spool...)
+			tc.spoolBytes += int64(len(entry.payload))
+			tc.spoolMu.Unlock()
+			return err
+		default:
+			tc.logger().Warnf("spool: dropping submission spooled %s: %s", entry.queued.Format(time.RFC3339), err)
+			tc.spoolStats.Dropped++
+		}
+		tc.spoolMu.Unlock()
+	}
+}
+
+// flushSpool drains the spool (Config.SpoolFlushOnClose), anything which
+// cannot be submitted is dropped.
+func (tc *TrapCheck) flushSpool() {
+	if tc.spoolMaxBytes == 0 {
+		return
+	}
+	if err := tc.drainSpool(context.Background()); err != nil {
+		tc.spoolMu.Lock()
+		defer tc.spoolMu.Unlock()
+		tc.logger().Warnf("spool: flush on close failed, dropping %d submission(s): %s", len(tc.spool), err)
+		for len(tc.spool) > 0 {
+			tc.dropSpoolHeadLocked()
+		}
+	}
+}
@@ -0,0 +1,442 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// spoolTestBroker simulates a broker outage, while down submissions fail with
+// status, otherwise the bodies of accepted submissions are recorded in order.
+type spoolTestBroker struct {
+	accepted []string
+	status   int32
+	mu       sync.Mutex
+}
+
+func (b *spoolTestBroker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	if status := int(atomic.LoadInt32(&b.status)); status != 0 {
+		w.WriteHeader(status)
+		return
+	}
+	b.mu.Lock()
+	b.accepted = append(b.accepted, string(body))
+	b.mu.Unlock()
+	fmt.Fprintln(w, `{"stats":1}`)
+}
+
+func (b *spoolTestBroker) setStatus(status int) {
+	atomic.StoreInt32(&b.status, int32(status))
+}
+
+func (b *spoolTestBroker) bodies() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]string(nil), b.accepted...)
+}
+
+func newSpoolTestTrapCheck(t *testing.T, url string, maxBytes int64, maxAge time.Duration) *TrapCheck {
+	t.Helper()
+	tc := newTestTrapCheck(url)
+	tc.custSubmissionURL = url
+	tc.submissionTimeout = 250 * time.Millisecond
+	tc.spoolMaxBytes = maxBytes
+	tc.spoolMaxAge = maxAge
+	return tc
+}
+
+func spoolTestMetrics(n int) bytes.Buffer {
+	var metrics bytes.Buffer
+	fmt.Fprintf(&metrics, `{"m%d":{"_type":"n","_value":%d}}`, n, n)
+	return metrics
+}
+
+func TestTrapCheck_SendMetricsSpoolOutage(t *testing.T) {
+	broker := &spoolTestBroker{}
+	ts := httptest.NewServer(broker)
+	defer ts.Close()
+
+	tc := newSpoolTestTrapCheck(t, ts.URL+"/write/test", 1<<20, time.Minute)
+
+	// outage window, every submission is spooled
+	broker.setStatus(http.StatusServiceUnavailable)
+	for i := 1; i <= 3; i++ {
+		result, err := tc.SendMetrics(context.Background(), spoolTestMetrics(i))
+		if !errors.Is(err, ErrSpooled) {
+			t.Fatalf("SendMetrics(%d) error = %v, want %v", i, err, ErrSpooled)
+		}
+		if result != nil {
+			t.Fatalf("SendMetrics(%d) result = %v, want nil", i, result)
+		}
+	}
+	stats := tc.SpoolStats()
+	if stats.Entries != 3 || stats.Spooled != 3 || stats.Oldest.IsZero() {
+		t.Fatalf("SpoolStats() = %+v, want 3 entries", stats)
+	}
+	if len(broker.bodies()) != 0 {
+		t.Fatalf("accepted = %v, want none", broker.bodies())
+	}
+
+	// recovery, spool drained oldest first then the new submission
+	broker.setStatus(0)
+	result, err := tc.SendMetrics(context.Background(), spoolTestMetrics(4))
+	if err != nil {
+		t.Fatalf("SendMetrics() unexpected error: %s", err)
+	}
+	if result == nil || result.Stats != 1 {
+		t.Fatalf("SendMetrics() result = %v", result)
+	}
+
+	got := broker.bodies()
+	if len(got) != 4 {
+		t.Fatalf("accepted %d submissions, want 4: %v", len(got), got)
+	}
+	for i, body := range got {
+		want := spoolTestMetrics(i + 1)
+		if body != want.String() {
+			t.Errorf("submission %d = %s, want %s", i, body, want.String())
+		}
+	}
+
+	stats = tc.SpoolStats()
+	if stats.Entries != 0 || stats.Bytes != 0 || stats.Drained != 3 || stats.Dropped != 0 || !stats.Oldest.IsZero() {
+		t.Errorf("SpoolStats() = %+v, want empty with 3 drained", stats)
+	}
+}
+
+func TestTrapCheck_SendMetricsSpoolConcurrentDrain(t *testing.T) {
+	broker := &spoolTestBroker{}
+	release := make(chan struct{})
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			<-release // hold the first drained submission
+		}
+		broker.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	tc := newSpoolTestTrapCheck(t, ts.URL+"/write/test", 1<<20, time.Minute)
+	tc.submissionTimeout = 5 * time.Second
+
+	for i := 1; i <= 3; i++ {
+		metrics := spoolTestMetrics(i)
+		_ = tc.spoolSubmission(metrics.Bytes(), "", fmt.Errorf("outage"))
+	}
+
+	// the first submission drains the spool, the second must not be sent
+	// ahead of the spooled submissions
+	var wg sync.WaitGroup
+	for i := 4; i <= 5; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := tc.SendMetrics(context.Background(), spoolTestMetrics(i)); err != nil {
+				t.Errorf("SendMetrics(%d) error = %v", i, err)
+			}
+		}(i)
+		time.Sleep(50 * time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+
+	got := broker.bodies()
+	if len(got) != 5 {
+		t.Fatalf("accepted %d submissions, want 5: %v", len(got), got)
+	}
+	for i, body := range got[:3] {
+		want := spoolTestMetrics(i + 1)
+		if body != want.String() {
+			t.Errorf("submission %d = %s, want %s (spooled submissions first)", i, body, want.String())
+		}
+	}
+}
+
+func TestTrapCheck_SendMetricsSpoolLimits(t *testing.T) {
+	m1 := spoolTestMetrics(1)
+	entrySize := int64(m1.Len())
+
+	t.Run("max bytes drops oldest", func(t *testing.T) {
+		broker := &spoolTestBroker{}
+		ts := httptest.NewServer(broker)
+		defer ts.Close()
+
+		tc := newSpoolTestTrapCheck(t, ts.URL+"/write/test", 2*entrySize, time.Minute)
+		broker.setStatus(http.StatusServiceUnavailable)
+		for i := 1; i <= 3; i++ {
+			if _, err := tc.SendMetrics(context.Background(), spoolTestMetrics(i)); !errors.Is(err, ErrSpooled) {
+				t.Fatalf("SendMetrics(%d) error = %v, want %v", i, err, ErrSpooled)
+			}
+		}
+		stats := tc.SpoolStats()
+		if stats.Entries != 2 || stats.Bytes != 2*entrySize || stats.Dropped != 1 {
+			t.Fatalf("SpoolStats() = %+v, want 2 entries, 1 dropped", stats)
+		}
+
+		broker.setStatus(0)
+		if _, err := tc.SendMetrics(context.Background(), spoolTestMetrics(4)); err != nil {
+			t.Fatalf("SendMetrics() unexpected error: %s", err)
+		}
+		m2, m3, m4 := spoolTestMetrics(2), spoolTestMetrics(3), spoolTestMetrics(4)
+		want := []string{m2.String(), m3.String(), m4.String()}
+		if got := broker.bodies(); fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("accepted = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("max age drops expired", func(t *testing.T) {
+		broker := &spoolTestBroker{}
+		ts := httptest.NewServer(broker)
+		defer ts.Close()
+
+		tc := newSpoolTestTrapCheck(t, ts.URL+"/write/test", 1<<20, 50*time.Millisecond)
+		broker.setStatus(http.StatusServiceUnavailable)
+		if _, err := tc.SendMetrics(context.Background(), spoolTestMetrics(1)); !errors.Is(err, ErrSpooled) {
+			t.Fatalf("SendMetrics() error = %v, want %v", err, ErrSpooled)
+		}
+		time.Sleep(100 * time.Millisecond)
+
+		broker.setStatus(0)
+		if _, err := tc.SendMetrics(context.Background(), spoolTestMetrics(2)); err != nil {
+			t.Fatalf("SendMetrics() unexpected error: %s", err)
+		}
+		m2 := spoolTestMetrics(2)
+		if got := broker.bodies(); len(got) != 1 || got[0] != m2.String() {
+			t.Errorf("accepted = %v, want [%s]", got, m2.String())
+		}
+		if stats := tc.SpoolStats(); stats.Dropped != 1 || stats.Drained != 0 {
+			t.Errorf("SpoolStats() = %+v, want 1 dropped", stats)
+		}
+	})
+
+	t.Run("payload larger than spool", func(t *testing.T) {
+		broker := &spoolTestBroker{}
+		ts := httptest.NewServer(broker)
+		defer ts.Close()
+
+		tc := newSpoolTestTrapCheck(t, ts.URL+"/write/test", entrySize-1, time.Minute)
+		broker.setStatus(http.StatusServiceUnavailable)
+		_, err := tc.SendMetrics(context.Background(), spoolTestMetrics(1))
+		if err == nil || errors.Is(err, ErrSpooled) {
+			t.Fatalf("SendMetrics() error = %v, want submission error", err)
+		}
+		if stats := tc.SpoolStats(); stats.Entries != 0 || stats.Spooled != 0 {
+			t.Errorf("SpoolStats() = %+v, want empty", stats)
+		}
+	})
+
+	t.Run("rejected payload not spooled", func(t *testing.T) {
+		broker := &spoolTestBroker{}
+		ts := httptest.NewServer(broker)
+		defer ts.Close()
+
+		tc := newSpoolTestTrapCheck(t, ts.URL+"/write/test", 1<<20, time.Minute)
+		broker.setStatus(http.StatusNotAcceptable)
+		_, err := tc.SendMetrics(context.Background(), spoolTestMetrics(1))
+		if err == nil || errors.Is(err, ErrSpooled) {
+			t.Fatalf("SendMetrics() error = %v, want submission error", err)
+		}
+		if stats := tc.SpoolStats(); stats.Entries != 0 {
+			t.Errorf("SpoolStats() = %+v, want empty", stats)
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		broker := &spoolTestBroker{}
+		ts := httptest.NewServer(broker)
+		defer ts.Close()
+
+		tc := newSpoolTestTrapCheck(t, ts.URL+"/write/test", 0, time.Minute)
+		broker.setStatus(http.StatusServiceUnavailable)
+		_, err := tc.SendMetrics(context.Background(), spoolTestMetrics(1))
+		if err == nil || errors.Is(err, ErrSpooled) {
+			t.Fatalf("SendMetrics() error = %v, want submission error", err)
+		}
+	})
+}
+
+func TestTrapCheck_SendMetricsSpoolErrors(t *testing.T) {
+	closed := httptest.NewServer(http.NotFoundHandler())
+	refusedURL := closed.URL + "/write/test"
+	closed.Close()
+
+	tests := []struct {
+		handler   http.HandlerFunc
+		name      string
+		url       string
+		wantErr   error
+		wantSpool bool
+	}{
+		{
+			name: "5xx",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusBadGateway)
+			},
+			wantSpool: true,
+		},
+		{
+			name: "429",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusTooManyRequests)
+			},
+			wantSpool: true,
+		},
+		{
+			name:      "connection refused",
+			url:       refusedURL,
+			wantSpool: true,
+		},
+		{
+			name: "response too large",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprintf(w, `{"stats":1,"error":"%s"}`, strings.Repeat("x", 64))
+			},
+			wantErr: ErrResponseTooLarge,
+		},
+		{
+			name: "unparsable response",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, `stats: 1`)
+			},
+		},
+		{
+			name: "response body timeout",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Length", "64")
+				fmt.Fprint(w, `{"stats":`)
+				w.(http.Flusher).Flush()
+				select {
+				case <-r.Context().Done():
+				case <-time.After(500 * time.Millisecond):
+				}
+			},
+			wantErr: context.DeadlineExceeded,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			url := tt.url
+			if tt.handler != nil {
+				ts := httptest.NewServer(tt.handler)
+				defer ts.Close()
+				url = ts.URL + "/write/test"
+			}
+
+			tc := newSpoolTestTrapCheck(t, url, 1<<20, time.Minute)
+			tc.maxResponseBytes = 32
+			_, err := tc.SendMetrics(context.Background(), spoolTestMetrics(1))
+			if err == nil {
+				t.Fatal("SendMetrics() expected error")
+			}
+			if errors.Is(err, ErrSpooled) != tt.wantSpool {
+				t.Fatalf("SendMetrics() error = %v, spooled want %t", err, tt.wantSpool)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("SendMetrics() error = %v, want %v", err, tt.wantErr)
+			}
+			if stats := tc.SpoolStats(); (stats.Entries == 1) != tt.wantSpool {
+				t.Errorf("SpoolStats() = %+v, spooled want %t", stats, tt.wantSpool)
+			}
+		})
+	}
+}
+
+func TestSpoolable(t *testing.T) {
+	dialErr := &url.Error{Op: "Put", URL: "https://broker/write/test", Err: &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}}
+	readErr := &url.Error{Op: "Put", URL: "https://broker/write/test", Err: &net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset by peer")}}
+	inflightErr := &url.Error{Op: "Put", URL: "https://broker/write/test", Err: context.DeadlineExceeded}
+
+	// request errors are returned by the retry client, marked by submitPayload
+	tests := []struct {
+		err     error
+		name    string
+		request bool
+		want    bool
+	}{
+		{name: "dial", err: dialErr, request: true, want: true},
+		{name: "dns", err: &url.Error{Op: "Put", Err: &net.DNSError{Err: "no such host", Name: "broker"}}, request: true, want: true},
+		{name: "deadline waiting to retry", err: context.DeadlineExceeded, request: true, want: true},
+		{name: "5xx", err: &statusError{code: http.StatusServiceUnavailable}, want: true},
+		{name: "429", err: &statusError{code: http.StatusTooManyRequests}, want: true},
+		{name: "rate limited", err: fmt.Errorf("%w: 429 Too Many Requests, retry after 1m exceeds deadline", ErrRateLimited), want: true},
+		{name: "deadline in flight", err: inflightErr, request: true},
+		{name: "connection reset", err: readErr, request: true},
+		{name: "404", err: &statusError{code: http.StatusNotFound}},
+		{name: "406", err: &statusError{code: http.StatusNotAcceptable}},
+		{name: "response too large", err: fmt.Errorf("%w: response truncated at 32 bytes", ErrResponseTooLarge)},
+		{name: "response body timeout", err: fmt.Errorf("reading response body, timed out (1s): %w", context.DeadlineExceeded)},
+		{name: "parse", err: fmt.Errorf("parsing response (x): %w", errors.New("invalid character"))},
+		{name: "strict tls", err: fmt.Errorf("giving up after 1 attempt(s): %w", fmt.Errorf("%w (cn: \"broker\")", ErrStrictTLSNoSAN))},
+		{name: "clock skew", err: fmt.Errorf("giving up after 1 attempt(s): %w", fmt.Errorf("%w (local time: x)", ErrPossibleClockSkew))},
+		{name: "redirected", err: fmt.Errorf("giving up after 1 attempt(s): %w", ErrRedirectedSubmission)},
+		{name: "cert pin", err: ErrCertPinMismatch},
+		{name: "filtered", err: ErrAllMetricsFiltered},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.err
+			if tt.request && requestUnsent(err) {
+				err = fmt.Errorf("making request: %w", &unsentError{err: err})
+			}
+			if got := spoolable(err); got != tt.want {
+				t.Errorf("spoolable(%v) = %t, want %t", err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTrapCheck_CloseFlushesSpool(t *testing.T) {
+	tests := []struct {
+		name         string
+		flush        bool
+		wantAccepted int
+	}{
+		{name: "flush", flush: true, wantAccepted: 2},
+		{name: "no flush", flush: false, wantAccepted: 0},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			broker := &spoolTestBroker{}
+			ts := httptest.NewServer(broker)
+			defer ts.Close()
+
+			tc := newSpoolTestTrapCheck(t, ts.URL+"/write/test", 1<<20, time.Minute)
+			tc.spoolFlushOnClose = tt.flush
+
+			broker.setStatus(http.StatusServiceUnavailable)
+			for i := 1; i <= 2; i++ {
+				if _, err := tc.SendMetrics(context.Background(), spoolTestMetrics(i)); !errors.Is(err, ErrSpooled) {
+					t.Fatalf("SendMetrics(%d) error = %v, want %v", i, err, ErrSpooled)
+				}
+			}
+
+			broker.setStatus(0)
+			if err := tc.Close(); err != nil {
+				t.Fatalf("Close() unexpected error: %s", err)
+			}
+			if got := broker.bodies(); len(got) != tt.wantAccepted {
+				t.Errorf("accepted = %v, want %d", got, tt.wantAccepted)
+			}
+		})
+	}
+}
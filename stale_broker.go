@@ -0,0 +1,69 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/circonus-labs/go-apiclient"
+	"github.com/circonus-labs/go-apiclient/config"
+	brokerList "github.com/circonus-labs/go-trapcheck/internal/broker_list"
+)
+
+// refreshStaleBroker handles a check bundle broker which is not in the broker
+// list (e.g. a cached bundle using a decommissioned broker), the check bundle
+// is fetched by CID and its current brokers and submission url are used. If
+// the check cannot be refreshed, brokerErr is returned (wrapped). stateMu
+// must be held (write lock).
+func (tc *TrapCheck) refreshStaleBroker(brokerErr error) error {
+	if !errors.Is(brokerErr, brokerList.ErrBrokerNotFound) || tc.noStaleBrokerRefresh {
+		return brokerErr
+	}
+	if tc.client == nil {
+		return fmt.Errorf("stale check bundle broker, unable to refresh check (%s): %w", ErrNoAPIClient, brokerErr)
+	}
+	if tc.checkBundle == nil || tc.checkBundle.CID == "" {
+		return fmt.Errorf("stale check bundle broker, unable to refresh check (no check bundle CID): %w", brokerErr)
+	}
+
+	cid := tc.checkBundle.CID
+	prevBroker := tc.brokerCID()
+	tc.logWith(nil).Warnf("check bundle broker %s not found, refreshing check bundle %s", prevBroker, cid)
+
+	bundle, err := tc.client.FetchCheckBundle(apiclient.CIDType(&cid))
+	if err != nil {
+		return fmt.Errorf("stale check bundle broker, fetching check bundle (%s): %s: %w", cid, err, brokerErr)
+	}
+	if bundle == nil || bundle.Status == "deleted" {
+		return fmt.Errorf("stale check bundle broker, check bundle (%s) gone: %w", cid, brokerErr)
+	}
+	surl, ok := bundle.Config[config.SubmissionURL]
+	if !ok {
+		return fmt.Errorf("stale check bundle broker, check bundle (%s) has no submission url: %w", cid, brokerErr)
+	}
+
+	tc.recordRefresh(RefreshReasonStaleBroker)
+	tc.checkBundle = bundle
+	if tc.custSubmissionURL == "" {
+		tc.submissionURL = surl
+	}
+	tc.clearStaleBrokerPin()
+	if tc.brokerList != nil {
+		_ = tc.brokerList.RefreshBrokers() // the check may have moved to a new broker
+	}
+
+	if err := tc.fetchCheckBundleBroker(); err != nil {
+		return fmt.Errorf("stale check bundle broker, refreshed check bundle (%s): %s: %w", cid, err, brokerErr)
+	}
+
+	if cur := tc.brokerCID(); cur != prevBroker {
+		tc.logWith(nil).Warnf("check moved to broker %s (was %s)", cur, prevBroker)
+		tc.emitEventLocked(EventBrokerChanged, string(RefreshReasonStaleBroker), prevBroker, cur)
+	}
+	tc.saveCachedBundleLocked()
+	return nil
+}
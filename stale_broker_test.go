@@ -0,0 +1,148 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/circonus-labs/go-apiclient"
+	"github.com/circonus-labs/go-apiclient/config"
+	brokerList "github.com/circonus-labs/go-trapcheck/internal/broker_list"
+	"github.com/circonus-labs/go-trapcheck/trapchecktest"
+)
+
+func TestNewFromCheckBundle_staleBroker(t *testing.T) {
+	fb := trapchecktest.NewFakeTLSBroker(t, "broker.example.com")
+	api := trapchecktest.NewFakeAPI(fb)
+	logger := &LogWrapper{Log: log.New(io.Discard, "", log.LstdFlags)}
+	initTestBrokerList(t, api, logger) // broker list is shared, use the fake api brokers
+
+	current := api.AddCheckBundle(apiclient.CheckBundle{
+		CID:        "/check_bundle/1",
+		Brokers:    []string{"/broker/1"},
+		CheckUUIDs: []string{"abc-123"},
+		Type:       "httptrap",
+		Config:     apiclient.CheckBundleConfig{config.SubmissionURL: fb.SubmissionURL("abc-123", "secret")},
+		Status:     statusActive,
+	})
+
+	disabled := false
+	tests := []struct {
+		refresh   *bool
+		name      string
+		cid       string
+		wantFetch int
+		wantErr   bool
+	}{
+		{name: "refreshed", cid: current.CID, wantFetch: 1},
+		{name: "bundle gone", cid: "/check_bundle/999", wantFetch: 1, wantErr: true},
+		{name: "refresh disabled", cid: current.CID, refresh: &disabled, wantErr: true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			cached := apiclient.CheckBundle{
+				CID:     tt.cid,
+				Brokers: []string{"/broker/9"}, // decommissioned
+				Type:    "httptrap",
+				Config:  apiclient.CheckBundleConfig{config.SubmissionURL: "https://old-broker.example.com:43191/module/httptrap/abc-123/secret"},
+				Status:  statusActive,
+			}
+			fetches := api.Calls("FetchCheckBundle")
+			tc, err := NewFromCheckBundle(&Config{
+				Client:               api,
+				RefreshOnStaleBroker: tt.refresh,
+				Logger:               logger,
+			}, &cached)
+			if n := api.Calls("FetchCheckBundle") - fetches; n != tt.wantFetch {
+				t.Errorf("FetchCheckBundle calls = %d, want %d", n, tt.wantFetch)
+			}
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("NewFromCheckBundle() expected error")
+				}
+				if !errors.Is(err, brokerList.ErrBrokerNotFound) {
+					t.Errorf("NewFromCheckBundle() error = %v, want %v", err, brokerList.ErrBrokerNotFound)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewFromCheckBundle() error = %v", err)
+			}
+
+			if tc.submissionURL != current.Config[config.SubmissionURL] {
+				t.Errorf("submission url = %s, want %s", tc.submissionURL, current.Config[config.SubmissionURL])
+			}
+			if cid := tc.brokerCID(); cid != "/broker/1" {
+				t.Errorf("broker = %s, want /broker/1", cid)
+			}
+			if n := tc.RefreshStats()[string(RefreshReasonStaleBroker)]; n != 1 {
+				t.Errorf("stale broker refreshes = %d, want 1", n)
+			}
+
+			fb.Reset()
+			var metrics bytes.Buffer
+			metrics.WriteString(`{"foo":{"_type":"n","_value":1}}`)
+			if _, err := tc.SendMetrics(context.Background(), metrics); err != nil {
+				t.Fatalf("SendMetrics() error = %v", err)
+			}
+			if n := len(fb.Submissions()); n != 1 {
+				t.Errorf("broker submissions = %d, want 1", n)
+			}
+		})
+	}
+}
+
+func TestTrapCheck_refreshStaleBroker(t *testing.T) {
+	notFound := fmt.Errorf("retrieving broker (/broker/9): %w (/broker/9)", brokerList.ErrBrokerNotFound)
+	other := fmt.Errorf("retrieving broker (/broker/9): invalid state, broker list is nil")
+
+	tests := []struct {
+		client    API
+		brokerErr error
+		name      string
+		wantErrIs error
+		wantMsg   string
+	}{
+		{name: "other broker error", brokerErr: other, wantErrIs: other},
+		{name: "no api client", brokerErr: notFound, wantErrIs: brokerList.ErrBrokerNotFound, wantMsg: ErrNoAPIClient.Error()},
+		{
+			name:      "fetch error",
+			brokerErr: notFound,
+			wantErrIs: brokerList.ErrBrokerNotFound,
+			wantMsg:   "oops",
+			client: &APIMock{
+				FetchCheckBundleFunc: func(cid apiclient.CIDType) (*apiclient.CheckBundle, error) {
+					return nil, fmt.Errorf("API response code 500: oops")
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			tc := &TrapCheck{
+				client:      tt.client,
+				checkBundle: &apiclient.CheckBundle{CID: "/check_bundle/1", Brokers: []string{"/broker/9"}},
+			}
+			tc.Log = &LogWrapper{Log: log.New(io.Discard, "", log.LstdFlags)}
+			err := tc.refreshStaleBroker(tt.brokerErr)
+			if !errors.Is(err, tt.wantErrIs) {
+				t.Fatalf("refreshStaleBroker() error = %v, want %v", err, tt.wantErrIs)
+			}
+			if !strings.Contains(err.Error(), tt.wantMsg) {
+				t.Errorf("refreshStaleBroker() error = %v, want %q", err, tt.wantMsg)
+			}
+		})
+	}
+}
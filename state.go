@@ -0,0 +1,102 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/circonus-labs/go-apiclient"
+)
+
+// State contains everything needed to restore a working TrapCheck without
+// making any API calls - can be serialized (e.g. JSON) and cached on disk.
+type State struct {
+	Broker        *apiclient.Broker     `json:"broker,omitempty"`
+	BrokerCID     string                `json:"broker_cid"`
+	CACertPEM     string                `json:"ca_cert_pem,omitempty"`
+	SubmissionURL string                `json:"submission_url"`
+	CheckBundle   apiclient.CheckBundle `json:"check_bundle"`
+}
+
+// ExportState returns the current state of the trap check for caching,
+// use NewFromState to restore it.
+func (tc *TrapCheck) ExportState() (State, error) {
+	tc.stateMu.RLock()
+	defer tc.stateMu.RUnlock()
+	if tc.checkBundle == nil {
+		return State{}, fmt.Errorf("trap check not initialized/created")
+	}
+	if tc.submissionURL == "" {
+		return State{}, fmt.Errorf("invalid state, no submission url")
+	}
+
+	st := State{
+		CheckBundle:   *tc.checkBundle,
+		SubmissionURL: tc.submissionURL,
+		CACertPEM:     string(tc.caCertInUse),
+	}
+	if tc.broker != nil {
+		broker := *tc.broker
+		st.Broker = &broker
+		st.BrokerCID = broker.CID
+	} else if len(tc.checkBundle.Brokers) > 0 {
+		st.BrokerCID = tc.checkBundle.Brokers[0]
+	}
+
+	return st, nil
+}
+
+// NewFromState creates a new TrapCheck instance from state previously
+// returned by ExportState. When the state is complete no API calls are
+// made. The API is only used if the cached state proves invalid when
+// submitting (e.g. the broker returns a 404 and the check is refreshed).
+func NewFromState(cfg *Config, st State) (*TrapCheck, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("invalid configuration  (nil)")
+	}
+
+	if st.SubmissionURL == "" {
+		return nil, fmt.Errorf("invalid state, no submission url")
+	}
+
+	if st.CheckBundle.Type != "" && !strings.HasPrefix(st.CheckBundle.Type, "httptrap") {
+		return nil, fmt.Errorf("check type must be httptrap variant (%s)", st.CheckBundle.Type)
+	}
+
+	tc, err := newTrapCheck(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	bundle := st.CheckBundle
+	tc.checkBundle = &bundle
+	tc.submissionURL = st.SubmissionURL
+
+	if st.Broker != nil && (st.BrokerCID == "" || st.Broker.CID == st.BrokerCID) {
+		broker := *st.Broker
+		tc.broker = &broker
+	}
+
+	if len(tc.caCertPEM) == 0 && tc.caCertFile == "" && st.CACertPEM != "" {
+		// caller supplied ca cert material takes precedence over cached
+		if err := tc.setBrokerCACert([]byte(st.CACertPEM), ""); err != nil {
+			tc.logger().Warnf("cached broker ca cert: %s -- ignoring", err)
+		} else {
+			tc.caCertFromState = true
+		}
+	}
+
+	if err := tc.setBrokerTLSConfig(); err != nil {
+		return nil, err
+	}
+
+	if err := tc.verifySubmissionURL(); err != nil {
+		return nil, err
+	}
+
+	return tc, nil
+}
@@ -0,0 +1,185 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/circonus-labs/go-apiclient"
+)
+
+func TestTrapCheck_ExportState(t *testing.T) {
+	tc := &TrapCheck{}
+	if _, err := tc.ExportState(); err == nil {
+		t.Fatal("TrapCheck.ExportState() expected error, check bundle not initialized")
+	}
+
+	brokerIP := "127.0.0.1"
+	tc.checkBundle = &apiclient.CheckBundle{
+		CID:     "/check_bundle/123",
+		Brokers: []string{"/broker/123"},
+		Type:    "httptrap",
+		Config:  apiclient.CheckBundleConfig{"submission_url": "https://127.0.0.1:43191/module/httptrap/abc/foo"},
+	}
+	tc.submissionURL = "https://127.0.0.1:43191/module/httptrap/abc/foo"
+	tc.broker = &apiclient.Broker{
+		CID:     "/broker/123",
+		Details: []apiclient.BrokerDetail{{CN: "foo", IP: &brokerIP, Status: statusActive}},
+	}
+	tc.caCertInUse = []byte("ca cert")
+
+	st, err := tc.ExportState()
+	if err != nil {
+		t.Fatalf("TrapCheck.ExportState() error = %v", err)
+	}
+
+	data, err := json.Marshal(st)
+	if err != nil {
+		t.Fatalf("json marshal state: %s", err)
+	}
+	var got State
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("json unmarshal state: %s", err)
+	}
+	if !reflect.DeepEqual(got, st) {
+		t.Errorf("state json round trip = %+v, want %+v", got, st)
+	}
+	if got.BrokerCID != "/broker/123" || got.CACertPEM != "ca cert" {
+		t.Errorf("state = %+v, want broker cid and ca cert", got)
+	}
+}
+
+func TestNewFromState(t *testing.T) {
+	caPEM, ca, caKey := generateTestCA(t, time.Now().Add(time.Hour))
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, `{"stats":1}`)
+	}))
+	ts.TLS = &tls.Config{
+		Certificates: []tls.Certificate{generateTestCert(t, ca, caKey, "foo")},
+		MinVersion:   tls.VersionTLS12,
+	}
+	ts.StartTLS()
+	defer ts.Close()
+
+	tsURL, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatalf("creating test broker: %s", err)
+	}
+	brokerIP := tsURL.Hostname()
+	bp, err := strconv.Atoi(tsURL.Port())
+	if err != nil {
+		t.Fatalf("parsing test broker port: %s", err)
+	}
+	brokerPort := uint16(bp)
+
+	st := State{
+		CheckBundle: apiclient.CheckBundle{
+			CID:        "/check_bundle/123",
+			CheckUUIDs: []string{"abc-123"},
+			Brokers:    []string{"/broker/123"},
+			Type:       "httptrap",
+			Config:     apiclient.CheckBundleConfig{"submission_url": ts.URL},
+		},
+		Broker: &apiclient.Broker{
+			CID:  "/broker/123",
+			Name: "foo",
+			Type: circonusType,
+			Details: []apiclient.BrokerDetail{
+				{CN: "foo", Status: statusActive, Modules: []string{"httptrap"}, IP: &brokerIP, Port: &brokerPort},
+			},
+		},
+		BrokerCID:     "/broker/123",
+		CACertPEM:     string(caPEM),
+		SubmissionURL: ts.URL,
+	}
+
+	// no mock funcs - any api call will panic
+	cfg := &Config{Client: &APIMock{}}
+
+	if _, err := NewFromState(nil, st); err == nil {
+		t.Error("NewFromState() expected error, nil config")
+	}
+	if _, err := NewFromState(cfg, State{}); err == nil {
+		t.Error("NewFromState() expected error, empty state")
+	}
+
+	tc, err := NewFromState(cfg, st)
+	if err != nil {
+		t.Fatalf("NewFromState() error = %v", err)
+	}
+
+	var metrics bytes.Buffer
+	metrics.WriteString(`{"foo":{"_type":"n","_value":1}}`)
+	if _, err := tc.SendMetrics(context.Background(), metrics); err != nil {
+		t.Fatalf("TrapCheck.SendMetrics() error = %v", err)
+	}
+
+	st2, err := tc.ExportState()
+	if err != nil {
+		t.Fatalf("TrapCheck.ExportState() error = %v", err)
+	}
+	if !reflect.DeepEqual(st2, st) {
+		t.Errorf("TrapCheck.ExportState() = %+v, want %+v", st2, st)
+	}
+}
+
+func TestNewFromState_invalidState(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/deleted" {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		fmt.Fprintln(w, `{"stats":1}`)
+	}))
+	defer ts.Close()
+
+	client := &APIMock{
+		FetchCheckBundleFunc: func(cid apiclient.CIDType) (*apiclient.CheckBundle, error) {
+			return &apiclient.CheckBundle{
+				CID:        "/check_bundle/123",
+				CheckUUIDs: []string{"abc-123"},
+				Type:       "httptrap",
+				Config:     apiclient.CheckBundleConfig{"submission_url": ts.URL},
+				Status:     statusActive,
+			}, nil
+		},
+	}
+
+	st := State{
+		CheckBundle: apiclient.CheckBundle{
+			CID:    "/check_bundle/123",
+			Type:   "httptrap",
+			Config: apiclient.CheckBundleConfig{"submission_url": ts.URL + "/deleted"},
+		},
+		SubmissionURL: ts.URL + "/deleted",
+	}
+
+	tc, err := NewFromState(&Config{Client: client, RefreshRetryDelay: "0s"}, st)
+	if err != nil {
+		t.Fatalf("NewFromState() error = %v", err)
+	}
+
+	var metrics bytes.Buffer
+	metrics.WriteString(`{"foo":{"_type":"n","_value":1}}`)
+	if _, err := tc.SendMetrics(context.Background(), metrics); err != nil {
+		t.Fatalf("TrapCheck.SendMetrics() error = %v", err)
+	}
+	if n := len(client.FetchCheckBundleCalls()); n != 1 {
+		t.Errorf("FetchCheckBundle calls = %d, want 1", n)
+	}
+}
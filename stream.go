@@ -0,0 +1,414 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// errStreamResponded unblocks stream writes when the broker responded (or
+// the request failed) before the stream was closed.
+var errStreamResponded = errors.New("broker responded before the submission was complete")
+
+// SubmissionWriter streams metrics (a JSON document, written in any number of
+// pieces) into a single submission, see NewSubmission.
+type SubmissionWriter interface {
+	io.WriteCloser
+	// Result returns the submission result (and error) once Close has been called.
+	Result() (*TrapResult, error)
+}
+
+// NewSubmission starts a streamed submission, metrics written to the returned
+// SubmissionWriter are gzip compressed and sent as they are written (chunked
+// transfer encoding, no Content-Length) - the payload is never buffered.
+// Close completes the submission and returns its error, Result returns the
+// result. The SubmissionWriter is not safe for concurrent use.
+//
+// A streamed body cannot be rewound, the submission gets a single attempt
+// unless the compressed payload fits in Config.StreamRetryBufferBytes, then a
+// failed attempt is resubmitted like SendCompressedMetrics (retries, check
+// refresh). Without the buffer a broker 404/401/403 still refreshes the check,
+// for the next submission. SubmissionTimeout bounds the stream, from
+// NewSubmission to Close.
+func (tc *TrapCheck) NewSubmission(ctx context.Context) (SubmissionWriter, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if tc.checkDeactivated() {
+		return nil, ErrCheckDeactivated
+	}
+	if err := tc.throttle(ctx); err != nil {
+		return nil, err
+	}
+	probe, err := tc.circuitAllow()
+	if err != nil {
+		tc.recordSubmission(nil, err)
+		return nil, err
+	}
+
+	ss, err := tc.startStream(ctx)
+	if err != nil {
+		err = redactError(err, tc.submissionSecret())
+		tc.circuitRecord(probe, err)
+		tc.recordSubmission(nil, err)
+		return nil, err
+	}
+	ss.probe = probe
+	return ss, nil
+}
+
+// streamSubmission is the SubmissionWriter returned by NewSubmission.
+type streamSubmission struct {
+	start      time.Time
+	tc         *TrapCheck
+	parent     context.Context // caller context, for a resubmission
+	ctx        context.Context
+	cancel     context.CancelFunc
+	logger     Logger
+	state      submitState
+	span       *span
+	timer      *attemptTimer
+	pw         *io.PipeWriter
+	zw         *gzip.Writer
+	trace      *streamTrace
+	retryBuf   *streamRetryBuffer
+	meta       *traceMeta
+	done       chan struct{}
+	resp       *http.Response
+	respErr    error
+	writeErr   error
+	result     *TrapResult
+	err        error
+	submitUUID string
+	reqURL     string
+	metaFile   string
+	compressed countWriter
+	written    int64
+	probe      bool
+	closed     bool
+}
+
+// startStream starts the submission request, the request body is the
+// gzip compressed stream.
+func (tc *TrapCheck) startStream(ctx context.Context) (*streamSubmission, error) {
+	sid, err := uuid.NewRandom()
+	if err != nil {
+		return nil, fmt.Errorf("creating new submit ID: %w", err)
+	}
+	submitUUID := sid.String()
+
+	st, err := tc.prepareSubmit(submitUUID)
+	if err != nil {
+		return nil, err
+	}
+	logger := st.logger
+
+	ss := &streamSubmission{
+		start:      tc.clock().Now(),
+		tc:         tc,
+		parent:     ctx,
+		state:      st,
+		logger:     logger,
+		submitUUID: submitUUID,
+		done:       make(chan struct{}),
+	}
+
+	ctx, ss.span = tc.startSpan(ctx, SpanSubmit)
+	if tc.submissionTimeout > 0 {
+		ss.ctx, ss.cancel = context.WithTimeout(ctx, tc.submissionTimeout)
+	} else {
+		ss.ctx, ss.cancel = context.WithCancel(ctx)
+	}
+
+	pr, pw := io.Pipe()
+	req, err := http.NewRequestWithContext(ss.ctx, http.MethodPut, st.url, pr)
+	if err != nil {
+		ss.cancel()
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+	req.ContentLength = -1 // chunked
+	setSubmitHeaders(req.Header, submitUUID, EncodingGzip)
+	ss.reqURL = req.URL.String()
+	ss.pw = pw
+
+	writers := []io.Writer{pw, &ss.compressed}
+	if tc.streamRetryBuffer > 0 {
+		ss.retryBuf = &streamRetryBuffer{max: tc.streamRetryBuffer}
+		writers = append(writers, ss.retryBuf)
+	}
+	if traceDir := tc.traceDir(); traceDir != "" {
+		if tc.traceLevel == TraceLevelFull {
+			ss.meta = &traceMeta{
+				SubmissionURL:  redactSubmissionURL(st.url),
+				SubmitUUID:     submitUUID,
+				RequestHeaders: req.Header.Clone(),
+			}
+		}
+		if traceDir != "-" {
+			fn := path.Join(traceDir, tc.clock().Now().UTC().Format(traceTSFormat)+"_"+submitUUID+".json.gz")
+			ss.metaFile = strings.TrimSuffix(fn, ".json.gz") + ".meta.json"
+			if fh, err := os.Create(fn); err != nil {
+				logger.Errorf("creating (%s): %s -- skipping submit trace", fn, err)
+				ss.meta = nil
+				tc.traceWriteFailed(logger, traceDir)
+			} else {
+				ss.trace = &streamTrace{fh: fh, dir: traceDir}
+				writers = append(writers, ss.trace)
+			}
+		}
+	}
+	ss.zw = gzip.NewWriter(io.MultiWriter(writers...))
+
+	client := tc.submitClient(st.tlsConfig)
+	ss.timer = tc.instrumentSubmitClient(client)
+
+	go func() {
+		defer close(ss.done)
+		ss.resp, ss.respErr = client.Do(req) //nolint:bodyclose // closed in finish
+		if ss.respErr != nil {
+			pr.CloseWithError(ss.respErr)
+		} else {
+			pr.CloseWithError(errStreamResponded)
+		}
+		if tc.transport == nil {
+			client.CloseIdleConnections()
+		}
+	}()
+
+	return ss, nil
+}
+
+// Write compresses and sends p. An error is returned if the request failed
+// (the submission cannot be completed, Close returns the submission error)
+// or the metrics exceed Config.MaxPayloadSize.
+func (ss *streamSubmission) Write(p []byte) (int, error) {
+	if ss.closed {
+		return 0, fmt.Errorf("write to closed submission")
+	}
+	if ss.writeErr != nil {
+		return 0, ss.writeErr
+	}
+	if max := ss.tc.maxPayloadSize; max > 0 && ss.written+int64(len(p)) > max {
+		ss.writeErr = &PayloadTooLargeError{Size: ss.written + int64(len(p)), MaxSize: max, CompressedSize: -1}
+		ss.pw.CloseWithError(ss.writeErr)
+		return 0, ss.writeErr
+	}
+	n, err := ss.zw.Write(p)
+	ss.written += int64(n)
+	if err != nil {
+		ss.writeErr = fmt.Errorf("streaming metrics: %w", err)
+		return n, ss.writeErr
+	}
+	return n, nil
+}
+
+// Close completes the submission, returns the submission error.
+func (ss *streamSubmission) Close() error {
+	if ss.closed {
+		return ss.err
+	}
+	ss.closed = true
+	defer ss.cancel()
+
+	if ss.writeErr == nil && ss.written == 0 {
+		ss.writeErr = fmt.Errorf("zero length data, no metrics to submit")
+	}
+	if ss.writeErr == nil {
+		if err := ss.zw.Close(); err != nil {
+			ss.writeErr = fmt.Errorf("streaming metrics: %w", err)
+		}
+	}
+	if ss.writeErr != nil {
+		ss.pw.CloseWithError(ss.writeErr)
+	} else {
+		ss.pw.Close()
+	}
+	<-ss.done
+
+	tc := ss.tc
+	result, refresh, err := ss.finish()
+	err = redactError(err, ss.state.secret)
+	tc.endSubmitSpan(ss.span, result, err)
+	ss.writeTrace(err)
+
+	resubmit := ss.writeErr == nil && ss.retryBuf != nil && !ss.retryBuf.overflow
+	switch {
+	case err != nil && resubmit && (refresh || spoolable(err)):
+		ss.logger.Warnf("streamed submission failed (%s), resubmitting buffered payload (%d bytes)", err, ss.retryBuf.buf.Len())
+		result, err = tc.sendMetrics(ss.parent, ss.retryBuf.buf, EncodingGzip)
+		if result != nil {
+			result.BytesSent = int(ss.written)
+			result.UncompressedBytes = int(ss.written)
+		}
+	case refresh:
+		ss.refreshCheck(err)
+	}
+
+	ss.result, ss.err = result, err
+	tc.circuitRecord(ss.probe, err)
+	tc.recordSubmission(result, err)
+	return err
+}
+
+// refreshCheck refreshes the check after the broker rejected a streamed
+// submission which cannot be resubmitted (no retry buffer), so the next
+// submission uses the refreshed submission url and tls config.
+func (ss *streamSubmission) refreshCheck(submitErr error) {
+	tc := ss.tc
+	if wait := tc.refreshCooldownRemaining(); wait > 0 {
+		ss.logger.Warnf("check refresh suppressed, next refresh allowed in %s: %s", wait.String(), submitErr)
+		return
+	}
+	reason := submitRefreshReason(submitErr)
+	if tc.asyncRefresh {
+		if err := tc.startAsyncRefresh(reason); err != nil {
+			ss.logger.Warnf("unable to refresh (%s): %s", submitErr, err)
+			return
+		}
+		tc.recordRefreshOutcome(false)
+		return
+	}
+	if _, err := tc.refreshCheck(reason); err != nil {
+		tc.recordRefreshOutcome(false)
+		ss.logger.Warnf("unable to refresh (%s): %s", submitErr, err)
+		return
+	}
+	ss.logger.Warnf("check refreshed, streamed submission not resubmitted: %s", submitErr)
+}
+
+// Result returns the submission result, Close must be called first.
+func (ss *streamSubmission) Result() (*TrapResult, error) {
+	if !ss.closed {
+		return nil, fmt.Errorf("submission not closed")
+	}
+	return ss.result, ss.err
+}
+
+// finish reads the broker response to the streamed request.
+func (ss *streamSubmission) finish() (*TrapResult, bool, error) {
+	tc := ss.tc
+	if ss.resp != nil {
+		defer func() {
+			_, _ = io.CopyN(io.Discard, ss.resp.Body, tc.responseLimit())
+			ss.resp.Body.Close()
+		}()
+	}
+	if ss.writeErr != nil {
+		return nil, false, ss.writeErr
+	}
+	if ss.respErr != nil {
+		if err := clockSkewError(ss.respErr, tc.clock().Now()); err != nil {
+			return nil, false, fmt.Errorf("making request: %w", err)
+		}
+		if timedOut, ok := tc.timedOut(ss.parent, ss.ctx); ok {
+			return nil, false, fmt.Errorf("making request, %s: %w", timedOut, ss.respErr)
+		}
+		return nil, false, fmt.Errorf("making request: %w", ss.respErr)
+	}
+
+	result, refresh, err := tc.parseSubmitResponse(ss.parent, ss.ctx, ss.state, ss.reqURL, ss.resp, ss.meta)
+	if err != nil {
+		return nil, refresh, err
+	}
+
+	result.CheckUUID = ss.state.checkUUID
+	result.SubmitUUID = ss.submitUUID
+	result.FinalURL = redactSubmissionURL(ss.resp.Request.URL.String())
+	result.SubmitDuration = tc.clock().Since(ss.start)
+	result.LastReqDuration = result.SubmitDuration
+	result.BytesSent = int(ss.written)
+	result.BytesSentGzip = int(ss.compressed.n)
+	result.UncompressedBytes = int(ss.written)
+	result.Compressed = true
+	logCompressionRatio(ss.logger, int(ss.written), int(ss.compressed.n))
+	result.setAttempts(ss.timer.results())
+
+	return tc.checkResult(ss.logger, result)
+}
+
+// writeTrace completes the trace of the compressed stream and the metadata.
+func (ss *streamSubmission) writeTrace(err error) {
+	tc := ss.tc
+	traceDir := tc.traceDir()
+	if traceDir == "-" {
+		ss.logger.Infof("metric payload: streamed, %d bytes (%d compressed)", ss.written, ss.compressed.n)
+	}
+	if ss.trace != nil {
+		if e := ss.trace.close(); e != nil {
+			ss.logger.Errorf("writing metric trace (%s): %s", ss.trace.fh.Name(), e)
+			tc.traceWriteFailed(ss.logger, ss.trace.dir)
+		} else {
+			tc.traceWritten()
+		}
+	}
+	if ss.meta != nil {
+		ss.meta.Attempts = len(ss.timer.results())
+		ss.meta.SubmitDuration = tc.clock().Since(ss.start)
+		ss.meta.LastReqDuration = ss.meta.SubmitDuration
+		if err != nil {
+			ss.meta.Error = err.Error()
+		}
+		metaFile := ss.metaFile
+		if traceDir == "-" {
+			metaFile = ""
+		}
+		tc.writeTraceMeta(ss.logger, ss.meta, metaFile)
+	}
+}
+
+// streamTrace tees the compressed stream to the trace file, write errors are
+// reported when the trace is closed and do not fail the submission.
+type streamTrace struct {
+	fh  *os.File
+	err error
+	dir string
+}
+
+func (st *streamTrace) Write(p []byte) (int, error) {
+	if st.err == nil {
+		_, st.err = st.fh.Write(p)
+	}
+	return len(p), nil
+}
+
+func (st *streamTrace) close() error {
+	if err := st.fh.Close(); err != nil && st.err == nil {
+		st.err = err
+	}
+	return st.err
+}
+
+// streamRetryBuffer keeps a copy of the compressed stream, up to max bytes,
+// so a failed streamed submission can be resubmitted.
+type streamRetryBuffer struct {
+	buf      bytes.Buffer
+	max      int64
+	overflow bool
+}
+
+func (rb *streamRetryBuffer) Write(p []byte) (int, error) {
+	if rb.overflow {
+		return len(p), nil
+	}
+	if int64(rb.buf.Len()+len(p)) > rb.max {
+		rb.overflow = true
+		rb.buf = bytes.Buffer{}
+		return len(p), nil
+	}
+	return rb.buf.Write(p) //nolint:wrapcheck
+}
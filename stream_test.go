@@ -0,0 +1,249 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/circonus-labs/go-apiclient"
+	"github.com/circonus-labs/go-trapcheck/trapchecktest"
+)
+
+// streamMetrics writes n metrics to w, one write per metric.
+func streamMetrics(t *testing.T, w io.Writer, n int) []byte {
+	t.Helper()
+	var sent bytes.Buffer
+	out := io.MultiWriter(w, &sent)
+	write := func(s string) {
+		if _, err := io.WriteString(out, s); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+	write("{")
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			write(",")
+		}
+		write(fmt.Sprintf(`"metric_%06d":{"_type":"n","_value":%d}`, i, i))
+	}
+	write("}")
+	return sent.Bytes()
+}
+
+func TestTrapCheck_NewSubmission(t *testing.T) {
+	fb := trapchecktest.NewFakeBroker(t)
+	tc := newAsyncTestTrapCheck(fb, 0)
+	tc.traceMetrics = t.TempDir()
+	tc.submissionTimeout = 30 * time.Second // the stream is slow under -race
+
+	const numMetrics = 40000 // ~2MB uncompressed
+	sw, err := tc.NewSubmission(context.Background())
+	if err != nil {
+		t.Fatalf("NewSubmission() error = %v", err)
+	}
+	payload := streamMetrics(t, sw, numMetrics)
+	if len(payload) < 1<<20 {
+		t.Fatalf("payload = %d bytes, want > 1MB", len(payload))
+	}
+	if err := sw.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	result, err := sw.Result()
+	if err != nil {
+		t.Fatalf("Result() error = %v", err)
+	}
+	if result.Stats != numMetrics {
+		t.Errorf("Stats = %d, want %d", result.Stats, numMetrics)
+	}
+	if !result.Compressed || result.UncompressedBytes != len(payload) || result.BytesSentGzip >= len(payload) {
+		t.Errorf("result = %+v, want compressed %d bytes", result, len(payload))
+	}
+
+	subs := fb.Submissions()
+	if len(subs) != 1 {
+		t.Fatalf("broker submissions = %d, want 1", len(subs))
+	}
+	if !subs[0].Gzipped || !bytes.Equal(subs[0].Payload, payload) {
+		t.Errorf("submission gzipped = %t, payload %d bytes, want gzipped %d bytes", subs[0].Gzipped, len(subs[0].Payload), len(payload))
+	}
+	if got := subs[0].Header.Get(SubmitIDHeader); got != result.SubmitUUID {
+		t.Errorf("%s = %q, want %q", SubmitIDHeader, got, result.SubmitUUID)
+	}
+
+	traces, err := filepath.Glob(filepath.Join(tc.traceMetrics, "*_"+result.SubmitUUID+".json.gz"))
+	if err != nil || len(traces) != 1 {
+		t.Fatalf("trace files = %v (%v), want one", traces, err)
+	}
+	fh, err := os.Open(traces[0])
+	if err != nil {
+		t.Fatalf("opening trace: %v", err)
+	}
+	defer fh.Close()
+	zr, err := gzip.NewReader(fh)
+	if err != nil {
+		t.Fatalf("trace gzip: %v", err)
+	}
+	traced, err := io.ReadAll(zr)
+	if err != nil || !bytes.Equal(traced, payload) {
+		t.Errorf("trace = %d bytes (%v), want %d bytes", len(traced), err, len(payload))
+	}
+}
+
+func TestTrapCheck_NewSubmission_retry(t *testing.T) {
+	tests := []struct {
+		name      string
+		retryBuf  int64
+		wantSubs  int
+		wantError bool
+	}{
+		{name: "single attempt", wantSubs: 1, wantError: true},
+		{name: "buffer too small", retryBuf: 10, wantSubs: 1, wantError: true},
+		{name: "resubmitted", retryBuf: 1 << 20, wantSubs: 2},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			fb := trapchecktest.NewFakeBroker(t)
+			fb.QueueResponse(http.StatusServiceUnavailable, "busy")
+			tc := newAsyncTestTrapCheck(fb, 0)
+			tc.streamRetryBuffer = tt.retryBuf
+
+			sw, err := tc.NewSubmission(context.Background())
+			if err != nil {
+				t.Fatalf("NewSubmission() error = %v", err)
+			}
+			payload := streamMetrics(t, sw, 100)
+			err = sw.Close()
+			if (err != nil) != tt.wantError {
+				t.Fatalf("Close() error = %v, want error %t", err, tt.wantError)
+			}
+			subs := fb.Submissions()
+			if len(subs) != tt.wantSubs {
+				t.Fatalf("broker submissions = %d, want %d", len(subs), tt.wantSubs)
+			}
+			if tt.wantError {
+				return
+			}
+			if !bytes.Equal(subs[1].Payload, payload) {
+				t.Errorf("resubmitted payload = %d bytes, want %d", len(subs[1].Payload), len(payload))
+			}
+			if result, _ := sw.Result(); result == nil || result.UncompressedBytes != len(payload) || result.Stats != 100 {
+				t.Errorf("Result() = %+v, want %d bytes, 100 stats", result, len(payload))
+			}
+		})
+	}
+}
+
+func TestTrapCheck_NewSubmission_refresh(t *testing.T) {
+	fb := trapchecktest.NewFakeBroker(t)
+	fb.RespondNotFound(1)
+	refreshedURL := fb.SubmissionURL("abc-123", "rotated")
+
+	client := &APIMock{
+		FetchCheckBundleFunc: func(cid apiclient.CIDType) (*apiclient.CheckBundle, error) {
+			return &apiclient.CheckBundle{
+				CID:        "/check_bundle/123",
+				CheckUUIDs: []string{"abc-123"},
+				Type:       "httptrap",
+				Config:     apiclient.CheckBundleConfig{"submission_url": refreshedURL},
+				Status:     statusActive,
+			}, nil
+		},
+	}
+	tc := newAsyncTestTrapCheck(fb, 0)
+	tc.client = client
+	tc.custSubmissionURL = ""
+	tc.checkBundle = &apiclient.CheckBundle{CID: "/check_bundle/123", Type: "httptrap"}
+
+	// no retry buffer, the submission is not resubmitted but the check is refreshed
+	for i := 0; i < 2; i++ {
+		sw, err := tc.NewSubmission(context.Background())
+		if err != nil {
+			t.Fatalf("NewSubmission() error = %v", err)
+		}
+		streamMetrics(t, sw, 10)
+		err = sw.Close()
+		var stErr *statusError
+		if i == 0 && (!errors.As(err, &stErr) || stErr.code != http.StatusNotFound) {
+			t.Errorf("Close() error = %v, want 404", err)
+		}
+		if i == 1 && err != nil {
+			t.Errorf("Close() error = %v", err)
+		}
+	}
+
+	if n := len(client.FetchCheckBundleCalls()); n != 1 {
+		t.Errorf("FetchCheckBundle calls = %d, want 1", n)
+	}
+	subs := fb.Submissions()
+	if len(subs) != 2 {
+		t.Fatalf("broker submissions = %d, want 2", len(subs))
+	}
+	if subs[1].Path != "/module/httptrap/abc-123/rotated" {
+		t.Errorf("submission after refresh path = %s, want refreshed submission url", subs[1].Path)
+	}
+}
+
+func TestTrapCheck_NewSubmission_errors(t *testing.T) {
+	t.Run("payload too large", func(t *testing.T) {
+		fb := trapchecktest.NewFakeBroker(t)
+		tc := newAsyncTestTrapCheck(fb, 0)
+		tc.maxPayloadSize = 64
+
+		sw, err := tc.NewSubmission(context.Background())
+		if err != nil {
+			t.Fatalf("NewSubmission() error = %v", err)
+		}
+		if _, err := sw.Write(bytes.Repeat([]byte("x"), 100)); !errors.Is(err, ErrPayloadTooLarge) {
+			t.Errorf("Write() error = %v, want %v", err, ErrPayloadTooLarge)
+		}
+		if err := sw.Close(); !errors.Is(err, ErrPayloadTooLarge) {
+			t.Errorf("Close() error = %v, want %v", err, ErrPayloadTooLarge)
+		}
+		for _, sub := range fb.Submissions() {
+			if len(sub.Payload) > 0 {
+				t.Errorf("broker received payload %q, want aborted request", sub.Payload)
+			}
+		}
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		fb := trapchecktest.NewFakeBroker(t)
+		tc := newAsyncTestTrapCheck(fb, 0)
+
+		sw, err := tc.NewSubmission(context.Background())
+		if err != nil {
+			t.Fatalf("NewSubmission() error = %v", err)
+		}
+		if err := sw.Close(); err == nil {
+			t.Error("Close() expected error")
+		}
+		if _, err := sw.Write([]byte("{}")); err == nil {
+			t.Error("Write() after Close expected error")
+		}
+	})
+
+	t.Run("deactivated", func(t *testing.T) {
+		fb := trapchecktest.NewFakeBroker(t)
+		tc := newAsyncTestTrapCheck(fb, 0)
+		tc.deactivated = 1
+
+		if _, err := tc.NewSubmission(context.Background()); !errors.Is(err, ErrCheckDeactivated) {
+			t.Errorf("NewSubmission() error = %v, want %v", err, ErrCheckDeactivated)
+		}
+	})
+}
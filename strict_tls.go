@@ -0,0 +1,52 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+)
+
+// ErrStrictTLSNoSAN is returned (wrapped) by submissions when Config.StrictTLS
+// is set and the broker certificate has no subject alternative names, only a
+// common name - unset StrictTLS to verify the broker CN instead.
+var ErrStrictTLSNoSAN = errors.New("broker certificate has no SANs, required by StrictTLS")
+
+// strictTLSConfig returns a tls config using standard verification, the broker
+// certificate must be signed by the broker CA and have a SAN matching the
+// submission url host (or Config.TLSServerName). Pinned fingerprints are
+// checked after verification.
+func (tc *TrapCheck) strictTLSConfig(su *submissionURLParts, certPool *x509.CertPool) *tls.Config {
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		RootCAs:    certPool,
+		ServerName: su.host,
+	}
+	if tc.tlsServerName != "" {
+		tlsConfig.ServerName = tc.tlsServerName
+	}
+	if len(tc.pinnedFingerprints) > 0 {
+		tlsConfig.VerifyConnection = tc.verifyPinnedCert
+	}
+	return tlsConfig
+}
+
+// strictTLSError returns an ErrStrictTLSNoSAN error if err is a hostname
+// verification failure for a certificate without SANs, otherwise nil.
+func strictTLSError(err error) error {
+	var hostErr x509.HostnameError
+	if !errors.As(err, &hostErr) || hostErr.Certificate == nil {
+		return nil
+	}
+	cert := hostErr.Certificate
+	if len(cert.DNSNames) > 0 || len(cert.IPAddresses) > 0 {
+		return nil // has SANs, a plain name mismatch
+	}
+	return fmt.Errorf("%w (cn: %q, host: %q), unset StrictTLS to verify the broker cn: %s",
+		ErrStrictTLSNoSAN, cert.Subject.CommonName, hostErr.Host, err)
+}
@@ -0,0 +1,179 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/circonus-labs/go-apiclient"
+)
+
+func TestTrapCheck_SendMetricsStrictTLS(t *testing.T) {
+	caPEM, ca, caKey := generateTestCA(t, time.Now().Add(time.Hour))
+	cnOnly := generateTestCert(t, ca, caKey, "foo")
+	withSAN := generateTestSANCert(t, ca, caKey, "foo", []net.IP{net.ParseIP("127.0.0.1"), net.IPv6loopback})
+
+	tests := []struct {
+		wantErr   error
+		cert      tls.Certificate
+		name      string
+		strictTLS bool
+	}{
+		{name: "compat, cn only cert", cert: cnOnly},
+		{name: "compat, san cert", cert: withSAN},
+		{name: "strict, san cert", cert: withSAN, strictTLS: true},
+		{name: "strict, cn only cert", cert: cnOnly, strictTLS: true, wantErr: ErrStrictTLSNoSAN},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprintln(w, `{"stats":1}`)
+			}))
+			ts.TLS = &tls.Config{
+				Certificates: []tls.Certificate{tt.cert},
+				MinVersion:   tls.VersionTLS12,
+			}
+			ts.StartTLS()
+			defer ts.Close()
+
+			tsURL, err := url.Parse(ts.URL)
+			if err != nil {
+				t.Fatalf("creating test broker: %s", err)
+			}
+			brokerIP := tsURL.Hostname()
+			bp, err := strconv.Atoi(tsURL.Port())
+			if err != nil {
+				t.Fatalf("parsing test broker port: %s", err)
+			}
+			brokerPort := uint16(bp)
+
+			tc := &TrapCheck{strictTLS: tt.strictTLS}
+			tc.Log = &LogWrapper{
+				Log:   log.New(io.Discard, "", log.LstdFlags),
+				Debug: false,
+			}
+			client := &APIMock{
+				FetchBrokersFunc: func() (*[]apiclient.Broker, error) {
+					return &[]apiclient.Broker{
+						{
+							CID:  "/broker/123",
+							Name: "foo",
+							Type: circonusType,
+							Details: []apiclient.BrokerDetail{
+								{CN: "foo", Status: statusActive, Modules: []string{"httptrap"}, IP: &brokerIP, Port: &brokerPort},
+							},
+						},
+					}, nil
+				},
+			}
+			tc.brokerList = initTestBrokerList(t, client, tc.Log)
+			tc.client = client
+			tc.submissionTimeout = 5 * time.Second
+			tc.submissionURL = ts.URL
+			tc.checkBundle = &apiclient.CheckBundle{
+				Brokers:    []string{"/broker/123"},
+				CheckUUIDs: []string{"abc-123"},
+				Type:       "httptrap",
+				Config:     apiclient.CheckBundleConfig{"submission_url": ts.URL},
+			}
+			if err := tc.setBrokerCACert(caPEM, ""); err != nil {
+				t.Fatalf("TrapCheck.setBrokerCACert() error = %v", err)
+			}
+
+			var metrics bytes.Buffer
+			metrics.WriteString(`{"foo":{"_type":"n","_value":1}}`)
+			_, err = tc.SendMetrics(context.Background(), metrics)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("TrapCheck.SendMetrics() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("TrapCheck.SendMetrics() error = %v", err)
+			}
+
+			if tt.strictTLS {
+				if tc.tlsConfig.InsecureSkipVerify || tc.tlsConfig.RootCAs == nil || tc.tlsConfig.ServerName != brokerIP {
+					t.Errorf("strict tls config insecure = %t, root cas set = %t, server name = %q, want false, true, %q",
+						tc.tlsConfig.InsecureSkipVerify, tc.tlsConfig.RootCAs != nil, tc.tlsConfig.ServerName, brokerIP)
+				}
+			} else if tc.tlsConfig.ServerName != "foo" {
+				t.Errorf("tls config server name = %q, want %q", tc.tlsConfig.ServerName, "foo")
+			}
+		})
+	}
+}
+
+func Test_strictTLSError(t *testing.T) {
+	noSAN := &x509.Certificate{Subject: pkix.Name{CommonName: "foo"}}
+	withSAN := &x509.Certificate{Subject: pkix.Name{CommonName: "foo"}, DNSNames: []string{"foo"}}
+
+	tests := []struct {
+		err  error
+		name string
+		want bool
+	}{
+		{name: "nil", err: nil},
+		{name: "other error", err: fmt.Errorf("connection refused")},
+		{name: "no sans", err: &url.Error{Op: "Put", URL: "https://127.0.0.1", Err: x509.HostnameError{Certificate: noSAN, Host: "127.0.0.1"}}, want: true},
+		{name: "san mismatch", err: x509.HostnameError{Certificate: withSAN, Host: "127.0.0.1"}},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			err := strictTLSError(tt.err)
+			if got := errors.Is(err, ErrStrictTLSNoSAN); got != tt.want {
+				t.Errorf("strictTLSError() = %v, want ErrStrictTLSNoSAN %t", err, tt.want)
+			}
+		})
+	}
+}
+
+// generateTestSANCert creates a server certificate, with the supplied
+// common name and IP SANs, signed by the supplied CA.
+func generateTestSANCert(t *testing.T, ca *x509.Certificate, caKey *ecdsa.PrivateKey, cn string, ips []net.IP) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generating cert key: %s", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: cn},
+		IPAddresses:  ips,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     ca.NotAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("creating cert: %s", err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
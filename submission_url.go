@@ -0,0 +1,138 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// submissionURLParts is a parsed and validated submission url.
+type submissionURLParts struct {
+	scheme    string // http or https
+	host      string // hostname, ipv6 literals without brackets
+	port      string // explicit port, or the scheme default (80/443)
+	path      string
+	checkUUID string // from /module/httptrap/<uuid>/<secret>, may be empty
+	secret    string // path segment following the check uuid, may be empty
+	hostIsIP  bool
+}
+
+// hostPort returns host:port (ipv6 literals bracketed) for dialing.
+func (p *submissionURLParts) hostPort() string {
+	return net.JoinHostPort(p.host, p.port)
+}
+
+// isHTTP returns true if the submission url does not use tls.
+func (p *submissionURLParts) isHTTP() bool {
+	return p.scheme == "http"
+}
+
+// parseSubmissionURL parses and validates a submission url, the scheme must be
+// http or https and the host must be set. All submission url handling (tls,
+// broker cn matching, public ca detection, probing) uses it so that invalid
+// urls are rejected consistently.
+func parseSubmissionURL(surl string) (*submissionURLParts, error) {
+	if surl == "" {
+		return nil, fmt.Errorf("invalid submission url (empty)")
+	}
+
+	u, err := url.Parse(surl)
+	if err != nil {
+		return nil, fmt.Errorf("parse submission URL: %w", err)
+	}
+
+	p := &submissionURLParts{
+		scheme: strings.ToLower(u.Scheme),
+		host:   u.Hostname(),
+		port:   u.Port(),
+		path:   u.Path,
+	}
+
+	switch p.scheme {
+	case "http", "https":
+	case "":
+		return nil, fmt.Errorf("invalid submission url (%s), no scheme", u.Redacted())
+	default:
+		return nil, fmt.Errorf("invalid submission url (%s), unsupported scheme %q", u.Redacted(), u.Scheme)
+	}
+	if p.host == "" {
+		return nil, fmt.Errorf("invalid submission url (%s), no host", u.Redacted())
+	}
+	host := p.host
+	if i := strings.Index(host, "%"); i > 0 {
+		host = host[:i] // ipv6 zone
+	}
+	p.hostIsIP = net.ParseIP(host) != nil
+	if !p.hostIsIP && !validHostname(p.host) {
+		return nil, fmt.Errorf("invalid submission url (%s), invalid host %q", u.Redacted(), p.host)
+	}
+
+	switch {
+	case p.port == "" && p.scheme == "https":
+		p.port = "443"
+	case p.port == "":
+		p.port = "80"
+	default:
+		if n, err := strconv.ParseUint(p.port, 10, 16); err != nil || n == 0 {
+			return nil, fmt.Errorf("invalid submission url (%s), invalid port %q", u.Redacted(), p.port)
+		}
+	}
+
+	segments := strings.Split(strings.Trim(p.path, "/"), "/")
+	for i := 0; i < len(segments)-1; i++ {
+		if segments[i] != "httptrap" {
+			continue
+		}
+		if id, err := uuid.Parse(segments[i+1]); err == nil {
+			p.checkUUID = id.String()
+			if i+2 < len(segments) {
+				p.secret = segments[i+2]
+			}
+			break
+		}
+	}
+
+	return p, nil
+}
+
+// validHostname returns true if host only contains letters, digits, '-', '_' and '.'.
+func validHostname(host string) bool {
+	for _, r := range host {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '-' || r == '_' || r == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// withCustomURLHost returns the submission url surl using the scheme and host
+// (including the port) of the custom submission url, e.g. a stable DNS name
+// in front of the broker, keeping the surl path (check uuid and secret).
+func withCustomURLHost(surl, custom string) (string, error) {
+	cu, err := url.Parse(custom)
+	if err != nil {
+		return "", fmt.Errorf("parse custom submission URL: %w", err)
+	}
+	u, err := url.Parse(surl)
+	if err != nil {
+		return "", fmt.Errorf("parse submission URL: %w", err)
+	}
+	u.Scheme = cu.Scheme
+	u.Host = cu.Host
+	if _, err := parseSubmissionURL(u.String()); err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
@@ -0,0 +1,58 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+//go:build go1.18
+// +build go1.18
+
+package trapcheck
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func Fuzz_parseSubmissionURL(f *testing.F) {
+	for _, seed := range []string{
+		"",
+		":foo",
+		"broker.example.com",
+		"http://127.0.0.1/module/httptrap",
+		"https://api.circonus.com/module/httptrap/0c5b9a36-2a2e-4e3c-9c8f-0d1f2a3b4c5d/secret",
+		"https://[2001:db8::1]:43191/module/httptrap/0c5b9a36-2a2e-4e3c-9c8f-0d1f2a3b4c5d/secret",
+		"https://[fe80::1%25en0]/",
+		"https://broker.example.com:99999/",
+		"ftp://broker.example.com/",
+		"https://]",
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, surl string) {
+		su, err := parseSubmissionURL(surl)
+		if err != nil {
+			if su != nil {
+				t.Fatalf("parseSubmissionURL(%q) returned parts with error %s", surl, err)
+			}
+			return
+		}
+		if su.scheme != "http" && su.scheme != "https" {
+			t.Fatalf("parseSubmissionURL(%q) scheme = %q", surl, su.scheme)
+		}
+		if su.host == "" || su.port == "" {
+			t.Fatalf("parseSubmissionURL(%q) host = %q, port = %q", surl, su.host, su.port)
+		}
+		host, port, err := net.SplitHostPort(su.hostPort())
+		if err != nil || host != su.host || port != su.port {
+			t.Fatalf("parseSubmissionURL(%q) hostPort() = %q, does not round trip (%v)", surl, su.hostPort(), err)
+		}
+		if su.secret != "" && su.checkUUID == "" {
+			t.Fatalf("parseSubmissionURL(%q) secret without check uuid", surl)
+		}
+		if su.secret != "" && !strings.Contains(su.path, "/"+su.secret) {
+			t.Fatalf("parseSubmissionURL(%q) secret %q not in path %q", surl, su.secret, su.path)
+		}
+	})
+}
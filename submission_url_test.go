@@ -0,0 +1,189 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"io"
+	"log"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/circonus-labs/go-apiclient"
+)
+
+func Test_parseSubmissionURL(t *testing.T) {
+	tests := []struct {
+		want    *submissionURLParts
+		name    string
+		surl    string
+		wantErr bool
+	}{
+		{name: "empty", surl: "", wantErr: true},
+		{name: "invalid", surl: ":foo", wantErr: true},
+		{name: "no scheme", surl: "127.0.0.1:43191/module/httptrap/abc/secret", wantErr: true},
+		{name: "host only", surl: "broker.example.com", wantErr: true},
+		{name: "unsupported scheme", surl: "ftp://broker.example.com/", wantErr: true},
+		{name: "no host", surl: "https:///module/httptrap", wantErr: true},
+		{name: "invalid port", surl: "https://broker.example.com:99999/", wantErr: true},
+		{name: "zero port", surl: "https://broker.example.com:0/", wantErr: true},
+		{name: "unbracketed ipv6", surl: "https://::1/", wantErr: true},
+		{name: "invalid host", surl: "https://]", wantErr: true},
+		{
+			name: "http default port",
+			surl: "http://127.0.0.1/module/httptrap",
+			want: &submissionURLParts{scheme: "http", host: "127.0.0.1", port: "80", path: "/module/httptrap", hostIsIP: true},
+		},
+		{
+			name: "https default port, uuid and secret",
+			surl: "HTTPS://api.circonus.com/module/httptrap/0C5B9A36-2A2E-4E3C-9C8F-0D1F2A3B4C5D/mys3cr3t",
+			want: &submissionURLParts{
+				scheme:    "https",
+				host:      "api.circonus.com",
+				port:      "443",
+				path:      "/module/httptrap/0C5B9A36-2A2E-4E3C-9C8F-0D1F2A3B4C5D/mys3cr3t",
+				checkUUID: "0c5b9a36-2a2e-4e3c-9c8f-0d1f2a3b4c5d",
+				secret:    "mys3cr3t",
+			},
+		},
+		{
+			name: "ipv6 literal with port",
+			surl: "https://[2001:db8::1]:43191/module/httptrap/0c5b9a36-2a2e-4e3c-9c8f-0d1f2a3b4c5d",
+			want: &submissionURLParts{
+				scheme:    "https",
+				host:      "2001:db8::1",
+				port:      "43191",
+				path:      "/module/httptrap/0c5b9a36-2a2e-4e3c-9c8f-0d1f2a3b4c5d",
+				checkUUID: "0c5b9a36-2a2e-4e3c-9c8f-0d1f2a3b4c5d",
+				hostIsIP:  true,
+			},
+		},
+		{
+			name: "ipv6 literal default port",
+			surl: "https://[::1]/",
+			want: &submissionURLParts{scheme: "https", host: "::1", port: "443", path: "/", hostIsIP: true},
+		},
+		{
+			name: "no uuid after httptrap",
+			surl: "https://broker.example.com/module/httptrap/not-a-uuid/secret",
+			want: &submissionURLParts{scheme: "https", host: "broker.example.com", port: "443", path: "/module/httptrap/not-a-uuid/secret"},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseSubmissionURL(tt.surl)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseSubmissionURL() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseSubmissionURL() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSubmissionURL_callSites(t *testing.T) {
+	v6 := "2001:db8::1"
+	broker := &apiclient.Broker{
+		CID:  "/broker/1",
+		Name: "test",
+		Type: circonusType,
+		Details: []apiclient.BrokerDetail{
+			{CN: "v6.example.com", IP: &v6, Status: statusActive, Modules: []string{"httptrap"}},
+		},
+	}
+
+	caPEM, _, _ := generateTestCA(t, time.Now().Add(24*time.Hour))
+
+	tests := []struct {
+		name       string
+		surl       string
+		wantCN     string
+		wantPublic bool
+		wantTLS    bool
+		wantErr    bool
+	}{
+		{name: "empty", surl: "", wantErr: true},
+		{name: "invalid", surl: ":foo", wantErr: true},
+		{name: "no scheme", surl: "broker.example.com/module/httptrap", wantErr: true},
+		{name: "http skips tls", surl: "http://127.0.0.1:43191/module/httptrap"},
+		{name: "api.circonus.com", surl: "https://api.circonus.com/module/httptrap", wantPublic: true},
+		{name: "fqdn", surl: "https://broker.example.com/module/httptrap", wantCN: "broker.example.com", wantTLS: true},
+		{name: "ipv6 literal", surl: "https://[2001:db8::1]:43191/module/httptrap", wantCN: "v6.example.com", wantTLS: true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			tc := &TrapCheck{
+				broker:        broker,
+				checkBundle:   &apiclient.CheckBundle{Brokers: []string{broker.CID}, Config: apiclient.CheckBundleConfig{"submission_url": tt.surl}},
+				submissionURL: tt.surl,
+				caCertPEM:     caPEM,
+			}
+			tc.Log = &LogWrapper{Log: log.New(io.Discard, "", 0)}
+
+			err := tc.setBrokerTLSConfig()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("setBrokerTLSConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if _, err := tc.isPublicBroker(); err == nil {
+					t.Error("isPublicBroker() expected error")
+				}
+				if _, _, err := tc.getBrokerCNList(); err == nil {
+					t.Error("getBrokerCNList() expected error")
+				}
+				return
+			}
+			if (tc.tlsConfig != nil) != tt.wantTLS {
+				t.Errorf("tls config set = %v, want %v", tc.tlsConfig != nil, tt.wantTLS)
+			}
+			if tt.wantTLS && tc.tlsConfig.ServerName != tt.wantCN {
+				t.Errorf("ServerName = %q, want %q", tc.tlsConfig.ServerName, tt.wantCN)
+			}
+			if public, err := tc.isPublicBroker(); err != nil || public != tt.wantPublic {
+				t.Errorf("isPublicBroker() = %v, %v, want %v", public, err, tt.wantPublic)
+			}
+		})
+	}
+}
+
+func Test_withCustomURLHost(t *testing.T) {
+	tests := []struct {
+		name    string
+		surl    string
+		custom  string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:   "host and port",
+			surl:   "https://10.1.2.3:43191/module/httptrap/abc/newsecret",
+			custom: "https://trap.example.com/module/httptrap/abc/secret",
+			want:   "https://trap.example.com/module/httptrap/abc/newsecret",
+		},
+		{
+			name:   "scheme",
+			surl:   "https://10.1.2.3:43191/module/httptrap/abc/newsecret",
+			custom: "http://127.0.0.1:8080/write/local",
+			want:   "http://127.0.0.1:8080/module/httptrap/abc/newsecret",
+		},
+		{name: "invalid custom url", surl: "https://10.1.2.3:43191/module/httptrap/abc/s", custom: "ftp://trap.example.com/", wantErr: true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := withCustomURLHost(tt.surl, tt.custom)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("withCustomURLHost() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("withCustomURLHost() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -9,189 +9,308 @@ import (
 	"bytes"
 	"compress/gzip"
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/hashicorp/go-retryablehttp"
-
-	"github.com/circonus-labs/go-trapcheck/internal/release"
 )
 
 type TrapResult struct {
-	CheckUUID       string        `json:"check_uuid"`
-	Error           string        `json:"error,omitempty"`
-	SubmitUUID      string        `json:"submit_uuid"`
-	Filtered        uint64        `json:"filtered,omitempty"`
-	Stats           uint64        `json:"stats"`
-	SubmitDuration  time.Duration `json:"submit_dur"`
-	LastReqDuration time.Duration `json:"last_req_dur"`
-	BytesSent       int           `json:"bytes_sent"`
-	BytesSentGzip   int           `json:"bytes_sent_gz"`
+	CheckUUID         string        `json:"check_uuid"`
+	Error             string        `json:"error,omitempty"`
+	SubmitUUID        string        `json:"submit_uuid"`
+	FinalURL          string        `json:"final_url"` // url which accepted the submission, after redirects (check secret masked)
+	Filtered          uint64        `json:"filtered,omitempty"`
+	Stats             uint64        `json:"stats"`
+	SubmitDuration    time.Duration `json:"submit_dur"`
+	LastReqDuration   time.Duration `json:"last_req_dur"`
+	BytesSent         int           `json:"bytes_sent"`
+	BytesSentGzip     int           `json:"bytes_sent_gz"`
+	UncompressedBytes int           `json:"uncompressed_bytes"` // -1 (unknown) for pre-compressed payloads
+	Compressed        bool          `json:"compressed"`         // payload sent compressed (gzip, or pre-compressed by the caller)
+	// timing of the final (successful) attempt
+	DNSTime     time.Duration `json:"dns_dur"`
+	ConnectTime time.Duration `json:"connect_dur"`
+	TLSTime     time.Duration `json:"tls_dur"`
+	TTFB        time.Duration `json:"ttfb"`
+	// Attempts timing of every attempt, including retries, in order
+	Attempts []AttemptTiming `json:"attempts,omitempty"`
+}
+
+// HasError returns true if the broker reported an error in the response. Error is
+// set to "none" when there was no error (kept for backward compatibility), use
+// HasError rather than comparing the string.
+func (r *TrapResult) HasError() bool {
+	return r != nil && r.Error != "" && r.Error != "none"
+}
+
+// BrokerError is the broker reported error, returned when Config.ErrorOnBrokerError is set.
+type BrokerError struct {
+	Result  *TrapResult
+	Message string
+}
+
+func (e *BrokerError) Error() string {
+	return ErrBrokerReportedError.Error() + ": " + e.Message
 }
 
+func (e *BrokerError) Unwrap() error {
+	return ErrBrokerReportedError
+}
+
+// ErrAllMetricsFiltered is returned (with the TrapResult) when Config.ErrorOnAllFiltered
+// is set and the broker filtered every submitted metric (e.g. misconfigured metric filters).
+var ErrAllMetricsFiltered = errors.New("all metrics filtered by broker")
+
+// ErrBrokerReportedError is returned (wrapped in a *BrokerError, with the TrapResult) when
+// Config.ErrorOnBrokerError is set and the broker reported an error in a 200 response.
+var ErrBrokerReportedError = errors.New("broker reported error")
+
+// ErrRateLimited is returned (wrapped) when the broker asks for submissions
+// to be retried later (Retry-After) beyond the submission timeout/deadline.
+var ErrRateLimited = errors.New("rate limited by broker")
+
+// ErrResponseTooLarge is returned (wrapped) when the broker response body exceeds
+// Config.MaxResponseBytes, the response is not parsed.
+var ErrResponseTooLarge = errors.New("broker response too large")
+
+// ErrSubmissionUnauthorized is returned (wrapped) when the broker rejects the
+// submission with 401/403, e.g. a stale check secret after a secret rotation.
+// The check is refreshed and the submission retried once unless a custom
+// submission url is used.
+var ErrSubmissionUnauthorized = errors.New("submission unauthorized by broker")
+
+// SubmitIDHeader request header carrying the submit UUID (TrapResult.SubmitUUID),
+// to correlate client and broker/agent logs.
+const SubmitIDHeader = "X-Circonus-Submit-ID"
+
 const (
 	compressionThreshold     = 1024
+	compressionRatioLow      = 0.1 // compressed to <10% of the original size
+	compressionRatioHigh     = 0.8 // compression saved less than 20%
 	traceTSFormat            = "20060102_150405.000000000"
 	defaultSubmissionTimeout = "10s"
+	defaultMaxResponseBytes  = 1 << 20 // 1MB
+)
+
+// Content encodings supported for pre-compressed payloads.
+const (
+	EncodingGzip = "gzip"
+	EncodingZstd = "zstd"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
 )
 
+// validateEncodedPayload verifies the payload starts with the magic bytes of the declared encoding.
+func validateEncodedPayload(payload []byte, encoding string) error {
+	var magic []byte
+	switch encoding {
+	case EncodingGzip:
+		magic = gzipMagic
+	case EncodingZstd:
+		magic = zstdMagic
+	default:
+		return fmt.Errorf("unsupported content encoding (%s)", encoding)
+	}
+	if !bytes.HasPrefix(payload, magic) {
+		return fmt.Errorf("payload is not %s encoded (magic bytes mismatch)", encoding)
+	}
+	return nil
+}
+
 func (tc *TrapCheck) submit(ctx context.Context, metrics bytes.Buffer) (*TrapResult, bool, error) {
+	return tc.submitEncoded(ctx, metrics, "")
+}
 
-	metricLen := metrics.Len()
+// submitEncoded submits metrics, if encoding is set the metrics are
+// already compressed with it and are sent as is. The check secret is
+// masked in any returned error.
+func (tc *TrapCheck) submitEncoded(ctx context.Context, metrics bytes.Buffer, encoding string) (*TrapResult, bool, error) {
+	ctx, span := tc.startSpan(ctx, SpanSubmit)
+	result, refresh, err := tc.submitPayload(ctx, metrics, encoding)
+	tc.endSubmitSpan(span, result, err)
+	return result, refresh, err
+}
+
+// gzipPayload returns the gzip compressed payload.
+func gzipPayload(payload []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	n, err := zw.Write(payload)
+	if err != nil {
+		return nil, fmt.Errorf("compressing metrics: %w", err)
+	}
+	if n != len(payload) {
+		return nil, fmt.Errorf("gzwrite length mismatch, expected %d bytes, got %d (compressed: true)", len(payload), n)
+	}
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("closing gzip writer: %w", err)
+	}
+	return buf.Bytes(), nil
+}
 
-	if metricLen == 0 {
+// submitPayload submits the metrics, the check secret of the submission url
+// used is masked in any returned error.
+func (tc *TrapCheck) submitPayload(ctx context.Context, metrics bytes.Buffer, encoding string) (*TrapResult, bool, error) {
+	if metrics.Len() == 0 {
 		return nil, false, fmt.Errorf("zero length data, no metrics to submit")
 	}
 
-	start := time.Now()
+	start := tc.clock().Now()
 
-	if err := tc.setBrokerTLSConfig(); err != nil {
-		return nil, false, fmt.Errorf("unable to set TLS config: %w", err)
+	sid, err := uuid.NewRandom()
+	if err != nil {
+		return nil, false, fmt.Errorf("creating new submit ID: %w", err)
 	}
+	submitUUID := sid.String()
 
-	var client *http.Client
-
-	if tc.tlsConfig != nil {
-		client = &http.Client{
-			Transport: &http.Transport{
-				Proxy: http.ProxyFromEnvironment,
-				DialContext: (&net.Dialer{
-					Timeout:       10 * time.Second,
-					KeepAlive:     3 * time.Second,
-					FallbackDelay: -1 * time.Millisecond,
-				}).DialContext,
-				TLSClientConfig:     tc.tlsConfig,
-				TLSHandshakeTimeout: 10 * time.Second,
-				DisableKeepAlives:   true,
-				DisableCompression:  false,
-				MaxIdleConns:        1,
-				MaxIdleConnsPerHost: 0,
-			},
-			Timeout: tc.submissionTimeout,
-		}
-	} else {
-		client = &http.Client{
-			Transport: &http.Transport{
-				Proxy: http.ProxyFromEnvironment,
-				DialContext: (&net.Dialer{
-					Timeout:       10 * time.Second,
-					KeepAlive:     3 * time.Second,
-					FallbackDelay: -1 * time.Millisecond,
-				}).DialContext,
-				DisableKeepAlives:   true,
-				DisableCompression:  false,
-				MaxIdleConns:        1,
-				MaxIdleConnsPerHost: 0,
-			},
-			Timeout: tc.submissionTimeout,
-		}
+	st, err := tc.prepareSubmit(submitUUID)
+	if err != nil {
+		return nil, false, redactError(err, tc.submissionSecret())
 	}
 
-	submitUUID := "n/a"
+	result, refresh, err := tc.submitWithState(ctx, st, submitUUID, start, metrics, encoding)
+	return result, refresh, redactError(err, st.secret)
+}
 
-	payloadIsCompressed := false
-	reader := bytes.NewReader(metrics.Bytes())
-	subData := new(bytes.Buffer)
-	if metricLen > compressionThreshold {
-		zw := gzip.NewWriter(subData)
-		n, e1 := io.Copy(zw, reader)
-		// n, e1 := zw.Write(metrics.Bytes())
-		if e1 != nil {
-			return nil, false, fmt.Errorf("compressing metrics: %w", e1)
-		}
-		if int(n) != metricLen {
-			return nil, false, fmt.Errorf("gzwrite length mismatch data length %d != written length %d", metricLen, n)
-		}
-		if e2 := zw.Close(); e2 != nil {
-			return nil, false, fmt.Errorf("closing gzip writer: %w", e2)
-		}
-		payloadIsCompressed = true
-	} else {
-		n, e1 := io.Copy(subData, reader)
-		// n, e1 := subData.Write(metrics.Bytes())
-		if e1 != nil {
-			return nil, false, fmt.Errorf("writing metrics to buffer: %w", e1)
-		}
-		if int(n) != metricLen {
-			return nil, false, fmt.Errorf("write length mismatch data length %d != written length %d", metricLen, n)
+// submitWithState submits the metrics using the submission state.
+func (tc *TrapCheck) submitWithState(ctx context.Context, st submitState, submitUUID string, start time.Time, metrics bytes.Buffer, encoding string) (*TrapResult, bool, error) {
+	// the payload is read once, compression and tracing use the same bytes
+	payload := metrics.Bytes()
+	metricLen := len(payload)
+	logger := st.logger
+
+	client := tc.submitClient(st.tlsConfig)
+	timer := tc.instrumentSubmitClient(client)
+
+	// pre-compressed by the caller (encoding set) payloads are sent as is
+	contentEncoding := encoding
+	subData := payload
+	if encoding == "" && metricLen > compressionThreshold {
+		gz, err := gzipPayload(payload)
+		if err != nil {
+			return nil, false, err
 		}
+		subData = gz
+		contentEncoding = EncodingGzip
 	}
 
-	if traceDir := tc.traceMetrics; traceDir != "" {
+	var meta *traceMeta // submission metadata, TraceLevelFull
+	var metaFile string
+
+	if traceDir := tc.traceDir(); traceDir != "" {
+		if tc.traceLevel == TraceLevelFull {
+			meta = &traceMeta{SubmissionURL: redactSubmissionURL(st.url)}
+		}
 		if traceDir == "-" {
-			_, err := reader.Seek(0, io.SeekStart)
-			if err != nil {
-				tc.Log.Warnf("seeking start of metrics: %s", err)
+			if encoding != "" {
+				logger.Infof("metric payload: %d bytes, pre-compressed (%s)", metricLen, encoding)
 			} else {
-				tc.Log.Infof("metric payload: %s", metrics.String())
+				logger.Infof("metric payload: %s", string(payload))
 			}
 		} else {
-			sid, err := uuid.NewRandom()
-			if err != nil {
-				return nil, false, fmt.Errorf("creating new submit ID: %w", err)
-			}
-			submitUUID = sid.String()
-
-			fn := path.Join(traceDir, time.Now().UTC().Format(traceTSFormat)+"_"+submitUUID+".json")
-			if payloadIsCompressed {
+			fn := path.Join(traceDir, tc.clock().Now().UTC().Format(traceTSFormat)+"_"+submitUUID+".json")
+			metaFile = strings.TrimSuffix(fn, ".json") + ".meta.json"
+			switch contentEncoding {
+			case EncodingGzip:
 				fn += ".gz"
+			case EncodingZstd:
+				fn += ".zst"
 			}
 
 			if fh, e1 := os.Create(fn); e1 != nil {
-				tc.Log.Errorf("creating (%s): %s -- skipping submit trace", fn, err)
+				logger.Errorf("creating (%s): %s -- skipping submit trace", fn, e1)
+				meta = nil
+				tc.traceWriteFailed(logger, traceDir)
 			} else {
-				if _, e2 := fh.Write(subData.Bytes()); e2 != nil {
-					tc.Log.Errorf("writing metric trace: %s", e2)
+				_, e2 := fh.Write(subData)
+				if e2 != nil {
+					logger.Errorf("writing metric trace (%s): %s", fn, e2)
 				}
 				if e3 := fh.Close(); e3 != nil {
-					tc.Log.Warnf("closing metric trace (%s): %s", fn, e3)
+					logger.Warnf("closing metric trace (%s): %s", fn, e3)
+				}
+				if e2 != nil {
+					tc.traceWriteFailed(logger, traceDir)
+				} else {
+					tc.traceWritten()
 				}
 			}
 		}
 	}
 
-	dataLen := subData.Len()
+	dataLen := len(subData)
+
+	// submission timeout covers the entire submission (all attempts, including
+	// tls handshake and reading the response) - a shorter caller deadline still wins
+	parent := ctx
+	if tc.submissionTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, tc.submissionTimeout)
+		defer cancel()
+	}
 
 	var reqStart time.Time
-	req, err := retryablehttp.NewRequest("PUT", tc.submissionURL, subData.Bytes())
+	req, err := retryablehttp.NewRequest("PUT", st.url, subData)
 	if err != nil {
 		return nil, false, fmt.Errorf("creating request: %w", err)
 	}
 	req = req.WithContext(ctx)
-	req.Header.Set("User-Agent", release.NAME+"/"+release.VERSION)
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Accept", "application/json")
-	req.Header.Set("Connection", "close")
+	setSubmitHeaders(req.Header, submitUUID, contentEncoding)
 	req.Header.Set("Content-Length", strconv.Itoa(dataLen))
-	if payloadIsCompressed {
-		req.Header.Set("Content-Encoding", "gzip")
-	}
 
 	retries := 0
 
+	if meta != nil {
+		meta.SubmitUUID = submitUUID
+		meta.RequestHeaders = req.Header.Clone()
+		defer func() {
+			meta.Attempts = retries + 1
+			meta.SubmitDuration = tc.clock().Since(start)
+			meta.LastReqDuration = tc.clock().Since(reqStart)
+			tc.writeTraceMeta(logger, meta, metaFile)
+		}()
+	}
+
 	retryClient := retryablehttp.NewClient()
 	retryClient.HTTPClient = client
-	retryClient.Logger = tc.Log // submitLogshim{logh: tc.Log.Logger()}
+	retryClient.Logger = logger
 	retryClient.RetryWaitMin = 50 * time.Millisecond
 	retryClient.RetryWaitMax = 2 * time.Second
 	retryClient.RetryMax = 7
+	retryClient.Backoff = func(min, max time.Duration, attemptNum int, resp *http.Response) time.Duration {
+		if wait, ok := retryAfter(resp, tc.clock().Now()); ok {
+			return wait
+		}
+		return retryablehttp.DefaultBackoff(min, max, attemptNum, resp)
+	}
 	retryClient.RequestLogHook = func(l retryablehttp.Logger, r *http.Request, attempt int) {
 		if attempt > 0 {
-			reqStart = time.Now()
+			reqStart = tc.clock().Now()
 			l.Printf("retrying... %s %d", r.URL.String(), attempt)
 			retries++
 		}
 	}
 
 	retryClient.ResponseLogHook = func(l retryablehttp.Logger, r *http.Response) {
+		if tc.responseHook != nil {
+			tc.responseHook(r)
+		}
 		if r.StatusCode != http.StatusOK {
 			l.Printf("non-200 response %s: %s", r.Request.URL.String(), r.Status)
 			if r.StatusCode == http.StatusNotAcceptable {
@@ -221,50 +340,442 @@ func (tc *TrapCheck) submit(ctx context.Context, metrics bytes.Buffer) (*TrapRes
 		// 	}
 		// }
 
+		var hookErr *requestHookError
+		if errors.As(origErr, &hookErr) {
+			return false, hookErr
+		}
+
+		if errors.Is(origErr, ErrCertPinMismatch) || errors.Is(origErr, ErrRedirectedSubmission) {
+			return false, origErr
+		}
+
+		if tc.strictTLS {
+			if err := strictTLSError(origErr); err != nil {
+				return false, err
+			}
+		}
+
+		if err := clockSkewError(origErr, tc.clock().Now()); err != nil {
+			return false, err
+		}
+
+		if wait, ok := retryAfter(resp, tc.clock().Now()); ok {
+			if deadline, ok := ctx.Deadline(); ok && wait > deadline.Sub(tc.clock().Now()) {
+				return false, fmt.Errorf("%w: %s, retry after %s exceeds deadline", ErrRateLimited, resp.Status, wait)
+			}
+			logger.Warnf("%s - %s: retrying after %s", resp.Status, resp.Request.URL, wait)
+			return true, nil
+		}
+
 		retry, rhErr := retryablehttp.ErrorPropagatedRetryPolicy(ctx, resp, origErr)
 		if retry && rhErr != nil {
-			tc.Log.Warnf("request error (%s): %s (orig:%s)", resp.Request.URL, rhErr, origErr)
+			logger.Warnf("request error (%s): %s (orig:%s)", resp.Request.URL, rhErr, origErr)
 		}
 
 		return retry, nil
 	}
 
-	defer retryClient.HTTPClient.CloseIdleConnections()
+	retryClient.ErrorHandler = func(resp *http.Response, err error, attempts int) (*http.Response, error) {
+		if resp != nil {
+			_, _ = io.CopyN(io.Discard, resp.Body, tc.responseLimit())
+			resp.Body.Close()
+			if err == nil {
+				// retries exhausted on a retryable status (e.g. 5xx, 429)
+				err = &statusError{code: resp.StatusCode, status: resp.Status, url: req.URL.String()}
+			}
+		}
+		return nil, fmt.Errorf("%s %s giving up after %d attempt(s): %w", req.Method, req.URL, attempts, err)
+	}
+
+	if tc.transport == nil {
+		// caller supplied transports may be shared, leave their connections alone
+		defer retryClient.HTTPClient.CloseIdleConnections()
+	}
 
-	reqStart = time.Now()
+	reqStart = tc.clock().Now()
 	resp, err := retryClient.Do(req)
 	if resp != nil {
-		defer resp.Body.Close()
+		defer func() {
+			// drain (bounded) so the connection can be reused
+			_, _ = io.CopyN(io.Discard, resp.Body, tc.responseLimit())
+			resp.Body.Close()
+		}()
 	}
 	if err != nil {
+		if meta != nil {
+			meta.Error = err.Error()
+		}
+		if requestUnsent(err) {
+			err = &unsentError{err: err}
+		}
+		if timedOut, ok := tc.timedOut(parent, ctx); ok {
+			return nil, false, fmt.Errorf("making request, %s: %w", timedOut, err)
+		}
 		return nil, false, fmt.Errorf("making request: %w", err)
 	}
 
-	body, err := io.ReadAll(resp.Body)
+	result, refresh, err := tc.parseSubmitResponse(parent, ctx, st, req.URL.String(), resp, meta)
+	if err != nil {
+		return nil, refresh, err
+	}
+
+	result.CheckUUID = st.checkUUID
+	result.SubmitUUID = submitUUID
+	result.FinalURL = redactSubmissionURL(resp.Request.URL.String())
+	result.SubmitDuration = tc.clock().Since(start)
+	result.LastReqDuration = tc.clock().Since(reqStart)
+	result.BytesSent = metricLen
+	result.BytesSentGzip = dataLen
+	result.UncompressedBytes = metricLen
+	result.Compressed = contentEncoding != ""
+	if encoding != "" {
+		result.UncompressedBytes = -1
+	} else if result.Compressed {
+		logCompressionRatio(logger, metricLen, dataLen)
+	}
+	result.setAttempts(timer.results())
+
+	return tc.checkResult(logger, result)
+}
+
+// instrumentSubmitClient wraps the submission client transport with the
+// attempt timer, request hook and span transports, returns the timer.
+func (tc *TrapCheck) instrumentSubmitClient(client *http.Client) *attemptTimer {
+	timer := &attemptTimer{next: client.Transport}
+	client.Transport = timer
+
+	if tc.requestHook != nil {
+		client.Transport = &hookTransport{next: client.Transport, hook: tc.requestHook}
+	}
+
+	if tc.tracer != nil {
+		client.Transport = &spanTransport{next: client.Transport, tc: tc}
+	}
+
+	return timer
+}
+
+// setSubmitHeaders sets the submission request headers, other than Content-Length.
+func setSubmitHeaders(h http.Header, submitUUID, contentEncoding string) {
+	h.Set("User-Agent", userAgent())
+	h.Set("Content-Type", "application/json")
+	h.Set("Accept", "application/json")
+	h.Set("Connection", "close")
+	h.Set(SubmitIDHeader, submitUUID)
+	if contentEncoding != "" {
+		h.Set("Content-Encoding", contentEncoding)
+	}
+}
+
+// timedOut describes an exceeded submission deadline. The submission timeout is
+// only reported when it fired, a (shorter) caller deadline has no duration.
+func (tc *TrapCheck) timedOut(parent, ctx context.Context) (string, bool) {
+	if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return "", false
+	}
+	if tc.submissionTimeout > 0 && parent.Err() == nil {
+		return fmt.Sprintf("timed out (%s)", tc.submissionTimeout), true
+	}
+	return "timed out", true
+}
+
+// parseSubmitResponse reads and parses the broker response, returns true if
+// the check should be refreshed (404, 401/403).
+func (tc *TrapCheck) parseSubmitResponse(parent, ctx context.Context, st submitState, reqURL string, resp *http.Response, meta *traceMeta) (*TrapResult, bool, error) {
+	logger := st.logger
+	body, truncated, err := readResponseBody(resp.Body, tc.responseLimit())
+	if meta != nil {
+		meta.setResponse(resp, body)
+		if err != nil {
+			meta.Error = err.Error()
+		}
+	}
 	if err != nil {
+		if timedOut, ok := tc.timedOut(parent, ctx); ok {
+			return nil, false, fmt.Errorf("reading response body, %s: %w", timedOut, err)
+		}
 		return nil, false, fmt.Errorf("reading response body: %w", err)
 	}
 
-	if resp.StatusCode == http.StatusNotFound && tc.custSubmissionURL == "" {
-		tc.Log.Warnf("%s - %s: refreshing check", resp.Status, req.URL.String())
-		return nil, true, fmt.Errorf("%s - %s", resp.Status, req.URL.String())
+	if tc.agentMode {
+		switch {
+		case resp.StatusCode == http.StatusNotFound:
+			return nil, false, fmt.Errorf("%w (%s - %s), verify the submission url", ErrAgentEndpointNotFound, resp.Status, reqURL)
+		case resp.StatusCode == http.StatusNoContent, resp.StatusCode == http.StatusOK && len(body) == 0:
+			return &TrapResult{}, false, nil // agent accepted the metrics, no stats
+		}
+	}
+
+	if resp.StatusCode == http.StatusNotFound && st.refreshable {
+		logger.Warnf("%s - %s: refreshing check (%s)", resp.Status, reqURL, RefreshReasonHTTP404)
+		return nil, true, &statusError{code: resp.StatusCode, status: resp.Status, url: reqURL}
+	} else if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		stErr := &statusError{code: resp.StatusCode, status: resp.Status, url: reqURL}
+		if st.refreshable {
+			// the refreshed bundle carries the current (e.g. rotated) secret
+			logger.Warnf("%s - %s: refreshing check (%s)", resp.Status, reqURL, RefreshReasonHTTPUnauthorized)
+			return nil, true, stErr
+		}
+		return nil, false, fmt.Errorf("%w (verify the check secret in the submission url)", stErr)
 	} else if resp.StatusCode != http.StatusOK {
-		return nil, false, fmt.Errorf("%s - %s", resp.Status, req.URL.String())
+		return nil, false, &statusError{code: resp.StatusCode, status: resp.Status, url: reqURL}
+	}
+	if truncated {
+		return nil, false, fmt.Errorf("%w: response truncated at %d bytes", ErrResponseTooLarge, len(body))
 	}
 	var result TrapResult
 	if err := json.Unmarshal(body, &result); err != nil {
 		return nil, false, fmt.Errorf("parsing response (%s): %w", string(body), err)
 	}
+	return &result, false, nil
+}
 
-	result.CheckUUID = tc.checkBundle.CheckUUIDs[0]
-	result.SubmitUUID = submitUUID
-	result.SubmitDuration = time.Since(start)
-	result.LastReqDuration = time.Since(reqStart)
-	result.BytesSent = metricLen
-	result.BytesSentGzip = dataLen
+// setAttempts sets the attempts, and the timings of the last attempt.
+func (r *TrapResult) setAttempts(attempts []AttemptTiming) {
+	r.Attempts = attempts
+	if n := len(r.Attempts); n > 0 {
+		last := r.Attempts[n-1]
+		r.DNSTime = last.DNSTime
+		r.ConnectTime = last.ConnectTime
+		r.TLSTime = last.TLSTime
+		r.TTFB = last.TTFB
+	}
+}
+
+// checkResult applies the broker error and filtered metrics options to the result.
+func (tc *TrapCheck) checkResult(logger Logger, result *TrapResult) (*TrapResult, bool, error) {
 	if result.Error == "" {
 		result.Error = "none"
+	} else if tc.errorOnBrokerError {
+		return result, false, &BrokerError{Message: result.Error, Result: result}
 	}
 
-	return &result, false, nil
+	if result.Filtered > 0 {
+		if result.Stats == 0 && tc.errorOnAllFiltered {
+			return result, false, fmt.Errorf("%w (filtered: %d)", ErrAllMetricsFiltered, result.Filtered)
+		}
+		if tc.filteredWarnThreshold > 0 {
+			if pct := float64(result.Filtered) / float64(result.Stats+result.Filtered); pct > tc.filteredWarnThreshold {
+				logger.Warnf("broker filtered %d of %d metrics (%.1f%%) - check metric filters", result.Filtered, result.Stats+result.Filtered, pct*100)
+			}
+		}
+	}
+
+	return result, false, nil
+}
+
+// responseLimit returns the maximum bytes of a broker response which are read.
+func (tc *TrapCheck) responseLimit() int64 {
+	if tc.maxResponseBytes > 0 {
+		return tc.maxResponseBytes
+	}
+	return defaultMaxResponseBytes
+}
+
+// readResponseBody reads at most max bytes of the response body, truncated
+// is true if the body is larger.
+func readResponseBody(body io.Reader, max int64) ([]byte, bool, error) {
+	data, err := io.ReadAll(io.LimitReader(body, max+1))
+	if int64(len(data)) > max {
+		return data[:max], true, err //nolint:wrapcheck
+	}
+	return data, false, err //nolint:wrapcheck
+}
+
+// submitState is the state a submission uses, a snapshot so a concurrent
+// check refresh does not change the submission url or tls config while the
+// submission is in flight.
+type submitState struct {
+	logger      Logger
+	tlsConfig   *tls.Config
+	url         string
+	secret      string
+	checkUUID   string
+	refreshable bool
+}
+
+// prepareSubmit sets the broker tls config (reset first if the broker CA
+// cert is expiring) and returns the submission state, submitUUID is added
+// to the logger fields if set.
+func (tc *TrapCheck) prepareSubmit(submitUUID string) (submitState, error) {
+	var extra map[string]interface{}
+	if submitUUID != "" {
+		extra = map[string]interface{}{LogFieldSubmitUUID: submitUUID}
+	}
+
+	tc.stateMu.Lock()
+	defer tc.unlockState()
+
+	if tc.caCertExpiring() {
+		tc.redactLogger(tc.logWith(extra)).Warnf("broker CA cert expires %s (refresh window %s), refreshing TLS config", tc.caCertExpiry.Format(time.RFC3339), tc.caCertRefreshWindow)
+		tc.clearTLSConfigLocked(RefreshReasonCACertExpiry)
+	}
+
+	if err := tc.setBrokerTLSConfigLocked(); err != nil {
+		return submitState{}, fmt.Errorf("unable to set TLS config: %w", err)
+	}
+
+	return submitState{
+		logger:      tc.redactLogger(tc.logWith(extra)),
+		tlsConfig:   tc.tlsConfig,
+		url:         tc.submissionURL,
+		secret:      submissionURLSecret(tc.submissionURL),
+		checkUUID:   tc.checkUUID(),
+		refreshable: tc.refreshable(),
+	}, nil
+}
+
+// submitClient returns an http client for the submission url, using the
+// caller's transport if one was configured, otherwise a single use transport
+// (Config.TransportConfig) with the broker TLS config (if any).
+func (tc *TrapCheck) submitClient(tlsConfig *tls.Config) *http.Client {
+	var client *http.Client
+
+	if tc.transport != nil {
+		client = &http.Client{
+			Transport: tc.transport,
+			Timeout:   tc.submissionTimeout,
+		}
+	} else {
+		client = &http.Client{
+			Transport: tc.newSubmitTransport(tlsConfig),
+			Timeout:   tc.submissionTimeout,
+		}
+	}
+	if !tc.allowRedirects {
+		client.CheckRedirect = checkRedirect
+	}
+
+	return client
+}
+
+// retryAfter returns the wait requested by the broker in a Retry-After
+// header (seconds or HTTP-date form, relative to now) on a 409, 429 or 503 response.
+func retryAfter(resp *http.Response, now time.Time) (time.Duration, bool) {
+	if resp == nil {
+		return 0, false
+	}
+	switch resp.StatusCode {
+	case http.StatusConflict, http.StatusTooManyRequests, http.StatusServiceUnavailable:
+	default:
+		return 0, false
+	}
+	val := resp.Header.Get("Retry-After")
+	if val == "" {
+		return 0, false
+	}
+	if secs, err := strconv.ParseInt(val, 10, 64); err == nil {
+		if secs < 0 {
+			secs = 0
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	if when, err := http.ParseTime(val); err == nil {
+		wait := when.Sub(now)
+		if wait < 0 {
+			wait = 0
+		}
+		return wait, true
+	}
+	return 0, false
+}
+
+// logCompressionRatio logs (debug) the compressed/uncompressed ratio when it
+// is unusually low or high, e.g. highly repetitive metric names or payloads
+// which do not benefit from compression.
+func logCompressionRatio(logger Logger, uncompressed, compressed int) {
+	if uncompressed == 0 {
+		return
+	}
+	ratio := float64(compressed) / float64(uncompressed)
+	switch {
+	case ratio < compressionRatioLow:
+		logger.Debugf("compression ratio %.3f (%d -> %d bytes), payload highly compressible", ratio, uncompressed, compressed)
+	case ratio > compressionRatioHigh:
+		logger.Debugf("compression ratio %.3f (%d -> %d bytes), compression saving little", ratio, uncompressed, compressed)
+	}
+}
+
+// statusError is returned when the broker responds with a non-200 status.
+type statusError struct {
+	status string
+	url    string
+	code   int
+}
+
+func (e *statusError) Error() string {
+	return e.status + " - " + e.url
+}
+
+func (e *statusError) Unwrap() error {
+	if e.code == http.StatusUnauthorized || e.code == http.StatusForbidden {
+		return ErrSubmissionUnauthorized
+	}
+	return nil
+}
+
+// unsentError wraps a submission error where the payload never reached the
+// broker, the broker could not be connected to or the deadline passed while
+// waiting to retry.
+type unsentError struct {
+	err error
+}
+
+func (e *unsentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *unsentError) Unwrap() error {
+	return e.err
+}
+
+// requestUnsent returns true if the request error occurred before the payload
+// was sent. A deadline while a request is in flight (*url.Error) is not
+// included, the broker may have accepted the payload.
+func requestUnsent(err error) bool {
+	var dnsErr *net.DNSError
+	var opErr *net.OpError
+	var urlErr *url.Error
+	switch {
+	case errors.As(err, &dnsErr):
+		return true
+	case errors.As(err, &opErr) && opErr.Op == "dial":
+		return true
+	case errors.Is(err, context.DeadlineExceeded) && !errors.As(err, &urlErr):
+		return true // retryablehttp returns the bare context error while waiting to retry
+	}
+	return false
+}
+
+// requestHookError wraps an error returned by the caller's request hook.
+type requestHookError struct {
+	err error
+}
+
+func (e *requestHookError) Error() string {
+	return "request hook: " + e.err.Error()
+}
+
+func (e *requestHookError) Unwrap() error {
+	return e.err
+}
+
+// hookTransport calls the caller's request hook before each attempt is sent.
+type hookTransport struct {
+	next    http.RoundTripper
+	hook    func(req *http.Request, attempt int) error
+	attempt int
+}
+
+func (ht *hookTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	attempt := ht.attempt
+	ht.attempt++
+	req = req.Clone(req.Context()) // round trippers must not modify the original request
+	if err := ht.hook(req, attempt); err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, &requestHookError{err: err}
+	}
+	return ht.next.RoundTrip(req) //nolint:wrapcheck
 }
@@ -0,0 +1,111 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+)
+
+const defaultMaxInflightSubmissions = 1
+
+// ErrTooManyInflight is returned (wrapped) by SubmitAsync when
+// Config.MaxInflightSubmissions submissions are already in flight, the
+// metrics are not queued.
+var ErrTooManyInflight = errors.New("too many in-flight submissions")
+
+// SubmitAsync submits the metrics (copied, the buffer may be reused) in the
+// background, using SendMetrics, and calls cb with the outcome. At most
+// Config.MaxInflightSubmissions submissions are in flight, when the limit is
+// reached SubmitAsync returns ErrTooManyInflight immediately and cb is not
+// called. For every accepted submission cb is called exactly once, from a
+// separate goroutine - submissions in flight when Close is called are
+// canceled and cb receives an error wrapping ErrClosed. Close waits for the
+// submissions, not for the callbacks (cb may call Close). Returns ErrClosed
+// after Close.
+func (tc *TrapCheck) SubmitAsync(ctx context.Context, metrics bytes.Buffer, cb func(*TrapResult, error)) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if metrics.Len() == 0 {
+		return fmt.Errorf("no metrics to submit")
+	}
+	if cb == nil {
+		return fmt.Errorf("invalid callback (nil)")
+	}
+
+	tc.asyncRefreshMu.Lock()
+	if tc.closed {
+		tc.asyncRefreshMu.Unlock()
+		return ErrClosed
+	}
+	if tc.inflight == nil {
+		n := tc.maxInflight
+		if n <= 0 {
+			n = defaultMaxInflightSubmissions
+		}
+		tc.inflight = make(chan struct{}, n)
+		tc.inflightClose = make(chan struct{})
+	}
+	select {
+	case tc.inflight <- struct{}{}:
+	default:
+		tc.asyncRefreshMu.Unlock()
+		return fmt.Errorf("%w (max %d)", ErrTooManyInflight, cap(tc.inflight))
+	}
+	tc.inflightWG.Add(1)
+	closing := tc.inflightClose
+	tc.asyncRefreshMu.Unlock()
+
+	payload := bytes.NewBuffer(append([]byte(nil), metrics.Bytes()...))
+
+	go func() {
+		subCtx, cancel := context.WithCancel(ctx)
+		done := make(chan struct{})
+		go func() {
+			select {
+			case <-closing:
+				cancel()
+			case <-done:
+			}
+		}()
+
+		result, err := tc.SendMetrics(subCtx, *payload)
+		close(done)
+		cancel()
+		if err != nil {
+			select {
+			case <-closing:
+				err = fmt.Errorf("%w: %s", ErrClosed, err)
+			default:
+			}
+		}
+
+		// release the slot and complete the submission first, cb may
+		// submit again or call Close
+		<-tc.inflight
+		tc.inflightWG.Done()
+		cb(result, err)
+	}()
+
+	return nil
+}
+
+// stopAsyncSubmissions cancels in-flight SubmitAsync submissions and waits
+// for them to complete (their callbacks have been started), tc.closed must
+// already be set.
+func (tc *TrapCheck) stopAsyncSubmissions() {
+	tc.asyncRefreshMu.Lock()
+	if tc.inflightClose != nil && !tc.inflightClosed {
+		close(tc.inflightClose)
+		tc.inflightClosed = true
+	}
+	tc.asyncRefreshMu.Unlock()
+
+	tc.inflightWG.Wait()
+}
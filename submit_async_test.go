@@ -0,0 +1,246 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/circonus-labs/go-apiclient"
+	"github.com/circonus-labs/go-apiclient/config"
+	"github.com/circonus-labs/go-trapcheck/trapchecktest"
+)
+
+func newAsyncTestTrapCheck(fb *trapchecktest.FakeBroker, maxInflight int) *TrapCheck {
+	tc := newTestTrapCheck(fb.SubmissionURL("abc-123", "secret"))
+	tc.custSubmissionURL = tc.submissionURL
+	tc.maxInflight = maxInflight
+	return tc
+}
+
+func TestTrapCheck_SubmitAsync_concurrent(t *testing.T) {
+	fb := trapchecktest.NewFakeBroker(t)
+	fb.SetDelay(time.Millisecond)
+	tc := newAsyncTestTrapCheck(fb, 4)
+
+	var accepted, rejected, callbacks, failed int64
+	var wg, cbWG sync.WaitGroup
+	for p := 0; p < 32; p++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var metrics bytes.Buffer
+			for i := 0; i < 25; i++ {
+				metrics.Reset()
+				metrics.WriteString(`{"foo":{"_type":"n","_value":1}}`)
+				cbWG.Add(1)
+				err := tc.SubmitAsync(context.Background(), metrics, func(result *TrapResult, err error) {
+					defer cbWG.Done()
+					atomic.AddInt64(&callbacks, 1)
+					if err != nil || result == nil || result.Stats != 1 {
+						atomic.AddInt64(&failed, 1)
+					}
+				})
+				if err != nil {
+					cbWG.Done()
+				}
+				switch {
+				case err == nil:
+					atomic.AddInt64(&accepted, 1)
+				case errors.Is(err, ErrTooManyInflight):
+					atomic.AddInt64(&rejected, 1)
+					time.Sleep(time.Millisecond)
+				default:
+					t.Errorf("TrapCheck.SubmitAsync() error = %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	cbWG.Wait() // submissions still in flight at Close are canceled
+	if err := tc.Close(); err != nil {
+		t.Fatalf("TrapCheck.Close() error = %v", err)
+	}
+
+	if accepted == 0 {
+		t.Fatal("TrapCheck.SubmitAsync() accepted no submissions")
+	}
+	if callbacks != accepted {
+		t.Errorf("callbacks = %d, want %d (accepted)", callbacks, accepted)
+	}
+	if failed != 0 {
+		t.Errorf("failed callbacks = %d, want 0", failed)
+	}
+	if n := int64(len(fb.Submissions())); n != accepted {
+		t.Errorf("broker submissions = %d, want %d", n, accepted)
+	}
+	if accepted+rejected != 32*25 {
+		t.Errorf("accepted %d + rejected %d, want %d", accepted, rejected, 32*25)
+	}
+}
+
+func TestTrapCheck_SubmitAsync_concurrentRefresh(t *testing.T) {
+	const submissions = 64
+
+	fb := trapchecktest.NewFakeTLSBroker(t, "broker.example.com")
+	fb.SetDelay(time.Millisecond)
+	api := trapchecktest.NewFakeAPI(fb)
+	api.AddBroker("/broker/2", fb)
+	logger := &LogWrapper{Log: log.New(io.Discard, "", log.LstdFlags)}
+	initTestBrokerList(t, api, logger) // broker list is shared, use the fake api brokers
+
+	bundle := api.AddCheckBundle(apiclient.CheckBundle{
+		CID:        "/check_bundle/1",
+		Brokers:    []string{"/broker/1"},
+		CheckUUIDs: []string{"abc-123"},
+		Type:       "httptrap",
+		Config:     apiclient.CheckBundleConfig{config.SubmissionURL: fb.SubmissionURL("abc-123", "secret")},
+		Status:     statusActive,
+	})
+	tc, err := NewFromCheckBundle(&Config{
+		Client:                 api,
+		BundleRecheckInterval:  "1ms",
+		RefreshCooldown:        "1ms", // the recheck refreshes too, do not suppress the 404 refresh
+		MaxInflightSubmissions: submissions,
+		Logger:                 logger,
+	}, &bundle)
+	if err != nil {
+		t.Fatalf("NewFromCheckBundle() error = %v", err)
+	}
+
+	var failed int64
+	var cbWG sync.WaitGroup
+	submit := func(n int) {
+		for i := 0; i < n; i++ {
+			var metrics bytes.Buffer
+			metrics.WriteString(`{"foo":{"_type":"n","_value":1}}`)
+			cbWG.Add(1)
+			err := tc.SubmitAsync(context.Background(), metrics, func(result *TrapResult, err error) {
+				defer cbWG.Done()
+				if err != nil || result == nil || result.Stats != 1 {
+					t.Errorf("SubmitAsync callback result = %v, error = %v", result, err)
+					atomic.AddInt64(&failed, 1)
+				}
+			})
+			if err != nil {
+				cbWG.Done()
+				t.Fatalf("TrapCheck.SubmitAsync() error = %v", err)
+			}
+		}
+	}
+
+	submit(submissions / 2)
+	// the check moves while submissions are in flight, the 404s force a
+	// refresh which switches the broker and its tls config
+	if err := api.MoveCheckBundle(bundle.CID, "/broker/2"); err != nil {
+		t.Fatalf("FakeAPI.MoveCheckBundle() error = %v", err)
+	}
+	fb.RespondNotFound(submissions / 4)
+	submit(submissions / 2)
+	cbWG.Wait()
+	if err := tc.Close(); err != nil {
+		t.Fatalf("TrapCheck.Close() error = %v", err)
+	}
+
+	if failed != 0 {
+		t.Errorf("failed callbacks = %d, want 0", failed)
+	}
+	if tc.RefreshStats()[string(RefreshReasonHTTP404)] == 0 {
+		t.Error("TrapCheck.RefreshStats() no 404 refresh")
+	}
+	broker, err := tc.GetSelectedBroker()
+	if err != nil {
+		t.Fatalf("TrapCheck.GetSelectedBroker() error = %v", err)
+	}
+	if broker.CID != "/broker/2" {
+		t.Errorf("TrapCheck.GetSelectedBroker() = %s, want /broker/2", broker.CID)
+	}
+	if _, err := tc.GetBrokerTLSConfig(); err != nil {
+		t.Errorf("TrapCheck.GetBrokerTLSConfig() error = %v", err)
+	}
+}
+
+func TestTrapCheck_SubmitAsync_close(t *testing.T) {
+	fb := trapchecktest.NewFakeBroker(t)
+	fb.SetDelay(5 * time.Second)
+	tc := newAsyncTestTrapCheck(fb, 0) // default, 1 in flight
+
+	var metrics bytes.Buffer
+	metrics.WriteString(`{"foo":{"_type":"n","_value":1}}`)
+
+	var calls int32
+	errCh := make(chan error, 2)
+	cb := func(result *TrapResult, err error) {
+		atomic.AddInt32(&calls, 1)
+		errCh <- err
+	}
+
+	if err := tc.SubmitAsync(context.Background(), metrics, cb); err != nil {
+		t.Fatalf("TrapCheck.SubmitAsync() error = %v", err)
+	}
+	if err := tc.SubmitAsync(context.Background(), metrics, cb); !errors.Is(err, ErrTooManyInflight) {
+		t.Fatalf("TrapCheck.SubmitAsync() error = %v, want %v", err, ErrTooManyInflight)
+	}
+
+	start := time.Now()
+	if err := tc.Close(); err != nil {
+		t.Fatalf("TrapCheck.Close() error = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("TrapCheck.Close() took %s, in flight submission not canceled", elapsed)
+	}
+
+	select {
+	case err := <-errCh:
+		if !errors.Is(err, ErrClosed) {
+			t.Errorf("callback error = %v, want %v", err, ErrClosed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("callback not called")
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Fatalf("callbacks = %d, want 1", n)
+	}
+
+	if err := tc.SubmitAsync(context.Background(), metrics, cb); !errors.Is(err, ErrClosed) {
+		t.Errorf("TrapCheck.SubmitAsync() after close error = %v, want %v", err, ErrClosed)
+	}
+}
+
+func TestTrapCheck_SubmitAsync_closeFromCallback(t *testing.T) {
+	fb := trapchecktest.NewFakeBroker(t)
+	tc := newAsyncTestTrapCheck(fb, 0)
+
+	var metrics bytes.Buffer
+	metrics.WriteString(`{"foo":{"_type":"n","_value":1}}`)
+
+	closed := make(chan error, 1)
+	err := tc.SubmitAsync(context.Background(), metrics, func(result *TrapResult, err error) {
+		closed <- tc.Close() // e.g. shut down on error
+	})
+	if err != nil {
+		t.Fatalf("TrapCheck.SubmitAsync() error = %v", err)
+	}
+
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Errorf("TrapCheck.Close() error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("TrapCheck.Close() from the callback deadlocked")
+	}
+	if err := tc.SubmitAsync(context.Background(), metrics, func(*TrapResult, error) {}); !errors.Is(err, ErrClosed) {
+		t.Errorf("TrapCheck.SubmitAsync() after close error = %v, want %v", err, ErrClosed)
+	}
+}
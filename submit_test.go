@@ -0,0 +1,1002 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/circonus-labs/go-apiclient"
+	"github.com/circonus-labs/go-trapcheck/testsupport"
+	"github.com/circonus-labs/go-trapcheck/trapchecktest"
+	"github.com/google/uuid"
+)
+
+func TestTrapCheck_submitTimeout(t *testing.T) {
+	done := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-done:
+		}
+		fmt.Fprintln(w, `{"stats":1}`)
+	}))
+	defer ts.Close()
+	defer close(done)
+
+	tests := []struct {
+		name              string
+		wantMsg           string
+		submissionTimeout time.Duration
+		ctxTimeout        time.Duration
+	}{
+		{name: "submission timeout", submissionTimeout: 200 * time.Millisecond, wantMsg: "timed out (200ms)"},
+		{name: "shorter caller deadline", submissionTimeout: 5 * time.Second, ctxTimeout: 200 * time.Millisecond, wantMsg: "timed out:"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			tc := &TrapCheck{
+				checkBundle:       &apiclient.CheckBundle{},
+				custSubmissionURL: ts.URL,
+				submissionURL:     ts.URL,
+				submissionTimeout: tt.submissionTimeout,
+			}
+			tc.Log = &LogWrapper{
+				Log:   log.New(io.Discard, "", log.LstdFlags),
+				Debug: false,
+			}
+
+			ctx := context.Background()
+			if tt.ctxTimeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.ctxTimeout)
+				defer cancel()
+			}
+
+			var metrics bytes.Buffer
+			metrics.WriteString(`{"foo":{"_type":"n","_value":1}}`)
+
+			start := time.Now()
+			_, _, err := tc.submit(ctx, metrics)
+			elapsed := time.Since(start)
+
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Fatalf("TrapCheck.submit() error = %v, want %v", err, context.DeadlineExceeded)
+			}
+			if !strings.Contains(err.Error(), tt.wantMsg) {
+				t.Errorf("TrapCheck.submit() error = %v, want %q", err, tt.wantMsg)
+			}
+			if elapsed > 2*time.Second {
+				t.Errorf("TrapCheck.submit() took %s, expected to abort at timeout", elapsed)
+			}
+		})
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		resp   *http.Response
+		name   string
+		want   time.Duration
+		wantOK bool
+	}{
+		{name: "nil response"},
+		{name: "200", resp: &http.Response{StatusCode: http.StatusOK, Header: http.Header{"Retry-After": []string{"5"}}}},
+		{name: "429 no header", resp: &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{}}},
+		{name: "429 invalid header", resp: &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{"Retry-After": []string{"foo"}}}},
+		{name: "429 seconds", resp: &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{"Retry-After": []string{"5"}}}, want: 5 * time.Second, wantOK: true},
+		{name: "409 seconds", resp: &http.Response{StatusCode: http.StatusConflict, Header: http.Header{"Retry-After": []string{"1"}}}, want: time.Second, wantOK: true},
+		{name: "503 past date", resp: &http.Response{StatusCode: http.StatusServiceUnavailable, Header: http.Header{"Retry-After": []string{"Wed, 21 Oct 2015 07:28:00 GMT"}}}, want: 0, wantOK: true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := retryAfter(tt.resp, time.Now())
+			if ok != tt.wantOK {
+				t.Fatalf("retryAfter() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if got != tt.want {
+				t.Errorf("retryAfter() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("503 future date", func(t *testing.T) {
+		now := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+		when := now.Add(time.Minute).Format(http.TimeFormat)
+		got, ok := retryAfter(&http.Response{StatusCode: http.StatusServiceUnavailable, Header: http.Header{"Retry-After": []string{when}}}, now)
+		if !ok || got != time.Minute {
+			t.Errorf("retryAfter() = %s, %v, want 1m", got, ok)
+		}
+	})
+}
+
+func TestTrapCheck_submitRetryAfter(t *testing.T) {
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/busy" {
+			w.Header().Set("Retry-After", "30")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		if atomic.AddInt32(&requests, 1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		fmt.Fprintln(w, `{"stats":1}`)
+	}))
+	defer ts.Close()
+
+	tc := &TrapCheck{
+		checkBundle:       &apiclient.CheckBundle{},
+		custSubmissionURL: ts.URL,
+		submissionURL:     ts.URL,
+		submissionTimeout: 5 * time.Second,
+	}
+	tc.Log = &LogWrapper{
+		Log:   log.New(io.Discard, "", log.LstdFlags),
+		Debug: false,
+	}
+
+	var metrics bytes.Buffer
+	metrics.WriteString(`{"foo":{"_type":"n","_value":1}}`)
+
+	start := time.Now()
+	result, _, err := tc.submit(context.Background(), metrics)
+	if err != nil {
+		t.Fatalf("TrapCheck.submit() error = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("TrapCheck.submit() took %s, expected to wait for Retry-After (1s)", elapsed)
+	}
+	if result.Stats != 1 {
+		t.Errorf("TrapCheck.submit() stats = %d, want 1", result.Stats)
+	}
+
+	// requested wait exceeds the submission timeout, fail fast
+	tc.submissionURL = ts.URL + "/busy"
+	start = time.Now()
+	_, _, err = tc.submit(context.Background(), metrics)
+	if !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("TrapCheck.submit() error = %v, want %v", err, ErrRateLimited)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("TrapCheck.submit() took %s, expected to fail fast", elapsed)
+	}
+}
+
+func TestTrapCheck_submitRetryAfter_clock(t *testing.T) {
+	fc := testsupport.NewFakeClock(time.Now())
+	retryAt := fc.Now().Add(2 * time.Second).UTC().Format(http.TimeFormat)
+
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/busy" {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		if atomic.AddInt32(&requests, 1) == 1 {
+			w.Header().Set("Retry-After", retryAt)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, `{"stats":1}`)
+	}))
+	defer ts.Close()
+
+	tc := &TrapCheck{
+		checkBundle:       &apiclient.CheckBundle{},
+		custSubmissionURL: ts.URL,
+		submissionURL:     ts.URL,
+		submissionTimeout: 5 * time.Second,
+		clk:               fc,
+	}
+	tc.Log = &LogWrapper{Log: log.New(io.Discard, "", log.LstdFlags)}
+
+	var metrics bytes.Buffer
+	metrics.WriteString(`{"foo":{"_type":"n","_value":1}}`)
+
+	// the Retry-After date has passed on the clock, retried without waiting
+	fc.Advance(4 * time.Second)
+	start := time.Now()
+	if _, _, err := tc.submit(context.Background(), metrics); err != nil {
+		t.Fatalf("TrapCheck.submit() error = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed >= time.Second {
+		t.Errorf("TrapCheck.submit() took %s, want the Retry-After date relative to the clock", elapsed)
+	}
+
+	// the clock is past the submission deadline, the requested wait exceeds it
+	fc.Advance(time.Minute)
+	tc.submissionURL = ts.URL + "/busy"
+	if _, _, err := tc.submit(context.Background(), metrics); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("TrapCheck.submit() error = %v, want %v", err, ErrRateLimited)
+	}
+}
+
+func TestTrapCheck_submitFiltered(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/all":
+			fmt.Fprintln(w, `{"stats":0,"filtered":10}`)
+		case "/partial":
+			fmt.Fprintln(w, `{"stats":2,"filtered":8}`)
+		default:
+			fmt.Fprintln(w, `{"stats":10}`)
+		}
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		name               string
+		path               string
+		threshold          float64
+		errorOnAllFiltered bool
+		wantErr            bool
+		wantWarn           bool
+	}{
+		{name: "none filtered", path: "/", threshold: 0.5, errorOnAllFiltered: true},
+		{name: "all filtered, no error", path: "/all"},
+		{name: "all filtered, error", path: "/all", errorOnAllFiltered: true, wantErr: true},
+		{name: "partial, below threshold", path: "/partial", threshold: 0.9},
+		{name: "partial, above threshold", path: "/partial", threshold: 0.5, wantWarn: true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			tc := &TrapCheck{
+				checkBundle:           &apiclient.CheckBundle{},
+				custSubmissionURL:     ts.URL,
+				submissionURL:         ts.URL + tt.path,
+				submissionTimeout:     5 * time.Second,
+				filteredWarnThreshold: tt.threshold,
+				errorOnAllFiltered:    tt.errorOnAllFiltered,
+			}
+			tc.Log = &LogWrapper{
+				Log:   log.New(&logs, "", log.LstdFlags),
+				Debug: false,
+			}
+
+			var metrics bytes.Buffer
+			metrics.WriteString(`{"foo":{"_type":"n","_value":1}}`)
+			result, err := tc.SendMetrics(context.Background(), metrics)
+			if errors.Is(err, ErrAllMetricsFiltered) != tt.wantErr {
+				t.Fatalf("TrapCheck.SendMetrics() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if result == nil {
+				t.Fatal("TrapCheck.SendMetrics() result is nil")
+			}
+			if warned := strings.Contains(logs.String(), "filtered"); warned != tt.wantWarn {
+				t.Errorf("filtered warning logged = %v, want %v (%s)", warned, tt.wantWarn, logs.String())
+			}
+		})
+	}
+}
+
+func TestTrapCheck_submitBrokerError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/error":
+			fmt.Fprintln(w, `{"stats":1,"error":"parse error at offset 12"}`)
+		default:
+			fmt.Fprintln(w, `{"stats":2}`)
+		}
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		name               string
+		path               string
+		errorOnBrokerError bool
+		wantErr            bool
+		wantHasError       bool
+	}{
+		{name: "no error", path: "/", errorOnBrokerError: true},
+		{name: "error, flag off", path: "/error", wantHasError: true},
+		{name: "error, flag on", path: "/error", errorOnBrokerError: true, wantErr: true, wantHasError: true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			tc := &TrapCheck{
+				checkBundle:        &apiclient.CheckBundle{},
+				custSubmissionURL:  ts.URL,
+				submissionURL:      ts.URL + tt.path,
+				submissionTimeout:  5 * time.Second,
+				errorOnBrokerError: tt.errorOnBrokerError,
+			}
+			tc.Log = &LogWrapper{
+				Log:   log.New(io.Discard, "", log.LstdFlags),
+				Debug: false,
+			}
+
+			var metrics bytes.Buffer
+			metrics.WriteString(`{"foo":{"_type":"n","_value":1}}`)
+			result, err := tc.SendMetrics(context.Background(), metrics)
+			if errors.Is(err, ErrBrokerReportedError) != tt.wantErr {
+				t.Fatalf("TrapCheck.SendMetrics() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if result == nil {
+				t.Fatal("TrapCheck.SendMetrics() result is nil")
+			}
+			if result.HasError() != tt.wantHasError {
+				t.Errorf("TrapResult.HasError() = %v, want %v (%q)", result.HasError(), tt.wantHasError, result.Error)
+			}
+			if !tt.wantHasError && result.Error != "none" {
+				t.Errorf("TrapResult.Error = %q, want none", result.Error)
+			}
+			if tt.wantErr {
+				var brokerErr *BrokerError
+				if !errors.As(err, &brokerErr) {
+					t.Fatalf("TrapCheck.SendMetrics() error = %T, want *BrokerError", err)
+				}
+				if brokerErr.Message != "parse error at offset 12" {
+					t.Errorf("BrokerError.Message = %q", brokerErr.Message)
+				}
+				if brokerErr.Result == nil || brokerErr.Result.Stats != 1 {
+					t.Errorf("BrokerError.Result = %+v, want stats 1", brokerErr.Result)
+				}
+			}
+		})
+	}
+}
+
+func TestTrapCheck_submitHooks(t *testing.T) {
+	var requests int32
+	var gotAuth, gotLen []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = append(gotAuth, r.Header.Get("X-Internal-Auth"))
+		gotLen = append(gotLen, r.Header.Get("Content-Length"))
+		if atomic.AddInt32(&requests, 1) == 1 {
+			http.Error(w, "try again", http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprintln(w, `{"stats":1}`)
+	}))
+	defer ts.Close()
+
+	var attempts []int
+	var statuses []int
+	tc := &TrapCheck{
+		checkBundle:       &apiclient.CheckBundle{},
+		custSubmissionURL: ts.URL,
+		submissionURL:     ts.URL,
+		submissionTimeout: 5 * time.Second,
+		requestHook: func(req *http.Request, attempt int) error {
+			if req.URL.String() != ts.URL {
+				t.Errorf("request hook url = %s, want %s", req.URL, ts.URL)
+			}
+			attempts = append(attempts, attempt)
+			req.Header.Set("X-Internal-Auth", "secret")
+			return nil
+		},
+		responseHook: func(resp *http.Response) {
+			statuses = append(statuses, resp.StatusCode)
+		},
+	}
+	tc.Log = &LogWrapper{
+		Log:   log.New(io.Discard, "", log.LstdFlags),
+		Debug: false,
+	}
+
+	var metrics bytes.Buffer
+	metrics.WriteString(`{"foo":{"_type":"n","_value":1}}`)
+	if _, _, err := tc.submit(context.Background(), metrics); err != nil {
+		t.Fatalf("TrapCheck.submit() error = %v", err)
+	}
+
+	if !reflect.DeepEqual(attempts, []int{0, 1}) {
+		t.Errorf("request hook attempts = %v, want [0 1]", attempts)
+	}
+	if !reflect.DeepEqual(gotAuth, []string{"secret", "secret"}) {
+		t.Errorf("header on the wire = %v, want [secret secret]", gotAuth)
+	}
+	if want := strconv.Itoa(metrics.Len()); gotLen[0] != want {
+		t.Errorf("content length = %s, want %s", gotLen[0], want)
+	}
+	if !reflect.DeepEqual(statuses, []int{http.StatusInternalServerError, http.StatusOK}) {
+		t.Errorf("response hook statuses = %v, want [500 200]", statuses)
+	}
+
+	// hook error aborts the submission
+	hookErr := errors.New("no auth available")
+	tc.requestHook = func(req *http.Request, attempt int) error {
+		return hookErr
+	}
+	before := atomic.LoadInt32(&requests)
+	if _, _, err := tc.submit(context.Background(), metrics); !errors.Is(err, hookErr) {
+		t.Fatalf("TrapCheck.submit() error = %v, want %v", err, hookErr)
+	}
+	if after := atomic.LoadInt32(&requests); after != before {
+		t.Errorf("requests sent after hook error = %d, want 0", after-before)
+	}
+}
+
+func TestTrapCheck_SendCompressedMetrics(t *testing.T) {
+	var gotEncoding string
+	var gotBody []byte
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotEncoding = r.Header.Get("Content-Encoding")
+		gotBody, _ = io.ReadAll(r.Body)
+		fmt.Fprintln(w, `{"stats":1}`)
+	}))
+	defer ts.Close()
+
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	if _, err := zw.Write([]byte(`{"foo":{"_type":"n","_value":1}}`)); err != nil {
+		t.Fatalf("gzip write: %s", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("gzip close: %s", err)
+	}
+	gzData := append([]byte(nil), gz.Bytes()...)
+
+	zstd := []byte{0x28, 0xb5, 0x2f, 0xfd, 0x00, 0x01}
+
+	traceDir := t.TempDir()
+
+	tests := []struct {
+		name     string
+		encoding string
+		wantExt  string
+		payload  []byte
+		wantErr  bool
+	}{
+		{name: "gzip", payload: gzData, encoding: EncodingGzip, wantExt: ".json.gz"},
+		{name: "zstd", payload: zstd, encoding: EncodingZstd, wantExt: ".json.zst"},
+		{name: "invalid, unknown encoding", payload: gzData, encoding: "br", wantErr: true},
+		{name: "invalid, gzip declared zstd", payload: gzData, encoding: EncodingZstd, wantErr: true},
+		{name: "invalid, json declared gzip", payload: []byte(`{"foo":1}`), encoding: EncodingGzip, wantErr: true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			gotEncoding, gotBody = "", nil
+			tc := &TrapCheck{
+				checkBundle:       &apiclient.CheckBundle{},
+				custSubmissionURL: ts.URL,
+				submissionURL:     ts.URL,
+				submissionTimeout: 5 * time.Second,
+				traceMetrics:      traceDir,
+			}
+			tc.Log = &LogWrapper{
+				Log:   log.New(io.Discard, "", log.LstdFlags),
+				Debug: false,
+			}
+
+			var payload bytes.Buffer
+			payload.Write(tt.payload)
+			result, err := tc.SendCompressedMetrics(context.Background(), payload, tt.encoding)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("TrapCheck.SendCompressedMetrics() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if gotBody != nil {
+					t.Error("TrapCheck.SendCompressedMetrics() invalid payload was sent")
+				}
+				return
+			}
+			if gotEncoding != tt.encoding {
+				t.Errorf("Content-Encoding = %q, want %q", gotEncoding, tt.encoding)
+			}
+			if !bytes.Equal(gotBody, tt.payload) {
+				t.Error("payload was modified")
+			}
+			if result.BytesSent != len(tt.payload) || result.UncompressedBytes != -1 {
+				t.Errorf("BytesSent = %d UncompressedBytes = %d, want %d, -1", result.BytesSent, result.UncompressedBytes, len(tt.payload))
+			}
+			traces, err := filepath.Glob(filepath.Join(traceDir, "*_"+result.SubmitUUID+tt.wantExt))
+			if err != nil || len(traces) != 1 {
+				t.Errorf("trace file with %s extension not found (%v)", tt.wantExt, err)
+			}
+		})
+	}
+}
+
+// recordingTransport records requests and returns canned broker responses, in order.
+type recordingTransport struct {
+	requests  []*http.Request
+	bodies    [][]byte
+	responses []string
+	statuses  []int
+}
+
+func (rt *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		data, err := io.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+		body = data
+	}
+	idx := len(rt.requests)
+	rt.requests = append(rt.requests, req)
+	rt.bodies = append(rt.bodies, body)
+	if idx >= len(rt.statuses) {
+		idx = len(rt.statuses) - 1
+	}
+	return &http.Response{
+		StatusCode: rt.statuses[idx],
+		Status:     strconv.Itoa(rt.statuses[idx]) + " " + http.StatusText(rt.statuses[idx]),
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(rt.responses[idx])),
+		Request:    req,
+	}, nil
+}
+
+func TestTrapCheck_submitTransport(t *testing.T) {
+	rt := &recordingTransport{
+		statuses:  []int{http.StatusServiceUnavailable, http.StatusOK},
+		responses: []string{"", `{"stats":1}`},
+	}
+
+	// https without a broker or tls config, the transport handles the connection
+	tc, err := NewFromSubmissionURL(&Config{
+		SubmissionURL:   "https://trap.example.com/write/test",
+		Transport:       rt,
+		SubmitTLSConfig: &tls.Config{ServerName: "foobar", MinVersion: tls.VersionTLS12},
+	})
+	if err != nil {
+		t.Fatalf("NewFromSubmissionURL() error = %v", err)
+	}
+
+	var metrics bytes.Buffer
+	metrics.WriteString(`{"foo":{"_type":"n","_value":1}}`)
+	result, _, err := tc.submit(context.Background(), metrics)
+	if err != nil {
+		t.Fatalf("TrapCheck.submit() error = %v", err)
+	}
+	if result.Stats != 1 {
+		t.Errorf("TrapCheck.submit() stats = %d, want 1", result.Stats)
+	}
+	if tc.tlsConfig != nil {
+		t.Error("expected no broker tls config with a transport")
+	}
+
+	if len(rt.requests) != 2 {
+		t.Fatalf("transport requests = %d, want 2 (retry)", len(rt.requests))
+	}
+	for i, req := range rt.requests {
+		if req.Method != http.MethodPut {
+			t.Errorf("request %d method = %s, want PUT", i, req.Method)
+		}
+		if req.URL.String() != "https://trap.example.com/write/test" {
+			t.Errorf("request %d url = %s", i, req.URL)
+		}
+		if string(rt.bodies[i]) != metrics.String() {
+			t.Errorf("request %d body = %q, want %q", i, rt.bodies[i], metrics.String())
+		}
+	}
+}
+
+func TestTrapCheck_submitCompression(t *testing.T) {
+	small := []byte(`{"foo":{"_type":"n","_value":1}}`)
+	var large []byte
+	large = append(large, '{')
+	for i := 0; i < 100; i++ {
+		if i > 0 {
+			large = append(large, ',')
+		}
+		large = append(large, fmt.Sprintf(`"metric_%03d":{"_type":"n","_value":%d}`, i, i)...)
+	}
+	large = append(large, '}')
+
+	tests := []struct {
+		name           string
+		traceMetrics   string
+		payload        []byte
+		wantCompressed bool
+		wantExt        string
+	}{
+		{name: "small", payload: small, wantCompressed: false},
+		{name: "large", payload: large, wantCompressed: true},
+		{name: "small, traced", payload: small, traceMetrics: "dir", wantExt: ".json"},
+		{name: "large, traced", payload: large, traceMetrics: "dir", wantCompressed: true, wantExt: ".json.gz"},
+		{name: "small, traced to log", payload: small, traceMetrics: "-"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			rt := &recordingTransport{statuses: []int{http.StatusOK}, responses: []string{`{"stats":1}`}}
+			traceDir := tt.traceMetrics
+			if traceDir == "dir" {
+				traceDir = t.TempDir()
+			}
+			tc := &TrapCheck{
+				checkBundle:       &apiclient.CheckBundle{},
+				custSubmissionURL: "http://127.0.0.1:2609/write/test",
+				submissionURL:     "http://127.0.0.1:2609/write/test",
+				submissionTimeout: 5 * time.Second,
+				transport:         rt,
+				traceMetrics:      traceDir,
+			}
+			tc.Log = &LogWrapper{Log: log.New(io.Discard, "", 0), Debug: true}
+
+			var metrics bytes.Buffer
+			metrics.Write(tt.payload)
+			result, err := tc.SendMetrics(context.Background(), metrics)
+			if err != nil {
+				t.Fatalf("TrapCheck.SendMetrics() unexpected error: %s", err)
+			}
+
+			if result.Compressed != tt.wantCompressed {
+				t.Errorf("Compressed = %t, want %t", result.Compressed, tt.wantCompressed)
+			}
+			if result.UncompressedBytes != len(tt.payload) {
+				t.Errorf("UncompressedBytes = %d, want %d", result.UncompressedBytes, len(tt.payload))
+			}
+			if result.BytesSentGzip != len(rt.bodies[0]) {
+				t.Errorf("BytesSentGzip = %d, want %d (sent)", result.BytesSentGzip, len(rt.bodies[0]))
+			}
+			if tt.wantCompressed {
+				if result.BytesSentGzip >= result.UncompressedBytes {
+					t.Errorf("BytesSentGzip = %d, want < %d", result.BytesSentGzip, result.UncompressedBytes)
+				}
+				if got := rt.requests[0].Header.Get("Content-Encoding"); got != EncodingGzip {
+					t.Errorf("Content-Encoding = %q, want %q", got, EncodingGzip)
+				}
+			} else if result.BytesSentGzip != len(tt.payload) {
+				t.Errorf("BytesSentGzip = %d, want %d", result.BytesSentGzip, len(tt.payload))
+			}
+
+			if tt.traceMetrics == "" {
+				return
+			}
+			if result.SubmitUUID == "n/a" || result.SubmitUUID == "" {
+				t.Errorf("SubmitUUID = %q, want generated uuid when tracing", result.SubmitUUID)
+			}
+			if tt.wantExt != "" {
+				traces, err := filepath.Glob(filepath.Join(traceDir, "*_"+result.SubmitUUID+"*"))
+				if err != nil || len(traces) != 1 || !strings.HasSuffix(traces[0], "_"+result.SubmitUUID+tt.wantExt) {
+					t.Errorf("trace files = %v (%v), want one ending %s", traces, err, tt.wantExt)
+				}
+			}
+		})
+	}
+}
+
+// regression, tracing a payload just over the compression threshold
+// previously consumed the payload twice (gzwrite length mismatch).
+func TestTrapCheck_submitTracedOverThreshold(t *testing.T) {
+	payload := []byte(`{"foo":{"_type":"s","_value":"`)
+	pad := compressionThreshold + 1 - len(payload) - len(`"}}`)
+	payload = append(payload, bytes.Repeat([]byte("x"), pad)...)
+	payload = append(payload, `"}}`...)
+	if len(payload) != compressionThreshold+1 {
+		t.Fatalf("payload length = %d, want %d", len(payload), compressionThreshold+1)
+	}
+
+	gunzip := func(t *testing.T, data []byte) []byte {
+		t.Helper()
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("gzip reader: %s", err)
+		}
+		out, err := io.ReadAll(zr)
+		if err != nil {
+			t.Fatalf("gunzip: %s", err)
+		}
+		return out
+	}
+
+	for _, traceMetrics := range []string{"dir", "-"} {
+		traceMetrics := traceMetrics
+		t.Run(traceMetrics, func(t *testing.T) {
+			traceDir := traceMetrics
+			if traceDir == "dir" {
+				traceDir = t.TempDir()
+			}
+			var logs bytes.Buffer
+			rt := &recordingTransport{statuses: []int{http.StatusOK}, responses: []string{`{"stats":1}`}}
+			tc := &TrapCheck{
+				checkBundle:       &apiclient.CheckBundle{},
+				custSubmissionURL: "http://127.0.0.1:2609/write/test",
+				submissionURL:     "http://127.0.0.1:2609/write/test",
+				submissionTimeout: 5 * time.Second,
+				transport:         rt,
+				traceMetrics:      traceDir,
+			}
+			tc.Log = &LogWrapper{Log: log.New(&logs, "", 0)}
+
+			var metrics bytes.Buffer
+			metrics.Write(payload)
+			result, err := tc.SendMetrics(context.Background(), metrics)
+			if err != nil {
+				t.Fatalf("TrapCheck.SendMetrics() unexpected error: %s", err)
+			}
+			if !result.Compressed || result.UncompressedBytes != len(payload) {
+				t.Errorf("result compressed = %t, uncompressed bytes = %d, want true, %d", result.Compressed, result.UncompressedBytes, len(payload))
+			}
+			if got := gunzip(t, rt.bodies[0]); !bytes.Equal(got, payload) {
+				t.Errorf("submitted payload = %q, want %q", got, payload)
+			}
+
+			if traceMetrics == "-" {
+				if !strings.Contains(logs.String(), string(payload)) {
+					t.Errorf("traced payload not logged, logs = %q", logs.String())
+				}
+				return
+			}
+			traces, err := filepath.Glob(filepath.Join(traceDir, "*_"+result.SubmitUUID+".json.gz"))
+			if err != nil || len(traces) != 1 {
+				t.Fatalf("trace files = %v (%v), want one", traces, err)
+			}
+			data, err := os.ReadFile(traces[0])
+			if err != nil {
+				t.Fatalf("reading trace: %s", err)
+			}
+			if got := gunzip(t, data); !bytes.Equal(got, payload) {
+				t.Errorf("traced payload = %q, want %q", got, payload)
+			}
+		})
+	}
+}
+
+// bigBody is a response body of size bytes, counting the bytes read.
+type bigBody struct {
+	size int64
+	read int64
+}
+
+func (b *bigBody) Read(p []byte) (int, error) {
+	if b.read >= b.size {
+		return 0, io.EOF
+	}
+	n := int64(len(p))
+	if rem := b.size - b.read; n > rem {
+		n = rem
+	}
+	for i := int64(0); i < n; i++ {
+		p[i] = 'x'
+	}
+	b.read += n
+	return int(n), nil
+}
+
+func (b *bigBody) Close() error { return nil }
+
+type bigBodyTransport struct {
+	body   *bigBody
+	status int
+}
+
+func (bt *bigBodyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		_, _ = io.Copy(io.Discard, req.Body)
+		req.Body.Close()
+	}
+	return &http.Response{
+		StatusCode: bt.status,
+		Status:     strconv.Itoa(bt.status) + " " + http.StatusText(bt.status),
+		Header:     http.Header{"Content-Type": []string{"text/html"}},
+		Body:       bt.body,
+		Request:    req,
+	}, nil
+}
+
+func TestTrapCheck_submitResponseLimit(t *testing.T) {
+	const bodySize = 8 << 20 // 8MB
+
+	tests := []struct {
+		name          string
+		maxBytes      int64
+		status        int
+		wantErrIs     error
+		wantStatusErr bool
+	}{
+		{name: "default limit", status: http.StatusOK, wantErrIs: ErrResponseTooLarge},
+		{name: "configured limit", maxBytes: 1024, status: http.StatusOK, wantErrIs: ErrResponseTooLarge},
+		{name: "non-200, status reported", maxBytes: 1024, status: http.StatusBadRequest, wantStatusErr: true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			bt := &bigBodyTransport{body: &bigBody{size: bodySize}, status: tt.status}
+			tc := &TrapCheck{
+				checkBundle:       &apiclient.CheckBundle{},
+				custSubmissionURL: "http://127.0.0.1:2609/write/test",
+				submissionURL:     "http://127.0.0.1:2609/write/test",
+				submissionTimeout: 5 * time.Second,
+				transport:         bt,
+				maxResponseBytes:  tt.maxBytes,
+			}
+			tc.Log = &LogWrapper{Log: log.New(io.Discard, "", 0)}
+
+			var metrics bytes.Buffer
+			metrics.WriteString(`{"foo":{"_type":"n","_value":1}}`)
+			_, _, err := tc.submitEncoded(context.Background(), metrics, "")
+			if err == nil {
+				t.Fatal("TrapCheck.submitEncoded() expected error")
+			}
+			if tt.wantErrIs != nil && !errors.Is(err, tt.wantErrIs) {
+				t.Errorf("TrapCheck.submitEncoded() error = %v, want %v", err, tt.wantErrIs)
+			}
+			var se *statusError
+			if tt.wantStatusErr && !errors.As(err, &se) {
+				t.Errorf("TrapCheck.submitEncoded() error = %v, want status error", err)
+			}
+
+			// the response is read up to the limit (plus one byte) and drained up to the limit
+			limit := tc.responseLimit()
+			if bt.body.read > 2*limit+1 {
+				t.Errorf("response bytes read = %d, want <= %d", bt.body.read, 2*limit+1)
+			}
+		})
+	}
+}
+
+func TestLogCompressionRatio(t *testing.T) {
+	tests := []struct {
+		name         string
+		uncompressed int
+		compressed   int
+		want         string
+	}{
+		{name: "highly compressible", uncompressed: 10000, compressed: 500, want: "highly compressible"},
+		{name: "saving little", uncompressed: 1000, compressed: 900, want: "saving little"},
+		{name: "typical", uncompressed: 1000, compressed: 300},
+		{name: "zero", uncompressed: 0, compressed: 0},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			logCompressionRatio(&LogWrapper{Log: log.New(&buf, "", 0), Debug: true}, tt.uncompressed, tt.compressed)
+			if tt.want == "" {
+				if buf.Len() != 0 {
+					t.Errorf("unexpected log output: %s", buf.String())
+				}
+				return
+			}
+			if !strings.Contains(buf.String(), tt.want) {
+				t.Errorf("log output = %q, want %q", buf.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestTrapCheck_submitID(t *testing.T) {
+	var gotIDs []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotIDs = append(gotIDs, r.Header.Get(SubmitIDHeader))
+		fmt.Fprintln(w, `{"stats":1}`)
+	}))
+	defer ts.Close()
+
+	tc := &TrapCheck{
+		checkBundle:       &apiclient.CheckBundle{},
+		custSubmissionURL: ts.URL,
+		submissionURL:     ts.URL,
+		submissionTimeout: 5 * time.Second,
+	}
+	tc.Log = &LogWrapper{Log: log.New(io.Discard, "", 0)}
+
+	var results []*TrapResult
+	for i := 0; i < 2; i++ {
+		var metrics bytes.Buffer
+		metrics.WriteString(`{"foo":{"_type":"n","_value":1}}`)
+		result, err := tc.SendMetrics(context.Background(), metrics)
+		if err != nil {
+			t.Fatalf("TrapCheck.SendMetrics() unexpected error: %s", err)
+		}
+		results = append(results, result)
+	}
+
+	if len(gotIDs) != 2 {
+		t.Fatalf("requests = %d, want 2", len(gotIDs))
+	}
+	for i, result := range results {
+		if _, err := uuid.Parse(result.SubmitUUID); err != nil {
+			t.Errorf("SubmitUUID = %q, want uuid: %s", result.SubmitUUID, err)
+		}
+		if gotIDs[i] != result.SubmitUUID {
+			t.Errorf("%s = %q, want %q", SubmitIDHeader, gotIDs[i], result.SubmitUUID)
+		}
+	}
+	if results[0].SubmitUUID == results[1].SubmitUUID {
+		t.Errorf("SubmitUUID not unique per submission (%s)", results[0].SubmitUUID)
+	}
+}
+
+func TestTrapCheck_SendMetrics_unauthorized(t *testing.T) {
+	fb := trapchecktest.NewFakeBroker(t)
+	api := trapchecktest.NewFakeAPI(fb)
+	logger := &LogWrapper{Log: log.New(io.Discard, "", log.LstdFlags)}
+	initTestBrokerList(t, api, logger) // broker list is shared, use the fake api brokers
+
+	checkUUID := uuid.New().String()
+	bundle := api.AddCheckBundle(apiclient.CheckBundle{
+		Brokers:    []string{"/broker/1"},
+		CheckUUIDs: []string{checkUUID},
+		Type:       "httptrap",
+		Config:     apiclient.CheckBundleConfig{"submission_url": fb.SubmissionURL(checkUUID, "oldsecret")},
+		Status:     statusActive,
+	})
+
+	tc, err := New(&Config{
+		Client:            api,
+		CheckConfig:       &apiclient.CheckBundle{CID: bundle.CID},
+		RefreshRetryDelay: "10ms",
+		Logger:            logger,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	// secret rotated by other tooling, the broker rejects the old secret
+	bundle.Config = apiclient.CheckBundleConfig{"submission_url": fb.SubmissionURL(checkUUID, "newsecret")}
+	bundle.LastModified++
+	api.AddCheckBundle(bundle)
+	fb.QueueResponse(http.StatusForbidden, "forbidden")
+
+	var metrics bytes.Buffer
+	metrics.WriteString(`{"foo":{"_type":"n","_value":1}}`)
+	if _, err := tc.SendMetrics(context.Background(), metrics); err != nil {
+		t.Fatalf("TrapCheck.SendMetrics() error = %v", err)
+	}
+
+	subs := fb.Submissions()
+	if len(subs) != 2 {
+		t.Fatalf("broker submissions = %d, want 2", len(subs))
+	}
+	if want := "/module/httptrap/" + checkUUID + "/newsecret"; subs[1].Path != want {
+		t.Errorf("resubmission path = %s, want %s", subs[1].Path, want)
+	}
+	if n := tc.RefreshStats()[string(RefreshReasonHTTPUnauthorized)]; n != 1 {
+		t.Errorf("TrapCheck.RefreshStats() %s = %d, want 1", RefreshReasonHTTPUnauthorized, n)
+	}
+}
+
+func TestTrapCheck_SendMetrics_unauthorizedCustomURL(t *testing.T) {
+	fb := trapchecktest.NewFakeBroker(t)
+	fb.SetResponse(http.StatusUnauthorized, "unauthorized")
+	submissionURL := fb.SubmissionURL("abc-123", "secret")
+
+	tc := &TrapCheck{
+		client:            &APIMock{},
+		checkBundle:       &apiclient.CheckBundle{},
+		custSubmissionURL: submissionURL,
+		submissionURL:     submissionURL,
+		submissionTimeout: 5 * time.Second,
+	}
+	tc.Log = &LogWrapper{Log: log.New(io.Discard, "", 0)}
+
+	var metrics bytes.Buffer
+	metrics.WriteString(`{"foo":{"_type":"n","_value":1}}`)
+	_, err := tc.SendMetrics(context.Background(), metrics)
+	if !errors.Is(err, ErrSubmissionUnauthorized) {
+		t.Fatalf("TrapCheck.SendMetrics() error = %v, want %v", err, ErrSubmissionUnauthorized)
+	}
+	if n := len(fb.Submissions()); n != 1 {
+		t.Errorf("broker submissions = %d, want 1 (no retry)", n)
+	}
+	if n := len(tc.RefreshStats()); n != 0 {
+		t.Errorf("TrapCheck.RefreshStats() = %v, want no refreshes", tc.RefreshStats())
+	}
+}
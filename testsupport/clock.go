@@ -0,0 +1,91 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+// Package testsupport provides hooks for testing go-trapcheck consumers
+// deterministically, e.g. replacing the clock used for time based behavior
+// (broker list refresh interval, post-refresh delay, broker connect retries,
+// trace file names, result durations).
+package testsupport
+
+import (
+	"sync"
+	"time"
+
+	brokerList "github.com/circonus-labs/go-trapcheck/internal/broker_list"
+	"github.com/circonus-labs/go-trapcheck/internal/clock"
+)
+
+// Clock is the time source interface (Now, Since, Sleep and After).
+type Clock = clock.Clock
+
+// SetClock replaces the clock used by trap checks created afterwards and by
+// the broker list, returns a function restoring the previous clock. Trap
+// checks keep the clock they were created with.
+func SetClock(c Clock) (restore func()) {
+	prev := clock.SetDefault(c)
+	brokerList.SetClock(clock.Default())
+	return func() {
+		clock.SetDefault(prev)
+		brokerList.SetClock(prev)
+	}
+}
+
+// FakeClock is a Clock which only moves when advanced, Sleep and After
+// advance the clock by the duration and return immediately, so code waiting
+// on the clock runs without waiting in wall time.
+type FakeClock struct {
+	now    time.Time
+	sleeps []time.Duration
+	mu     sync.Mutex
+}
+
+// NewFakeClock returns a FakeClock set to now.
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+// Now returns the current fake time.
+func (fc *FakeClock) Now() time.Time {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	return fc.now
+}
+
+// Since returns the fake time elapsed since t.
+func (fc *FakeClock) Since(t time.Time) time.Duration {
+	return fc.Now().Sub(t)
+}
+
+// Sleep records d and advances the clock by d.
+func (fc *FakeClock) Sleep(d time.Duration) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	fc.sleeps = append(fc.sleeps, d)
+	if d > 0 {
+		fc.now = fc.now.Add(d)
+	}
+}
+
+// After records d, advances the clock by d and returns a channel with the new time.
+func (fc *FakeClock) After(d time.Duration) <-chan time.Time {
+	fc.Sleep(d)
+	ch := make(chan time.Time, 1)
+	ch <- fc.Now()
+	return ch
+}
+
+// Advance moves the clock forward by d.
+func (fc *FakeClock) Advance(d time.Duration) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	fc.now = fc.now.Add(d)
+}
+
+// Sleeps returns the durations passed to Sleep and After, in order.
+func (fc *FakeClock) Sleeps() []time.Duration {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	return append([]time.Duration(nil), fc.sleeps...)
+}
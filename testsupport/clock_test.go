@@ -0,0 +1,43 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package testsupport
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/circonus-labs/go-trapcheck/internal/clock"
+)
+
+func TestFakeClock(t *testing.T) {
+	start := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	fc := NewFakeClock(start)
+
+	fc.Sleep(2 * time.Second)
+	if got := <-fc.After(time.Minute); !got.Equal(start.Add(time.Minute + 2*time.Second)) {
+		t.Errorf("After() = %s, want %s", got, start.Add(time.Minute+2*time.Second))
+	}
+	fc.Advance(time.Hour)
+	if got := fc.Since(start); got != time.Hour+time.Minute+2*time.Second {
+		t.Errorf("Since() = %s, want 1h1m2s", got)
+	}
+	if got := fc.Sleeps(); !reflect.DeepEqual(got, []time.Duration{2 * time.Second, time.Minute}) {
+		t.Errorf("Sleeps() = %v, want [2s 1m0s]", got)
+	}
+}
+
+func TestSetClock(t *testing.T) {
+	fc := NewFakeClock(time.Now())
+	restore := SetClock(fc)
+	if clock.Default() != fc {
+		t.Fatalf("default clock = %T, want fake clock", clock.Default())
+	}
+	restore()
+	if _, ok := clock.Default().(clock.Real); !ok {
+		t.Errorf("default clock = %T, want real clock after restore", clock.Default())
+	}
+}
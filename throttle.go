@@ -0,0 +1,69 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+const (
+	defaultMinSubmissionInterval = "0s"
+)
+
+// ErrSubmissionThrottled is returned (wrapped in a *ThrottledError) when metrics are
+// submitted again within Config.MinSubmissionInterval of the last successful submission.
+var ErrSubmissionThrottled = errors.New("submission throttled")
+
+// ThrottledError is returned when a submission is throttled, Wait is how long until
+// the minimum submission interval has elapsed.
+type ThrottledError struct {
+	Wait time.Duration
+}
+
+func (e *ThrottledError) Error() string {
+	return ErrSubmissionThrottled.Error() + ", retry in " + e.Wait.String()
+}
+
+func (e *ThrottledError) Unwrap() error {
+	return ErrSubmissionThrottled
+}
+
+type forceSubmitKey struct{}
+
+// ForceSubmit returns a context which submits metrics even if the minimum
+// submission interval (Config.MinSubmissionInterval) has not elapsed.
+func ForceSubmit(ctx context.Context) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithValue(ctx, forceSubmitKey{}, true)
+}
+
+// throttle returns a *ThrottledError if the minimum submission interval since
+// the last successful submission has not elapsed. Failed submissions do not
+// count against the interval.
+func (tc *TrapCheck) throttle(ctx context.Context) error {
+	if tc.minSubmitInterval <= 0 {
+		return nil
+	}
+	if force, ok := ctx.Value(forceSubmitKey{}).(bool); ok && force {
+		return nil
+	}
+
+	tc.lastSubmissionMu.Lock()
+	last := tc.lastResultTime
+	tc.lastSubmissionMu.Unlock()
+
+	if last.IsZero() {
+		return nil
+	}
+	if wait := tc.minSubmitInterval - tc.clock().Since(last); wait > 0 {
+		return &ThrottledError{Wait: wait}
+	}
+	return nil
+}
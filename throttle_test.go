@@ -0,0 +1,143 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/circonus-labs/go-apiclient"
+)
+
+func TestTrapCheck_SendMetrics_throttle(t *testing.T) {
+	var requests int32
+	var fail int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if atomic.LoadInt32(&fail) == 1 {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		fmt.Fprintln(w, `{"stats":1}`)
+	}))
+	defer ts.Close()
+
+	newTC := func(interval time.Duration) *TrapCheck {
+		tc := &TrapCheck{
+			checkBundle:       &apiclient.CheckBundle{},
+			custSubmissionURL: ts.URL,
+			submissionURL:     ts.URL,
+			submissionTimeout: 5 * time.Second,
+			minSubmitInterval: interval,
+		}
+		tc.Log = &LogWrapper{Log: log.New(io.Discard, "", 0)}
+		return tc
+	}
+	send := func(ctx context.Context, tc *TrapCheck) error {
+		var metrics bytes.Buffer
+		metrics.WriteString(`{"foo":{"_type":"n","_value":1}}`)
+		_, err := tc.SendMetrics(ctx, metrics)
+		return err
+	}
+
+	t.Run("unset interval", func(t *testing.T) {
+		atomic.StoreInt32(&requests, 0)
+		tc := newTC(0)
+		for i := 0; i < 3; i++ {
+			if err := send(context.Background(), tc); err != nil {
+				t.Fatalf("SendMetrics() unexpected error: %s", err)
+			}
+		}
+		if n := atomic.LoadInt32(&requests); n != 3 {
+			t.Errorf("requests = %d, want 3", n)
+		}
+	})
+
+	t.Run("throttled", func(t *testing.T) {
+		atomic.StoreInt32(&requests, 0)
+		tc := newTC(time.Hour)
+		if err := send(context.Background(), tc); err != nil {
+			t.Fatalf("SendMetrics() unexpected error: %s", err)
+		}
+		err := send(context.Background(), tc)
+		var throttled *ThrottledError
+		if !errors.Is(err, ErrSubmissionThrottled) || !errors.As(err, &throttled) {
+			t.Fatalf("SendMetrics() error = %v, want *ThrottledError", err)
+		}
+		if throttled.Wait <= 0 || throttled.Wait > time.Hour {
+			t.Errorf("ThrottledError.Wait = %s, want (0, 1h]", throttled.Wait)
+		}
+		if n := atomic.LoadInt32(&requests); n != 1 {
+			t.Errorf("requests = %d, want 1", n)
+		}
+		if _, lastErr := tc.LastError(); lastErr != nil {
+			t.Errorf("LastError() = %v, throttled submissions must not be recorded", lastErr)
+		}
+	})
+
+	t.Run("force", func(t *testing.T) {
+		atomic.StoreInt32(&requests, 0)
+		tc := newTC(time.Hour)
+		for i := 0; i < 2; i++ {
+			if err := send(ForceSubmit(context.Background()), tc); err != nil {
+				t.Fatalf("SendMetrics() unexpected error: %s", err)
+			}
+		}
+		if n := atomic.LoadInt32(&requests); n != 2 {
+			t.Errorf("requests = %d, want 2", n)
+		}
+	})
+
+	t.Run("failed submissions not counted", func(t *testing.T) {
+		atomic.StoreInt32(&requests, 0)
+		tc := newTC(time.Hour)
+		atomic.StoreInt32(&fail, 1)
+		if err := send(context.Background(), tc); err == nil {
+			t.Fatal("SendMetrics() expected error")
+		}
+		atomic.StoreInt32(&fail, 0)
+		if err := send(context.Background(), tc); err != nil {
+			t.Fatalf("SendMetrics() after failure unexpected error: %s", err)
+		}
+	})
+
+	t.Run("interval elapsed", func(t *testing.T) {
+		tc := newTC(50 * time.Millisecond)
+		if err := send(context.Background(), tc); err != nil {
+			t.Fatalf("SendMetrics() unexpected error: %s", err)
+		}
+		time.Sleep(60 * time.Millisecond)
+		if err := send(context.Background(), tc); err != nil {
+			t.Fatalf("SendMetrics() after interval unexpected error: %s", err)
+		}
+	})
+
+	t.Run("concurrent", func(t *testing.T) {
+		tc := newTC(time.Hour)
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_ = send(context.Background(), tc)
+			}()
+		}
+		wg.Wait()
+		if err := send(context.Background(), tc); !errors.Is(err, ErrSubmissionThrottled) {
+			t.Errorf("SendMetrics() error = %v, want ErrSubmissionThrottled", err)
+		}
+	})
+}
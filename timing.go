@@ -0,0 +1,120 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// AttemptTiming is the timing breakdown of a single submission attempt.
+// Phases which did not occur (e.g. DNS for an ip, TLS for http) are zero.
+type AttemptTiming struct {
+	Error       string        `json:"error,omitempty"`
+	Duration    time.Duration `json:"dur"` // start of attempt until response headers (or error)
+	DNSTime     time.Duration `json:"dns_dur"`
+	ConnectTime time.Duration `json:"connect_dur"`
+	TLSTime     time.Duration `json:"tls_dur"`
+	TTFB        time.Duration `json:"ttfb"` // start of attempt until first response byte
+	StatusCode  int           `json:"status_code,omitempty"`
+}
+
+// attemptTimer collects the timing of each attempt sent through its transport.
+type attemptTimer struct {
+	next     http.RoundTripper
+	attempts []AttemptTiming
+	sync.Mutex
+}
+
+func (at *attemptTimer) RoundTrip(req *http.Request) (*http.Response, error) {
+	var mu sync.Mutex // trace callbacks may be called from dialing goroutines
+	var timing AttemptTiming
+	var dnsStart, connStart, tlsStart time.Time
+
+	start := time.Now()
+	trace := &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			mu.Lock()
+			dnsStart = time.Now()
+			mu.Unlock()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			mu.Lock()
+			if !dnsStart.IsZero() {
+				timing.DNSTime = time.Since(dnsStart)
+			}
+			mu.Unlock()
+		},
+		ConnectStart: func(string, string) {
+			mu.Lock()
+			if connStart.IsZero() {
+				connStart = time.Now()
+			}
+			mu.Unlock()
+		},
+		ConnectDone: func(_, _ string, err error) {
+			mu.Lock()
+			if err == nil && !connStart.IsZero() {
+				timing.ConnectTime = time.Since(connStart)
+			}
+			mu.Unlock()
+		},
+		TLSHandshakeStart: func() {
+			mu.Lock()
+			tlsStart = time.Now()
+			mu.Unlock()
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			mu.Lock()
+			if !tlsStart.IsZero() {
+				timing.TLSTime = time.Since(tlsStart)
+			}
+			mu.Unlock()
+		},
+		GotFirstResponseByte: func() {
+			mu.Lock()
+			timing.TTFB = time.Since(start)
+			mu.Unlock()
+		},
+	}
+
+	resp, err := at.next.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+
+	mu.Lock()
+	timing.Duration = time.Since(start)
+	if err != nil {
+		timing.Error = err.Error()
+	} else if resp != nil {
+		timing.StatusCode = resp.StatusCode
+	}
+	mu.Unlock()
+
+	at.Lock()
+	at.attempts = append(at.attempts, timing)
+	at.Unlock()
+
+	return resp, err //nolint:wrapcheck
+}
+
+// results returns a copy of the collected attempt timings.
+func (at *attemptTimer) results() []AttemptTiming {
+	at.Lock()
+	defer at.Unlock()
+	return append([]AttemptTiming(nil), at.attempts...)
+}
+
+// CloseIdleConnections passes through to the wrapped transport.
+func (at *attemptTimer) CloseIdleConnections() {
+	type closeIdler interface {
+		CloseIdleConnections()
+	}
+	if ci, ok := at.next.(closeIdler); ok {
+		ci.CloseIdleConnections()
+	}
+}
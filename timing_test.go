@@ -0,0 +1,84 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTrapCheck_submitTiming(t *testing.T) {
+	var requests int32
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+		fmt.Fprintln(w, `{"stats":1}`)
+	}))
+	defer ts.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ts.Certificate())
+
+	tc, err := NewFromSubmissionURL(&Config{
+		SubmissionURL:   ts.URL,
+		SubmitTLSConfig: &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12},
+	})
+	if err != nil {
+		t.Fatalf("NewFromSubmissionURL() error = %v", err)
+	}
+
+	var metrics bytes.Buffer
+	metrics.WriteString(`{"foo":{"_type":"n","_value":1}}`)
+	result, _, err := tc.submit(context.Background(), metrics)
+	if err != nil {
+		t.Fatalf("TrapCheck.submit() error = %v", err)
+	}
+
+	if len(result.Attempts) != 2 {
+		t.Fatalf("attempts = %d, want 2", len(result.Attempts))
+	}
+	if result.Attempts[0].StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("attempt 0 status = %d, want %d", result.Attempts[0].StatusCode, http.StatusServiceUnavailable)
+	}
+	if result.Attempts[1].StatusCode != http.StatusOK {
+		t.Errorf("attempt 1 status = %d, want %d", result.Attempts[1].StatusCode, http.StatusOK)
+	}
+	for i, at := range result.Attempts {
+		if at.ConnectTime <= 0 || at.TLSTime <= 0 || at.TTFB <= 0 {
+			t.Errorf("attempt %d timing not populated %+v", i, at)
+		}
+		if at.ConnectTime+at.TLSTime > at.TTFB {
+			t.Errorf("attempt %d connect+tls (%s) after ttfb (%s)", i, at.ConnectTime+at.TLSTime, at.TTFB)
+		}
+		if at.TTFB > at.Duration {
+			t.Errorf("attempt %d ttfb (%s) after duration (%s)", i, at.TTFB, at.Duration)
+		}
+	}
+	last := result.Attempts[1]
+	if result.ConnectTime != last.ConnectTime || result.TLSTime != last.TLSTime || result.TTFB != last.TTFB || result.DNSTime != last.DNSTime {
+		t.Errorf("result timing does not match final attempt %+v", last)
+	}
+	if result.DNSTime != 0 {
+		t.Errorf("dns time = %s, want 0 (ip submission url)", result.DNSTime)
+	}
+	if result.TTFB < 10*time.Millisecond {
+		t.Errorf("ttfb = %s, want >= server processing time", result.TTFB)
+	}
+	if result.SubmitDuration < result.Attempts[0].Duration+result.Attempts[1].Duration {
+		t.Errorf("submit duration (%s) less than sum of attempts", result.SubmitDuration)
+	}
+}
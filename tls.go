@@ -9,33 +9,62 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
-	"net/url"
+	"os"
 	"strings"
+	"time"
+)
+
+const (
+	defaultCACertRefreshWindow = "24h"
+	// minimum time between broker CA cert fetches triggered by expiry, to
+	// prevent API request storms when the replacement cert also expires soon.
+	caCertMinRefreshInterval = 5 * time.Minute
 )
 
 // clearTLSConfig sets the resetTLSConfig flag so that on the next setBrokerTLSConfig call
 // the broker will be refreshed and a new tls configuration will be created. The most common
 // reason for this to be done is a change to the configuration of a broker cluster (e.g. add/del).
-func (tc *TrapCheck) clearTLSConfig() {
+func (tc *TrapCheck) clearTLSConfig(reason RefreshReason) {
+	tc.stateMu.Lock()
+	defer tc.unlockState()
+	tc.clearTLSConfigLocked(reason)
+}
+
+// clearTLSConfigLocked see clearTLSConfig, stateMu must be held.
+func (tc *TrapCheck) clearTLSConfigLocked(reason RefreshReason) {
 	tc.resetTLSConfig = true
+	tc.resetTLSReason = reason
 }
 
 // setBrokerTLSConfig sets the broker tls configuration if was
 // not supplied by the caller in the configuration.
 func (tc *TrapCheck) setBrokerTLSConfig() error {
-	if tc.brokerList == nil {
-		if err := tc.initBrokerList(); err != nil {
-			return err
-		}
-	}
+	tc.stateMu.Lock()
+	defer tc.unlockState()
+	return tc.setBrokerTLSConfigLocked()
+}
 
+// setBrokerTLSConfigLocked see setBrokerTLSConfig, stateMu must be held.
+func (tc *TrapCheck) setBrokerTLSConfigLocked() (err error) {
+	var reason RefreshReason
 	if tc.resetTLSConfig {
+		reason = tc.resetTLSReason
+		tc.logger().Warnf("refreshing broker tls config (%s)", tc.resetTLSReason)
+		tc.recordRefresh(tc.resetTLSReason)
 		tc.broker = nil    // force refresh
 		tc.tlsConfig = nil // don't use, refresh and reset
 		tc.resetTLSConfig = false
 		// tc.custTLSConfig = nil // don't use, refresh and reset
-		_ = tc.brokerList.RefreshBrokers()
+		if tc.brokerList != nil {
+			_ = tc.brokerList.RefreshBrokers()
+		}
+	}
+
+	// caller supplied transport handles tls
+	if tc.transport != nil {
+		return nil
 	}
 
 	// setBrokerTLSConfig has already initialized it
@@ -43,18 +72,27 @@ func (tc *TrapCheck) setBrokerTLSConfig() error {
 		return nil
 	}
 
-	u, err := url.Parse(tc.submissionURL)
+	su, err := parseSubmissionURL(tc.submissionURL)
 	if err != nil {
-		return fmt.Errorf("parse submission URL: %w", err)
+		return err
 	}
 
-	if u.Scheme == "http" {
+	if su.isHTTP() {
 		return nil // not using tls
 	}
 
+	_, span := tc.startSpan(tc.spanParent(), SpanSetBrokerTLSConfig)
+	if span != nil {
+		defer func() {
+			span.setString(SpanAttrBrokerCID, tc.brokerCID())
+			span.setError(err)
+			span.end()
+		}()
+	}
+
 	// caller supplied tls config
 	if tc.custTLSConfig != nil {
-		tc.Log.Debugf("using custom tls configuration")
+		tc.logger().Debugf("using custom tls configuration")
 		tc.tlsConfig = tc.custTLSConfig.Clone()
 		return nil
 	}
@@ -69,14 +107,14 @@ func (tc *TrapCheck) setBrokerTLSConfig() error {
 	}
 
 	if tc.broker == nil {
-		if tc.checkBundle == nil {
-			return fmt.Errorf("invalid state, check bundle not initialized")
-		}
-		if len(tc.checkBundle.Brokers) == 0 {
-			return fmt.Errorf("invalid check bundle, 0 brokers")
-		}
-		if err = tc.fetchBroker(tc.checkBundle.Brokers[0], tc.checkBundle.Type); err != nil {
-			return err
+		if err = tc.fetchCheckBundleBroker(); err != nil {
+			if err = tc.refreshStaleBroker(err); err != nil {
+				return err
+			}
+			// the refreshed check may use a different submission url
+			if su, err = parseSubmissionURL(tc.submissionURL); err != nil {
+				return err
+			}
 		}
 	}
 
@@ -86,25 +124,47 @@ func (tc *TrapCheck) setBrokerTLSConfig() error {
 	}
 
 	certPool := x509.NewCertPool()
-	cert, err := tc.fetchCert()
+	cert, err := tc.brokerCACert()
 	if err != nil {
-		return fmt.Errorf("fetch broker ca cert: %w", err)
+		return err
 	}
 	if !certPool.AppendCertsFromPEM(cert) {
 		return fmt.Errorf("unable to append cert to pool")
 	}
+	expiry, err := caCertExpiry(cert)
+	if err != nil {
+		return fmt.Errorf("broker ca cert expiry: %w", err)
+	}
+	tc.caCertExpiry = expiry
+	tc.caCertLastFetch = tc.clock().Now()
+	tc.caCertInUse = cert
+
+	if tc.strictTLS {
+		tc.tlsConfig = tc.strictTLSConfig(su, certPool)
+		tc.emitEventLocked(EventTLSRebuilt, string(reason), "", su.host)
+		return nil
+	}
+
+	serverName := cn
+	if tc.tlsServerName != "" {
+		// e.g. sni routing load balancer, the cert is still verified against the broker cn list
+		serverName = tc.tlsServerName
+	}
 
 	tlsConfig := &tls.Config{
 		MinVersion: tls.VersionTLS12,
-		ServerName: cn,
+		ServerName: serverName,
 		// go1.15+ see VerifyConnection below - until CN added to SAN in broker certs
 		// NOTE: InsecureSkipVerify:true does NOT disable VerifyConnection()
 		InsecureSkipVerify: true, //nolint:gosec
 		VerifyConnection: func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) == 0 {
+				return fmt.Errorf("peer cert verify: no peer certificates")
+			}
 			commonName := cs.PeerCertificates[0].Subject.CommonName
 			if !strings.Contains(cnList, commonName) {
-				tc.Log.Warnf("certificate name mismatch (refreshing TLS config) common cause, new broker added to cluster or check moved to new broker -- cn: %q, acceptable: %q", commonName, cnList)
-				tc.clearTLSConfig()
+				tc.logger().Warnf("certificate name mismatch (refreshing TLS config) common cause, new broker added to cluster or check moved to new broker -- cn: %q, acceptable: %q", commonName, cnList)
+				tc.clearTLSConfig(RefreshReasonTLSNameMismatch)
 				return x509.CertificateInvalidError{
 					Cert:   cs.PeerCertificates[0],
 					Reason: x509.NameMismatch,
@@ -122,15 +182,94 @@ func (tc *TrapCheck) setBrokerTLSConfig() error {
 			if err != nil {
 				return fmt.Errorf("peer cert verify: %w", err)
 			}
-			return nil
+			return tc.verifyPinnedCert(cs)
 		},
 	}
 
 	tc.tlsConfig = tlsConfig
+	tc.emitEventLocked(EventTLSRebuilt, string(reason), "", cn)
 
 	return nil
 }
 
+// setBrokerCACert validates and saves caller supplied broker CA cert
+// material, PEM data takes precedence over a file.
+func (tc *TrapCheck) setBrokerCACert(data []byte, file string) error {
+	switch {
+	case len(data) > 0:
+		if _, err := caCertExpiry(data); err != nil {
+			return fmt.Errorf("invalid broker ca cert pem: %w", err)
+		}
+		tc.caCertPEM = append([]byte(nil), data...)
+	case file != "":
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("reading broker ca cert file: %w", err)
+		}
+		if _, err := caCertExpiry(data); err != nil {
+			return fmt.Errorf("invalid broker ca cert file (%s): %w", file, err)
+		}
+		tc.caCertFile = file
+	}
+	return nil
+}
+
+// brokerCACert returns the broker CA cert - caller supplied PEM data,
+// the caller supplied file (re-read so a rotated cert is picked up),
+// or fetched from the API.
+func (tc *TrapCheck) brokerCACert() ([]byte, error) {
+	if len(tc.caCertPEM) > 0 {
+		tc.logger().Debugf("using supplied broker ca cert")
+		return tc.caCertPEM, nil
+	}
+	if tc.caCertFile != "" {
+		tc.logger().Debugf("using broker ca cert file %s", tc.caCertFile)
+		data, err := os.ReadFile(tc.caCertFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading broker ca cert file: %w", err)
+		}
+		return data, nil
+	}
+	cert, err := tc.fetchCert()
+	if err != nil {
+		return nil, fmt.Errorf("fetch broker ca cert: %w", err)
+	}
+	return cert, nil
+}
+
+// caCertExpiring returns true if the broker CA cert has expired or will
+// expire within the refresh window and it has not been fetched recently.
+func (tc *TrapCheck) caCertExpiring() bool {
+	if tc.caCertExpiry.IsZero() {
+		return false
+	}
+	if tc.clock().Since(tc.caCertLastFetch) < caCertMinRefreshInterval {
+		return false
+	}
+	return tc.caCertExpiry.Sub(tc.clock().Now()) < tc.caCertRefreshWindow
+}
+
+// caCertExpiry returns the earliest NotAfter of the certificate(s) in the PEM data.
+func caCertExpiry(data []byte) (time.Time, error) {
+	var expiry time.Time
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("parse certificate: %w", err)
+		}
+		if expiry.IsZero() || cert.NotAfter.Before(expiry) {
+			expiry = cert.NotAfter
+		}
+	}
+	if expiry.IsZero() {
+		return time.Time{}, fmt.Errorf("no certificates found")
+	}
+	return expiry, nil
+}
+
 // caCert contains broker CA certificate returned from Circonus API.
 type caCert struct {
 	Contents string `json:"contents"`
@@ -139,7 +278,11 @@ type caCert struct {
 // fetchCert fetches CA certificate using Circonus API.
 func (tc *TrapCheck) fetchCert() ([]byte, error) {
 
-	tc.Log.Debugf("fetching broker cert from api")
+	if tc.client == nil {
+		return nil, fmt.Errorf("fetch broker CA cert from API: %w", ErrNoAPIClient)
+	}
+
+	tc.logger().Debugf("fetching broker cert from api")
 
 	response, err := tc.client.Get("/pki/ca.crt")
 	if err != nil {
@@ -6,15 +6,33 @@
 package trapcheck
 
 import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
 	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
 	"fmt"
 	"io"
 	"log"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strconv"
 	"testing"
+	"time"
 
 	"github.com/circonus-labs/go-apiclient"
 	"github.com/circonus-labs/go-apiclient/config"
+	brokerList "github.com/circonus-labs/go-trapcheck/internal/broker_list"
 )
 
 func TestTrapCheck_fetchCert(t *testing.T) {
@@ -245,4 +263,493 @@ func TestTrapCheck_setBrokerTLSConfig(t *testing.T) {
 	}
 }
 
+func TestTrapCheck_caCertExpiring(t *testing.T) {
+	tc := &TrapCheck{caCertRefreshWindow: 24 * time.Hour}
+
+	tests := []struct {
+		expiry    time.Time
+		lastFetch time.Time
+		name      string
+		want      bool
+	}{
+		{name: "no ca cert", want: false},
+		{name: "not expiring", expiry: time.Now().Add(48 * time.Hour), lastFetch: time.Now().Add(-time.Hour), want: false},
+		{name: "within window", expiry: time.Now().Add(time.Hour), lastFetch: time.Now().Add(-time.Hour), want: true},
+		{name: "expired", expiry: time.Now().Add(-time.Hour), lastFetch: time.Now().Add(-time.Hour), want: true},
+		{name: "expired, fetched recently", expiry: time.Now().Add(-time.Hour), lastFetch: time.Now(), want: false},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			tc.caCertExpiry = tt.expiry
+			tc.caCertLastFetch = tt.lastFetch
+			if got := tc.caCertExpiring(); got != tt.want {
+				t.Errorf("TrapCheck.caCertExpiring() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTrapCheck_submitRefreshesExpiringCACert(t *testing.T) {
+	tc := &TrapCheck{}
+	tc.Log = &LogWrapper{
+		Log:   log.New(io.Discard, "", log.LstdFlags),
+		Debug: false,
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "beep boop")
+	}))
+	defer ts.Close()
+
+	tsURL, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatalf("creating test broker: %s", err)
+	}
+	brokerIP := tsURL.Hostname()
+	bp, err := strconv.Atoi(tsURL.Port())
+	if err != nil {
+		t.Fatalf("parsing test broker port: %s", err)
+	}
+	brokerPort := uint16(bp)
+
+	notAfter := time.Now().Add(time.Hour)
+	caPEM, _, _ := generateTestCA(t, notAfter)
+	caJSON, err := json.Marshal(&caCert{Contents: string(caPEM)})
+	if err != nil {
+		t.Fatalf("encoding ca cert: %s", err)
+	}
+
+	fetches := 0
+	client := &APIMock{
+		GetFunc: func(requrl string) ([]byte, error) {
+			fetches++
+			return caJSON, nil
+		},
+		FetchBrokersFunc: func() (*[]apiclient.Broker, error) {
+			return &[]apiclient.Broker{
+				{
+					CID:  "/broker/123",
+					Name: "foo",
+					Type: circonusType,
+					Details: []apiclient.BrokerDetail{
+						{CN: "foo", Status: statusActive, Modules: []string{"httptrap"}, IP: &brokerIP, Port: &brokerPort},
+					},
+				},
+			}, nil
+		},
+	}
+
+	tc.brokerList = initTestBrokerList(t, client, tc.Log)
+	tc.client = client
+	tc.caCertRefreshWindow = 24 * time.Hour
+	tc.submissionURL = fmt.Sprintf("https://%s:%d/", brokerIP, brokerPort)
+	tc.checkBundle = &apiclient.CheckBundle{
+		Brokers: []string{"/broker/123"},
+		Type:    "httptrap",
+		Config:  apiclient.CheckBundleConfig{"submission_url": tc.submissionURL},
+	}
+
+	if err := tc.setBrokerTLSConfig(); err != nil {
+		t.Fatalf("TrapCheck.setBrokerTLSConfig() error = %v", err)
+	}
+	expiry, err := tc.GetBrokerCACertExpiry()
+	if err != nil {
+		t.Fatalf("TrapCheck.GetBrokerCACertExpiry() error = %v", err)
+	}
+	if !expiry.Equal(notAfter.UTC().Truncate(time.Second)) {
+		t.Errorf("TrapCheck.GetBrokerCACertExpiry() = %s, want %s", expiry, notAfter)
+	}
+
+	// force the last fetch outside the minimum refresh interval
+	tc.caCertLastFetch = time.Now().Add(-2 * caCertMinRefreshInterval)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel() // only the tls config rebuild is of interest, not the request
+	var metrics bytes.Buffer
+	metrics.WriteString(`{"foo":{"_type":"n","_value":1}}`)
+	_, _, _ = tc.submit(ctx, metrics)
+
+	if fetches != 2 {
+		t.Errorf("expected ca cert to be re-fetched, fetches = %d, want 2", fetches)
+	}
+	if n := tc.RefreshStats()[string(RefreshReasonCACertExpiry)]; n != 1 {
+		t.Errorf("TrapCheck.RefreshStats() %s = %d, want 1", RefreshReasonCACertExpiry, n)
+	}
+}
+
+func TestTrapCheck_setBrokerCACert(t *testing.T) {
+	caPEM, _, _ := generateTestCA(t, time.Now().Add(time.Hour))
+
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.crt")
+	if err := os.WriteFile(caFile, caPEM, 0600); err != nil {
+		t.Fatalf("writing ca file: %s", err)
+	}
+	badFile := filepath.Join(dir, "bad.crt")
+	if err := os.WriteFile(badFile, []byte("foobar"), 0600); err != nil {
+		t.Fatalf("writing bad ca file: %s", err)
+	}
+
+	tests := []struct {
+		name     string
+		file     string
+		wantFile string
+		data     []byte
+		wantPEM  bool
+		wantErr  bool
+	}{
+		{name: "none"},
+		{name: "valid pem", data: caPEM, wantPEM: true},
+		{name: "invalid pem", data: []byte("foobar"), wantErr: true},
+		{name: "valid file", file: caFile, wantFile: caFile},
+		{name: "missing file", file: filepath.Join(dir, "missing.crt"), wantErr: true},
+		{name: "invalid file", file: badFile, wantErr: true},
+		{name: "pem takes precedence", data: caPEM, file: badFile, wantPEM: true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			tc := &TrapCheck{}
+			err := tc.setBrokerCACert(tt.data, tt.file)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("TrapCheck.setBrokerCACert() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if (len(tc.caCertPEM) > 0) != tt.wantPEM {
+				t.Errorf("TrapCheck.setBrokerCACert() pem set = %v, want %v", len(tc.caCertPEM) > 0, tt.wantPEM)
+			}
+			if tc.caCertFile != tt.wantFile {
+				t.Errorf("TrapCheck.setBrokerCACert() file = %q, want %q", tc.caCertFile, tt.wantFile)
+			}
+		})
+	}
+}
+
+func TestTrapCheck_SendMetricsBrokerCACertFile(t *testing.T) {
+	tc := &TrapCheck{}
+	tc.Log = &LogWrapper{
+		Log:   log.New(io.Discard, "", log.LstdFlags),
+		Debug: false,
+	}
+
+	caPEM, ca, caKey := generateTestCA(t, time.Now().Add(time.Hour))
+	caFile := filepath.Join(t.TempDir(), "ca.crt")
+	if err := os.WriteFile(caFile, caPEM, 0600); err != nil {
+		t.Fatalf("writing ca file: %s", err)
+	}
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, `{"stats":1}`)
+	}))
+	ts.TLS = &tls.Config{
+		Certificates: []tls.Certificate{generateTestCert(t, ca, caKey, "foo")},
+		MinVersion:   tls.VersionTLS12,
+	}
+	ts.StartTLS()
+	defer ts.Close()
+
+	tsURL, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatalf("creating test broker: %s", err)
+	}
+	brokerIP := tsURL.Hostname()
+	bp, err := strconv.Atoi(tsURL.Port())
+	if err != nil {
+		t.Fatalf("parsing test broker port: %s", err)
+	}
+	brokerPort := uint16(bp)
+
+	client := &APIMock{
+		GetFunc: func(requrl string) ([]byte, error) {
+			return nil, fmt.Errorf("ca cert should not be fetched from api")
+		},
+		FetchBrokersFunc: func() (*[]apiclient.Broker, error) {
+			return &[]apiclient.Broker{
+				{
+					CID:  "/broker/123",
+					Name: "foo",
+					Type: circonusType,
+					Details: []apiclient.BrokerDetail{
+						{CN: "foo", Status: statusActive, Modules: []string{"httptrap"}, IP: &brokerIP, Port: &brokerPort},
+					},
+				},
+			}, nil
+		},
+	}
+
+	tc.brokerList = initTestBrokerList(t, client, tc.Log)
+	tc.client = client
+	tc.submissionTimeout = 5 * time.Second
+	tc.submissionURL = ts.URL
+	tc.checkBundle = &apiclient.CheckBundle{
+		Brokers:    []string{"/broker/123"},
+		CheckUUIDs: []string{"abc-123"},
+		Type:       "httptrap",
+		Config:     apiclient.CheckBundleConfig{"submission_url": ts.URL},
+	}
+	if err := tc.setBrokerCACert(nil, caFile); err != nil {
+		t.Fatalf("TrapCheck.setBrokerCACert() error = %v", err)
+	}
+
+	var metrics bytes.Buffer
+	metrics.WriteString(`{"foo":{"_type":"n","_value":1}}`)
+	result, err := tc.SendMetrics(context.Background(), metrics)
+	if err != nil {
+		t.Fatalf("TrapCheck.SendMetrics() error = %v", err)
+	}
+	if result.Stats != 1 {
+		t.Errorf("TrapCheck.SendMetrics() stats = %d, want 1", result.Stats)
+	}
+}
+
+// initTestBrokerList initializes the broker list singleton with the supplied client.
+func initTestBrokerList(t *testing.T, client API, logger Logger) brokerList.BrokerList {
+	t.Helper()
+
+	if err := brokerList.Init(client, logger); err != nil {
+		t.Fatalf("initializing broker list: %s", err)
+	}
+	bl, err := brokerList.GetInstance()
+	if err != nil {
+		t.Fatalf("getting broker list instance: %s", err)
+	}
+	if err := bl.SetClient(client); err != nil {
+		t.Fatalf("broker list setting client: %s", err)
+	}
+	if err := bl.FetchBrokers(); err != nil {
+		t.Fatalf("broker list fetching brokers: %s", err)
+	}
+	return bl
+}
+
+// generateTestCA creates a self-signed CA certificate, returns the
+// PEM encoded certificate, the parsed certificate and the private key.
+func generateTestCA(t *testing.T, notAfter time.Time) ([]byte, *x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generating ca key: %s", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test Certificate Authority"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("creating ca cert: %s", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("parsing ca cert: %s", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), cert, key
+}
+
+// generateTestCert creates a server certificate, with the supplied
+// common name, signed by the supplied CA.
+func generateTestCert(t *testing.T, ca *x509.Certificate, caKey *ecdsa.PrivateKey, cn string) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generating cert key: %s", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     ca.NotAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("creating cert: %s", err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
 var circCA = []byte(`{"contents":"# Circonus Certificate Authority G2\n-----BEGIN CERTIFICATE-----\nMIIE6zCCA9OgAwIBAgIJALY0C6uznIh+MA0GCSqGSIb3DQEBCwUAMIGpMQswCQYD\nVQQGEwJVUzERMA8GA1UECBMITWFyeWxhbmQxDzANBgNVBAcTBkZ1bHRvbjEXMBUG\nA1UEChMOQ2lyY29udXMsIEluYy4xETAPBgNVBAsTCENpcmNvbnVzMSowKAYDVQQD\nEyFDaXJjb251cyBDZXJ0aWZpY2F0ZSBBdXRob3JpdHkgRzIxHjAcBgkqhkiG9w0B\nCQEWD2NhQGNpcmNvbnVzLm5ldDAeFw0xOTEyMDYyMDAzMzdaFw0zOTEyMDYyMDAz\nMzdaMIGpMQswCQYDVQQGEwJVUzERMA8GA1UECBMITWFyeWxhbmQxDzANBgNVBAcT\nBkZ1bHRvbjEXMBUGA1UEChMOQ2lyY29udXMsIEluYy4xETAPBgNVBAsTCENpcmNv\nbnVzMSowKAYDVQQDEyFDaXJjb251cyBDZXJ0aWZpY2F0ZSBBdXRob3JpdHkgRzIx\nHjAcBgkqhkiG9w0BCQEWD2NhQGNpcmNvbnVzLm5ldDCCASIwDQYJKoZIhvcNAQEB\nBQADggEPADCCAQoCggEBAK9oN6wBfBgjRYKBbL0Hllcr9TR2e0wIDGhk15Ltym32\nzkndEcNKoz61BBJZGalPYDQ8khGQEJAHF6jE/q+qPFHA7vMoIll0frD/C8MM09PK\nwvvw+HfnRLjnAWwmefDsE+zhdXlOMnsRPPmMHOCYw0RYe4z8Zna3Jl57zZt8zlKh\nFnWRsZg8zc5dFQsAteu2vV+ZSYXUZyj2IgmqaeKgjyUL09ByBKH+weS0ICXiIS51\n8lEmofj87ceBMRJHjIwnFr9dRvj3YU/DZVL8NVy91jBHPw9PhLV8XQRh6oQXkrSr\nvlcs3NN2FNqWIfZmL6g8/OCCXr3oFgotumGUc7H/cS0CAwEAAaOCARIwggEOMB0G\nA1UdDgQWBBRk0xgZQ17grBWWZbRRTzZfqlAd4zCB3gYDVR0jBIHWMIHTgBRk0xgZ\nQ17grBWWZbRRTzZfqlAd46GBr6SBrDCBqTELMAkGA1UEBhMCVVMxETAPBgNVBAgT\nCE1hcnlsYW5kMQ8wDQYDVQQHEwZGdWx0b24xFzAVBgNVBAoTDkNpcmNvbnVzLCBJ\nbmMuMREwDwYDVQQLEwhDaXJjb251czEqMCgGA1UEAxMhQ2lyY29udXMgQ2VydGlm\naWNhdGUgQXV0aG9yaXR5IEcyMR4wHAYJKoZIhvcNAQkBFg9jYUBjaXJjb251cy5u\nZXSCCQC2NAurs5yIfjAMBgNVHRMEBTADAQH/MA0GCSqGSIb3DQEBCwUAA4IBAQCq\n9yqOHBWeP65jUnr+pn5nf9+dJhIQ/zgEiIygUwJoSo0+OG1fwfXEeQMQdrYJlTfT\nLLgAlK/lJ0fXfS4ruMwyOnH5/2UTrh2eE1u8xToKg7afbaIoO/sg002f3qod1MRx\nJYPppNW16wG4kaBKOXJY6LzqXeaStCFotrer5Wt4tl/xOaVav1lmdXC8V3vUtoMJ\nFasyBc3tBlgKRJ0f2ijD+P6vEie4w8gJMSurqqKskiY+2zuNzClki0bqCi06m0lt\nTESkwBQfV80GJXyz4kTQIZgGnwLcNE9GOlihWX2axTpW7RwpX25lOaMtu+vZtao/\nyQRBN07uOh4gEhJIngzr\n-----END CERTIFICATE-----\n"}`)
+
+func TestTrapCheck_SendMetricsMultipleBrokers(t *testing.T) {
+	tc := &TrapCheck{}
+	tc.Log = &LogWrapper{
+		Log:   log.New(io.Discard, "", log.LstdFlags),
+		Debug: false,
+	}
+
+	caPEM, ca, caKey := generateTestCA(t, time.Now().Add(time.Hour))
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, `{"stats":1}`)
+	}))
+	ts.TLS = &tls.Config{
+		Certificates: []tls.Certificate{generateTestCert(t, ca, caKey, "second")},
+		MinVersion:   tls.VersionTLS12,
+	}
+	ts.StartTLS()
+	defer ts.Close()
+
+	tsURL, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatalf("creating test broker: %s", err)
+	}
+	brokerIP := tsURL.Hostname()
+	bp, err := strconv.Atoi(tsURL.Port())
+	if err != nil {
+		t.Fatalf("parsing test broker port: %s", err)
+	}
+	brokerPort := uint16(bp)
+	otherIP := "192.0.2.1"
+
+	client := &APIMock{
+		FetchBrokersFunc: func() (*[]apiclient.Broker, error) {
+			return &[]apiclient.Broker{
+				{
+					CID:  "/broker/1",
+					Name: "first",
+					Type: circonusType,
+					Details: []apiclient.BrokerDetail{
+						{CN: "first", Status: statusActive, Modules: []string{"httptrap"}, IP: &otherIP, Port: &brokerPort},
+					},
+				},
+				{
+					CID:  "/broker/2",
+					Name: "second",
+					Type: circonusType,
+					Details: []apiclient.BrokerDetail{
+						{CN: "second", Status: statusActive, Modules: []string{"httptrap"}, IP: &brokerIP, Port: &brokerPort},
+					},
+				},
+			}, nil
+		},
+	}
+
+	tc.brokerList = initTestBrokerList(t, client, tc.Log)
+	tc.client = client
+	tc.caCertPEM = caPEM
+	tc.submissionTimeout = 5 * time.Second
+	tc.submissionURL = ts.URL
+	tc.checkBundle = &apiclient.CheckBundle{
+		Brokers:    []string{"/broker/1", "/broker/2"},
+		CheckUUIDs: []string{"abc-123"},
+		Type:       "httptrap",
+		Config:     apiclient.CheckBundleConfig{"submission_url": ts.URL},
+	}
+
+	if err := tc.setBrokerTLSConfig(); err != nil {
+		t.Fatalf("TrapCheck.setBrokerTLSConfig() error = %v", err)
+	}
+	if tc.broker == nil || tc.broker.CID != "/broker/2" {
+		t.Fatalf("broker = %v, want /broker/2", tc.broker)
+	}
+
+	var metrics bytes.Buffer
+	metrics.WriteString(`{"foo":{"_type":"n","_value":1}}`)
+	result, err := tc.SendMetrics(context.Background(), metrics)
+	if err != nil {
+		t.Fatalf("TrapCheck.SendMetrics() error = %v", err)
+	}
+	if result.Stats != 1 {
+		t.Errorf("TrapCheck.SendMetrics() stats = %d, want 1", result.Stats)
+	}
+}
+
+func TestTrapCheck_SendMetricsTLSServerName(t *testing.T) {
+	tc := &TrapCheck{tlsServerName: "lb.example.com"}
+	tc.Log = &LogWrapper{
+		Log:   log.New(io.Discard, "", log.LstdFlags),
+		Debug: false,
+	}
+
+	caPEM, ca, caKey := generateTestCA(t, time.Now().Add(time.Hour))
+	nodeCert := generateTestCert(t, ca, caKey, "node1")
+
+	sni := make(chan string, 10)
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, `{"stats":1}`)
+	}))
+	ts.TLS = &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			sni <- hello.ServerName
+			return &tls.Config{
+				Certificates: []tls.Certificate{nodeCert},
+				MinVersion:   tls.VersionTLS12,
+			}, nil
+		},
+	}
+	ts.StartTLS()
+	defer ts.Close()
+
+	tsURL, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatalf("creating test broker: %s", err)
+	}
+	brokerIP := tsURL.Hostname()
+	bp, err := strconv.Atoi(tsURL.Port())
+	if err != nil {
+		t.Fatalf("parsing test broker port: %s", err)
+	}
+	brokerPort := uint16(bp)
+
+	client := &APIMock{
+		FetchBrokersFunc: func() (*[]apiclient.Broker, error) {
+			return &[]apiclient.Broker{
+				{
+					CID:  "/broker/123",
+					Name: "cluster",
+					Type: enterpriseType,
+					Details: []apiclient.BrokerDetail{
+						{CN: "node1", Status: statusActive, Modules: []string{"httptrap"}, IP: &brokerIP, Port: &brokerPort},
+					},
+				},
+			}, nil
+		},
+	}
+
+	tc.brokerList = initTestBrokerList(t, client, tc.Log)
+	tc.client = client
+	tc.caCertPEM = caPEM
+	tc.submissionTimeout = 5 * time.Second
+	tc.submissionURL = ts.URL
+	tc.checkBundle = &apiclient.CheckBundle{
+		Brokers:    []string{"/broker/123"},
+		CheckUUIDs: []string{"abc-123"},
+		Type:       "httptrap",
+		Config:     apiclient.CheckBundleConfig{"submission_url": ts.URL},
+	}
+
+	var metrics bytes.Buffer
+	metrics.WriteString(`{"foo":{"_type":"n","_value":1}}`)
+	if _, err := tc.SendMetrics(context.Background(), metrics); err != nil {
+		t.Fatalf("TrapCheck.SendMetrics() error = %v", err)
+	}
+
+	select {
+	case got := <-sni:
+		if got != "lb.example.com" {
+			t.Errorf("client hello server name = %q, want %q", got, "lb.example.com")
+		}
+	default:
+		t.Fatal("no client hello received")
+	}
+
+	// the presented cert is still verified against the broker cn list, not the server name
+	for _, cn := range []string{"lb.example.com", "node2"} {
+		cert := generateTestCert(t, ca, caKey, cn)
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			t.Fatalf("parsing test cert: %s", err)
+		}
+		if err := tc.tlsConfig.VerifyConnection(tls.ConnectionState{PeerCertificates: []*x509.Certificate{leaf}}); err == nil {
+			t.Errorf("VerifyConnection(%s) expected name mismatch error", cn)
+		}
+	}
+}
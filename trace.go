@@ -0,0 +1,118 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+const (
+	// TraceLevelPayload trace only the metric payload (default).
+	TraceLevelPayload = "payload"
+	// TraceLevelFull trace the metric payload and the submission metadata
+	// (url, request headers, attempts, response status/body and durations).
+	TraceLevelFull = "full"
+
+	traceMaxResponseBody = 4096
+
+	defaultTraceMaxFailures = 5
+)
+
+// traceMeta is the submission metadata traced with TraceLevelFull.
+type traceMeta struct {
+	RequestHeaders  http.Header   `json:"request_headers"`
+	SubmitUUID      string        `json:"submit_uuid"`
+	SubmissionURL   string        `json:"submission_url"`
+	Status          string        `json:"status,omitempty"`
+	ResponseBody    string        `json:"response_body,omitempty"`
+	Error           string        `json:"error,omitempty"`
+	SubmitDuration  time.Duration `json:"submit_dur"`
+	LastReqDuration time.Duration `json:"last_req_dur"`
+	StatusCode      int           `json:"status_code,omitempty"`
+	Attempts        int           `json:"attempts"`
+	Truncated       bool          `json:"response_body_truncated,omitempty"`
+}
+
+// setResponse records the response status and (truncated) body.
+func (tm *traceMeta) setResponse(resp *http.Response, body []byte) {
+	if resp == nil {
+		return
+	}
+	tm.Status = resp.Status
+	tm.StatusCode = resp.StatusCode
+	if len(body) > traceMaxResponseBody {
+		body = body[:traceMaxResponseBody]
+		tm.Truncated = true
+	}
+	tm.ResponseBody = string(body)
+}
+
+// writeTraceMeta logs the metadata ("-") or writes it to fn.
+func (tc *TrapCheck) writeTraceMeta(logger Logger, meta *traceMeta, fn string) {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		logger.Warnf("encoding submission trace metadata: %s", err)
+		return
+	}
+	if fn == "" {
+		logger.Infof("metric submission: %s", string(data))
+		return
+	}
+	if err := os.WriteFile(fn, data, 0600); err != nil {
+		logger.Errorf("writing submission trace metadata (%s): %s", fn, err)
+	}
+}
+
+// traceDir returns the current trace metrics setting.
+func (tc *TrapCheck) traceDir() string {
+	tc.traceMu.Lock()
+	defer tc.traceMu.Unlock()
+	return tc.traceMetrics
+}
+
+// traceWritten resets the consecutive trace write failures.
+func (tc *TrapCheck) traceWritten() {
+	tc.traceMu.Lock()
+	tc.traceFailures = 0
+	tc.traceMu.Unlock()
+}
+
+// traceWriteFailed counts a failed trace write to dir, after traceMaxFailures
+// consecutive failures tracing is disabled (once, with a warning) so a full
+// disk or removed directory does not log an error for every submission.
+func (tc *TrapCheck) traceWriteFailed(logger Logger, dir string) {
+	tc.traceMu.Lock()
+	defer tc.traceMu.Unlock()
+	if tc.traceMetrics != dir {
+		return // already disabled or changed
+	}
+	tc.traceFailures++
+	max := tc.traceMaxFailures
+	if max == 0 {
+		max = defaultTraceMaxFailures
+	}
+	if max < 0 || tc.traceFailures < max {
+		return
+	}
+	tc.traceMetrics = ""
+	tc.traceFailures = 0
+	logger.Warnf("trace metrics (%s): %d consecutive write failures -- tracing disabled, re-enable with TraceMetrics()", dir, max)
+}
+
+// redactSubmissionURL masks credentials and the check secret (the path
+// segment following the check uuid) in a submission url.
+func redactSubmissionURL(submissionURL string) string {
+	u, err := url.Parse(submissionURL)
+	if err != nil {
+		return secretMask
+	}
+	u.RawQuery = ""
+	return redactSecret(u.Redacted(), submissionURLSecret(submissionURL))
+}
@@ -0,0 +1,96 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// TransportConfig tunes the transport used for submissions (ignored when
+// Config.Transport is set). Zero values use the defaults.
+type TransportConfig struct {
+	// DialTimeout maximum time to establish a connection (default 10s)
+	DialTimeout time.Duration
+	// TLSHandshakeTimeout maximum time for the TLS handshake (default 10s)
+	TLSHandshakeTimeout time.Duration
+	// KeepAlive tcp keep-alive period (default 3s)
+	KeepAlive time.Duration
+	// IdleConnTimeout maximum time an idle connection is kept (default 0, no limit)
+	IdleConnTimeout time.Duration
+	// MaxIdleConns maximum idle connections (default 1)
+	MaxIdleConns int
+}
+
+const (
+	defaultDialTimeout         = 10 * time.Second
+	defaultTLSHandshakeTimeout = 10 * time.Second
+	defaultKeepAlive           = 3 * time.Second
+	defaultMaxIdleConns        = 1
+)
+
+// Validate verifies none of the settings are negative.
+func (c TransportConfig) Validate() error {
+	durations := []struct {
+		name  string
+		value time.Duration
+	}{
+		{name: "dial timeout", value: c.DialTimeout},
+		{name: "tls handshake timeout", value: c.TLSHandshakeTimeout},
+		{name: "keep alive", value: c.KeepAlive},
+		{name: "idle conn timeout", value: c.IdleConnTimeout},
+	}
+	for _, d := range durations {
+		if d.value < 0 {
+			return fmt.Errorf("invalid %s (%s), must be >= 0", d.name, d.value)
+		}
+	}
+	if c.MaxIdleConns < 0 {
+		return fmt.Errorf("invalid max idle conns (%d), must be >= 0", c.MaxIdleConns)
+	}
+	return nil
+}
+
+// withDefaults returns a copy with zero values replaced by the defaults.
+func (c TransportConfig) withDefaults() TransportConfig {
+	if c.DialTimeout == 0 {
+		c.DialTimeout = defaultDialTimeout
+	}
+	if c.TLSHandshakeTimeout == 0 {
+		c.TLSHandshakeTimeout = defaultTLSHandshakeTimeout
+	}
+	if c.KeepAlive == 0 {
+		c.KeepAlive = defaultKeepAlive
+	}
+	if c.MaxIdleConns == 0 {
+		c.MaxIdleConns = defaultMaxIdleConns
+	}
+	return c
+}
+
+// newSubmitTransport returns a single use transport for submissions with
+// the transport settings and tlsConfig (if any).
+func (tc *TrapCheck) newSubmitTransport(tlsConfig *tls.Config) *http.Transport {
+	cfg := tc.transportConfig.withDefaults()
+	return &http.Transport{
+		Proxy: tc.proxyFunc(),
+		DialContext: tc.dialContext(&net.Dialer{
+			Timeout:       cfg.DialTimeout,
+			KeepAlive:     cfg.KeepAlive,
+			FallbackDelay: -1 * time.Millisecond,
+		}),
+		TLSClientConfig:     tlsConfig,
+		TLSHandshakeTimeout: cfg.TLSHandshakeTimeout,
+		IdleConnTimeout:     cfg.IdleConnTimeout,
+		DisableKeepAlives:   true,
+		DisableCompression:  false,
+		MaxIdleConns:        cfg.MaxIdleConns,
+		MaxIdleConnsPerHost: 0,
+	}
+}
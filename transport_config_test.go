@@ -0,0 +1,107 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"crypto/tls"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestTransportConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     TransportConfig
+		wantErr bool
+	}{
+		{name: "zero"},
+		{name: "valid", cfg: TransportConfig{DialTimeout: 30 * time.Second, TLSHandshakeTimeout: 45 * time.Second, KeepAlive: time.Second, IdleConnTimeout: time.Minute, MaxIdleConns: 4}},
+		{name: "invalid, negative dial timeout", cfg: TransportConfig{DialTimeout: -1}, wantErr: true},
+		{name: "invalid, negative tls handshake timeout", cfg: TransportConfig{TLSHandshakeTimeout: -time.Second}, wantErr: true},
+		{name: "invalid, negative keep alive", cfg: TransportConfig{KeepAlive: -time.Second}, wantErr: true},
+		{name: "invalid, negative idle conn timeout", cfg: TransportConfig{IdleConnTimeout: -time.Second}, wantErr: true},
+		{name: "invalid, negative max idle conns", cfg: TransportConfig{MaxIdleConns: -1}, wantErr: true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.cfg.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("TransportConfig.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			_, err := NewFromSubmissionURL(&Config{
+				SubmissionURL:   "http://127.0.0.1:2609/write/test",
+				TransportConfig: tt.cfg,
+			})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewFromSubmissionURL() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTrapCheck_newSubmitTransport(t *testing.T) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	tests := []struct {
+		name string
+		cfg  TransportConfig
+		want TransportConfig
+	}{
+		{
+			name: "defaults",
+			want: TransportConfig{DialTimeout: defaultDialTimeout, TLSHandshakeTimeout: defaultTLSHandshakeTimeout, KeepAlive: defaultKeepAlive, MaxIdleConns: defaultMaxIdleConns},
+		},
+		{
+			name: "configured",
+			cfg:  TransportConfig{DialTimeout: 30 * time.Second, TLSHandshakeTimeout: 45 * time.Second, KeepAlive: time.Second, IdleConnTimeout: time.Minute, MaxIdleConns: 4},
+			want: TransportConfig{DialTimeout: 30 * time.Second, TLSHandshakeTimeout: 45 * time.Second, KeepAlive: time.Second, IdleConnTimeout: time.Minute, MaxIdleConns: 4},
+		},
+		{
+			name: "partial",
+			cfg:  TransportConfig{TLSHandshakeTimeout: 30 * time.Second},
+			want: TransportConfig{DialTimeout: defaultDialTimeout, TLSHandshakeTimeout: 30 * time.Second, KeepAlive: defaultKeepAlive, MaxIdleConns: defaultMaxIdleConns},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			tc, err := NewFromSubmissionURL(&Config{
+				SubmissionURL:   "http://127.0.0.1:2609/write/test",
+				TransportConfig: tt.cfg,
+			})
+			if err != nil {
+				t.Fatalf("NewFromSubmissionURL() unexpected error: %s", err)
+			}
+
+			if got := tc.transportConfig.withDefaults(); got != tt.want {
+				t.Errorf("transport config = %+v, want %+v", got, tt.want)
+			}
+
+			tr := tc.newSubmitTransport(tlsConfig)
+			if tr.TLSHandshakeTimeout != tt.want.TLSHandshakeTimeout {
+				t.Errorf("TLSHandshakeTimeout = %s, want %s", tr.TLSHandshakeTimeout, tt.want.TLSHandshakeTimeout)
+			}
+			if tr.IdleConnTimeout != tt.want.IdleConnTimeout {
+				t.Errorf("IdleConnTimeout = %s, want %s", tr.IdleConnTimeout, tt.want.IdleConnTimeout)
+			}
+			if tr.MaxIdleConns != tt.want.MaxIdleConns {
+				t.Errorf("MaxIdleConns = %d, want %d", tr.MaxIdleConns, tt.want.MaxIdleConns)
+			}
+			if tr.TLSClientConfig != tlsConfig {
+				t.Error("TLSClientConfig not set")
+			}
+			if tr.DialContext == nil {
+				t.Error("DialContext not set")
+			}
+
+			client := tc.submitClient(tc.tlsConfig)
+			if _, ok := client.Transport.(*http.Transport); !ok {
+				t.Fatalf("submitClient() transport = %T, want *http.Transport", client.Transport)
+			}
+		})
+	}
+}
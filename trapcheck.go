@@ -11,63 +11,389 @@ import (
 	"bytes"
 	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"net"
+	"net/http"
+	"net/url"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/circonus-labs/go-apiclient"
 	"github.com/circonus-labs/go-apiclient/config"
 	brokerList "github.com/circonus-labs/go-trapcheck/internal/broker_list"
+	"github.com/circonus-labs/go-trapcheck/internal/clock"
+)
+
+// ErrRefreshSuppressed is returned (wrapped) by SendMetrics when the broker
+// indicates the check needs to be refreshed but a refresh was performed
+// recently (see Config.RefreshCooldown) - callers may want to back off.
+var ErrRefreshSuppressed = errors.New("check refresh suppressed")
+
+// ErrNoAPIClient is returned by operations requiring the Circonus API when
+// the TrapCheck was created without an API client (see NewFromSubmissionURL).
+var ErrNoAPIClient = errors.New("no api client configured")
+
+// MultipleMatchBehavior defines how a check bundle is chosen when a search
+// finds multiple active bundles of the configured type.
+type MultipleMatchBehavior string
+
+const (
+	// MultipleMatchError return an error (default).
+	MultipleMatchError MultipleMatchBehavior = "error"
+	// MultipleMatchOldest use the bundle created first.
+	MultipleMatchOldest MultipleMatchBehavior = "oldest"
+	// MultipleMatchNewest use the bundle created last.
+	MultipleMatchNewest MultipleMatchBehavior = "newest"
+	// MultipleMatchTag use the bundle with Config.MultipleMatchTag.
+	MultipleMatchTag MultipleMatchBehavior = "tag"
 )
 
 type Config struct {
 	// Client is a valid circonus go-apiclient instance
 	Client API
+	// TraceProvider starts spans around check initialization, broker selection, broker tls
+	// config and each submission (and submission attempt), e.g. an OpenTelemetry adapter (default none)
+	TraceProvider Tracer
+	// RequestHook is called with each submission request (including retries, attempt is 0 based)
+	// after the standard headers are set and before it is sent, an error aborts the submission
+	RequestHook func(req *http.Request, attempt int) error
+	// ResponseHook is called with each submission response
+	ResponseHook func(resp *http.Response)
+	// EventHandler is called with lifecycle events (check created/found/refreshed, broker selected/changed,
+	// tls config rebuilt, submission failed), in order from a separate goroutine - Close waits for queued events
+	EventHandler func(Event)
 	// CheckConfig is a valid circonus go-apiclient.CheckBundle configuration
 	// or nil for defaults
 	CheckConfig *apiclient.CheckBundle
-	// SubmitTLSConfig is a *tls.Config to use when submitting to the broker
+	// SubmitTLSConfig is a *tls.Config to use when submitting to the broker (mutually exclusive with PublicCA)
 	SubmitTLSConfig *tls.Config
+	// Transport replaces the transport used for submissions (it is wrapped with the
+	// submission retry handling). When set, no broker TLS config is built, if
+	// SubmitTLSConfig is also set it is ignored and a warning is logged.
+	Transport http.RoundTripper
 	// Logger interface for logging
 	Logger Logger
 	// SubmissionURL explicit submission url (e.g. submitting to an agent, if tls used a SubmitTLSConfig is required)
 	SubmissionURL string
+	// AgentMode submit to a circonus-agent (SubmissionURL e.g. http://127.0.0.1:2609/write/<id>) without
+	// a check bundle, enabled for an http SubmissionURL without a CheckConfig
+	AgentMode bool
 	// SubmissionTimeout sets the timeout for submitting metrics to a broker
 	SubmissionTimeout string
-	// BrokerMaxResponseTime defines the timeout in which brokers must respond when selecting
+	// MinSubmissionInterval minimum time between successful submissions, SendMetrics returns a
+	// *ThrottledError (ErrSubmissionThrottled) if called sooner, unless the context is from
+	// ForceSubmit (default 0s, disabled)
+	MinSubmissionInterval string
+	// CheckSecret secret used in the submission url when a check is created (at least 16 characters,
+	// a-z, A-Z, 0-9, '-', '_', '.', '~'), default a randomly generated secret. Ignored if CheckConfig sets one.
+	CheckSecret string
+	// CheckDefaults fleet-wide defaults (period, timeout, metric filters, tags) used when creating
+	// a check for settings not in CheckConfig, before the built-in defaults
+	CheckDefaults CheckDefaults
+	// CheckInstanceID replaces the default instance id (hostname:app) used for the check display name,
+	// target, notes and default search tag (service:<id>). May be a template using the fields of
+	// InstanceIDData e.g. `{{.Hostname}}-{{.App}}-{{.Env "POD_NAME"}}`
+	CheckInstanceID string
+	// BrokerMaxResponseTime defines the timeout in which brokers must respond when selecting,
+	// "0" measures the connect time without enforcing a limit (see ConnInfo.BrokerProbe)
 	BrokerMaxResponseTime string
+	// ProxyURL proxy to use for submissions and broker selection instead of the HTTP[S]_PROXY environment variables
+	ProxyURL string
+	// NoProxy comma separated list of hosts/domains which should not use ProxyURL
+	NoProxy string
 	// TraceMetrics path to write traced metrics to (must be writable by the user running app)
 	TraceMetrics string
+	// TraceLevel what is traced when TraceMetrics is set, "payload" (default) or "full" - adds
+	// the submission metadata (redacted url, request headers, attempts, response, durations)
+	TraceLevel string
+	// TraceMaxFailures consecutive trace write failures after which tracing is disabled, until
+	// re-enabled with TraceMetrics (default 5, negative never disables)
+	TraceMaxFailures int
+	// BrokerCACertFile path to a PEM encoded broker CA cert to use instead of fetching it from the API
+	BrokerCACertFile string
+	// CheckBundleCacheFile path to a file where the check bundle is cached, when set New uses the cached
+	// bundle (if valid) instead of searching/creating the check and writes the bundle after creation or refresh
+	CheckBundleCacheFile string
+	// RefreshCooldown minimum time between check refreshes triggered by the broker (default 60s),
+	// doubles for each consecutive refresh which does not result in a successful submission
+	RefreshCooldown string
+	// RefreshRetryDelay time to wait after refreshing a check before retrying submission (default 2s)
+	RefreshRetryDelay string
+	// RefreshRetryJitter maximum random time added to RefreshRetryDelay (default 0s)
+	RefreshRetryJitter string
+	// BundleRecheckInterval how often SendMetrics fetches the check bundle to detect external changes
+	// (e.g. tags added by other tooling), a newer bundle replaces the local copy (default 0s, disabled)
+	BundleRecheckInterval string
+	// CheckActiveTimeout maximum time to wait for a newly created check to be active with a
+	// submission url (default 5s, 0 to not wait)
+	CheckActiveTimeout string
+	// InitJitter maximum random time New and NewFromCheckBundle wait before making their first
+	// API call, spreads out API and broker requests when a fleet restarts (default 0s, disabled)
+	InitJitter string
+	// CACertRefreshWindow defines how long before the broker CA cert expires the TLS config is rebuilt (default 24h)
+	CACertRefreshWindow string
+	// BrokerCACertPEM PEM encoded broker CA cert to use instead of fetching it from the API (takes precedence over BrokerCACertFile)
+	BrokerCACertPEM []byte
+	// Broker pre-selected broker to use when creating a check and for the broker tls config (if its CID
+	// matches the check bundle broker), avoids broker list and broker API calls - it is still verified
+	Broker *apiclient.Broker
+	// BrokerSelectionStrategy how a broker is chosen from the valid brokers when creating a check,
+	// BrokerSelectionRandom (default) or BrokerSelectionSpread (fewest checks matching CheckSearchTags)
+	BrokerSelectionStrategy BrokerSelectionStrategy
+	// PreferEnterpriseBrokers when any enterprise broker is valid, only enterprise brokers are
+	// selectable (default true). When false all valid brokers are considered equally, with
+	// BrokerSelectionSpread enterprise brokers are preferred among the least loaded brokers.
+	PreferEnterpriseBrokers *bool
+	// BrokerLoadCacheTTL how long the per broker check counts used by BrokerSelectionSpread are cached (default 5m)
+	BrokerLoadCacheTTL string
 	// BrokerSelectTags defines a tag to use when selecting a broker to use (when creating a check)
 	BrokerSelectTags apiclient.TagType
 	// CheckSearchTags defines a tag to use when searching for a check
 	CheckSearchTags apiclient.TagType
-	// PublicCA indicates the broker is using a public cert (do not use custom TLS config)
+	// MultipleMatchBehavior how to pick a bundle when multiple match the search (default MultipleMatchError)
+	MultipleMatchBehavior MultipleMatchBehavior
+	// MultipleMatchTag the tag identifying the bundle to use with MultipleMatchTag behavior
+	MultipleMatchTag string
+	// CheckSearchCriteria overrides the default check search query (type, target, and search tags)
+	// e.g. `(active:1)(notes:"tcid:abc")` - note, the value is used as is (no escaping)
+	CheckSearchCriteria apiclient.SearchQueryType
+	// FilteredWarnThreshold fraction (0..1) of filtered metrics above which a warning is logged (0 disables)
+	FilteredWarnThreshold float64
+	// IPProtocol constrains broker connections (submissions, broker validation) to
+	// IPProtocolIPv4 or IPProtocolIPv6 (default IPProtocolAuto)
+	IPProtocol string
+	// MaxPayloadSize maximum size (bytes) of metrics accepted by SendMetrics, larger payloads
+	// return ErrPayloadTooLarge without making a network call (0 = unlimited)
+	MaxPayloadSize int64
+	// MaxResponseBytes maximum size (bytes) of a broker response read, larger responses return
+	// ErrResponseTooLarge (default 1MB)
+	MaxResponseBytes int64
+	// VerifySubmissionURL probe (tcp) the submission url host:port within BrokerMaxResponseTime when
+	// the trap check is created or the check is refreshed, returns ErrSubmissionEndpointUnreachable on failure
+	VerifySubmissionURL bool
+	// ErrorOnAllFiltered return ErrAllMetricsFiltered (with the result) when the broker filtered every metric
+	ErrorOnAllFiltered bool
+	// ErrorOnBrokerError return a *BrokerError wrapping ErrBrokerReportedError (with the result) when
+	// the broker reports an error in a 200 response (default false, check TrapResult.HasError)
+	ErrorOnBrokerError bool
+	// MetricFilters used instead of the default (allow all) metric filters when creating a check,
+	// if CheckConfig does not set any (see MetricFilterBuilder). Existing checks are not changed.
+	// Mutually exclusive with CheckDefaults.MetricFilters.
+	MetricFilters [][]string
+	// PinnedCertFingerprints hex SHA-256 fingerprints of the broker leaf certificate DER, when set the
+	// broker certificate must match one of them in addition to the CA and CN validation (see GetBrokerCertFingerprint)
+	PinnedCertFingerprints []string
+	// PublicCAHosts additional hosts (matched against the submission url hostname, case insensitive, a
+	// leading '.' matches subdomains) using a public CA cert, in addition to the default (api.circonus.com)
+	PublicCAHosts []string
+	// BrokerPortOverrides broker host to port used when validating brokers, in addition to the
+	// defaults (trap.noit.circonus.net and api.circonus.net use 443)
+	BrokerPortOverrides map[string]uint16
+	// PublicCA indicates the broker is using a public cert (do not use custom TLS config), mutually
+	// exclusive with SubmitTLSConfig - all constructors return an error if both are set
 	PublicCA bool
+	// TLSServerName overrides the ServerName (SNI) of the broker tls config, e.g. brokers behind an
+	// SNI routing load balancer. The broker cert is still verified against the broker CN list (with
+	// StrictTLS, against TLSServerName). Ignored when SubmitTLSConfig or Transport is set.
+	TLSServerName string
+	// AllowRedirects follow broker redirects to any host (up to 10), by default only same host
+	// redirects are followed (up to 3), others fail with ErrRedirectedSubmission
+	AllowRedirects bool
+	// StrictTLS verify the broker certificate with standard verification (broker CA, SAN matching
+	// the submission url host) instead of the compatibility broker CN verification. Broker certs
+	// without SANs fail with ErrStrictTLSNoSAN. Ignored when SubmitTLSConfig or Transport is set.
+	StrictTLS bool
+	// SkipBrokerConnectivityCheck do not verify brokers are reachable (tcp connect) when selecting
+	// or validating a broker, e.g. when checks are created from a host which cannot reach the brokers
+	SkipBrokerConnectivityCheck bool
+	// StrictBrokerTypeMatch require brokers to advertise the extended check type module
+	// (e.g. httptrap:cua) rather than only the base module (httptrap), which only logs a warning
+	StrictBrokerTypeMatch bool
+	// ValidateBundle NewFromCheckBundle fetches the bundle from the API and uses the current bundle if the
+	// submission url or brokers changed, returns ErrBundleGone if it no longer exists (default false, no API calls)
+	ValidateBundle bool
+	// AllowRefreshWithCustomURL refresh the check (broker 404/401/403, RefreshCheckBundle) when SubmissionURL
+	// is set and the check bundle CID is known (CheckConfig.CID), the SubmissionURL scheme and host are kept
+	// with the refreshed bundle submission url path (check uuid, secret). Default false, not refreshed.
+	AllowRefreshWithCustomURL bool
+	// RefreshReplacesCustomURL with AllowRefreshWithCustomURL, a refresh switches to the check bundle
+	// submission url (the trap check then behaves as if SubmissionURL was not set)
+	RefreshReplacesCustomURL bool
+	// RefreshOnStaleBroker when the check bundle broker is not in the broker list (e.g. a cached bundle
+	// using a decommissioned broker) fetch the bundle by CID and use its current brokers and submission
+	// url (default true). When false, or the bundle cannot be fetched, the broker error is returned.
+	RefreshOnStaleBroker *bool
+	// AsyncRefresh refresh the check in a background worker when the broker returns a 404,
+	// SendMetrics returns ErrCheckRefreshing immediately (and while the refresh is in progress)
+	// instead of refreshing and resubmitting inline. Call Close to stop the worker.
+	AsyncRefresh bool
+	// SpoolMaxBytes maximum bytes of failed submissions held in memory and resubmitted (oldest
+	// first) before the next submission, SendMetrics returns ErrSpooled (0 disables the spool)
+	SpoolMaxBytes int64
+	// SpoolMaxAge maximum age of a spooled submission, older submissions are dropped (default 10m)
+	SpoolMaxAge string
+	// SpoolFlushOnClose resubmit spooled submissions when Close is called
+	SpoolFlushOnClose bool
+	// CircuitBreakerThreshold consecutive failed submissions (broker unreachable, 5xx, 404, 429 after the
+	// normal retries) after which SendMetrics fails fast with a *CircuitOpenError (ErrCircuitOpen) for
+	// CircuitBreakerCooldown, then a single probe submission is sent (default 0, disabled)
+	CircuitBreakerThreshold int
+	// CircuitBreakerCooldown how long the circuit breaker stays open before a probe submission (default 30s)
+	CircuitBreakerCooldown string
+	// StreamRetryBufferBytes NewSubmission keeps a copy of streamed (compressed) payloads up to this
+	// size so a failed submission can be resubmitted (default 0, streamed submissions get a single attempt)
+	StreamRetryBufferBytes int64
+	// TransportConfig dial, keep-alive, TLS handshake and idle connection settings for the
+	// submission transport, zero values use the defaults (ignored when Transport is set)
+	TransportConfig TransportConfig
+	// MaxInflightSubmissions maximum number of SubmitAsync submissions in flight, further calls
+	// return ErrTooManyInflight (default 1)
+	MaxInflightSubmissions int
+	// BatchConcurrency maximum number of checks NewBatch searches for/creates concurrently (default 4)
+	BatchConcurrency int
 }
 
 type TrapCheck struct {
 	client                API
 	Log                   Logger
+	requestHook           func(req *http.Request, attempt int) error
+	tracer                Tracer
+	initSpanCtx           context.Context
+	responseHook          func(resp *http.Response)
+	eventHandler          func(Event)
 	brokerList            brokerList.BrokerList
+	caCertExpiry          time.Time
+	caCertLastFetch       time.Time
+	lastRefresh           time.Time
+	lastBundleRecheck     time.Time
 	checkConfig           *apiclient.CheckBundle
 	checkBundle           *apiclient.CheckBundle
+	stateSaveBundle       *apiclient.CheckBundle
 	broker                *apiclient.Broker
+	preselectedBroker     *apiclient.Broker
 	tlsConfig             *tls.Config
 	custTLSConfig         *tls.Config
+	transport             http.RoundTripper
 	custSubmissionURL     string
+	caCertFile            string
+	bundleCacheFile       string
+	proxyURL              *url.URL
+	noProxy               []string
+	publicCAHosts         []string
+	brokerPortOverrides   map[string]string
+	pinnedFingerprints    map[string]bool
 	traceMetrics          string
+	traceLevel            string
+	ipProtocol            string
+	checkInstanceID       string
+	checkSecret           string
+	tlsServerName         string
 	submissionURL         string
+	caCertPEM             []byte
+	caCertInUse           []byte
+	refreshStats          map[RefreshReason]uint64
+	apiCalls              map[string]uint64
+	metricFilters         [][]string
+	checkDefaults         CheckDefaults
 	checkSearchTags       apiclient.TagType
+	checkSearchCriteria   apiclient.SearchQueryType
+	multipleMatchBehavior MultipleMatchBehavior
+	multipleMatchTag      string
 	brokerSelectTags      apiclient.TagType
+	brokerSelectStrategy  BrokerSelectionStrategy
+	brokerLoadCacheTTL    time.Duration
 	submissionTimeout     time.Duration
 	brokerMaxResponseTime time.Duration
+	caCertRefreshWindow   time.Duration
+	checkActiveTimeout    time.Duration
+	initJitter            time.Duration
+	minSubmitInterval     time.Duration
+	bundleRecheckInterval time.Duration
+	refreshCooldown       time.Duration
+	refreshRetryDelay     time.Duration
+	refreshRetryJitter    time.Duration
+	refreshFailures       int
+	refreshFlight         *refreshFlight
+	brokerProbes          map[string]BrokerProbeResult
+	submitConnectTime     int64
+	probeDial             func(network, address string, timeout time.Duration) (net.Conn, error)
+	refreshMu             sync.Mutex
+	stateMu               sync.RWMutex
+	deactivated           int32
+	maxPayloadSize        int64
+	maxResponseBytes      int64
+	filteredWarnThreshold float64
+	lastResultTime        time.Time
+	lastErrorTime         time.Time
+	lastError             error
+	lastResult            *TrapResult
+	clk                   clock.Clock
+	logMu                 sync.RWMutex
+	refreshStatsMu        sync.Mutex
+	apiCallsMu            sync.Mutex
+	lastSubmissionMu      sync.Mutex
+	asyncRefreshMu        sync.Mutex
+	asyncRefreshWG        sync.WaitGroup
+	eventsMu              sync.Mutex
+	eventsWG              sync.WaitGroup
+	asyncRefreshCancel    context.CancelFunc
+	inflight              chan struct{}
+	inflightClose         chan struct{}
+	spoolDrain            chan struct{}
+	inflightWG            sync.WaitGroup
+	maxInflight           int
+	traceMu               sync.Mutex
+	traceFailures         int
+	traceMaxFailures      int
+	circuitMu             sync.Mutex
+	circuitState          CircuitState
+	circuitOpenUntil      time.Time
+	circuitCooldown       time.Duration
+	circuitFailures       int
+	circuitThreshold      int
+	streamRetryBuffer     int64
+	spool                 []spoolEntry
+	eventQueue            []Event
+	stateEvents           []Event
+	spoolStats            SpoolStats
+	spoolMaxBytes         int64
+	spoolBytes            int64
+	spoolMaxAge           time.Duration
+	spoolMu               sync.Mutex
+	transportConfig       TransportConfig
+	resetTLSReason        RefreshReason
 	newCheckBundle        bool
+	errorOnAllFiltered    bool
+	errorOnBrokerError    bool
+	verifySubmission      bool
+	caCertFromState       bool
 	usingPublicCA         bool
 	resetTLSConfig        bool
+	asyncRefresh          bool
+	strictBrokerTypeMatch bool
+	strictTLS             bool
+	allowRedirects        bool
+	skipBrokerConnCheck   bool
+	noEnterprisePref      bool
+	noStaleBrokerRefresh  bool
+	allowCustomRefresh    bool
+	refreshReplacesURL    bool
+	asyncRefreshing       bool
+	closed                bool
+	inflightClosed        bool
+	eventsClosed          bool
+	eventsDispatching     bool
+	connInfoLogged        bool
+	spoolFlushOnClose     bool
+	preselectedVerified   bool
+	circuitProbing        bool
+	agentMode             bool
 }
 
 // New creates a new TrapCheck instance
@@ -82,101 +408,81 @@ func New(cfg *Config) (*TrapCheck, error) {
 		return nil, fmt.Errorf("invalid configuration (nil api client)")
 	}
 
-	tc := &TrapCheck{
-		client:            cfg.Client,
-		checkSearchTags:   cfg.CheckSearchTags,
-		custSubmissionURL: cfg.SubmissionURL,
-		brokerSelectTags:  cfg.BrokerSelectTags,
-		checkBundle:       nil,
-		broker:            nil,
-		tlsConfig:         nil,
-		submissionURL:     "",
-		newCheckBundle:    true,
-		usingPublicCA:     false,
+	tc, err := newTrapCheck(cfg)
+	if err != nil {
+		return nil, err
 	}
+	tc.newCheckBundle = true
 
-	if cfg.SubmitTLSConfig != nil {
-		tc.custTLSConfig = cfg.SubmitTLSConfig.Clone()
-	}
-	if cfg.CheckConfig != nil {
-		userCheckConfig := *cfg.CheckConfig
-		tc.checkConfig = &userCheckConfig
-	}
-	if cfg.PublicCA {
-		tc.custTLSConfig = nil
-		tc.usingPublicCA = true
-	}
+	tc.waitInitJitter()
 
-	if cfg.Logger != nil {
-		tc.Log = cfg.Logger
+	if bundle := tc.loadCachedBundle(); bundle != nil && tc.custSubmissionURL == "" {
+		tc.logger().Debugf("using cached check bundle (%s) from %s", bundle.CID, tc.bundleCacheFile)
+		tc.newCheckBundle = false
+		tc.checkBundle = bundle
+		tc.submissionURL = bundle.Config[config.SubmissionURL]
+		if tc.preselectedBroker == nil {
+			if err := tc.initBrokerList(); err != nil {
+				return nil, err
+			}
+		}
+		if err := tc.setBrokerTLSConfig(); err != nil {
+			return nil, err
+		}
+		if err := tc.verifySubmissionURL(); err != nil {
+			return nil, err
+		}
 	} else {
-		tc.Log = &LogWrapper{
-			Log:   log.New(io.Discard, "", log.LstdFlags),
-			Debug: false,
+		if err := tc.setupCheck(); err != nil {
+			return nil, err
 		}
-	}
-
-	dur := cfg.BrokerMaxResponseTime
-	if dur == "" {
-		dur = defaultBrokerMaxResponseTime
-	}
-	maxDur, err := time.ParseDuration(dur)
-	if err != nil {
-		return nil, fmt.Errorf("parsing broker max response time (%s): %w", dur, err)
-	}
-	tc.brokerMaxResponseTime = maxDur
-
-	if cfg.TraceMetrics != "" {
-		err := testTraceMetricsDir(cfg.TraceMetrics) //nolint:govet
-		if err != nil {
-			tc.Log.Warnf("trace metrics directory (%s): %s -- disabling", cfg.TraceMetrics, err)
-		} else {
-			tc.traceMetrics = cfg.TraceMetrics
+		if tc.custSubmissionURL == "" {
+			tc.saveCachedBundle(tc.checkBundle)
 		}
 	}
 
-	if cfg.CheckConfig != nil {
-		// verify that if the check type is set, it is a variant of httptrap
-		// this module ONLY deals with httptraps.
-		if cfg.CheckConfig.Type != "" && !strings.HasPrefix(cfg.CheckConfig.Type, "httptrap") {
-			return nil, fmt.Errorf("check type must be httptrap variant (%s)", cfg.CheckConfig.Type)
-		}
-	}
+	tc.logAPICallStats("initialization")
 
+	return tc, nil
+}
+
+// setupCheck finds or creates the check (or uses the custom submission url),
+// then initializes the broker list and broker tls config and verifies the
+// submission url.
+func (tc *TrapCheck) setupCheck() error {
 	tc.submissionURL = tc.custSubmissionURL
 	if tc.submissionURL == "" {
-		if err := tc.initializeCheck(); err != nil { //nolint:govet
-			return nil, err
+		if err := tc.initializeCheck(); err != nil {
+			return err
 		}
 		if surl, ok := tc.checkBundle.Config[config.SubmissionURL]; ok {
 			tc.submissionURL = surl
 		} else {
-			return nil, fmt.Errorf("no submission url found in check bundle config")
+			return fmt.Errorf("no submission url found in check bundle config")
 		}
 	} else {
 		// assume a valid bundle was provided in the check config
 		tc.checkBundle = tc.checkConfig
 	}
 
-	sto := cfg.SubmissionTimeout
-	if sto == "" {
-		sto = defaultSubmissionTimeout
-	}
-	stdur, err := time.ParseDuration(sto)
-	if err != nil {
-		return nil, fmt.Errorf("parsing submission timeout (%s): %w", sto, err)
+	if tc.agentMode {
+		if tc.checkBundle == nil {
+			tc.checkBundle = &apiclient.CheckBundle{}
+		}
+		return tc.verifySubmissionURL() // no broker
 	}
-	tc.submissionTimeout = stdur
 
-	if err := tc.initBrokerList(); err != nil {
-		return nil, err
+	if tc.preselectedBroker == nil {
+		if err := tc.initBrokerList(); err != nil {
+			return err
+		}
 	}
 
 	if err := tc.setBrokerTLSConfig(); err != nil {
-		return nil, err
+		return err
 	}
 
-	return tc, nil
+	return tc.verifySubmissionURL()
 }
 
 // NewFromCheckBundle creates a new TrapCheck instance
@@ -193,18 +499,159 @@ func NewFromCheckBundle(cfg *Config, bundle *apiclient.CheckBundle) (*TrapCheck,
 	if bundle == nil {
 		return nil, fmt.Errorf("invalid check bundle (nil)")
 	}
+
+	// verify that if the check type is set, it is a variant of httptrap
+	// this module ONLY deals with httptraps.
+	if bundle.Type != "" && !strings.HasPrefix(bundle.Type, "httptrap") {
+		return nil, fmt.Errorf("check type must be httptrap variant (%s)", bundle.Type)
+	}
+
+	surl, ok := bundle.Config[config.SubmissionURL]
+	if !ok {
+		return nil, fmt.Errorf("invalid check bundle, no submission url found")
+	}
+
+	tc, err := newTrapCheck(cfg)
+	if err != nil {
+		return nil, err
+	}
+
 	userBundle := *bundle
+	tc.checkBundle = &userBundle
+	tc.submissionURL = surl
+
+	tc.waitInitJitter()
+
+	if cfg.ValidateBundle {
+		if err := tc.validateCheckBundle(); err != nil {
+			return nil, err
+		}
+	}
+
+	if tc.preselectedBroker == nil {
+		if err := tc.initBrokerList(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tc.setBrokerTLSConfig(); err != nil {
+		return nil, err
+	}
+
+	if err := tc.verifySubmissionURL(); err != nil {
+		return nil, err
+	}
+
+	return tc, nil
+}
+
+// NewFromSubmissionURL creates a new TrapCheck instance which submits
+// directly to the configured SubmissionURL without an API client.
+// The scheme must be http, or PublicCA must be true, or a SubmitTLSConfig
+// or Transport must be provided. In this mode the check cannot be searched, created,
+// or refreshed - operations requiring the API return ErrNoAPIClient.
+func NewFromSubmissionURL(cfg *Config) (*TrapCheck, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("invalid configuration  (nil)")
+	}
+
+	if cfg.SubmissionURL == "" {
+		return nil, fmt.Errorf("invalid configuration (no submission url)")
+	}
+
+	su, err := parseSubmissionURL(cfg.SubmissionURL)
+	if err != nil {
+		return nil, err
+	}
+	if !su.isHTTP() && !cfg.PublicCA && cfg.SubmitTLSConfig == nil && cfg.Transport == nil {
+		return nil, fmt.Errorf("invalid configuration (%s submission url requires PublicCA, SubmitTLSConfig or Transport)", su.scheme)
+	}
+
+	tc, err := newTrapCheck(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	tc.submissionURL = cfg.SubmissionURL
+	tc.checkBundle = &apiclient.CheckBundle{}
+	if cfg.CheckConfig != nil {
+		userBundle := *cfg.CheckConfig
+		tc.checkBundle = &userBundle
+	}
+
+	if tc.client != nil {
+		if err := tc.initBrokerList(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tc.setBrokerTLSConfig(); err != nil {
+		return nil, err
+	}
+
+	if err := tc.verifySubmissionURL(); err != nil {
+		return nil, err
+	}
+
+	return tc, nil
+}
+
+// newTrapCheck validates the configuration and performs the setup common
+// to all constructors. The check bundle, submission url, broker list and
+// broker tls config are left to the individual constructors.
+func newTrapCheck(cfg *Config) (*TrapCheck, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
 
 	tc := &TrapCheck{
-		client:            cfg.Client,
-		checkSearchTags:   cfg.CheckSearchTags,
-		custSubmissionURL: cfg.SubmissionURL,
-		brokerSelectTags:  cfg.BrokerSelectTags,
-		checkBundle:       &userBundle,
-		broker:            nil,
-		tlsConfig:         nil,
-		submissionURL:     "",
-		newCheckBundle:    false,
+		client:                cfg.Client,
+		checkSearchTags:       NormalizeTags(cfg.CheckSearchTags),
+		checkSearchCriteria:   cfg.CheckSearchCriteria,
+		multipleMatchBehavior: cfg.MultipleMatchBehavior,
+		multipleMatchTag:      cfg.MultipleMatchTag,
+		custSubmissionURL:     cfg.SubmissionURL,
+		checkSecret:           cfg.CheckSecret,
+		tlsServerName:         cfg.TLSServerName,
+		brokerSelectTags:      NormalizeTags(cfg.BrokerSelectTags),
+		brokerSelectStrategy:  cfg.BrokerSelectionStrategy,
+		usingPublicCA:         cfg.PublicCA,
+		filteredWarnThreshold: cfg.FilteredWarnThreshold,
+		errorOnAllFiltered:    cfg.ErrorOnAllFiltered,
+		errorOnBrokerError:    cfg.ErrorOnBrokerError,
+		bundleCacheFile:       cfg.CheckBundleCacheFile,
+		verifySubmission:      cfg.VerifySubmissionURL,
+		maxPayloadSize:        cfg.MaxPayloadSize,
+		maxResponseBytes:      cfg.MaxResponseBytes,
+		ipProtocol:            cfg.IPProtocol,
+		requestHook:           cfg.RequestHook,
+		tracer:                cfg.TraceProvider,
+		responseHook:          cfg.ResponseHook,
+		eventHandler:          cfg.EventHandler,
+		transport:             cfg.Transport,
+		traceLevel:            cfg.TraceLevel,
+		asyncRefresh:          cfg.AsyncRefresh,
+		strictBrokerTypeMatch: cfg.StrictBrokerTypeMatch,
+		strictTLS:             cfg.StrictTLS,
+		allowRedirects:        cfg.AllowRedirects,
+		skipBrokerConnCheck:   cfg.SkipBrokerConnectivityCheck,
+		noEnterprisePref:      cfg.PreferEnterpriseBrokers != nil && !*cfg.PreferEnterpriseBrokers,
+		noStaleBrokerRefresh:  cfg.RefreshOnStaleBroker != nil && !*cfg.RefreshOnStaleBroker,
+		allowCustomRefresh:    cfg.AllowRefreshWithCustomURL,
+		refreshReplacesURL:    cfg.RefreshReplacesCustomURL,
+		spoolMaxBytes:         cfg.SpoolMaxBytes,
+		spoolFlushOnClose:     cfg.SpoolFlushOnClose,
+		transportConfig:       cfg.TransportConfig,
+		maxInflight:           cfg.MaxInflightSubmissions,
+		traceMaxFailures:      cfg.TraceMaxFailures,
+		circuitThreshold:      cfg.CircuitBreakerThreshold,
+		streamRetryBuffer:     cfg.StreamRetryBufferBytes,
+		clk:                   clock.Default(),
+		agentMode:             agentModeConfig(cfg),
+	}
+
+	if cfg.Client != nil {
+		tc.client = &countingAPI{next: cfg.Client, tc: tc}
 	}
 
 	if cfg.SubmitTLSConfig != nil {
@@ -215,6 +662,23 @@ func NewFromCheckBundle(cfg *Config, bundle *apiclient.CheckBundle) (*TrapCheck,
 		tc.checkConfig = &userCheckConfig
 	}
 
+	tc.publicCAHosts = append(append([]string{}, defaultPublicCAHosts...), cfg.PublicCAHosts...)
+	tc.brokerPortOverrides = make(map[string]string, len(defaultBrokerPortOverrides)+len(cfg.BrokerPortOverrides))
+	for host, port := range defaultBrokerPortOverrides {
+		tc.brokerPortOverrides[host] = port
+	}
+	for host, port := range cfg.BrokerPortOverrides {
+		tc.brokerPortOverrides[host] = strconv.Itoa(int(port))
+	}
+
+	tc.metricFilters = copyMetricFilters(cfg.MetricFilters)
+	tc.checkDefaults = cfg.CheckDefaults.clone()
+
+	if cfg.Broker != nil {
+		broker := *cfg.Broker
+		tc.preselectedBroker = &broker
+	}
+
 	if cfg.Logger != nil {
 		tc.Log = cfg.Logger
 	} else {
@@ -224,56 +688,79 @@ func NewFromCheckBundle(cfg *Config, bundle *apiclient.CheckBundle) (*TrapCheck,
 		}
 	}
 
-	dur := cfg.BrokerMaxResponseTime
-	if dur == "" {
-		dur = defaultBrokerMaxResponseTime
+	if cfg.Transport != nil && cfg.SubmitTLSConfig != nil {
+		tc.logger().Warnf("both Transport and SubmitTLSConfig set, using Transport -- SubmitTLSConfig ignored")
 	}
-	maxDur, err := time.ParseDuration(dur)
-	if err != nil {
-		return nil, fmt.Errorf("parsing broker max response time (%s): %w", dur, err)
-	}
-	tc.brokerMaxResponseTime = maxDur
 
-	if cfg.TraceMetrics != "" {
-		err := testTraceMetricsDir(cfg.TraceMetrics) //nolint:govet
-		if err != nil {
-			tc.Log.Warnf("trace metrics directory (%s): %s -- disabling", cfg.TraceMetrics, err)
-		} else {
-			tc.traceMetrics = cfg.TraceMetrics
+	var err error
+	if cfg.CheckInstanceID != "" {
+		if tc.checkInstanceID, err = resolveInstanceID(cfg.CheckInstanceID); err != nil {
+			return nil, err
 		}
 	}
+	if tc.brokerMaxResponseTime, err = parseDurationSetting(cfg.BrokerMaxResponseTime, defaultBrokerMaxResponseTime); err != nil {
+		return nil, fmt.Errorf("parsing broker max response time %w", err)
+	}
+	if tc.submissionTimeout, err = parseDurationSetting(cfg.SubmissionTimeout, defaultSubmissionTimeout); err != nil {
+		return nil, fmt.Errorf("parsing submission timeout %w", err)
+	}
+	if tc.caCertRefreshWindow, err = parseDurationSetting(cfg.CACertRefreshWindow, defaultCACertRefreshWindow); err != nil {
+		return nil, fmt.Errorf("parsing ca cert refresh window %w", err)
+	}
 
-	// verify that if the check type is set, it is a variant of httptrap
-	// this module ONLY deals with httptraps.
-	if tc.checkBundle.Type != "" && !strings.HasPrefix(tc.checkBundle.Type, "httptrap") {
-		return nil, fmt.Errorf("check type must be httptrap variant (%s)", tc.checkBundle.Type)
+	if tc.checkActiveTimeout, err = parseDurationSetting(cfg.CheckActiveTimeout, defaultCheckActiveTimeout); err != nil {
+		return nil, fmt.Errorf("parsing check active timeout %w", err)
 	}
 
-	surl, ok := tc.checkBundle.Config[config.SubmissionURL]
-	if !ok {
-		return nil, fmt.Errorf("invalid check bundle, no submission url found")
+	if tc.initJitter, err = parseDurationSetting(cfg.InitJitter, defaultInitJitter); err != nil {
+		return nil, fmt.Errorf("parsing init jitter %w", err)
 	}
 
-	tc.submissionURL = surl
+	if tc.brokerLoadCacheTTL, err = parseDurationSetting(cfg.BrokerLoadCacheTTL, defaultBrokerLoadCacheTTL); err != nil {
+		return nil, fmt.Errorf("parsing broker load cache ttl %w", err)
+	}
 
-	sto := cfg.SubmissionTimeout
-	if sto == "" {
-		sto = defaultSubmissionTimeout
+	if tc.minSubmitInterval, err = parseDurationSetting(cfg.MinSubmissionInterval, defaultMinSubmissionInterval); err != nil {
+		return nil, fmt.Errorf("parsing min submission interval %w", err)
 	}
-	stdur, err := time.ParseDuration(sto)
-	if err != nil {
-		return nil, fmt.Errorf("parsing submission timeout (%s): %w", sto, err)
+
+	if tc.bundleRecheckInterval, err = parseDurationSetting(cfg.BundleRecheckInterval, defaultBundleRecheckInterval); err != nil {
+		return nil, fmt.Errorf("parsing bundle recheck interval %w", err)
+	}
+
+	if tc.spoolMaxAge, err = parseDurationSetting(cfg.SpoolMaxAge, defaultSpoolMaxAge); err != nil {
+		return nil, fmt.Errorf("parsing spool max age %w", err)
 	}
-	tc.submissionTimeout = stdur
 
-	if err := tc.initBrokerList(); err != nil {
+	if tc.circuitCooldown, err = parseDurationSetting(cfg.CircuitBreakerCooldown, defaultCircuitBreakerCooldown); err != nil {
+		return nil, fmt.Errorf("parsing circuit breaker cooldown %w", err)
+	}
+
+	if err := tc.setPinnedFingerprints(cfg.PinnedCertFingerprints); err != nil {
 		return nil, err
 	}
 
-	if err := tc.setBrokerTLSConfig(); err != nil {
+	if err := tc.setBrokerCACert(cfg.BrokerCACertPEM, cfg.BrokerCACertFile); err != nil {
 		return nil, err
 	}
 
+	if err := tc.setRefreshOptions(cfg); err != nil {
+		return nil, err
+	}
+
+	if err := tc.setProxy(cfg.ProxyURL, cfg.NoProxy); err != nil {
+		return nil, err
+	}
+
+	if cfg.TraceMetrics != "" {
+		err := testTraceMetricsDir(cfg.TraceMetrics) //nolint:govet
+		if err != nil {
+			tc.logger().Warnf("trace metrics directory (%s): %s -- disabling", cfg.TraceMetrics, err)
+		} else {
+			tc.traceMetrics = cfg.TraceMetrics
+		}
+	}
+
 	return tc, nil
 }
 
@@ -281,7 +768,10 @@ func (tc *TrapCheck) initBrokerList() error {
 	if tc.brokerList != nil {
 		return nil
 	}
-	if err := brokerList.Init(tc.client, tc.Log); err != nil {
+	if tc.client == nil {
+		return fmt.Errorf("initializing broker list: %w", ErrNoAPIClient)
+	}
+	if err := brokerList.Init(tc.uncountedClient(), tc.brokerListLogger()); err != nil {
 		return fmt.Errorf("initializing broker list: %w", err)
 	}
 
@@ -289,13 +779,15 @@ func (tc *TrapCheck) initBrokerList() error {
 	if err != nil {
 		return fmt.Errorf("getting broker list instance: %w", err)
 	}
-	tc.brokerList = bl
+	tc.brokerList = bl.WithLogger(tc.brokerListLogger())
 	return nil
 }
 
 // SendMetrics submits the metrics to the broker
 // metrics must be valid JSON encoded data for the broker httptrap check
-// returns trap results in a structure or an error.
+// returns trap results in a structure or an error. Note, with ErrorOnAllFiltered
+// both the trap results and ErrAllMetricsFiltered are returned, with ErrorOnBrokerError
+// both the trap results and a *BrokerError (ErrBrokerReportedError) are returned.
 func (tc *TrapCheck) SendMetrics(ctx context.Context, metrics bytes.Buffer) (*TrapResult, error) { //nolint:contextcheck
 	if ctx == nil {
 		ctx = context.Background()
@@ -303,14 +795,77 @@ func (tc *TrapCheck) SendMetrics(ctx context.Context, metrics bytes.Buffer) (*Tr
 	if metrics.Len() == 0 {
 		return nil, fmt.Errorf("no metrics to submit")
 	}
+	if err := tc.checkPayloadSize(metrics.Bytes(), ""); err != nil {
+		return nil, err
+	}
+	if err := tc.throttle(ctx); err != nil {
+		return nil, err
+	}
+	probe, err := tc.circuitAllow()
+	if err != nil {
+		err = tc.spoolCircuitOpen(metrics.Bytes(), "", err)
+		tc.recordSubmission(nil, err)
+		return nil, err
+	}
+	tc.recheckBundle()
 
-	result, refresh, submitErr := tc.submit(ctx, metrics)
+	result, err := tc.sendMetricsSpooled(ctx, metrics, "")
+	tc.circuitRecord(probe, err)
+	tc.recordSubmission(result, err)
+	return result, err
+}
+
+// SendCompressedMetrics submits metrics which have already been compressed
+// by the caller, encoding must be "gzip" (EncodingGzip) or "zstd" (EncodingZstd)
+// and must match the payload. The payload is sent as is, BytesSent reflects
+// the compressed size and UncompressedBytes is -1 (unknown).
+func (tc *TrapCheck) SendCompressedMetrics(ctx context.Context, gz bytes.Buffer, encoding string) (*TrapResult, error) { //nolint:contextcheck
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if gz.Len() == 0 {
+		return nil, fmt.Errorf("no metrics to submit")
+	}
+	if err := validateEncodedPayload(gz.Bytes(), encoding); err != nil {
+		return nil, err
+	}
+	if err := tc.checkPayloadSize(gz.Bytes(), encoding); err != nil {
+		return nil, err
+	}
+	if err := tc.throttle(ctx); err != nil {
+		return nil, err
+	}
+	probe, err := tc.circuitAllow()
+	if err != nil {
+		err = tc.spoolCircuitOpen(gz.Bytes(), encoding, err)
+		tc.recordSubmission(nil, err)
+		return nil, err
+	}
+	tc.recheckBundle()
+
+	result, err := tc.sendMetricsSpooled(ctx, gz, encoding)
+	tc.circuitRecord(probe, err)
+	tc.recordSubmission(result, err)
+	return result, err
+}
+
+func (tc *TrapCheck) sendMetrics(ctx context.Context, metrics bytes.Buffer, encoding string) (*TrapResult, error) {
+	if tc.asyncRefresh {
+		return tc.sendMetricsAsyncRefresh(ctx, metrics, encoding)
+	}
+
+	result, refresh, submitErr := tc.submitEncoded(ctx, metrics, encoding)
 
 	if refresh {
+		if wait := tc.refreshCooldownRemaining(); wait > 0 {
+			tc.logger().Warnf("check refresh suppressed, next refresh allowed in %s: %s", wait.String(), submitErr)
+			return nil, &refreshSuppressedError{err: submitErr, wait: wait}
+		}
 		// try to refresh the check and reset the tls config
 		// check moved to a different broker, etc.
-		refreshed, refreshErr := tc.refreshCheck()
+		refreshed, refreshErr := tc.refreshCheck(submitRefreshReason(submitErr))
 		if refreshErr != nil {
+			tc.recordRefreshOutcome(false)
 			return nil, refreshErr
 		}
 		if !refreshed {
@@ -318,19 +873,35 @@ func (tc *TrapCheck) SendMetrics(ctx context.Context, metrics bytes.Buffer) (*Tr
 			// submission url) just return the original submit error
 			return nil, fmt.Errorf("unable to refresh: %w", submitErr)
 		}
-		delay := 2 * time.Second
-		tc.Log.Warnf("check refreshed, retrying submission in %s", delay.String())
-		time.Sleep(delay)
+		delay := tc.refreshRetryDelayWithJitter()
+		tc.logger().Warnf("check refreshed, retrying submission in %s", delay.String())
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("waiting to retry submission: %w", ctx.Err())
+		case <-tc.clock().After(delay):
+		}
 		// try submission again, if it fails again just pass the error back to the caller
-		result, _, submitErr = tc.submit(ctx, metrics)
+		result, _, submitErr = tc.submitEncoded(ctx, metrics, encoding)
+		tc.recordRefreshOutcome(submitErr == nil)
 		if submitErr != nil {
-			tc.Log.Warnf("unable to submit after refresh: %s", submitErr)
+			tc.logger().Warnf("unable to submit after refresh: %s", submitErr)
 		}
 	}
 
 	return result, submitErr
 }
 
+// GetBrokerCACertExpiry returns the expiration time of the broker CA cert
+// used to verify the broker - can be used to alert before the cert lapses.
+func (tc *TrapCheck) GetBrokerCACertExpiry() (time.Time, error) {
+	tc.stateMu.RLock()
+	defer tc.stateMu.RUnlock()
+	if tc.caCertExpiry.IsZero() {
+		return time.Time{}, fmt.Errorf("broker ca cert not in use or not initialized")
+	}
+	return tc.caCertExpiry, nil
+}
+
 // IsNewCheckBundle returns true if the check bundle was created.
 func (tc *TrapCheck) IsNewCheckBundle() bool {
 	return tc.newCheckBundle
@@ -338,20 +909,40 @@ func (tc *TrapCheck) IsNewCheckBundle() bool {
 
 // GetCheckBundle returns the trap check bundle currently in use - can be used
 // for caching checks on disk and re-using the check quickly by passing
-// the CID in via the check bundle config.
+// the CID in via the check bundle config (or see Config.CheckBundleCacheFile).
 func (tc *TrapCheck) GetCheckBundle() (apiclient.CheckBundle, error) {
+	if tc.agentMode {
+		return apiclient.CheckBundle{}, ErrNoCheckBundle
+	}
+	tc.stateMu.RLock()
+	defer tc.stateMu.RUnlock()
 	if tc.checkBundle == nil {
 		return apiclient.CheckBundle{}, fmt.Errorf("trap check not initialized/created")
 	}
 	return *tc.checkBundle, nil
 }
 
+// GetSelectedBroker returns a copy of the broker currently in use.
+func (tc *TrapCheck) GetSelectedBroker() (apiclient.Broker, error) {
+	tc.stateMu.RLock()
+	defer tc.stateMu.RUnlock()
+	if tc.broker == nil {
+		return apiclient.Broker{}, fmt.Errorf("broker not selected")
+	}
+	return *tc.broker, nil
+}
+
 // RefreshCheckBundle will pull down a fresh copy from the API.
 func (tc *TrapCheck) RefreshCheckBundle() (apiclient.CheckBundle, error) {
-	refreshed, refreshErr := tc.refreshCheck()
+	if tc.agentMode {
+		return apiclient.CheckBundle{}, ErrNoCheckBundle
+	}
+	refreshed, refreshErr := tc.refreshCheck(RefreshReasonManual)
 	if refreshErr != nil {
 		return apiclient.CheckBundle{}, refreshErr
 	}
+	tc.stateMu.RLock()
+	defer tc.stateMu.RUnlock()
 	if !refreshed {
 		return apiclient.CheckBundle{}, fmt.Errorf("check bundle could not be refreshed - using custom submission URL %s", tc.custSubmissionURL)
 	}
@@ -362,12 +953,20 @@ func (tc *TrapCheck) RefreshCheckBundle() (apiclient.CheckBundle, error) {
 // for pre-seeding multiple check creation without repeatedly
 // calling the API for the same CA cert - returns tls config, error.
 func (tc *TrapCheck) GetBrokerTLSConfig() (*tls.Config, error) {
+	if tc.agentMode {
+		return nil, nil // no broker
+	}
+	tc.stateMu.RLock()
+	defer tc.stateMu.RUnlock()
 	if public, err := tc.isPublicBroker(); err != nil {
 		return nil, err
 	} else if public {
 		return nil, nil
 	}
 	if tc.tlsConfig == nil {
+		if tc.client == nil {
+			return nil, fmt.Errorf("tls config has not been initialized: %w", ErrNoAPIClient)
+		}
 		return nil, fmt.Errorf("tls config has not been initialized")
 	}
 	return tc.tlsConfig.Clone(), nil
@@ -381,16 +980,49 @@ func (tc *TrapCheck) isPublicBroker() (bool, error) {
 		return false, fmt.Errorf("invalid state, no submission url")
 	}
 	if tc.usingPublicCA {
-		return false, nil
+		return true, nil
+	}
+	su, err := parseSubmissionURL(tc.submissionURL)
+	if err != nil {
+		return false, err
+	}
+	hosts := tc.publicCAHosts
+	if hosts == nil {
+		hosts = defaultPublicCAHosts
+	}
+	return matchPublicCAHost(su.host, hosts), nil
+}
+
+// matchPublicCAHost reports whether host matches one of the public CA hosts,
+// case insensitive. An entry with a leading '.' (e.g. ".circonus.com") matches
+// any subdomain, otherwise the host must match exactly.
+func matchPublicCAHost(host string, hosts []string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if host == "" {
+		return false
+	}
+	for _, h := range hosts {
+		h = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(h)), ".")
+		switch {
+		case h == "" || h == ".":
+		case strings.HasPrefix(h, "."):
+			if strings.HasSuffix(host, h) {
+				return true
+			}
+		case host == h:
+			return true
+		}
 	}
-	return strings.Contains(tc.submissionURL, "api.circonus.com"), nil
+	return false
 }
 
 // TraceMetrics allows changing the tracing of metric submissions dynamically,
 // pass "" to disable tracing going forward. returns current setting or error.
 // on error, the current setting will not be changed.
-// Note: if going from no Logger to trace="-" the Logger will need to be set.
+// Note: if going from no Logger to trace="-" the Logger will need to be set (see SetLogger).
 func (tc *TrapCheck) TraceMetrics(trace string) (string, error) {
+	tc.traceMu.Lock()
+	defer tc.traceMu.Unlock()
 	curr := tc.traceMetrics
 	if trace != "" {
 		err := testTraceMetricsDir(trace)
@@ -399,6 +1031,7 @@ func (tc *TrapCheck) TraceMetrics(trace string) (string, error) {
 		}
 	}
 	tc.traceMetrics = trace
+	tc.traceFailures = 0
 	return curr, nil
 }
 
@@ -6,36 +6,46 @@
 package trapcheck
 
 import (
+	"bytes"
+	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"net/http/httptest"
-	"net/url"
 	"reflect"
-	"strconv"
 	"testing"
+	"time"
 
 	"github.com/circonus-labs/go-apiclient"
+	"github.com/circonus-labs/go-trapcheck/trapchecktest"
 )
 
-func TestNew(t *testing.T) {
-	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		fmt.Fprintln(w, "beep boop")
-	}))
-	defer ts.Close()
-
-	tsURL, err := url.Parse(ts.URL)
-	if err != nil {
-		t.Fatalf("creating test broker: %s", err)
-	}
-	brokerIP := tsURL.Hostname()
-	bp, err := strconv.Atoi(tsURL.Port())
-	if err != nil {
-		t.Fatalf("parsing test broker port: %s", err)
+// newTestTrapCheck returns a trap check submitting to submissionURL, with a
+// discarding logger - tests set the options they exercise.
+func newTestTrapCheck(submissionURL string) *TrapCheck {
+	tc := &TrapCheck{
+		checkBundle:       &apiclient.CheckBundle{},
+		submissionURL:     submissionURL,
+		submissionTimeout: 5 * time.Second,
 	}
-	brokerPort := uint16(bp)
+	tc.Log = &LogWrapper{Log: log.New(io.Discard, "", log.LstdFlags)}
+	return tc
+}
+
+func TestNew(t *testing.T) {
+	fb := trapchecktest.NewFakeBroker(t)
+	api := trapchecktest.NewFakeAPI(fb)
+	api.AddCheckBundle(apiclient.CheckBundle{
+		CID:        "/check_bundle/123",
+		Brokers:    []string{"/broker/1"},
+		CheckUUIDs: []string{"abc-123"},
+		Type:       "httptrap",
+		Config:     apiclient.CheckBundleConfig{"submission_url": fb.SubmissionURL("abc-123", "secret")},
+		Status:     "active",
+	})
 
 	tests := []struct {
 		cfg     *Config
@@ -45,36 +55,12 @@ func TestNew(t *testing.T) {
 	}{
 		{name: "invalid, nil config", wantErr: true},
 		{name: "invalid, no api client", cfg: &Config{}, wantErr: true},
+		{name: "invalid, broker ca cert pem", cfg: &Config{Client: &APIMock{}, BrokerCACertPEM: []byte("foobar")}, wantErr: true},
 		{
 			name: "valid, pre-existing check",
 			cfg: &Config{
 				CheckConfig: &apiclient.CheckBundle{CID: "/check_bundle/123"},
-				Client: &APIMock{
-					FetchCheckBundleFunc: func(cid apiclient.CIDType) (*apiclient.CheckBundle, error) {
-						return &apiclient.CheckBundle{
-							CID:     "/check_bundle/123",
-							Brokers: []string{"/broker/123"},
-							Type:    "httptrap",
-							Config:  apiclient.CheckBundleConfig{"submission_url": fmt.Sprintf("http://%s:%d", brokerIP, brokerPort)},
-							Status:  "active",
-						}, nil
-					},
-					FetchBrokerFunc: func(cid apiclient.CIDType) (*apiclient.Broker, error) {
-						return &apiclient.Broker{
-							CID:  "/broker/123",
-							Name: "foo",
-							Type: circonusType,
-							Details: []apiclient.BrokerDetail{
-								{
-									Status:  statusActive,
-									Modules: []string{"httptrap"},
-									IP:      &brokerIP,
-									Port:    &brokerPort,
-								},
-							},
-						}, nil
-					},
-				},
+				Client:      api,
 			},
 			wantErr: false,
 		},
@@ -246,3 +232,69 @@ func TestTrapCheck_RefreshCheckBundle(t *testing.T) {
 		})
 	}
 }
+
+func TestNewFromSubmissionURL(t *testing.T) {
+	tests := []struct {
+		cfg     *Config
+		name    string
+		wantErr bool
+	}{
+		{name: "invalid, nil config", wantErr: true},
+		{name: "invalid, no submission url", cfg: &Config{}, wantErr: true},
+		{name: "invalid, bad submission url", cfg: &Config{SubmissionURL: ":foo"}, wantErr: true},
+		{name: "invalid, https w/o tls config or public ca", cfg: &Config{SubmissionURL: "https://127.0.0.1:2609/write/test"}, wantErr: true},
+		{name: "valid, http", cfg: &Config{SubmissionURL: "http://127.0.0.1:2609/write/test"}, wantErr: false},
+		{name: "valid, https public ca", cfg: &Config{SubmissionURL: "https://trap.example.com/write/test", PublicCA: true}, wantErr: false},
+		{name: "valid, https transport", cfg: &Config{SubmissionURL: "https://127.0.0.1:2609/write/test", Transport: http.DefaultTransport}, wantErr: false},
+		{
+			name: "valid, https tls config",
+			cfg: &Config{
+				SubmissionURL:   "https://127.0.0.1:2609/write/test",
+				SubmitTLSConfig: &tls.Config{ServerName: "foobar", MinVersion: tls.VersionTLS12},
+			},
+			wantErr: false,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewFromSubmissionURL(tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewFromSubmissionURL() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewFromSubmissionURL_noAPIClient(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, `{"stats":1}`)
+	}))
+	defer ts.Close()
+
+	tc, err := NewFromSubmissionURL(&Config{SubmissionURL: ts.URL})
+	if err != nil {
+		t.Fatalf("NewFromSubmissionURL() error = %v", err)
+	}
+
+	var metrics bytes.Buffer
+	metrics.WriteString(`{"foo":{"_type":"n","_value":1}}`)
+	result, err := tc.SendMetrics(context.Background(), metrics)
+	if err != nil {
+		t.Fatalf("SendMetrics() error = %v", err)
+	}
+	if result.Stats != 1 {
+		t.Errorf("SendMetrics() stats = %d, want 1", result.Stats)
+	}
+
+	// http submission url without a check config, agent mode
+	if _, err := tc.RefreshCheckBundle(); !errors.Is(err, ErrNoCheckBundle) {
+		t.Errorf("RefreshCheckBundle() error = %v, want %v", err, ErrNoCheckBundle)
+	}
+	if _, err := tc.UpdateCheckTags(context.Background(), []string{"foo:bar"}); !errors.Is(err, ErrNoAPIClient) {
+		t.Errorf("UpdateCheckTags() error = %v, want %v", err, ErrNoAPIClient)
+	}
+	if cfg, err := tc.GetBrokerTLSConfig(); err != nil || cfg != nil {
+		t.Errorf("GetBrokerTLSConfig() = %v, %v, want nil, nil", cfg, err)
+	}
+}
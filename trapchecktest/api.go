@@ -0,0 +1,341 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapchecktest
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/circonus-labs/go-apiclient"
+	"github.com/circonus-labs/go-apiclient/config"
+	"github.com/google/uuid"
+)
+
+// FakeAPI is an in-memory implementation of the trapcheck API interface.
+// Brokers and check bundles are held in memory, check bundles created through
+// the API get a submission url on the FakeBroker of their (first) broker.
+type FakeAPI struct {
+	brokers     []apiclient.Broker
+	fakeBrokers map[string]*FakeBroker
+	bundles     []apiclient.CheckBundle
+	calls       map[string]int
+	caCertPEM   []byte
+	nextID      int
+	mu          sync.Mutex
+}
+
+// NewFakeAPI returns a FakeAPI with broker (as /broker/1) in its broker list. If the
+// broker uses tls its CA cert is returned for the broker CA cert (/pki/ca.crt).
+func NewFakeAPI(broker *FakeBroker) *FakeAPI {
+	api := &FakeAPI{
+		fakeBrokers: make(map[string]*FakeBroker),
+		calls:       make(map[string]int),
+	}
+	if broker != nil {
+		api.AddBroker("/broker/1", broker)
+		api.caCertPEM = broker.CACertPEM()
+	}
+	return api
+}
+
+// AddBroker adds the fake broker to the broker list with cid, and returns the broker.
+func (api *FakeAPI) AddBroker(cid string, broker *FakeBroker) apiclient.Broker {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	b := broker.Broker(cid, strings.TrimPrefix(cid, "/broker/"))
+	api.brokers = append(api.brokers, b)
+	api.fakeBrokers[cid] = broker
+	return b
+}
+
+// AddCheckBundle adds (or replaces, by cid) a check bundle, a cid is assigned
+// if the bundle does not have one. Returns the stored bundle.
+func (api *FakeAPI) AddCheckBundle(bundle apiclient.CheckBundle) apiclient.CheckBundle {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	if bundle.CID == "" {
+		bundle.CID = api.newCID()
+	}
+	for i := range api.bundles {
+		if api.bundles[i].CID == bundle.CID {
+			api.bundles[i] = bundle
+			return bundle
+		}
+	}
+	api.bundles = append(api.bundles, bundle)
+	return bundle
+}
+
+// MoveCheckBundle moves the check bundle to another broker (which must have
+// been added with AddBroker), the submission url changes to the new broker -
+// e.g. to exercise the check refresh path.
+func (api *FakeAPI) MoveCheckBundle(cid, brokerCID string) error {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	fb, ok := api.fakeBrokers[brokerCID]
+	if !ok {
+		return fmt.Errorf("unknown broker (%s)", brokerCID)
+	}
+	for i := range api.bundles {
+		b := &api.bundles[i]
+		if b.CID != cid {
+			continue
+		}
+		checkUUID, secret := "", ""
+		if len(b.CheckUUIDs) > 0 {
+			checkUUID = b.CheckUUIDs[0]
+		}
+		if b.Config != nil {
+			secret = b.Config[config.Secret]
+		}
+		b.Brokers = []string{brokerCID}
+		b.Config = copyConfig(b.Config)
+		b.Config[config.SubmissionURL] = fb.SubmissionURL(checkUUID, secret)
+		b.LastModified++
+		return nil
+	}
+	return notFound("check bundle", cid)
+}
+
+// CheckBundles returns a copy of the check bundles.
+func (api *FakeAPI) CheckBundles() []apiclient.CheckBundle {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	return append([]apiclient.CheckBundle(nil), api.bundles...)
+}
+
+// Calls returns the number of calls made to the API method (e.g. "CreateCheckBundle").
+func (api *FakeAPI) Calls(method string) int {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	return api.calls[method]
+}
+
+// TotalCalls returns the number of calls made to all API methods.
+func (api *FakeAPI) TotalCalls() int {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	total := 0
+	for _, n := range api.calls {
+		total += n
+	}
+	return total
+}
+
+// Get returns the broker CA cert for /pki/ca.crt.
+func (api *FakeAPI) Get(requrl string) ([]byte, error) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	api.calls["Get"]++
+	if requrl != "/pki/ca.crt" || len(api.caCertPEM) == 0 {
+		return nil, notFound("url", requrl)
+	}
+	data, err := json.Marshal(struct {
+		Contents string `json:"contents"`
+	}{Contents: string(api.caCertPEM)})
+	if err != nil {
+		return nil, fmt.Errorf("encoding ca cert: %w", err)
+	}
+	return data, nil
+}
+
+// FetchBroker returns the broker with the cid.
+func (api *FakeAPI) FetchBroker(cid apiclient.CIDType) (*apiclient.Broker, error) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	api.calls["FetchBroker"]++
+	id := cidString(cid)
+	for _, b := range api.brokers {
+		if b.CID == id {
+			broker := b
+			return &broker, nil
+		}
+	}
+	return nil, notFound("broker", id)
+}
+
+// FetchBrokers returns all brokers.
+func (api *FakeAPI) FetchBrokers() (*[]apiclient.Broker, error) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	api.calls["FetchBrokers"]++
+	brokers := append([]apiclient.Broker(nil), api.brokers...)
+	return &brokers, nil
+}
+
+// SearchBrokers returns the brokers with all of the tags in the search criteria ((tags:a,b)).
+func (api *FakeAPI) SearchBrokers(searchCriteria *apiclient.SearchQueryType, filterCriteria *apiclient.SearchFilterType) (*[]apiclient.Broker, error) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	api.calls["SearchBrokers"]++
+	terms := parseSearch(searchCriteria)
+	brokers := []apiclient.Broker{}
+	for _, b := range api.brokers {
+		if hasTags(b.Tags, terms["tags"]) {
+			brokers = append(brokers, b)
+		}
+	}
+	return &brokers, nil
+}
+
+// FetchCheckBundle returns the check bundle with the cid.
+func (api *FakeAPI) FetchCheckBundle(cid apiclient.CIDType) (*apiclient.CheckBundle, error) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	api.calls["FetchCheckBundle"]++
+	id := cidString(cid)
+	for _, b := range api.bundles {
+		if b.CID == id {
+			bundle := b
+			return &bundle, nil
+		}
+	}
+	return nil, notFound("check bundle", id)
+}
+
+// CreateCheckBundle creates an active check bundle with a check uuid and a
+// submission url on the FakeBroker of its first broker.
+func (api *FakeAPI) CreateCheckBundle(cfg *apiclient.CheckBundle) (*apiclient.CheckBundle, error) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	api.calls["CreateCheckBundle"]++
+	if cfg == nil {
+		return nil, fmt.Errorf("invalid check bundle config (nil)")
+	}
+	if len(cfg.Brokers) == 0 {
+		return nil, fmt.Errorf("API response code 400: check bundle requires a broker")
+	}
+	fb, ok := api.fakeBrokers[cfg.Brokers[0]]
+	if !ok {
+		return nil, fmt.Errorf("API response code 400: unknown broker (%s)", cfg.Brokers[0])
+	}
+
+	bundle := *cfg
+	bundle.CID = api.newCID()
+	bundle.Status = "active"
+	bundle.CheckUUIDs = []string{uuid.New().String()}
+	bundle.Checks = []string{strings.Replace(bundle.CID, "check_bundle", "check", 1)}
+	bundle.Created = uint(time.Now().Unix())
+	bundle.LastModified = bundle.Created
+	bundle.Config = copyConfig(cfg.Config)
+	secret := bundle.Config[config.Secret]
+	if secret == "" {
+		secret = "secret"
+		bundle.Config[config.Secret] = secret
+	}
+	bundle.Config[config.SubmissionURL] = fb.SubmissionURL(bundle.CheckUUIDs[0], secret)
+	api.bundles = append(api.bundles, bundle)
+
+	created := bundle
+	return &created, nil
+}
+
+// SearchCheckBundles returns the check bundles matching the search criteria,
+// (active:1), (type:"x"), (target:"x") and (tags:a,b) are supported.
+func (api *FakeAPI) SearchCheckBundles(searchCriteria *apiclient.SearchQueryType, filterCriteria *apiclient.SearchFilterType) (*[]apiclient.CheckBundle, error) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	api.calls["SearchCheckBundles"]++
+	terms := parseSearch(searchCriteria)
+	bundles := []apiclient.CheckBundle{}
+	for _, b := range api.bundles {
+		switch {
+		case terms["active"] == "1" && b.Status != "active":
+		case terms["type"] != "" && terms["type"] != b.Type:
+		case terms["target"] != "" && terms["target"] != b.Target:
+		case !hasTags(b.Tags, terms["tags"]):
+		default:
+			bundles = append(bundles, b)
+		}
+	}
+	return &bundles, nil
+}
+
+// UpdateCheckBundle replaces the check bundle with the same cid.
+func (api *FakeAPI) UpdateCheckBundle(cfg *apiclient.CheckBundle) (*apiclient.CheckBundle, error) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	api.calls["UpdateCheckBundle"]++
+	if cfg == nil {
+		return nil, fmt.Errorf("invalid check bundle config (nil)")
+	}
+	for i := range api.bundles {
+		if api.bundles[i].CID != cfg.CID {
+			continue
+		}
+		bundle := *cfg
+		bundle.Config = copyConfig(cfg.Config)
+		bundle.LastModified = api.bundles[i].LastModified + 1
+		api.bundles[i] = bundle
+		updated := bundle
+		return &updated, nil
+	}
+	return nil, notFound("check bundle", cfg.CID)
+}
+
+// newCID returns the next check bundle cid, the lock must be held.
+func (api *FakeAPI) newCID() string {
+	api.nextID++
+	return fmt.Sprintf("/check_bundle/%d", api.nextID)
+}
+
+// notFound returns an error matching the API client's 404 errors.
+func notFound(kind, id string) error {
+	return fmt.Errorf("API response code 404: %s (%s) not found", kind, id)
+}
+
+func cidString(cid apiclient.CIDType) string {
+	if cid == nil {
+		return ""
+	}
+	return *cid
+}
+
+func copyConfig(cfg apiclient.CheckBundleConfig) apiclient.CheckBundleConfig {
+	c := make(apiclient.CheckBundleConfig, len(cfg)+2)
+	for k, v := range cfg {
+		c[k] = v
+	}
+	return c
+}
+
+var searchTerm = regexp.MustCompile(`\((\w+):"?([^)"]*)"?\)`)
+
+// parseSearch returns the (key:value) terms of a search query.
+func parseSearch(query *apiclient.SearchQueryType) map[string]string {
+	terms := make(map[string]string)
+	if query == nil {
+		return terms
+	}
+	for _, m := range searchTerm.FindAllStringSubmatch(string(*query), -1) {
+		terms[m[1]] = m[2]
+	}
+	return terms
+}
+
+// hasTags returns true if tags contains all of the comma separated want tags.
+func hasTags(tags []string, want string) bool {
+	for _, w := range strings.Split(want, ",") {
+		if w == "" {
+			continue
+		}
+		found := false
+		for _, t := range tags {
+			if strings.EqualFold(t, w) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
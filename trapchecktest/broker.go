@@ -0,0 +1,262 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+// Package trapchecktest provides a fake broker and a fake API for testing
+// go-trapcheck consumers end-to-end, without a Circonus account.
+//
+// The trapcheck broker list is cached per process, using the API client of the
+// first trap check created - use one FakeAPI (adding a FakeBroker per scenario
+// with AddBroker) for all trap checks in a test binary.
+package trapchecktest
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/circonus-labs/go-apiclient"
+)
+
+// Submission is a metric submission received by a FakeBroker.
+type Submission struct {
+	Header  http.Header
+	Path    string
+	Method  string
+	Payload []byte // decompressed, if sent gzip encoded
+	Gzipped bool
+}
+
+// Response is a response returned by a FakeBroker.
+type Response struct {
+	Body   string // e.g. `{"stats":1}`
+	Status int    // default http.StatusOK
+}
+
+// FakeBroker is an httptest server accepting httptrap submissions. Submissions
+// are recorded and answered with queued responses, then the default response
+// (`{"stats":N}`, N the number of top level metrics in the payload).
+type FakeBroker struct {
+	server      *httptest.Server
+	tb          testing.TB
+	cn          string
+	caCertPEM   []byte
+	submissions []Submission
+	queued      []Response
+	def         *Response
+	notFound    int
+	delay       time.Duration
+	mu          sync.Mutex
+}
+
+// NewFakeBroker starts a FakeBroker using http, it is closed when the test completes.
+func NewFakeBroker(tb testing.TB) *FakeBroker {
+	tb.Helper()
+	fb := &FakeBroker{tb: tb}
+	fb.server = httptest.NewServer(http.HandlerFunc(fb.handle))
+	tb.Cleanup(fb.Close)
+	return fb
+}
+
+// NewFakeTLSBroker starts a FakeBroker using https, with a certificate for
+// cn signed by a generated CA (see CACertPEM), it is closed when the test completes.
+func NewFakeTLSBroker(tb testing.TB, cn string) *FakeBroker {
+	tb.Helper()
+	ca, err := newCA()
+	if err != nil {
+		tb.Fatalf("trapchecktest: generating ca: %s", err)
+	}
+	cert, err := ca.issue(cn)
+	if err != nil {
+		tb.Fatalf("trapchecktest: generating broker cert: %s", err)
+	}
+
+	fb := &FakeBroker{tb: tb, cn: cn, caCertPEM: ca.certPEM}
+	fb.server = httptest.NewUnstartedServer(http.HandlerFunc(fb.handle))
+	fb.server.TLS = &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	fb.server.StartTLS()
+	tb.Cleanup(fb.Close)
+	return fb
+}
+
+// Close shuts down the broker.
+func (fb *FakeBroker) Close() {
+	fb.server.Close()
+}
+
+// URL returns the base url of the broker (http://ip:port or https://ip:port).
+func (fb *FakeBroker) URL() string {
+	return fb.server.URL
+}
+
+// CACertPEM returns the PEM encoded CA cert which signed the broker certificate (nil if not using tls).
+func (fb *FakeBroker) CACertPEM() []byte {
+	return fb.caCertPEM
+}
+
+// CN returns the common name of the broker certificate ("" if not using tls).
+func (fb *FakeBroker) CN() string {
+	return fb.cn
+}
+
+// SubmissionURL returns an httptrap submission url on the broker for the check uuid and secret.
+func (fb *FakeBroker) SubmissionURL(checkUUID, secret string) string {
+	return fb.server.URL + "/module/httptrap/" + checkUUID + "/" + secret
+}
+
+// Broker returns an active broker with a single instance (the fake broker),
+// supporting httptrap checks.
+func (fb *FakeBroker) Broker(cid, name string) apiclient.Broker {
+	host, portStr, err := net.SplitHostPort(fb.server.Listener.Addr().String())
+	if err != nil {
+		fb.tb.Fatalf("trapchecktest: broker address: %s", err)
+	}
+	p, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		fb.tb.Fatalf("trapchecktest: broker port: %s", err)
+	}
+	port := uint16(p)
+	cn := fb.cn
+	if cn == "" {
+		cn = name
+	}
+	return apiclient.Broker{
+		CID:  cid,
+		Name: name,
+		Type: "circonus",
+		Details: []apiclient.BrokerDetail{
+			{
+				CN:      cn,
+				IP:      &host,
+				Port:    &port,
+				Status:  "active",
+				Modules: []string{"httptrap"},
+			},
+		},
+	}
+}
+
+// SetResponse sets the default response, used when no responses are queued.
+func (fb *FakeBroker) SetResponse(status int, body string) {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+	fb.def = &Response{Status: status, Body: body}
+}
+
+// QueueResponse queues a response, queued responses are used in order before the default.
+func (fb *FakeBroker) QueueResponse(status int, body string) {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+	fb.queued = append(fb.queued, Response{Status: status, Body: body})
+}
+
+// RespondNotFound responds 404 to the next n submissions (e.g. a deleted or
+// moved check), then recovers.
+func (fb *FakeBroker) RespondNotFound(n int) {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+	fb.notFound = n
+}
+
+// SetDelay delays every response (e.g. to exercise submission timeouts).
+func (fb *FakeBroker) SetDelay(d time.Duration) {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+	fb.delay = d
+}
+
+// Submissions returns a copy of the recorded submissions, including those
+// answered with an error status.
+func (fb *FakeBroker) Submissions() []Submission {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+	return append([]Submission(nil), fb.submissions...)
+}
+
+// Reset clears the recorded submissions and queued responses.
+func (fb *FakeBroker) Reset() {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+	fb.submissions = nil
+	fb.queued = nil
+	fb.notFound = 0
+}
+
+func (fb *FakeBroker) handle(w http.ResponseWriter, r *http.Request) {
+	sub := Submission{Method: r.Method, Path: r.URL.Path, Header: r.Header.Clone()}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if strings.EqualFold(r.Header.Get("Content-Encoding"), "gzip") {
+		zr, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			http.Error(w, "gzip: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		body, err = io.ReadAll(zr)
+		if err != nil {
+			http.Error(w, "gzip: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		sub.Gzipped = true
+	}
+	sub.Payload = body
+
+	fb.mu.Lock()
+	fb.submissions = append(fb.submissions, sub)
+	delay := fb.delay
+	var resp Response
+	switch {
+	case fb.notFound > 0:
+		fb.notFound--
+		resp = Response{Status: http.StatusNotFound, Body: "not found"}
+	case len(fb.queued) > 0:
+		resp = fb.queued[0]
+		fb.queued = fb.queued[1:]
+	case fb.def != nil:
+		resp = *fb.def
+	default:
+		resp = Response{Body: fmt.Sprintf(`{"stats":%d}`, countMetrics(body))}
+	}
+	fb.mu.Unlock()
+
+	if delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	if resp.Status == 0 {
+		resp.Status = http.StatusOK
+	}
+	if resp.Status == http.StatusOK {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.WriteHeader(resp.Status)
+	fmt.Fprint(w, resp.Body)
+}
+
+// countMetrics returns the number of top level keys in a JSON object payload.
+func countMetrics(payload []byte) int {
+	var metrics map[string]interface{}
+	if err := json.Unmarshal(payload, &metrics); err != nil {
+		return 0
+	}
+	return len(metrics)
+}
@@ -0,0 +1,235 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapchecktest_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/circonus-labs/go-apiclient"
+	trapcheck "github.com/circonus-labs/go-trapcheck"
+	brokerList "github.com/circonus-labs/go-trapcheck/internal/broker_list"
+	"github.com/circonus-labs/go-trapcheck/trapchecktest"
+)
+
+var _ trapcheck.API = (*trapchecktest.FakeAPI)(nil)
+
+// testConfig returns a config for a check on the broker, target identifies
+// the check (the fake api is shared by the subtests).
+func testConfig(api *trapchecktest.FakeAPI, brokerCID, target string) *trapcheck.Config {
+	return &trapcheck.Config{
+		Client:            api,
+		CheckConfig:       &apiclient.CheckBundle{Brokers: []string{brokerCID}, Target: target},
+		SubmissionTimeout: "5s",
+		RefreshRetryDelay: "10ms",
+		Logger:            &trapcheck.LogWrapper{Log: log.New(io.Discard, "", log.LstdFlags)},
+	}
+}
+
+// useBrokerList points the (per process) trapcheck broker list at the api,
+// so the brokers of a previous test (or run, with -count) are not used.
+func useBrokerList(t *testing.T, api *trapchecktest.FakeAPI) {
+	t.Helper()
+
+	logger := &trapcheck.LogWrapper{Log: log.New(io.Discard, "", log.LstdFlags)}
+	if err := brokerList.Init(api, logger); err != nil {
+		t.Fatalf("initializing broker list: %s", err)
+	}
+	bl, err := brokerList.GetInstance()
+	if err != nil {
+		t.Fatalf("getting broker list instance: %s", err)
+	}
+	if err := bl.SetClient(api); err != nil {
+		t.Fatalf("broker list setting client: %s", err)
+	}
+	if err := bl.FetchBrokers(); err != nil {
+		t.Fatalf("broker list fetching brokers: %s", err)
+	}
+}
+
+func sendMetrics(t *testing.T, tc *trapcheck.TrapCheck) (*trapcheck.TrapResult, error) {
+	t.Helper()
+	var metrics bytes.Buffer
+	metrics.WriteString(`{"foo":{"_type":"n","_value":1}}`)
+	return tc.SendMetrics(context.Background(), metrics)
+}
+
+func TestFakeBroker(t *testing.T) {
+	fb := trapchecktest.NewFakeBroker(t)
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write([]byte(`{"a":1,"b":2}`)); err != nil {
+		t.Fatalf("gzip write: %s", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("gzip close: %s", err)
+	}
+	req, err := http.NewRequest(http.MethodPut, fb.SubmissionURL("abc-123", "secret"), &buf)
+	if err != nil {
+		t.Fatalf("creating request: %s", err)
+	}
+	req.Header.Set("Content-Encoding", "gzip")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("submitting: %s", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || strings.TrimSpace(string(body)) != `{"stats":2}` {
+		t.Errorf("response = %d %s, want 200 {\"stats\":2}", resp.StatusCode, body)
+	}
+	subs := fb.Submissions()
+	if len(subs) != 1 {
+		t.Fatalf("Submissions() = %d, want 1", len(subs))
+	}
+	if !subs[0].Gzipped || string(subs[0].Payload) != `{"a":1,"b":2}` {
+		t.Errorf("Submissions()[0] gzipped = %t payload = %s", subs[0].Gzipped, subs[0].Payload)
+	}
+	if subs[0].Path != "/module/httptrap/abc-123/secret" {
+		t.Errorf("Submissions()[0] path = %s", subs[0].Path)
+	}
+}
+
+func TestFakeBroker_responses(t *testing.T) {
+	fb := trapchecktest.NewFakeBroker(t)
+	fb.RespondNotFound(1)
+	fb.QueueResponse(http.StatusServiceUnavailable, "busy")
+	fb.SetResponse(http.StatusOK, `{"stats":5}`)
+
+	want := []int{http.StatusNotFound, http.StatusServiceUnavailable, http.StatusOK}
+	for i, code := range want {
+		req, err := http.NewRequest(http.MethodPut, fb.SubmissionURL("abc-123", "secret"), strings.NewReader(`{}`))
+		if err != nil {
+			t.Fatalf("creating request: %s", err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("submitting: %s", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != code {
+			t.Errorf("submission %d status = %d, want %d", i, resp.StatusCode, code)
+		}
+	}
+}
+
+// TestEndToEnd uses one FakeAPI for all trap checks.
+func TestEndToEnd(t *testing.T) {
+	tlsBroker := trapchecktest.NewFakeTLSBroker(t, "broker.example.com")
+	api := trapchecktest.NewFakeAPI(tlsBroker) // /broker/1, the api returns its ca cert
+	fb1 := trapchecktest.NewFakeBroker(t)
+	fb2 := trapchecktest.NewFakeBroker(t)
+	slow := trapchecktest.NewFakeBroker(t)
+	api.AddBroker("/broker/2", fb1)
+	api.AddBroker("/broker/3", fb2)
+	api.AddBroker("/broker/4", slow)
+	useBrokerList(t, api)
+
+	t.Run("create check", func(t *testing.T) {
+		tc, err := trapcheck.New(testConfig(api, "/broker/2", "create"))
+		if err != nil {
+			t.Fatalf("New() error = %v", err)
+		}
+		if !tc.IsNewCheckBundle() {
+			t.Errorf("IsNewCheckBundle() = false, want true")
+		}
+		if n := api.Calls("CreateCheckBundle"); n != 1 {
+			t.Errorf("CreateCheckBundle calls = %d, want 1", n)
+		}
+
+		result, err := sendMetrics(t, tc)
+		if err != nil {
+			t.Fatalf("SendMetrics() error = %v", err)
+		}
+		if result.Stats != 1 {
+			t.Errorf("SendMetrics() stats = %d, want 1", result.Stats)
+		}
+		if n := len(fb1.Submissions()); n != 1 {
+			t.Errorf("broker submissions = %d, want 1", n)
+		}
+
+		// a second trap check finds the created check instead of creating another
+		if _, err := trapcheck.New(testConfig(api, "/broker/2", "create")); err != nil {
+			t.Fatalf("New() error = %v", err)
+		}
+		if n := api.Calls("CreateCheckBundle"); n != 1 {
+			t.Errorf("CreateCheckBundle calls = %d, want 1", n)
+		}
+	})
+
+	t.Run("check moved", func(t *testing.T) {
+		fb1.Reset()
+		tc, err := trapcheck.New(testConfig(api, "/broker/2", "moved"))
+		if err != nil {
+			t.Fatalf("New() error = %v", err)
+		}
+		bundle, err := tc.GetCheckBundle()
+		if err != nil {
+			t.Fatalf("GetCheckBundle() error = %v", err)
+		}
+
+		// check moved to another broker, the old broker no longer accepts it
+		if err := api.MoveCheckBundle(bundle.CID, "/broker/3"); err != nil {
+			t.Fatalf("MoveCheckBundle() error = %v", err)
+		}
+		fb1.RespondNotFound(1)
+
+		if _, err := sendMetrics(t, tc); err != nil {
+			t.Fatalf("SendMetrics() error = %v", err)
+		}
+		if n := len(fb2.Submissions()); n != 1 {
+			t.Errorf("new broker submissions = %d, want 1", n)
+		}
+		if n := tc.RefreshStats()[string(trapcheck.RefreshReasonHTTP404)]; n != 1 {
+			t.Errorf("RefreshStats() %s = %d, want 1", trapcheck.RefreshReasonHTTP404, n)
+		}
+	})
+
+	t.Run("tls", func(t *testing.T) {
+		tc, err := trapcheck.New(testConfig(api, "/broker/1", "tls"))
+		if err != nil {
+			t.Fatalf("New() error = %v", err)
+		}
+		if _, err := sendMetrics(t, tc); err != nil {
+			t.Fatalf("SendMetrics() error = %v", err)
+		}
+
+		info, err := tc.ConnectionInfo()
+		if err != nil {
+			t.Fatalf("ConnectionInfo() error = %v", err)
+		}
+		if !info.UsesTLS || info.PublicCA || info.ServerName != "broker.example.com" {
+			t.Errorf("ConnectionInfo() = %s", info)
+		}
+		if info.CACertSubject == "" {
+			t.Errorf("ConnectionInfo() CACertSubject empty, want generated CA")
+		}
+	})
+
+	t.Run("slow broker", func(t *testing.T) {
+		cfg := testConfig(api, "/broker/4", "slow")
+		cfg.SubmissionTimeout = "200ms"
+		tc, err := trapcheck.New(cfg)
+		if err != nil {
+			t.Fatalf("New() error = %v", err)
+		}
+
+		slow.SetDelay(5 * time.Second)
+		if _, err := sendMetrics(t, tc); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("SendMetrics() error = %v, want %v", err, context.DeadlineExceeded)
+		}
+	})
+}
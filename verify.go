@@ -0,0 +1,44 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ErrSubmissionEndpointUnreachable is returned (wrapped) when Config.VerifySubmissionURL
+// is set and the submission url host:port cannot be reached within BrokerMaxResponseTime.
+var ErrSubmissionEndpointUnreachable = errors.New("submission endpoint unreachable")
+
+// verifySubmissionURL probes (tcp) the submission url host:port - the port
+// may differ from the one the broker was validated with (e.g. a load balancer).
+func (tc *TrapCheck) verifySubmissionURL() error {
+	if !tc.verifySubmission {
+		return nil
+	}
+
+	su, err := parseSubmissionURL(tc.submissionURL)
+	if err != nil {
+		return err
+	}
+
+	if tc.useProxy(su.host) || (tc.proxyURL == nil && (os.Getenv("HTTP_PROXY") != "" || os.Getenv("HTTPS_PROXY") != "")) {
+		tc.logger().Debugf("skipping submission url verification, proxy configured")
+		return nil
+	}
+
+	target := su.hostPort()
+	conn, err := tc.dialTimeout(su.host, su.port, tc.brokerMaxResponseTime)
+	if err != nil {
+		return fmt.Errorf("%w (%s): %s", ErrSubmissionEndpointUnreachable, target, err)
+	}
+	conn.Close()
+
+	tc.logger().Debugf("submission url endpoint %s -- is reachable", target)
+	return nil
+}
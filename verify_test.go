@@ -0,0 +1,57 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNewFromSubmissionURL_verifySubmissionURL(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, `{"stats":1}`)
+	}))
+	defer ts.Close()
+
+	// reserve a port, then close the listener so nothing is listening on it
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %s", err)
+	}
+	deadURL := "http://" + l.Addr().String() + "/write/test"
+	l.Close()
+
+	tests := []struct {
+		name    string
+		url     string
+		verify  bool
+		wantErr bool
+	}{
+		{name: "reachable", url: ts.URL + "/write/test", verify: true},
+		{name: "unreachable", url: deadURL, verify: true, wantErr: true},
+		{name: "unreachable, not verified", url: deadURL, verify: false},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewFromSubmissionURL(&Config{
+				SubmissionURL:         tt.url,
+				VerifySubmissionURL:   tt.verify,
+				BrokerMaxResponseTime: "250ms",
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewFromSubmissionURL() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr && !errors.Is(err, ErrSubmissionEndpointUnreachable) {
+				t.Errorf("NewFromSubmissionURL() error = %v, want %v", err, ErrSubmissionEndpointUnreachable)
+			}
+		})
+	}
+}
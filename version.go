@@ -0,0 +1,70 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"runtime/debug"
+	"sync"
+
+	"github.com/circonus-labs/go-trapcheck/internal/release"
+)
+
+// modulePath is used to find the go-trapcheck module version in the build info.
+const modulePath = "github.com/circonus-labs/go-trapcheck"
+
+// VersionInfo identifies the go-trapcheck release.
+type VersionInfo struct {
+	Name    string
+	Version string
+}
+
+var (
+	versionOnce sync.Once
+	versionInfo VersionInfo
+)
+
+// Version returns the go-trapcheck version (also sent in the submission User-Agent).
+func Version() string {
+	return Release().Version
+}
+
+// Release returns the go-trapcheck name and version. The version is the module
+// version from the build info when go-trapcheck is a dependency of the binary,
+// otherwise (e.g. a replace directive pointing to a local copy) the release constant.
+func Release() VersionInfo {
+	versionOnce.Do(func() {
+		bi, ok := debug.ReadBuildInfo()
+		versionInfo = VersionInfo{Name: release.NAME, Version: moduleVersion(bi, ok)}
+	})
+	return versionInfo
+}
+
+// moduleVersion returns the go-trapcheck module version from the build info,
+// or release.VERSION if it is not available.
+func moduleVersion(bi *debug.BuildInfo, ok bool) string {
+	if !ok || bi == nil {
+		return release.VERSION
+	}
+	for _, dep := range bi.Deps {
+		if dep == nil || dep.Path != modulePath {
+			continue
+		}
+		v := dep.Version
+		if dep.Replace != nil {
+			v = dep.Replace.Version
+		}
+		if v != "" && v != "(devel)" {
+			return v
+		}
+	}
+	return release.VERSION
+}
+
+// userAgent returns the User-Agent used for broker requests.
+func userAgent() string {
+	v := Release()
+	return v.Name + "/" + v.Version
+}
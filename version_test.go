@@ -0,0 +1,88 @@
+// Copyright (c) 2021 Circonus, Inc. <support@circonus.com>
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package trapcheck
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"testing"
+	"time"
+
+	"github.com/circonus-labs/go-apiclient"
+	"github.com/circonus-labs/go-trapcheck/internal/release"
+)
+
+func TestModuleVersion(t *testing.T) {
+	tests := []struct {
+		bi   *debug.BuildInfo
+		name string
+		want string
+		ok   bool
+	}{
+		{name: "no build info", want: release.VERSION},
+		{name: "not a dependency", bi: &debug.BuildInfo{Main: debug.Module{Path: modulePath, Version: "(devel)"}}, ok: true, want: release.VERSION},
+		{
+			name: "dependency",
+			bi:   &debug.BuildInfo{Deps: []*debug.Module{{Path: "github.com/foo/bar", Version: "v1.0.0"}, {Path: modulePath, Version: "v0.0.99"}}},
+			ok:   true,
+			want: "v0.0.99",
+		},
+		{
+			name: "replaced",
+			bi:   &debug.BuildInfo{Deps: []*debug.Module{{Path: modulePath, Version: "v0.0.99", Replace: &debug.Module{Path: "github.com/fork/go-trapcheck", Version: "v0.0.100"}}}},
+			ok:   true,
+			want: "v0.0.100",
+		},
+		{
+			name: "replaced, local",
+			bi:   &debug.BuildInfo{Deps: []*debug.Module{{Path: modulePath, Version: "v0.0.99", Replace: &debug.Module{Path: "../go-trapcheck"}}}},
+			ok:   true,
+			want: release.VERSION,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			if got := moduleVersion(tt.bi, tt.ok); got != tt.want {
+				t.Errorf("moduleVersion() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVersion_userAgent(t *testing.T) {
+	if Version() == "" {
+		t.Fatal("Version() is empty")
+	}
+	if Release().Name != release.NAME {
+		t.Errorf("Release().Name = %s, want %s", Release().Name, release.NAME)
+	}
+
+	rt := &recordingTransport{statuses: []int{http.StatusOK}, responses: []string{`{"stats":1}`}}
+	tc := &TrapCheck{
+		checkBundle:       &apiclient.CheckBundle{},
+		custSubmissionURL: "http://127.0.0.1:2609/write/test",
+		submissionURL:     "http://127.0.0.1:2609/write/test",
+		submissionTimeout: 5 * time.Second,
+		transport:         rt,
+	}
+	tc.Log = &LogWrapper{Log: log.New(io.Discard, "", 0)}
+
+	var metrics bytes.Buffer
+	metrics.WriteString(`{"foo":{"_type":"n","_value":1}}`)
+	if _, err := tc.SendMetrics(context.Background(), metrics); err != nil {
+		t.Fatalf("TrapCheck.SendMetrics() unexpected error: %s", err)
+	}
+
+	want := Release().Name + "/" + Version()
+	if got := rt.requests[0].Header.Get("User-Agent"); got != want {
+		t.Errorf("User-Agent = %q, want %q", got, want)
+	}
+}